@@ -18,10 +18,11 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"github.com/caarlos0/env"
 	pubsub "github.com/devtron-labs/common-lib/pubsub-lib"
+	"github.com/devtron-labs/lens/internal/server"
+	"github.com/devtron-labs/lens/internal/tracing"
 	"github.com/devtron-labs/lens/pkg/middleware"
-	"net/http"
 	"os"
 	"time"
 
@@ -33,60 +34,146 @@ import (
 )
 
 type App struct {
-	MuxRouter        *api.MuxRouter
-	Logger           *zap.SugaredLogger
-	IngestionService pkg.IngestionService
-	server           *http.Server
-	db               *pg.DB
-	natsSubscription *client.NatsSubscriptionImpl
-	pubSubClient     *pubsub.PubSubClientServiceImpl
+	MuxRouter            *api.MuxRouter
+	Logger               *zap.SugaredLogger
+	IngestionService     pkg.IngestionService
+	serverConfig         *server.ServerConfig
+	server               *server.HttpServer
+	shutdownConfig       *ShutdownConfig
+	tracerProvider       *tracing.TracerProvider
+	db                   *pg.DB
+	natsSubscription     client.NatsSubscription
+	pubSubClient         *pubsub.PubSubClientServiceImpl
+	retentionService     pkg.RetentionService
+	resetService         pkg.ResetService
+	recomputeService     pkg.RecomputeService
+	reprocessService     pkg.ReprocessService
+	failurePolicyService pkg.FailurePolicyService
+	outboxService        pkg.ReleaseOutboxService
+	streamService        pkg.ReleaseStreamService
 }
 
-func NewApp(MuxRouter *api.MuxRouter, Logger *zap.SugaredLogger, db *pg.DB, IngestionService pkg.IngestionService, natsSubscription *client.NatsSubscriptionImpl, pubSubClient *pubsub.PubSubClientServiceImpl) *App {
+func NewApp(MuxRouter *api.MuxRouter, Logger *zap.SugaredLogger, db *pg.DB, IngestionService pkg.IngestionService, natsSubscription *client.NatsSubscriptionImpl, pubSubClient *pubsub.PubSubClientServiceImpl,
+	retentionService pkg.RetentionService, resetService pkg.ResetService, recomputeService pkg.RecomputeService,
+	reprocessService pkg.ReprocessService, failurePolicyService pkg.FailurePolicyService, outboxService pkg.ReleaseOutboxService, streamService pkg.ReleaseStreamService, serverConfig *server.ServerConfig, shutdownConfig *ShutdownConfig,
+	tracerProvider *tracing.TracerProvider) *App {
 	return &App{
-		MuxRouter:        MuxRouter,
-		Logger:           Logger,
-		db:               db,
-		natsSubscription: natsSubscription,
-		IngestionService: IngestionService,
-		pubSubClient:     pubSubClient,
+		tracerProvider:       tracerProvider,
+		serverConfig:         serverConfig,
+		shutdownConfig:       shutdownConfig,
+		MuxRouter:            MuxRouter,
+		Logger:               Logger,
+		db:                   db,
+		natsSubscription:     natsSubscription,
+		IngestionService:     IngestionService,
+		pubSubClient:         pubSubClient,
+		retentionService:     retentionService,
+		resetService:         resetService,
+		recomputeService:     recomputeService,
+		reprocessService:     reprocessService,
+		failurePolicyService: failurePolicyService,
+		outboxService:        outboxService,
+		streamService:        streamService,
 	}
 }
 
 func (app *App) Start() {
-	port := 8080 //TODO: extract from environment variable
-	app.Logger.Infow("starting server on ", "port", port)
-	app.MuxRouter.Router.Use(middleware.PrometheusMiddleware)
+	app.MuxRouter.Router.Use(middleware.PrometheusMiddleware, middleware.TracingMiddleware)
 	app.MuxRouter.Init()
-	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: app.MuxRouter.Router}
-	app.server = server
-	err := server.ListenAndServe()
+	httpServer, err := server.NewHttpServer(app.Logger, app.serverConfig, app.MuxRouter.Router)
+	if err != nil {
+		app.Logger.Errorw("error in configuring http server", "err", err)
+		os.Exit(2)
+	}
+	app.server = httpServer
+	httpServer.RegisterOnShutdown(app.streamService.Close)
+	app.retentionService.Start()
+	app.resetService.Start()
+	app.recomputeService.Start()
+	app.reprocessService.Start()
+	app.failurePolicyService.Start()
+	app.outboxService.Start()
+	err = httpServer.ListenAndServe()
 	if err != nil {
 		app.Logger.Errorw("error in startup", "err", err)
 		os.Exit(2)
 	}
 }
 
+type ShutdownConfig struct {
+	HttpShutdownTimeoutSecs   int `env:"HTTP_SHUTDOWN_TIMEOUT_SECS" envDefault:"5"`
+	IngestionDrainTimeoutSecs int `env:"INGESTION_DRAIN_TIMEOUT_SECS" envDefault:"30"`
+}
+
+func GetShutdownConfig() (*ShutdownConfig, error) {
+	cfg := &ShutdownConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+type shutdownPhase struct {
+	name    string
+	timeout time.Duration //zero runs without deadline
+	run     func(ctx context.Context) error
+}
+
+// Stop shuts down in order: http, nats consumption, in-flight ingestion, nats connection, workers, db and tracing
 func (app *App) Stop() {
 	app.Logger.Infow("lens shutdown initiating")
-	timeoutContext, _ := context.WithTimeout(context.Background(), 5*time.Second)
-
-	app.Logger.Infow("closing router")
-	err := app.server.Shutdown(timeoutContext)
-	if err != nil {
-		app.Logger.Errorw("error in mux router shutdown", "err", err)
-	}
+	runShutdownPhases(app.Logger, app.shutdownPhases())
+	app.Logger.Infow("housekeeping done. exiting now")
+}
 
-	//Draining nats connection
-	if err != nil {
-		app.Logger.Errorw("Error while draining nats connection", "error", err)
+func (app *App) shutdownPhases() []shutdownPhase {
+	return []shutdownPhase{
+		{name: "stop http server", timeout: time.Duration(app.shutdownConfig.HttpShutdownTimeoutSecs) * time.Second, run: func(ctx context.Context) error {
+			if app.server == nil {
+				return nil
+			}
+			return app.server.Shutdown(ctx)
+		}},
+		// parks new deliveries unacked rather than unsubscribing, the pubsub library keeps the subscription to itself
+		{name: "stop nats consumption", run: func(ctx context.Context) error {
+			app.natsSubscription.StopConsuming()
+			return nil
+		}},
+		{name: "wait for in-flight ingestion", timeout: time.Duration(app.shutdownConfig.IngestionDrainTimeoutSecs) * time.Second, run: app.natsSubscription.WaitInFlight},
+		{name: "close nats connection", run: func(ctx context.Context) error {
+			// the outbox publishes on the connection, what is left is published on next start
+			app.outboxService.Stop()
+			app.natsSubscription.Close()
+			return nil
+		}},
+		{name: "stop background workers", run: func(ctx context.Context) error {
+			app.retentionService.Stop()
+			app.resetService.Stop()
+			app.recomputeService.Stop()
+			app.reprocessService.Stop()
+			app.failurePolicyService.Stop()
+			return nil
+		}},
+		{name: "close db connection", run: func(ctx context.Context) error {
+			return app.db.Close()
+		}},
+		{name: "flush traces", timeout: 5 * time.Second, run: app.tracerProvider.Shutdown},
 	}
+}
 
-	app.Logger.Infow("closing db connection")
-	err = app.db.Close()
-	if err != nil {
-		app.Logger.Errorw("error in closing db connection", "err", err)
+// runShutdownPhases runs every phase even if an earlier one failed or timed out
+func runShutdownPhases(logger *zap.SugaredLogger, phases []shutdownPhase) {
+	for _, phase := range phases {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if phase.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, phase.timeout)
+		}
+		start := time.Now()
+		logger.Infow("shutdown phase started", "phase", phase.name)
+		err := phase.run(ctx)
+		cancel()
+		if err != nil {
+			logger.Errorw("shutdown phase failed", "phase", phase.name, "duration", time.Since(start).String(), "err", err)
+			continue
+		}
+		logger.Infow("shutdown phase done", "phase", phase.name, "duration", time.Since(start).String())
 	}
-
-	app.Logger.Infow("housekeeping done. exiting now")
 }
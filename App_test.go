@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/lens/pkg"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type callRecorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *callRecorder) record(call string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+type fakeNatsSubscription struct {
+	recorder *callRecorder
+	// inFlight is closed when the in-flight ingestion finishes, nil never finishes
+	inFlight chan struct{}
+}
+
+func (f *fakeNatsSubscription) StopConsuming() { f.recorder.record("nats.StopConsuming") }
+func (f *fakeNatsSubscription) Close()         { f.recorder.record("nats.Close") }
+func (f *fakeNatsSubscription) WaitInFlight(ctx context.Context) error {
+	defer f.recorder.record("nats.WaitInFlight")
+	select {
+	case <-f.inFlight:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type fakeRetentionService struct {
+	pkg.RetentionService
+	recorder *callRecorder
+}
+
+func (f *fakeRetentionService) Stop() { f.recorder.record("retention.Stop") }
+
+type fakeResetService struct {
+	pkg.ResetService
+	recorder *callRecorder
+}
+
+func (f *fakeResetService) Stop() { f.recorder.record("reset.Stop") }
+
+type fakeRecomputeService struct {
+	pkg.RecomputeService
+	recorder *callRecorder
+}
+
+func (f *fakeRecomputeService) Stop() { f.recorder.record("recompute.Stop") }
+
+type fakeReprocessService struct {
+	pkg.ReprocessService
+	recorder *callRecorder
+}
+
+func (f *fakeReprocessService) Stop() { f.recorder.record("reprocess.Stop") }
+
+type fakeFailurePolicyService struct {
+	pkg.FailurePolicyService
+	recorder *callRecorder
+}
+
+func (f *fakeFailurePolicyService) Stop() { f.recorder.record("failurePolicy.Stop") }
+
+type fakeReleaseOutboxService struct {
+	pkg.ReleaseOutboxService
+	recorder *callRecorder
+}
+
+func (f *fakeReleaseOutboxService) Stop() { f.recorder.record("outbox.Stop") }
+
+type logEntry struct {
+	Msg   string `json:"msg"`
+	Phase string `json:"phase"`
+	Err   string `json:"err"`
+}
+
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) entries(msg string) []logEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var entries []logEntry
+	scanner := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for scanner.Scan() {
+		entry := logEntry{}
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Msg == msg {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func newShutdownTestApp(inFlight chan struct{}, drainTimeoutSecs int) (*App, *callRecorder, *logBuffer) {
+	logs := &logBuffer{}
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(logs), zap.InfoLevel)
+	recorder := &callRecorder{}
+	app := &App{
+		Logger:               zap.New(core).Sugar(),
+		db:                   pg.Connect(&pg.Options{Addr: "localhost:0"}),
+		natsSubscription:     &fakeNatsSubscription{recorder: recorder, inFlight: inFlight},
+		retentionService:     &fakeRetentionService{recorder: recorder},
+		resetService:         &fakeResetService{recorder: recorder},
+		recomputeService:     &fakeRecomputeService{recorder: recorder},
+		reprocessService:     &fakeReprocessService{recorder: recorder},
+		failurePolicyService: &fakeFailurePolicyService{recorder: recorder},
+		outboxService:        &fakeReleaseOutboxService{recorder: recorder},
+		shutdownConfig:       &ShutdownConfig{HttpShutdownTimeoutSecs: 1, IngestionDrainTimeoutSecs: drainTimeoutSecs},
+	}
+	return app, recorder, logs
+}
+
+func phaseLogs(logs *logBuffer) []string {
+	var phases []string
+	for _, entry := range logs.entries("shutdown phase started") {
+		phases = append(phases, entry.Phase)
+	}
+	return phases
+}
+
+func TestApp_StopOrder(t *testing.T) {
+	inFlight := make(chan struct{})
+	app, recorder, logs := newShutdownTestApp(inFlight, 5)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(inFlight)
+	}()
+	app.Stop()
+
+	wantCalls := []string{"nats.StopConsuming", "nats.WaitInFlight", "outbox.Stop", "nats.Close", "retention.Stop", "reset.Stop", "recompute.Stop", "reprocess.Stop", "failurePolicy.Stop"}
+	if !reflect.DeepEqual(recorder.calls, wantCalls) {
+		t.Errorf("Stop() calls = %v, want %v", recorder.calls, wantCalls)
+	}
+	wantPhases := []string{"stop http server", "stop nats consumption", "wait for in-flight ingestion", "close nats connection", "stop background workers", "close db connection", "flush traces"}
+	if got := phaseLogs(logs); !reflect.DeepEqual(got, wantPhases) {
+		t.Errorf("Stop() phases = %v, want %v", got, wantPhases)
+	}
+	if failed := len(logs.entries("shutdown phase failed")); failed != 0 {
+		t.Errorf("Stop() failed phases = %d, want 0", failed)
+	}
+}
+
+func TestApp_StopInFlightDeadline(t *testing.T) {
+	app, recorder, logs := newShutdownTestApp(nil, 1)
+	start := time.Now()
+	app.Stop()
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("Stop() took %s, drain deadline not applied", elapsed)
+	}
+	failed := logs.entries("shutdown phase failed")
+	if len(failed) != 1 || failed[0].Phase != "wait for in-flight ingestion" {
+		t.Fatalf("Stop() failed phases = %v, want only in-flight ingestion", failed)
+	}
+	if failed[0].Err != context.DeadlineExceeded.Error() {
+		t.Errorf("Stop() in-flight error = %v, want deadline exceeded", failed[0].Err)
+	}
+	// the remaining phases still run after the deadline
+	if got := recorder.calls[len(recorder.calls)-6:]; !reflect.DeepEqual(got, []string{"nats.Close", "retention.Stop", "reset.Stop", "recompute.Stop", "reprocess.Stop", "failurePolicy.Stop"}) {
+		t.Errorf("Stop() calls after deadline = %v", got)
+	}
+}
+
+func TestRunShutdownPhases_ContinuesAfterError(t *testing.T) {
+	var ran []string
+	runShutdownPhases(zap.NewNop().Sugar(), []shutdownPhase{
+		{name: "a", run: func(ctx context.Context) error { ran = append(ran, "a"); return errors.New("boom") }},
+		{name: "b", run: func(ctx context.Context) error { ran = append(ran, "b"); return nil }},
+	})
+	if !reflect.DeepEqual(ran, []string{"a", "b"}) {
+		t.Errorf("runShutdownPhases() ran = %v", ran)
+	}
+}
@@ -22,23 +22,32 @@ package main
 import (
 	pubsub "github.com/devtron-labs/common-lib/pubsub-lib"
 	"github.com/devtron-labs/lens/api"
+	"github.com/devtron-labs/lens/bean"
 	"github.com/devtron-labs/lens/client"
 	"github.com/devtron-labs/lens/client/gitSensor"
 	"github.com/devtron-labs/lens/internal/logger"
+	"github.com/devtron-labs/lens/internal/server"
 	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/internal/tracing"
 	"github.com/devtron-labs/lens/pkg"
+	"github.com/devtron-labs/lens/pkg/auth"
 	"github.com/google/wire"
 )
 
 func InitializeApp() (*App, error) {
 	wire.Build(
 		NewApp,
+		server.GetServerConfig,
+		GetShutdownConfig,
+		tracing.GetTracingConfig,
+		tracing.NewTracerProvider,
 		api.NewMuxRouter,
 		logger.NewSugardLogger,
 		sql.GetConfig,
 		sql.NewDbConnection,
 		api.NewRestHandlerImpl,
 		wire.Bind(new(api.RestHandler), new(*api.RestHandlerImpl)),
+		pkg.GetIngestionConfig,
 		pkg.NewIngestionServiceImpl,
 		wire.Bind(new(pkg.IngestionService), new(*pkg.IngestionServiceImpl)),
 		sql.NewAppReleaseRepositoryImpl,
@@ -47,16 +56,94 @@ func InitializeApp() (*App, error) {
 		wire.Bind(new(sql.LeadTimeRepository), new(*sql.LeadTimeRepositoryImpl)),
 		sql.NewPipelineMaterialRepositoryImpl,
 		wire.Bind(new(sql.PipelineMaterialRepository), new(*sql.PipelineMaterialRepositoryImpl)),
+		sql.NewReleaseRollupRepositoryImpl,
+		wire.Bind(new(sql.ReleaseRollupRepository), new(*sql.ReleaseRollupRepositoryImpl)),
+		sql.NewResetBatchRepositoryImpl,
+		wire.Bind(new(sql.ResetBatchRepository), new(*sql.ResetBatchRepositoryImpl)),
+		sql.NewReleaseOverrideRepositoryImpl,
+		wire.Bind(new(sql.ReleaseOverrideRepository), new(*sql.ReleaseOverrideRepositoryImpl)),
+		sql.NewReleaseTagRepositoryImpl,
+		wire.Bind(new(sql.ReleaseTagRepository), new(*sql.ReleaseTagRepositoryImpl)),
+		sql.NewReleaseAnnotationRepositoryImpl,
+		wire.Bind(new(sql.ReleaseAnnotationRepository), new(*sql.ReleaseAnnotationRepositoryImpl)),
+		sql.NewReleaseStatusEventRepositoryImpl,
+		wire.Bind(new(sql.ReleaseStatusEventRepository), new(*sql.ReleaseStatusEventRepositoryImpl)),
+		sql.NewFailurePolicyRepositoryImpl,
+		wire.Bind(new(sql.FailurePolicyRepository), new(*sql.FailurePolicyRepositoryImpl)),
+		sql.NewFailureRecomputeJobRepositoryImpl,
+		wire.Bind(new(sql.FailureRecomputeJobRepository), new(*sql.FailureRecomputeJobRepositoryImpl)),
+		sql.NewReleaseStageHistoryRepositoryImpl,
+		wire.Bind(new(sql.ReleaseStageHistoryRepository), new(*sql.ReleaseStageHistoryRepositoryImpl)),
+		sql.NewReleaseOutboxRepositoryImpl,
+		wire.Bind(new(sql.ReleaseOutboxRepository), new(*sql.ReleaseOutboxRepositoryImpl)),
+		sql.NewRawEventRepositoryImpl,
+		wire.Bind(new(sql.RawEventRepository), new(*sql.RawEventRepositoryImpl)),
+		sql.NewRecomputeJobRepositoryImpl,
+		wire.Bind(new(sql.RecomputeJobRepository), new(*sql.RecomputeJobRepositoryImpl)),
+		sql.NewApiKeyRepositoryImpl,
+		wire.Bind(new(sql.ApiKeyRepository), new(*sql.ApiKeyRepositoryImpl)),
+		auth.GetAuthConfig,
+		auth.NewAuthServiceImpl,
+		wire.Bind(new(auth.AuthService), new(*auth.AuthServiceImpl)),
+		api.NewAuthMiddleware,
+		pkg.GetHealthConfig,
+		pkg.NewHealthServiceImpl,
+		wire.Bind(new(pkg.HealthService), new(*pkg.HealthServiceImpl)),
+		pkg.NewFailurePolicyServiceImpl,
+		wire.Bind(new(pkg.FailurePolicyService), new(*pkg.FailurePolicyServiceImpl)),
+		pkg.NewReleaseServiceImpl,
+		wire.Bind(new(pkg.ReleaseService), new(*pkg.ReleaseServiceImpl)),
+		pkg.GetResetConfig,
+		pkg.NewResetServiceImpl,
+		wire.Bind(new(pkg.ResetService), new(*pkg.ResetServiceImpl)),
+		pkg.NewRawEventServiceImpl,
+		wire.Bind(new(pkg.RawEventService), new(*pkg.RawEventServiceImpl)),
+		pkg.NewRecomputeServiceImpl,
+		wire.Bind(new(pkg.RecomputeService), new(*pkg.RecomputeServiceImpl)),
+		pkg.GetReprocessConfig,
+		pkg.NewReprocessServiceImpl,
+		wire.Bind(new(pkg.ReprocessService), new(*pkg.ReprocessServiceImpl)),
+		pkg.GetReleaseStreamConfig,
+		pkg.NewReleaseStreamServiceImpl,
+		wire.Bind(new(pkg.ReleaseStreamService), new(*pkg.ReleaseStreamServiceImpl)),
+		pkg.GetReleaseOutboxConfig,
+		pkg.NewReleaseOutboxServiceImpl,
+		wire.Bind(new(pkg.ReleaseOutboxService), new(*pkg.ReleaseOutboxServiceImpl)),
+		pkg.GetRetentionConfig,
+		pkg.NewRetentionServiceImpl,
+		wire.Bind(new(pkg.RetentionService), new(*pkg.RetentionServiceImpl)),
 		pkg.NewDeploymentMetricServiceImpl,
 		wire.Bind(new(pkg.DeploymentMetricService), new(*pkg.DeploymentMetricServiceImpl)),
+		gitSensor.GetResilienceConfig,
 		gitSensor.GetGitSensorConfig,
 		gitSensor.NewGitSensorSession,
-		wire.Bind(new(gitSensor.GitSensorClient), new(*gitSensor.GitSensorClientImpl)),
 		gitSensor.GetConfig,
 		gitSensor.NewGitSensorGrpcClientImpl,
-		wire.Bind(new(gitSensor.GitSensorGrpcClient), new(*gitSensor.GitSensorGrpcClientImpl)),
+		gitSensor.GetLocalGitConfig,
+		gitSensor.NewLocalGitChangesProviderImpl,
+		bean.GetGitSensorProtocolConfig,
+		gitSensor.NewGitChangesProvider,
+		gitSensor.GetGitChangesCacheConfig,
+		sql.NewGitChangesCacheRepositoryImpl,
+		wire.Bind(new(sql.GitChangesCacheRepository), new(*sql.GitChangesCacheRepositoryImpl)),
+		gitSensor.NewGitChangesCacheImpl,
+		wire.Bind(new(gitSensor.GitChangesCache), new(*gitSensor.GitChangesCacheImpl)),
 		pubsub.NewPubSubClientServiceImpl,
 		client.NewNatsSubscription,
 	)
 	return &App{}, nil
 }
+
+func InitializeMigrateCommand() (*MigrateCommand, error) {
+	wire.Build(
+		NewMigrateCommand,
+		NewMigrationSource,
+		logger.NewSugardLogger,
+		sql.GetConfig,
+		sql.NewDbConnection,
+		sql.GetMigrationConfig,
+		sql.NewMigratorImpl,
+		wire.Bind(new(sql.Migrator), new(*sql.MigratorImpl)),
+	)
+	return &MigrateCommand{}, nil
+}
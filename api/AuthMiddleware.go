@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/devtron-labs/lens/pkg"
+	"github.com/devtron-labs/lens/pkg/apperror"
+	"github.com/devtron-labs/lens/pkg/auth"
+	pg "github.com/go-pg/pg/v10"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// AppIdResolver finds the app a request acts on so that per-app allow-lists can be enforced
+type AppIdResolver func(r *http.Request) (int, error)
+
+var errAllAppsRequired = errors.New("route acts beyond a single app, token limited to apps")
+
+type AuthMiddleware struct {
+	logger           *zap.SugaredLogger
+	authService      auth.AuthService
+	releaseService   pkg.ReleaseService
+	resetService     pkg.ResetService
+	recomputeService pkg.RecomputeService
+}
+
+func NewAuthMiddleware(logger *zap.SugaredLogger,
+	authService auth.AuthService,
+	releaseService pkg.ReleaseService,
+	resetService pkg.ResetService,
+	recomputeService pkg.RecomputeService) *AuthMiddleware {
+	return &AuthMiddleware{
+		logger:           logger,
+		authService:      authService,
+		releaseService:   releaseService,
+		resetService:     resetService,
+		recomputeService: recomputeService,
+	}
+}
+
+// Require authenticates the bearer token, checks the scope and, when appIdOf is set, the app allow-list
+func (impl *AuthMiddleware) Require(scope string, appIdOf AppIdResolver, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !impl.authService.IsEnabled() {
+			next(w, r)
+			return
+		}
+		token := bearerToken(r)
+		principal, err := impl.authService.Authenticate(r.Context(), token)
+		if err == auth.ErrUnauthenticated {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="lens"`)
+			writeApiError(w, apperror.Unauthenticated, err.Error(), "authentication required")
+			return
+		} else if err != nil {
+			impl.logger.Errorw("error in authenticating request", "err", err)
+			writeApiError(w, apperror.Internal, "", "could not authenticate request")
+			return
+		}
+		if !principal.HasScope(scope) {
+			impl.logger.Infow("request denied, missing scope", "subject", principal.Subject, "scope", scope, "path", r.URL.Path)
+			writeApiError(w, apperror.Forbidden, fmt.Sprintf("scope %s required", scope), "not allowed")
+			return
+		}
+		if appIdOf != nil && len(principal.AppIds) > 0 {
+			appId, err := appIdOf(r)
+			if err == errAllAppsRequired {
+				impl.logger.Infow("request denied, token limited to apps", "subject", principal.Subject, "path", r.URL.Path)
+				writeApiError(w, apperror.Forbidden, err.Error(), "not allowed")
+				return
+			} else if err == pg.ErrNoRows {
+				writeApiError(w, apperror.NotFound, err.Error(), "not found")
+				return
+			} else if err != nil {
+				writeApiError(w, apperror.Validation, err.Error(), "could not determine app of request")
+				return
+			}
+			if !principal.CanAccessApp(appId) {
+				impl.logger.Infow("request denied, app not allowed", "subject", principal.Subject, "appId", appId, "path", r.URL.Path)
+				writeApiError(w, apperror.Forbidden, fmt.Sprintf("app %d not allowed", appId), "not allowed")
+				return
+			}
+		}
+		next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// writeApiError fails the request with the status of kind, its code is the kind
+func writeApiError(w http.ResponseWriter, kind apperror.Kind, internalMessage string, userMessage string) {
+	status := kind.HttpStatus()
+	response := Response{
+		Code:   status,
+		Status: http.StatusText(status),
+		Errors: []*ApiError{{HttpStatusCode: status, Code: string(kind), InternalMessage: internalMessage, UserMessage: userMessage}},
+	}
+	b, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+// requestActor is the authenticated subject of the request, the actor claimed in the body is only taken when
+// authentication is disabled
+func requestActor(r *http.Request, claimed string) string {
+	if principal := auth.PrincipalFrom(r.Context()); principal != nil {
+		return principal.Subject
+	}
+	return claimed
+}
+
+// AllApps guards routes acting on whole environments or globally, only tokens without an app allow-list pass
+func AllApps(r *http.Request) (int, error) {
+	return 0, errAllAppsRequired
+}
+
+func QueryAppId(r *http.Request) (int, error) {
+	return strconv.Atoi(r.URL.Query().Get("app_id"))
+}
+
+// maxAuthorizedBodyBytes bounds the body read to find the app of a request
+const maxAuthorizedBodyBytes = 10 << 20
+
+// BodyAppId reads the app id from the json body decoded into the request type of the handler, so that the app
+// checked is the one the handler acts on, the body is left readable for the handler
+func BodyAppId[T any](appIdOf func(request *T) int) AppIdResolver {
+	return func(r *http.Request) (int, error) {
+		body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxAuthorizedBodyBytes))
+		if err != nil {
+			return 0, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		request := new(T)
+		if err = json.Unmarshal(body, request); err != nil {
+			return 0, err
+		}
+		appId := appIdOf(request)
+		if appId <= 0 {
+			return 0, errors.New("app id is required")
+		}
+		return appId, nil
+	}
+}
+
+func DeploymentEventAppId(event *pkg.DeploymentEvent) int {
+	return event.ApplicationId
+}
+
+func StatusEventAppId(event *pkg.DeploymentStatusEvent) int {
+	return event.ApplicationId
+}
+
+func ResetRequestAppId(request *pkg.ResetRequest) int {
+	return request.AppId
+}
+
+func RecomputeRequestAppId(request *pkg.RecomputeRequest) int {
+	return request.AppId
+}
+
+func (impl *AuthMiddleware) ReleaseAppId(r *http.Request) (int, error) {
+	appReleaseId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, err
+	}
+	release, err := impl.releaseService.GetRelease(r.Context(), appReleaseId)
+	if err != nil {
+		return 0, err
+	}
+	return release.AppId, nil
+}
+
+func (impl *AuthMiddleware) ResetBatchAppId(r *http.Request) (int, error) {
+	resetBatchId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, err
+	}
+	resetBatch, err := impl.resetService.GetResetBatch(r.Context(), resetBatchId)
+	if err != nil {
+		return 0, err
+	}
+	return resetBatch.AppId, nil
+}
+
+func (impl *AuthMiddleware) RecomputeJobAppId(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, err
+	}
+	job, err := impl.recomputeService.GetRecomputeJob(r.Context(), id)
+	if err != nil {
+		return 0, err
+	}
+	return job.AppId, nil
+}
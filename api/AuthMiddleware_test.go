@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devtron-labs/lens/pkg"
+	"github.com/devtron-labs/lens/pkg/auth"
+	"go.uber.org/zap"
+)
+
+type fakeAuthService struct {
+	auth.AuthService
+	principal *auth.Principal
+	created   *auth.ApiKeyRequest
+}
+
+func (impl *fakeAuthService) IsEnabled() bool {
+	return true
+}
+
+func (impl *fakeAuthService) Authenticate(ctx context.Context, token string) (*auth.Principal, error) {
+	return impl.principal, nil
+}
+
+func (impl *fakeAuthService) CreateApiKey(ctx context.Context, request *auth.ApiKeyRequest) (*auth.ApiKeyResponse, error) {
+	if request.CreatedBy == "" {
+		return nil, errors.New("created_by violates not-null constraint")
+	}
+	impl.created = request
+	return &auth.ApiKeyResponse{Id: 1, Name: request.Name, Key: "key"}, nil
+}
+
+func TestRequestActor(t *testing.T) {
+	r := httptest.NewRequest("POST", "/reset-app-environment", nil)
+	if actor := requestActor(r, "jane"); actor != "jane" {
+		t.Errorf("requestActor() without authentication = %q, want the claimed actor", actor)
+	}
+	r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Subject: "ci"}))
+	if actor := requestActor(r, "jane"); actor != "ci" {
+		t.Errorf("requestActor() of authenticated request = %q, want the subject", actor)
+	}
+}
+
+func TestRequireAllApps(t *testing.T) {
+	tests := []struct {
+		name   string
+		appIds []int
+		want   int
+	}{
+		{"unrestricted admin", nil, http.StatusOK},
+		{"admin limited to apps", []int{7}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authService := &fakeAuthService{principal: &auth.Principal{Subject: "ops", Scopes: []string{auth.ScopeAdmin}, AppIds: tt.appIds}}
+			authz := NewAuthMiddleware(zap.NewNop().Sugar(), authService, nil, nil, nil)
+			handler := authz.Require(auth.ScopeAdmin, AllApps, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest("PUT", "/failure-policies/1", nil))
+			if w.Code != tt.want {
+				t.Errorf("Require() status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateApiKeyWithoutAuthentication(t *testing.T) {
+	authService := &fakeAuthService{}
+	handler := &RestHandlerImpl{logger: zap.NewNop().Sugar(), authService: authService}
+	w := httptest.NewRecorder()
+	handler.CreateApiKey(w, httptest.NewRequest("POST", "/api-keys", strings.NewReader(`{"name": "ci", "scopes": ["ingest"]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("CreateApiKey() status = %d, body %s", w.Code, w.Body.String())
+	}
+	if authService.created.CreatedBy != systemActor {
+		t.Errorf("CreateApiKey() createdBy = %q, want %q", authService.created.CreatedBy, systemActor)
+	}
+	response := &Response{}
+	if err := json.Unmarshal(w.Body.Bytes(), response); err != nil || response.Result == nil {
+		t.Errorf("CreateApiKey() response = %s, err %v", w.Body.String(), err)
+	}
+}
+
+func TestRequireBodyAppId(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"allowed app", `{"ApplicationId": 5, "EnvironmentId": 1}`, http.StatusOK},
+		{"other app", `{"ApplicationId": 99}`, http.StatusForbidden},
+		{"duplicate keys, last one wins", `{"ApplicationId": 5, "applicationid": 99}`, http.StatusForbidden},
+		{"duplicate keys ending on allowed app", `{"applicationid": 99, "ApplicationId": 5}`, http.StatusOK},
+		{"missing app", `{"EnvironmentId": 1}`, http.StatusBadRequest},
+		{"too large", `{"ApplicationId": 5, "pad": "` + strings.Repeat("x", maxAuthorizedBodyBytes) + `"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authService := &fakeAuthService{principal: &auth.Principal{Subject: "ci", Scopes: []string{auth.ScopeIngest}, AppIds: []int{5}}}
+			authz := NewAuthMiddleware(zap.NewNop().Sugar(), authService, nil, nil, nil)
+			handler := authz.Require(auth.ScopeIngest, BodyAppId(DeploymentEventAppId), func(w http.ResponseWriter, r *http.Request) {
+				event := &pkg.DeploymentEvent{}
+				if err := json.NewDecoder(r.Body).Decode(event); err != nil || event.ApplicationId != 5 {
+					t.Errorf("handler decoded app %d, err %v, want the checked app 5", event.ApplicationId, err)
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest("POST", "/new-deployment-event", strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Errorf("Require() status = %d, want %d, body %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -18,30 +18,90 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/devtron-labs/lens/client/gitSensor"
+	"github.com/devtron-labs/lens/internal/metrics"
 	"github.com/devtron-labs/lens/pkg"
+	"github.com/devtron-labs/lens/pkg/apperror"
+	"github.com/devtron-labs/lens/pkg/auth"
+	"github.com/gorilla/mux"
 	"go.uber.org/zap"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type RestHandler interface {
 	GetDeploymentMetrics(w http.ResponseWriter, r *http.Request)
+	GetDailyMetrics(w http.ResponseWriter, r *http.Request)
 	ProcessDeploymentEvent(w http.ResponseWriter, r *http.Request)
 	ResetApplication(w http.ResponseWriter, r *http.Request)
+	RestoreApplication(w http.ResponseWriter, r *http.Request)
+	UpdateRelease(w http.ResponseWriter, r *http.Request)
+	GetReleaseAudits(w http.ResponseWriter, r *http.Request)
+	GetReleaseTimeline(w http.ResponseWriter, r *http.Request)
+	SaveReleaseTags(w http.ResponseWriter, r *http.Request)
+	GetReleaseTags(w http.ResponseWriter, r *http.Request)
+	DeleteReleaseTag(w http.ResponseWriter, r *http.Request)
+	AddReleaseAnnotation(w http.ResponseWriter, r *http.Request)
+	GetReleaseAnnotations(w http.ResponseWriter, r *http.Request)
+	CreateApiKey(w http.ResponseWriter, r *http.Request)
+	RevokeApiKey(w http.ResponseWriter, r *http.Request)
+	Livez(w http.ResponseWriter, r *http.Request)
+	Readyz(w http.ResponseWriter, r *http.Request)
+	PurgeGitChangesCache(w http.ResponseWriter, r *http.Request)
+	ProcessStatusEvent(w http.ResponseWriter, r *http.Request)
+	GetFailurePolicy(w http.ResponseWriter, r *http.Request)
+	SaveFailurePolicy(w http.ResponseWriter, r *http.Request)
+	RecomputeFailures(w http.ResponseWriter, r *http.Request)
+	GetFailureRecomputeJob(w http.ResponseWriter, r *http.Request)
+	Recompute(w http.ResponseWriter, r *http.Request)
+	GetRecomputeJob(w http.ResponseWriter, r *http.Request)
+	StreamReleases(w http.ResponseWriter, r *http.Request)
 }
 
 func NewRestHandlerImpl(logger *zap.SugaredLogger,
 	deploymentMetricService pkg.DeploymentMetricService,
-	ingestionService pkg.IngestionService) *RestHandlerImpl {
+	ingestionService pkg.IngestionService,
+	resetService pkg.ResetService,
+	releaseService pkg.ReleaseService,
+	authService auth.AuthService,
+	healthService pkg.HealthService,
+	gitChangesCache gitSensor.GitChangesCache,
+	failurePolicyService pkg.FailurePolicyService,
+	rawEventService pkg.RawEventService,
+	recomputeService pkg.RecomputeService,
+	releaseStreamService pkg.ReleaseStreamService) *RestHandlerImpl {
 	return &RestHandlerImpl{logger: logger,
 		deploymentMetricService: deploymentMetricService,
-		ingestionService:        ingestionService}
+		ingestionService:        ingestionService,
+		resetService:            resetService,
+		releaseService:          releaseService,
+		authService:             authService,
+		healthService:           healthService,
+		gitChangesCache:         gitChangesCache,
+		failurePolicyService:    failurePolicyService,
+		rawEventService:         rawEventService,
+		recomputeService:        recomputeService,
+		releaseStreamService:    releaseStreamService}
 }
 
 type RestHandlerImpl struct {
 	logger                  *zap.SugaredLogger
 	deploymentMetricService pkg.DeploymentMetricService
 	ingestionService        pkg.IngestionService
+	resetService            pkg.ResetService
+	releaseService          pkg.ReleaseService
+	authService             auth.AuthService
+	healthService           pkg.HealthService
+	gitChangesCache         gitSensor.GitChangesCache
+	failurePolicyService    pkg.FailurePolicyService
+	rawEventService         pkg.RawEventService
+	recomputeService        pkg.RecomputeService
+	releaseStreamService    pkg.ReleaseStreamService
 }
 type Response struct {
 	Code   int         `json:"code,omitempty"`
@@ -57,20 +117,32 @@ type ApiError struct {
 	UserDetailMessage string      `json:"userDetailMessage,omitempty"`
 }
 
+// writeJsonResp writes respBody with status, or err with the status and code of its kind. The handler rejecting
+// a request itself with a 4xx status makes an untyped err a validation error, details of internal errors are
+// only logged.
 func (impl RestHandlerImpl) writeJsonResp(w http.ResponseWriter, err error, respBody interface{}, status int) {
+	if err != nil {
+		kind := apperror.KindOf(err)
+		if kind == apperror.Internal && status >= http.StatusBadRequest && status < http.StatusInternalServerError {
+			kind = apperror.Validation
+		}
+		userMessage := kind.UserMessage()
+		var typed *apperror.Error
+		if errors.As(err, &typed) && typed.Message != "" {
+			userMessage = typed.Message
+		}
+		internalMessage := err.Error()
+		if kind == apperror.Internal {
+			impl.logger.Errorw("error in serving request", "err", err)
+			internalMessage = ""
+		}
+		writeApiError(w, kind, internalMessage, userMessage)
+		return
+	}
 	response := Response{}
 	response.Code = status
 	response.Status = http.StatusText(status)
-	if err == nil {
-		response.Result = respBody
-	} else {
-		apiErr := &ApiError{}
-		apiErr.Code = "000" // 000=unknown
-		apiErr.InternalMessage = err.Error()
-		apiErr.UserMessage = respBody
-		response.Errors = []*ApiError{apiErr}
-
-	}
+	response.Result = respBody
 	b, err := json.Marshal(response)
 	if err != nil {
 		impl.logger.Error("error in marshaling err object", err)
@@ -100,24 +172,21 @@ func (handler RestHandlerImpl) SaveGitProvider(w http.ResponseWriter, r *http.Re
 	}
 }*/
 
-func (impl *RestHandlerImpl) GetDeploymentMetrics(w http.ResponseWriter, r *http.Request) {
-	//decoder := json.NewDecoder(r.Body)
+func (impl *RestHandlerImpl) decodeMetricRequest(r *http.Request) (*pkg.MetricRequest, error) {
 	v := r.URL.Query()
 	impl.logger.Infow("metrics request", "req", v)
 	metricRequest := &pkg.MetricRequest{}
 	if v.Get("env_id") != "" {
 		envId, err := strconv.Atoi(v.Get("env_id"))
 		if err != nil {
-			impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
-			return
+			return nil, err
 		}
 		metricRequest.EnvId = envId
 	}
 	if v.Get("app_id") != "" {
 		appId, err := strconv.Atoi(v.Get("app_id"))
 		if err != nil {
-			impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
-			return
+			return nil, err
 		}
 		metricRequest.AppId = appId
 	}
@@ -129,47 +198,476 @@ func (impl *RestHandlerImpl) GetDeploymentMetrics(w http.ResponseWriter, r *http
 		to := v.Get("to")
 		metricRequest.To = to
 	}
+	// tag=key:value, repeated for several tags
+	for _, tag := range v["tag"] {
+		parts := strings.SplitN(tag, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid tag filter %q, expected key:value", tag)
+		}
+		if metricRequest.Tags == nil {
+			metricRequest.Tags = make(map[string]string)
+		}
+		metricRequest.Tags[parts[0]] = parts[1]
+	}
+	metricRequest.GroupBy = v.Get("group_by")
+	return metricRequest, nil
+}
 
-	//err := decoder.Decode(metricRequest)
-	//if err != nil {
-	//	impl.logger.Error(err)
-	//	impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
-	//	return
-	//}
-	metrics, err := impl.deploymentMetricService.GetDeploymentMetrics(metricRequest)
+func (impl *RestHandlerImpl) GetDeploymentMetrics(w http.ResponseWriter, r *http.Request) {
+	metricRequest, err := impl.decodeMetricRequest(r)
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	metrics, err := impl.deploymentMetricService.GetDeploymentMetrics(r.Context(), metricRequest)
 	impl.logger.Infof("metrics %+v", metrics)
 	impl.writeJsonResp(w, err, metrics, 200)
 }
 
+func (impl *RestHandlerImpl) GetDailyMetrics(w http.ResponseWriter, r *http.Request) {
+	metricRequest, err := impl.decodeMetricRequest(r)
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	dailyMetrics, err := impl.deploymentMetricService.GetDailyMetrics(r.Context(), metricRequest)
+	impl.writeJsonResp(w, err, dailyMetrics, 200)
+}
+
 func (impl *RestHandlerImpl) ProcessDeploymentEvent(w http.ResponseWriter, r *http.Request) {
-	decoder := json.NewDecoder(r.Body)
+	start := time.Now()
+	metrics.EventReceived(metrics.SourceRest)
+	payload, err := io.ReadAll(r.Body)
 	deploymentEvent := &pkg.DeploymentEvent{}
-	err := decoder.Decode(deploymentEvent)
+	if err == nil {
+		err = json.Unmarshal(payload, deploymentEvent)
+	}
 	if err != nil {
 		impl.logger.Error(err)
+		metrics.EventProcessed(metrics.SourceRest, metrics.OutcomeRejected, start)
 		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
 		return
 	}
-	release, err := impl.ingestionService.ProcessDeploymentEvent(deploymentEvent)
+	err = impl.rawEventService.RecordDeploymentEvent(r.Context(), metrics.SourceRest, payload, deploymentEvent)
+	if err != nil {
+		metrics.EventProcessed(metrics.SourceRest, metrics.OutcomeFailed, start)
+		impl.writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	release, err := impl.ingestionService.ProcessDeploymentEvent(r.Context(), deploymentEvent)
+	if err != nil {
+		metrics.EventProcessed(metrics.SourceRest, metrics.OutcomeFailed, start)
+	} else {
+		metrics.EventProcessed(metrics.SourceRest, metrics.OutcomeSuccess, start)
+	}
 	impl.logger.Infow("release saved", "release", release)
 	impl.writeJsonResp(w, err, release, 200)
 }
 
-type ResetRequest struct {
-	AppId         int `json:"appId"`
-	EnvironmentId int `json:"environmentId"`
-}
-
 func (impl *RestHandlerImpl) ResetApplication(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
-	resetRequest := &ResetRequest{}
+	resetRequest := &pkg.ResetRequest{}
 	err := decoder.Decode(resetRequest)
 	if err != nil {
 		impl.logger.Error(err)
 		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
 		return
 	}
-	status, err := impl.ingestionService.CleanAppDataForEnvironment(resetRequest.AppId, resetRequest.EnvironmentId)
-	impl.logger.Infow("save", "status", status)
-	impl.writeJsonResp(w, err, status, 200)
+	resetRequest.RequestedBy = requestActor(r, resetRequest.RequestedBy)
+	resetBatch, err := impl.resetService.ResetAppEnvironment(r.Context(), resetRequest)
+	impl.logger.Infow("reset", "resetBatch", resetBatch)
+	impl.writeJsonResp(w, err, resetBatch, 200)
+}
+
+func (impl *RestHandlerImpl) RestoreApplication(w http.ResponseWriter, r *http.Request) {
+	resetBatchId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	restoreRequest := &pkg.RestoreRequest{}
+	err = decoder.Decode(restoreRequest)
+	if err != nil {
+		impl.logger.Error(err)
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	restoreRequest.ResetBatchId = resetBatchId
+	restoreRequest.RequestedBy = requestActor(r, restoreRequest.RequestedBy)
+	resetBatch, err := impl.resetService.RestoreResetBatch(r.Context(), restoreRequest)
+	impl.logger.Infow("restore", "resetBatch", resetBatch)
+	impl.writeJsonResp(w, err, resetBatch, 200)
+}
+
+func (impl *RestHandlerImpl) UpdateRelease(w http.ResponseWriter, r *http.Request) {
+	appReleaseId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	updateRequest := &pkg.ReleaseUpdateRequest{}
+	err = decoder.Decode(updateRequest)
+	if err != nil {
+		impl.logger.Error(err)
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	updateRequest.AppReleaseId = appReleaseId
+	updateRequest.Actor = requestActor(r, updateRequest.Actor)
+	release, err := impl.releaseService.UpdateRelease(r.Context(), updateRequest)
+	impl.logger.Infow("release updated", "release", release)
+	impl.writeJsonResp(w, err, release, 200)
+}
+
+func (impl *RestHandlerImpl) GetReleaseAudits(w http.ResponseWriter, r *http.Request) {
+	appReleaseId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	audits, err := impl.releaseService.GetReleaseAudits(r.Context(), appReleaseId)
+	impl.writeJsonResp(w, err, audits, 200)
+}
+
+func (impl *RestHandlerImpl) GetReleaseTimeline(w http.ResponseWriter, r *http.Request) {
+	appReleaseId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	timeline, err := impl.releaseService.GetTimeline(r.Context(), appReleaseId)
+	impl.writeJsonResp(w, err, timeline, 200)
+}
+
+func (impl *RestHandlerImpl) SaveReleaseTags(w http.ResponseWriter, r *http.Request) {
+	appReleaseId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	tags := make(map[string]string)
+	err = decoder.Decode(&tags)
+	if err != nil {
+		impl.logger.Error(err)
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	tags, err = impl.releaseService.SaveTags(r.Context(), appReleaseId, tags)
+	impl.writeJsonResp(w, err, tags, 200)
+}
+
+func (impl *RestHandlerImpl) GetReleaseTags(w http.ResponseWriter, r *http.Request) {
+	appReleaseId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	tags, err := impl.releaseService.GetTags(r.Context(), appReleaseId)
+	impl.writeJsonResp(w, err, tags, 200)
+}
+
+func (impl *RestHandlerImpl) DeleteReleaseTag(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	appReleaseId, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	err = impl.releaseService.DeleteTag(r.Context(), appReleaseId, vars["key"])
+	impl.writeJsonResp(w, err, err == nil, 200)
+}
+
+func (impl *RestHandlerImpl) AddReleaseAnnotation(w http.ResponseWriter, r *http.Request) {
+	appReleaseId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	annotationRequest := &pkg.AnnotationRequest{}
+	err = decoder.Decode(annotationRequest)
+	if err != nil {
+		impl.logger.Error(err)
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	annotationRequest.AppReleaseId = appReleaseId
+	annotationRequest.CreatedBy = requestActor(r, annotationRequest.CreatedBy)
+	annotation, err := impl.releaseService.AddAnnotation(r.Context(), annotationRequest)
+	impl.writeJsonResp(w, err, annotation, 200)
+}
+
+func (impl *RestHandlerImpl) GetReleaseAnnotations(w http.ResponseWriter, r *http.Request) {
+	appReleaseId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	annotations, err := impl.releaseService.GetAnnotations(r.Context(), appReleaseId)
+	impl.writeJsonResp(w, err, annotations, 200)
+}
+
+// systemActor is recorded for actions without an authenticated subject nor a claimed actor
+const systemActor = "system"
+
+func (impl *RestHandlerImpl) CreateApiKey(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	apiKeyRequest := &auth.ApiKeyRequest{}
+	err := decoder.Decode(apiKeyRequest)
+	if err != nil {
+		impl.logger.Error(err)
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	// with authentication disabled there is no subject, e.g. when creating the first key of a setup using keys only
+	apiKeyRequest.CreatedBy = requestActor(r, systemActor)
+	apiKey, err := impl.authService.CreateApiKey(r.Context(), apiKeyRequest)
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	impl.writeJsonResp(w, nil, apiKey, 200)
+}
+
+func (impl *RestHandlerImpl) RevokeApiKey(w http.ResponseWriter, r *http.Request) {
+	apiKeyId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	err = impl.authService.RevokeApiKey(r.Context(), apiKeyId)
+	impl.writeJsonResp(w, err, err == nil, 200)
+}
+
+// Livez only tells the process is serving, dependencies are left to Readyz
+func (impl *RestHandlerImpl) Livez(w http.ResponseWriter, r *http.Request) {
+	impl.writeJsonResp(w, nil, "OK", http.StatusOK)
+}
+
+func (impl *RestHandlerImpl) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := impl.healthService.Readiness(r.Context())
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	impl.writeJsonResp(w, nil, report, status)
+}
+
+// PurgeGitChangesCache drops cached git changes, of a single material with ?pipeline_material_id=
+func (impl *RestHandlerImpl) PurgeGitChangesCache(w http.ResponseWriter, r *http.Request) {
+	pipelineMaterialId := 0
+	if value := r.URL.Query().Get("pipeline_material_id"); value != "" {
+		var err error
+		pipelineMaterialId, err = strconv.Atoi(value)
+		if err != nil || pipelineMaterialId <= 0 {
+			impl.writeJsonResp(w, fmt.Errorf("invalid pipeline_material_id %q", value), nil, http.StatusBadRequest)
+			return
+		}
+	}
+	purged, err := impl.gitChangesCache.Purge(r.Context(), pipelineMaterialId)
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	impl.writeJsonResp(w, nil, map[string]int{"purged": purged}, http.StatusOK)
+}
+
+func (impl *RestHandlerImpl) ProcessStatusEvent(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(r.Body)
+	statusEvent := &pkg.DeploymentStatusEvent{}
+	if err == nil {
+		err = json.Unmarshal(payload, statusEvent)
+	}
+	if err != nil {
+		impl.logger.Error(err)
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	err = impl.rawEventService.RecordStatusEvent(r.Context(), metrics.SourceRest, payload, statusEvent)
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	release, err := impl.ingestionService.ProcessStatusEvent(r.Context(), statusEvent)
+	impl.writeJsonResp(w, err, release, 200)
+}
+
+// GetFailurePolicy returns the failure policy in force for an environment, 0 for the default policy
+func (impl *RestHandlerImpl) GetFailurePolicy(w http.ResponseWriter, r *http.Request) {
+	environmentId, err := strconv.Atoi(mux.Vars(r)["environmentId"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	policy, err := impl.failurePolicyService.GetPolicy(r.Context(), environmentId)
+	impl.writeJsonResp(w, err, policy, 200)
+}
+
+func (impl *RestHandlerImpl) SaveFailurePolicy(w http.ResponseWriter, r *http.Request) {
+	environmentId, err := strconv.Atoi(mux.Vars(r)["environmentId"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	policyRequest := &pkg.FailurePolicyRequest{}
+	err = decoder.Decode(policyRequest)
+	if err != nil {
+		impl.logger.Error(err)
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	policyRequest.EnvironmentId = environmentId
+	policyRequest.UpdatedBy = requestActor(r, policyRequest.UpdatedBy)
+	policy, err := impl.failurePolicyService.SavePolicy(r.Context(), policyRequest)
+	impl.writeJsonResp(w, err, policy, 200)
+}
+
+// RecomputeFailures starts classifying again the releases affected by a change of the failure policy of an
+// environment, the job is returned right away
+func (impl *RestHandlerImpl) RecomputeFailures(w http.ResponseWriter, r *http.Request) {
+	environmentId, err := strconv.Atoi(mux.Vars(r)["environmentId"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	request := &pkg.FailureRecomputeRequest{}
+	// the body is optional, the recompute used to take none
+	err = json.NewDecoder(r.Body).Decode(request)
+	if err != nil && err != io.EOF {
+		impl.logger.Error(err)
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	request.EnvironmentId = environmentId
+	request.RequestedBy = requestActor(r, request.RequestedBy)
+	if request.RequestedBy == "" {
+		request.RequestedBy = systemActor
+	}
+	job, err := impl.failurePolicyService.Recompute(r.Context(), request)
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	impl.writeJsonResp(w, nil, job, http.StatusAccepted)
+}
+
+func (impl *RestHandlerImpl) GetFailureRecomputeJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	job, err := impl.failurePolicyService.GetRecomputeJob(r.Context(), id)
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	impl.writeJsonResp(w, nil, job, http.StatusOK)
+}
+
+// Recompute starts rebuilding the releases of an app environment from its raw events, the job is returned right away
+func (impl *RestHandlerImpl) Recompute(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	request := &pkg.RecomputeRequest{}
+	err := decoder.Decode(request)
+	if err != nil {
+		impl.logger.Error(err)
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	request.RequestedBy = requestActor(r, request.RequestedBy)
+	job, err := impl.recomputeService.Recompute(r.Context(), request)
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	impl.writeJsonResp(w, nil, job, http.StatusAccepted)
+}
+
+func (impl *RestHandlerImpl) GetRecomputeJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+		return
+	}
+	job, err := impl.recomputeService.GetRecomputeJob(r.Context(), id)
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	impl.writeJsonResp(w, nil, job, http.StatusOK)
+}
+
+// StreamReleases pushes releases as ingestion creates and updates them as server-sent events, optionally of an
+// app and environment only. A client resuming with Last-Event-ID gets the events it missed, or a reset event
+// when they are no longer known and it should reload.
+func (impl *RestHandlerImpl) StreamReleases(w http.ResponseWriter, r *http.Request) {
+	filter := pkg.ReleaseStreamFilter{}
+	var err error
+	if v := r.URL.Query().Get("app_id"); v != "" {
+		if filter.AppId, err = strconv.Atoi(v); err != nil {
+			impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("env_id"); v != "" {
+		if filter.EnvironmentId, err = strconv.Atoi(v); err != nil {
+			impl.writeJsonResp(w, err, nil, http.StatusBadRequest)
+			return
+		}
+	}
+	controller := http.NewResponseController(w)
+	// the stream outlives the server write timeout
+	if err = controller.SetWriteDeadline(time.Time{}); err != nil {
+		impl.logger.Errorw("error in clearing write deadline of release stream", "err", err)
+		impl.writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	subscription, err := impl.releaseStreamService.Subscribe(filter, r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		impl.writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	defer impl.releaseStreamService.Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if subscription.Reset {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range subscription.Replay {
+		writeStreamEvent(w, event)
+	}
+	if err = controller.Flush(); err != nil {
+		return
+	}
+	heartbeat := time.NewTicker(impl.releaseStreamService.HeartbeatInterval())
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscription.Events:
+			if !ok {
+				// lagging behind, the client reconnects with Last-Event-ID
+				return
+			}
+			writeStreamEvent(w, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err = controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, event *pkg.ReleaseStreamEvent) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Id, event.Type, event.Data)
 }
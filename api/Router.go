@@ -18,6 +18,7 @@ package api
 
 import (
 	"encoding/json"
+	"github.com/devtron-labs/lens/pkg/auth"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
@@ -25,13 +26,14 @@ import (
 )
 
 type MuxRouter struct {
-	logger      *zap.SugaredLogger
-	Router      *mux.Router
-	restHandler RestHandler
+	logger         *zap.SugaredLogger
+	Router         *mux.Router
+	restHandler    RestHandler
+	authMiddleware *AuthMiddleware
 }
 
-func NewMuxRouter(logger *zap.SugaredLogger, restHandler RestHandler) *MuxRouter {
-	return &MuxRouter{logger: logger, Router: mux.NewRouter(), restHandler: restHandler}
+func NewMuxRouter(logger *zap.SugaredLogger, restHandler RestHandler, authMiddleware *AuthMiddleware) *MuxRouter {
+	return &MuxRouter{logger: logger, Router: mux.NewRouter(), restHandler: restHandler, authMiddleware: authMiddleware}
 }
 
 func (r MuxRouter) Init() {
@@ -51,10 +53,37 @@ func (r MuxRouter) Init() {
 		_, _ = writer.Write(b)
 	})
 
-	r.Router.Path("/deployment-metrics").HandlerFunc(r.restHandler.GetDeploymentMetrics).
+	r.Router.Path("/livez").HandlerFunc(r.restHandler.Livez).Methods("GET")
+	r.Router.Path("/readyz").HandlerFunc(r.restHandler.Readyz).Methods("GET")
+
+	authz := r.authMiddleware
+	r.Router.Path("/deployment-metrics").HandlerFunc(authz.Require(auth.ScopeReadMetrics, QueryAppId, r.restHandler.GetDeploymentMetrics)).
+		Queries("app_id", "{app_id}", "env_id", "{env_id}", "from", "{from}", "to", "{to}").
+		Methods("GET", "OPTIONS")
+	r.Router.Path("/deployment-metrics/daily").HandlerFunc(authz.Require(auth.ScopeReadMetrics, QueryAppId, r.restHandler.GetDailyMetrics)).
 		Queries("app_id", "{app_id}", "env_id", "{env_id}", "from", "{from}", "to", "{to}").
 		Methods("GET", "OPTIONS")
-	r.Router.Path("/new-deployment-event").HandlerFunc(r.restHandler.ProcessDeploymentEvent).Methods("POST")
-	r.Router.Path("/reset-app-environment").HandlerFunc(r.restHandler.ResetApplication).Methods("POST")
+	r.Router.Path("/new-deployment-event").HandlerFunc(authz.Require(auth.ScopeIngest, BodyAppId(DeploymentEventAppId), r.restHandler.ProcessDeploymentEvent)).Methods("POST")
+	r.Router.Path("/deployment-status-event").HandlerFunc(authz.Require(auth.ScopeIngest, BodyAppId(StatusEventAppId), r.restHandler.ProcessStatusEvent)).Methods("POST")
+	r.Router.Path("/reset-app-environment").HandlerFunc(authz.Require(auth.ScopeAdmin, BodyAppId(ResetRequestAppId), r.restHandler.ResetApplication)).Methods("POST")
+	r.Router.Path("/releases/{id:[0-9]+}").HandlerFunc(authz.Require(auth.ScopeAdmin, authz.ReleaseAppId, r.restHandler.UpdateRelease)).Methods("PATCH")
+	r.Router.Path("/releases/{id:[0-9]+}/audit").HandlerFunc(authz.Require(auth.ScopeReadMetrics, authz.ReleaseAppId, r.restHandler.GetReleaseAudits)).Methods("GET")
+	r.Router.Path("/releases/{id:[0-9]+}/timeline").HandlerFunc(authz.Require(auth.ScopeReadMetrics, authz.ReleaseAppId, r.restHandler.GetReleaseTimeline)).Methods("GET")
+	r.Router.Path("/releases/{id:[0-9]+}/tags").HandlerFunc(authz.Require(auth.ScopeIngest, authz.ReleaseAppId, r.restHandler.SaveReleaseTags)).Methods("POST")
+	r.Router.Path("/releases/{id:[0-9]+}/tags").HandlerFunc(authz.Require(auth.ScopeReadMetrics, authz.ReleaseAppId, r.restHandler.GetReleaseTags)).Methods("GET")
+	r.Router.Path("/releases/{id:[0-9]+}/tags/{key}").HandlerFunc(authz.Require(auth.ScopeIngest, authz.ReleaseAppId, r.restHandler.DeleteReleaseTag)).Methods("DELETE")
+	r.Router.Path("/releases/{id:[0-9]+}/annotations").HandlerFunc(authz.Require(auth.ScopeIngest, authz.ReleaseAppId, r.restHandler.AddReleaseAnnotation)).Methods("POST")
+	r.Router.Path("/releases/{id:[0-9]+}/annotations").HandlerFunc(authz.Require(auth.ScopeReadMetrics, authz.ReleaseAppId, r.restHandler.GetReleaseAnnotations)).Methods("GET")
+	r.Router.Path("/reset-app-environment/{id:[0-9]+}/restore").HandlerFunc(authz.Require(auth.ScopeAdmin, authz.ResetBatchAppId, r.restHandler.RestoreApplication)).Methods("POST")
+	r.Router.Path("/api-keys").HandlerFunc(authz.Require(auth.ScopeAdmin, AllApps, r.restHandler.CreateApiKey)).Methods("POST")
+	r.Router.Path("/api-keys/{id:[0-9]+}").HandlerFunc(authz.Require(auth.ScopeAdmin, AllApps, r.restHandler.RevokeApiKey)).Methods("DELETE")
+	r.Router.Path("/failure-policies/{environmentId:[0-9]+}").HandlerFunc(authz.Require(auth.ScopeReadMetrics, AllApps, r.restHandler.GetFailurePolicy)).Methods("GET")
+	r.Router.Path("/failure-policies/{environmentId:[0-9]+}").HandlerFunc(authz.Require(auth.ScopeAdmin, AllApps, r.restHandler.SaveFailurePolicy)).Methods("PUT")
+	r.Router.Path("/failure-policies/{environmentId:[0-9]+}/recompute").HandlerFunc(authz.Require(auth.ScopeAdmin, AllApps, r.restHandler.RecomputeFailures)).Methods("POST")
+	r.Router.Path("/failure-policies/recompute/{id:[0-9]+}").HandlerFunc(authz.Require(auth.ScopeAdmin, AllApps, r.restHandler.GetFailureRecomputeJob)).Methods("GET")
+	r.Router.Path("/recompute").HandlerFunc(authz.Require(auth.ScopeAdmin, BodyAppId(RecomputeRequestAppId), r.restHandler.Recompute)).Methods("POST")
+	r.Router.Path("/recompute/{id:[0-9]+}").HandlerFunc(authz.Require(auth.ScopeAdmin, authz.RecomputeJobAppId, r.restHandler.GetRecomputeJob)).Methods("GET")
+	r.Router.Path("/stream/releases").HandlerFunc(authz.Require(auth.ScopeReadMetrics, QueryAppId, r.restHandler.StreamReleases)).Methods("GET")
+	r.Router.Path("/git-changes-cache").HandlerFunc(authz.Require(auth.ScopeAdmin, AllApps, r.restHandler.PurgeGitChangesCache)).Methods("DELETE")
 
 }
@@ -16,6 +16,21 @@
 
 package bean
 
+import "github.com/caarlos0/env"
+
+const (
+	GitSensorProtocolRest  = "REST"
+	GitSensorProtocolGrpc  = "GRPC"
+	GitSensorProtocolLocal = "LOCAL"
+)
+
 type GitSensorProtocolConfig struct {
+	// Protocol selects where git changes are read from: REST, GRPC or LOCAL bare repositories
 	Protocol string `env:"GIT_SENSOR_PROTOCOL" envDefault:"REST"`
 }
+
+func GetGitSensorProtocolConfig() (*GitSensorProtocolConfig, error) {
+	cfg := &GitSensorProtocolConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
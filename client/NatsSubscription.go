@@ -17,46 +17,55 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	pubsub "github.com/devtron-labs/common-lib/pubsub-lib"
 	"github.com/devtron-labs/common-lib/pubsub-lib/model"
+	"github.com/devtron-labs/lens/internal/metrics"
+	"github.com/devtron-labs/lens/internal/tracing"
 	"github.com/devtron-labs/lens/pkg"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"sync"
+	"time"
 )
 
+// NatsSubscription consumes deployment events. The pubsub library does not hand out its subscription, so shutdown
+// cannot unsubscribe nor drain it: StopConsuming parks new deliveries instead and Close closes the connection,
+// parked and buffered messages are never acked and nats redelivers them after the consumer ack wait. Draining the
+// whole connection is not an option either, nats deletes a durable consumer created by the library on drain.
 type NatsSubscription interface {
+	// StopConsuming parks deliveries arriving from now on unacked, they are redelivered once the connection closes
+	StopConsuming()
+	// WaitInFlight blocks until deployment events being processed are done or ctx expires
+	WaitInFlight(ctx context.Context) error
+	// Close closes the nats connection and releases parked deliveries
+	Close()
 }
 
 type NatsSubscriptionImpl struct {
 	pubSubClient     *pubsub.PubSubClientServiceImpl
 	logger           *zap.SugaredLogger
 	ingestionService pkg.IngestionService
+	rawEventService  pkg.RawEventService
+	mu               sync.Mutex
+	stopping         bool
+	inFlight         sync.WaitGroup
+	releaseCh        chan struct{}
+	closeOnce        sync.Once
 }
 
 func NewNatsSubscription(pubSubClient *pubsub.PubSubClientServiceImpl,
 	logger *zap.SugaredLogger,
-	ingestionService pkg.IngestionService) (*NatsSubscriptionImpl, error) {
+	ingestionService pkg.IngestionService,
+	rawEventService pkg.RawEventService) (*NatsSubscriptionImpl, error) {
 	ns := &NatsSubscriptionImpl{
 		pubSubClient:     pubSubClient,
 		logger:           logger,
 		ingestionService: ingestionService,
-	}
-	callback := func(msg *model.PubSubMsg) {
-		ns.logger.Debugw("received msg", "msg", msg)
-		// defer msg.Ack()
-		deploymentEvent := &pkg.DeploymentEvent{}
-		err := json.Unmarshal([]byte(msg.Data), deploymentEvent)
-		if err != nil {
-			ns.logger.Errorw("err in reading msg", "err", err, "msg", string(msg.Data))
-			return
-		}
-		ns.logger.Debugw("deploymentEvent", "id", deploymentEvent)
-		release, err := ns.ingestionService.ProcessDeploymentEvent(deploymentEvent)
-		if err != nil {
-			ns.logger.Errorw("err in processing deploymentEvent", "deploymentEvent", deploymentEvent, "err", err)
-			return
-		}
-		ns.logger.Infow("app release saved ", "apprelease", release)
+		rawEventService:  rawEventService,
+		releaseCh:        make(chan struct{}),
 	}
 
 	var loggerFunc pubsub.LoggerFunc = func(msg model.PubSubMsg) (string, []interface{}) {
@@ -68,9 +77,87 @@ func NewNatsSubscription(pubSubClient *pubsub.PubSubClientServiceImpl,
 		return "got message for deployment stage completion", []interface{}{"envId", deploymentEvent.EnvironmentId, "appId", deploymentEvent.ApplicationId, "ciArtifactId", deploymentEvent.CiArtifactId}
 	}
 
-	err := pubSubClient.Subscribe(pubsub.CD_SUCCESS, callback, loggerFunc)
+	err := pubSubClient.Subscribe(pubsub.CD_SUCCESS, ns.onMessage, loggerFunc)
 	if err != nil {
 		ns.logger.Errorw("Error while subscribing to pubsub client", "topic", pubsub.CD_SUCCESS, "error", err)
 	}
 	return ns, err
 }
+
+func (ns *NatsSubscriptionImpl) onMessage(msg *model.PubSubMsg) {
+	if !ns.begin() {
+		// pubsub acks once the callback returns, hold the message until the connection is closed so the ack fails
+		// and the message is redelivered instead of being dropped
+		<-ns.releaseCh
+		return
+	}
+	defer ns.inFlight.Done()
+	start := time.Now()
+	metrics.EventReceived(metrics.SourceNats)
+	ns.logger.Debugw("received msg", "msg", msg)
+	deploymentEvent := &pkg.DeploymentEvent{}
+	err := json.Unmarshal([]byte(msg.Data), deploymentEvent)
+	if err != nil {
+		ns.logger.Errorw("err in reading msg", "err", err, "msg", string(msg.Data))
+		metrics.EventProcessed(metrics.SourceNats, metrics.OutcomeRejected, start)
+		return
+	}
+	ns.logger.Debugw("deploymentEvent", "id", deploymentEvent)
+	ctx := tracing.Extract(context.Background(), deploymentEvent.TraceContext)
+	err = ns.rawEventService.RecordDeploymentEvent(ctx, metrics.SourceNats, []byte(msg.Data), deploymentEvent)
+	if err != nil {
+		metrics.EventProcessed(metrics.SourceNats, metrics.OutcomeFailed, start)
+		return
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "nats.consume "+pubsub.CD_SUCCESS, trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("messaging.system", "nats"), attribute.String("messaging.message.id", msg.MsgId)))
+	release, err := ns.ingestionService.ProcessDeploymentEvent(ctx, deploymentEvent)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		ns.logger.Errorw("err in processing deploymentEvent", "deploymentEvent", deploymentEvent, "err", err)
+		metrics.EventProcessed(metrics.SourceNats, metrics.OutcomeFailed, start)
+		return
+	}
+	metrics.EventProcessed(metrics.SourceNats, metrics.OutcomeSuccess, start)
+	ns.logger.Infow("app release saved ", "apprelease", release)
+}
+
+func (ns *NatsSubscriptionImpl) begin() bool {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if ns.stopping {
+		return false
+	}
+	ns.inFlight.Add(1)
+	return true
+}
+
+func (ns *NatsSubscriptionImpl) StopConsuming() {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.stopping = true
+}
+
+func (ns *NatsSubscriptionImpl) WaitInFlight(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		ns.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the connection without draining it, see NatsSubscription
+func (ns *NatsSubscriptionImpl) Close() {
+	ns.closeOnce.Do(func() {
+		if ns.pubSubClient != nil && ns.pubSubClient.NatsClient != nil {
+			ns.pubSubClient.NatsClient.Conn.Close()
+		}
+		close(ns.releaseCh)
+	})
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/common-lib/pubsub-lib/model"
+	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/pkg"
+	"go.uber.org/zap"
+)
+
+type blockingIngestionService struct {
+	started   chan struct{}
+	release   chan struct{}
+	processed atomic.Int32
+}
+
+func (b *blockingIngestionService) ProcessDeploymentEvent(ctx context.Context, deploymentEvent *pkg.DeploymentEvent) (*sql.AppRelease, error) {
+	b.started <- struct{}{}
+	<-b.release
+	b.processed.Add(1)
+	return &sql.AppRelease{}, nil
+}
+
+func (b *blockingIngestionService) ProcessStatusEvent(ctx context.Context, statusEvent *pkg.DeploymentStatusEvent) (*sql.AppRelease, error) {
+	return &sql.AppRelease{}, nil
+}
+
+func (b *blockingIngestionService) ReprocessRelease(ctx context.Context, appRelease *sql.AppRelease) (*sql.AppRelease, error) {
+	return appRelease, nil
+}
+
+type nopRawEventService struct{}
+
+func (nopRawEventService) RecordDeploymentEvent(ctx context.Context, source string, payload []byte, deploymentEvent *pkg.DeploymentEvent) error {
+	return nil
+}
+
+func (nopRawEventService) RecordStatusEvent(ctx context.Context, source string, payload []byte, statusEvent *pkg.DeploymentStatusEvent) error {
+	return nil
+}
+
+func TestNatsSubscription_Drain(t *testing.T) {
+	ingestionService := &blockingIngestionService{started: make(chan struct{}, 2), release: make(chan struct{})}
+	ns := &NatsSubscriptionImpl{logger: zap.NewNop().Sugar(), ingestionService: ingestionService, rawEventService: nopRawEventService{}, releaseCh: make(chan struct{})}
+	msg := &model.PubSubMsg{Data: `{"ApplicationId": 1}`}
+
+	inFlightDone := make(chan struct{})
+	go func() {
+		ns.onMessage(msg)
+		close(inFlightDone)
+	}()
+	<-ingestionService.started
+	ns.StopConsuming()
+
+	parkedDone := make(chan struct{})
+	go func() {
+		ns.onMessage(msg)
+		close(parkedDone)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ns.WaitInFlight(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WaitInFlight() = %v, want deadline exceeded while event is processed", err)
+	}
+
+	close(ingestionService.release)
+	<-inFlightDone
+	if err := ns.WaitInFlight(context.Background()); err != nil {
+		t.Fatalf("WaitInFlight() = %v", err)
+	}
+	select {
+	case <-parkedDone:
+		t.Fatal("message delivered after StopConsuming returned before Close")
+	default:
+	}
+
+	ns.Close()
+	<-parkedDone
+	if got := ingestionService.processed.Load(); got != 1 {
+		t.Errorf("processed = %d, want only the in-flight event", got)
+	}
+}
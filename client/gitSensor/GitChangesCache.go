@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gitSensor
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/lens/internal/metrics"
+	"github.com/devtron-labs/lens/internal/sql"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+type GitChangesCacheConfig struct {
+	Enabled bool `env:"GIT_CHANGES_CACHE_ENABLED" envDefault:"true"`
+	// MemoryEntries bounds the in-memory LRU in front of postgres, 0 disables it
+	MemoryEntries int `env:"GIT_CHANGES_CACHE_MEMORY_ENTRIES" envDefault:"1000"`
+	// MaxEntries bounds the entries kept in postgres, the least recently used are evicted, 0 keeps all
+	MaxEntries int `env:"GIT_CHANGES_CACHE_MAX_ENTRIES" envDefault:"100000"`
+	// MaxEntryBytes skips caching changes larger than this once serialized
+	MaxEntryBytes     int `env:"GIT_CHANGES_CACHE_MAX_ENTRY_BYTES" envDefault:"1048576"`
+	EvictIntervalSecs int `env:"GIT_CHANGES_CACHE_EVICT_INTERVAL_SECS" envDefault:"60"`
+}
+
+func GetGitChangesCacheConfig() (*GitChangesCacheConfig, error) {
+	cfg := &GitChangesCacheConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// GitChangesCache serves the changes of a commit range from an in-memory LRU, then postgres, before asking
+// the provider. The changes between two commits never change, so entries are only dropped for size or by a purge.
+type GitChangesCache interface {
+	GitChangesProvider
+	// Purge drops the cached changes of a pipeline material, of all materials for 0, returns the purged postgres entries
+	Purge(ctx context.Context, pipelineMaterialId int) (int, error)
+}
+
+type GitChangesCacheImpl struct {
+	logger       *zap.SugaredLogger
+	config       *GitChangesCacheConfig
+	provider     GitChangesProvider
+	repository   sql.GitChangesCacheRepository
+	mu           sync.Mutex
+	memory       *lruCache
+	lastEviction time.Time
+}
+
+func NewGitChangesCacheImpl(logger *zap.SugaredLogger,
+	config *GitChangesCacheConfig,
+	provider GitChangesProvider,
+	repository sql.GitChangesCacheRepository) *GitChangesCacheImpl {
+	return &GitChangesCacheImpl{
+		logger:     logger,
+		config:     config,
+		provider:   provider,
+		repository: repository,
+		memory:     newLruCache(config.MemoryEntries),
+	}
+}
+
+// GetReleaseChanges returns shared cached changes, callers must not modify them
+func (impl *GitChangesCacheImpl) GetReleaseChanges(ctx context.Context, request *ReleaseChangesRequest) (*GitChanges, error) {
+	// only ranges between commit hashes are immutable, a branch or tag name may move
+	if !impl.config.Enabled || !isCommitHash(request.OldCommit) || !isCommitHash(request.NewCommit) {
+		return impl.provider.GetReleaseChanges(ctx, request)
+	}
+	key := gitChangesCacheKey(request)
+	if impl.memory.capacity > 0 {
+		impl.mu.Lock()
+		changes, ok := impl.memory.get(key)
+		impl.mu.Unlock()
+		metrics.GitChangesCacheLookup(metrics.CacheTierMemory, ok)
+		if ok {
+			return changes, nil
+		}
+	}
+
+	entry, err := impl.repository.FindByKey(ctx, key)
+	if err == nil {
+		changes := &GitChanges{}
+		if err = json.Unmarshal(entry.Changes, changes); err == nil {
+			metrics.GitChangesCacheLookup(metrics.CacheTierPostgres, true)
+			impl.remember(key, request.PipelineMaterialId, changes)
+			return changes, nil
+		}
+		impl.logger.Errorw("error in decoding cached git changes", "cacheKey", key, "err", err)
+	} else if !errors.Is(err, pg.ErrNoRows) {
+		// the cache is an optimisation, its failures fall through to the provider
+		impl.logger.Warnw("error in reading git changes cache", "cacheKey", key, "err", err)
+	}
+	metrics.GitChangesCacheLookup(metrics.CacheTierPostgres, false)
+
+	changes, err := impl.provider.GetReleaseChanges(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	impl.store(ctx, key, request, changes)
+	return changes, nil
+}
+
+func (impl *GitChangesCacheImpl) Ping(ctx context.Context) error {
+	return impl.provider.Ping(ctx)
+}
+
+func (impl *GitChangesCacheImpl) Purge(ctx context.Context, pipelineMaterialId int) (int, error) {
+	impl.mu.Lock()
+	impl.memory.purge(pipelineMaterialId)
+	impl.mu.Unlock()
+	purged, err := impl.repository.Purge(ctx, pipelineMaterialId)
+	if err != nil {
+		impl.logger.Errorw("error in purging git changes cache", "pipelineMaterialId", pipelineMaterialId, "err", err)
+		return 0, err
+	}
+	impl.logger.Infow("git changes cache purged", "pipelineMaterialId", pipelineMaterialId, "count", purged)
+	return purged, nil
+}
+
+func (impl *GitChangesCacheImpl) remember(key string, pipelineMaterialId int, changes *GitChanges) {
+	impl.mu.Lock()
+	evicted := impl.memory.add(key, pipelineMaterialId, changes)
+	impl.mu.Unlock()
+	if evicted > 0 {
+		metrics.GitChangesCacheEvicted(metrics.CacheTierMemory, evicted)
+	}
+}
+
+func (impl *GitChangesCacheImpl) store(ctx context.Context, key string, request *ReleaseChangesRequest, changes *GitChanges) {
+	payload, err := json.Marshal(changes)
+	if err != nil {
+		impl.logger.Errorw("error in encoding git changes", "cacheKey", key, "err", err)
+		return
+	}
+	if impl.config.MaxEntryBytes > 0 && len(payload) > impl.config.MaxEntryBytes {
+		impl.logger.Infow("git changes too large to cache", "pipelineMaterialId", request.PipelineMaterialId, "bytes", len(payload))
+		return
+	}
+	impl.remember(key, request.PipelineMaterialId, changes)
+	now := time.Now()
+	err = impl.repository.Save(ctx, &sql.GitChangesCache{
+		CacheKey:           key,
+		PipelineMaterialId: request.PipelineMaterialId,
+		OldCommit:          request.OldCommit,
+		NewCommit:          request.NewCommit,
+		Changes:            payload,
+		SizeBytes:          len(payload),
+		CreatedOn:          now,
+		LastAccessedOn:     now,
+	})
+	if err != nil {
+		impl.logger.Warnw("error in saving git changes cache", "cacheKey", key, "err", err)
+		return
+	}
+	impl.evict(ctx, now)
+}
+
+// evict trims postgres to MaxEntries at most once per EvictIntervalSecs
+func (impl *GitChangesCacheImpl) evict(ctx context.Context, now time.Time) {
+	if impl.config.MaxEntries <= 0 {
+		return
+	}
+	impl.mu.Lock()
+	due := now.Sub(impl.lastEviction) >= time.Duration(impl.config.EvictIntervalSecs)*time.Second
+	if due {
+		impl.lastEviction = now
+	}
+	impl.mu.Unlock()
+	if !due {
+		return
+	}
+	evicted, err := impl.repository.EvictLeastRecentlyUsed(ctx, impl.config.MaxEntries)
+	if err != nil {
+		impl.logger.Warnw("error in evicting git changes cache", "err", err)
+		return
+	}
+	if evicted > 0 {
+		metrics.GitChangesCacheEvicted(metrics.CacheTierPostgres, evicted)
+	}
+}
+
+// gitChangesCacheKey addresses the changes of a commit range by content, the sha256 of material and commits
+func gitChangesCacheKey(request *ReleaseChangesRequest) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\x00%s\x00%s", request.PipelineMaterialId, request.OldCommit, request.NewCommit)))
+	return hex.EncodeToString(sum[:])
+}
+
+// isCommitHash is true for full sha1 or sha256 commit hashes
+func isCommitHash(value string) bool {
+	if len(value) != 40 && len(value) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+type lruEntry struct {
+	key                string
+	pipelineMaterialId int
+	changes            *GitChanges
+}
+
+// lruCache keeps the most recently used capacity entries, it is not safe for concurrent use
+type lruCache struct {
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+func newLruCache(capacity int) *lruCache {
+	return &lruCache{capacity: capacity, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (cache *lruCache) get(key string) (*GitChanges, bool) {
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(*lruEntry).changes, true
+}
+
+// add returns the number of entries evicted to make room
+func (cache *lruCache) add(key string, pipelineMaterialId int, changes *GitChanges) int {
+	if cache.capacity <= 0 {
+		return 0
+	}
+	if element, ok := cache.entries[key]; ok {
+		element.Value.(*lruEntry).changes = changes
+		cache.order.MoveToFront(element)
+		return 0
+	}
+	cache.entries[key] = cache.order.PushFront(&lruEntry{key: key, pipelineMaterialId: pipelineMaterialId, changes: changes})
+	evicted := 0
+	for cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*lruEntry).key)
+		evicted++
+	}
+	return evicted
+}
+
+// purge drops the entries of a pipeline material, all entries for 0
+func (cache *lruCache) purge(pipelineMaterialId int) {
+	for element := cache.order.Front(); element != nil; {
+		next := element.Next()
+		if entry := element.Value.(*lruEntry); pipelineMaterialId == 0 || entry.pipelineMaterialId == pipelineMaterialId {
+			cache.order.Remove(element)
+			delete(cache.entries, entry.key)
+		}
+		element = next
+	}
+}
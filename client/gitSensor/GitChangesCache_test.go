@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gitSensor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/lens/internal/sql"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+type countingProvider struct {
+	calls   int
+	changes *GitChanges
+}
+
+func (provider *countingProvider) GetReleaseChanges(ctx context.Context, request *ReleaseChangesRequest) (*GitChanges, error) {
+	provider.calls++
+	return provider.changes, nil
+}
+
+func (provider *countingProvider) Ping(ctx context.Context) error {
+	return nil
+}
+
+type memoryCacheRepository struct {
+	entries map[string]*sql.GitChangesCache
+}
+
+func (repository *memoryCacheRepository) FindByKey(ctx context.Context, cacheKey string) (*sql.GitChangesCache, error) {
+	entry, ok := repository.entries[cacheKey]
+	if !ok {
+		return nil, pg.ErrNoRows
+	}
+	return entry, nil
+}
+
+func (repository *memoryCacheRepository) Save(ctx context.Context, entry *sql.GitChangesCache) error {
+	repository.entries[entry.CacheKey] = entry
+	return nil
+}
+
+func (repository *memoryCacheRepository) Purge(ctx context.Context, pipelineMaterialId int) (int, error) {
+	purged := 0
+	for key, entry := range repository.entries {
+		if pipelineMaterialId == 0 || entry.PipelineMaterialId == pipelineMaterialId {
+			delete(repository.entries, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (repository *memoryCacheRepository) EvictLeastRecentlyUsed(ctx context.Context, keep int) (int, error) {
+	return 0, nil
+}
+
+func TestGitChangesCache_GetReleaseChanges(t *testing.T) {
+	provider := &countingProvider{changes: &GitChanges{
+		Commits:   []*Commit{{Hash: &Hash{Long: strings.Repeat("b", 40)}, Author: &Author{Date: time.Unix(1700000000, 0).UTC()}}},
+		FileStats: FileStats{{Name: "main.go", Addition: 3, Deletion: 1}},
+	}}
+	repository := &memoryCacheRepository{entries: make(map[string]*sql.GitChangesCache)}
+	config := &GitChangesCacheConfig{Enabled: true, MemoryEntries: 1, MaxEntryBytes: 1 << 20}
+	cache := NewGitChangesCacheImpl(zap.NewNop().Sugar(), config, provider, repository)
+	request := &ReleaseChangesRequest{PipelineMaterialId: 9, OldCommit: strings.Repeat("a", 40), NewCommit: strings.Repeat("b", 40)}
+	other := &ReleaseChangesRequest{PipelineMaterialId: 10, OldCommit: strings.Repeat("c", 40), NewCommit: strings.Repeat("d", 40)}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.GetReleaseChanges(context.Background(), request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if provider.calls != 1 || len(repository.entries) != 1 {
+		t.Fatalf("expected one provider call and one stored entry, got %d calls and %d entries", provider.calls, len(repository.entries))
+	}
+
+	// other pushes request out of the single entry LRU, it is then served from postgres
+	if _, err := cache.GetReleaseChanges(context.Background(), other); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := cache.GetReleaseChanges(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls != 2 {
+		t.Fatalf("expected postgres hit, provider called %d times", provider.calls)
+	}
+	if changes.FileStats[0].Addition != 3 || !changes.Commits[0].Author.Date.Equal(provider.changes.Commits[0].Author.Date) {
+		t.Fatalf("unexpected changes from postgres %+v", changes)
+	}
+
+	purged, err := cache.Purge(context.Background(), 9)
+	if err != nil || purged != 1 {
+		t.Fatalf("purged %d, err %v", purged, err)
+	}
+	if _, err = cache.GetReleaseChanges(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls != 3 {
+		t.Fatalf("expected a provider call after purge, got %d calls", provider.calls)
+	}
+
+	// branch names may move and are never cached
+	branch := &ReleaseChangesRequest{PipelineMaterialId: 9, OldCommit: "main", NewCommit: strings.Repeat("b", 40)}
+	for i := 0; i < 2; i++ {
+		if _, err = cache.GetReleaseChanges(context.Background(), branch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if provider.calls != 5 {
+		t.Fatalf("expected uncached calls for a branch range, got %d calls", provider.calls)
+	}
+}
+
+func TestGitChangesCache_MaxEntryBytes(t *testing.T) {
+	provider := &countingProvider{changes: &GitChanges{FileStats: FileStats{{Name: strings.Repeat("x", 100)}}}}
+	repository := &memoryCacheRepository{entries: make(map[string]*sql.GitChangesCache)}
+	cache := NewGitChangesCacheImpl(zap.NewNop().Sugar(), &GitChangesCacheConfig{Enabled: true, MemoryEntries: 10, MaxEntryBytes: 50}, provider, repository)
+	request := &ReleaseChangesRequest{PipelineMaterialId: 9, OldCommit: strings.Repeat("a", 40), NewCommit: strings.Repeat("b", 40)}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.GetReleaseChanges(context.Background(), request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if provider.calls != 2 || len(repository.entries) != 0 {
+		t.Fatalf("oversized changes were cached, %d calls and %d entries", provider.calls, len(repository.entries))
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gitSensor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/devtron-labs/lens/bean"
+	pb "github.com/devtron-labs/protos/gitSensor"
+)
+
+// GitChangesProvider reads the commits and file stats between two commits of a pipeline material.
+// git-sensor over REST or gRPC and local bare repositories are interchangeable implementations.
+type GitChangesProvider interface {
+	GetReleaseChanges(ctx context.Context, request *ReleaseChangesRequest) (*GitChanges, error)
+	// Ping checks that the provider can serve changes
+	Ping(ctx context.Context) error
+}
+
+// NewGitChangesProvider selects the provider configured by GIT_SENSOR_PROTOCOL
+func NewGitChangesProvider(protocolConfig *bean.GitSensorProtocolConfig,
+	restClient *GitSensorClientImpl,
+	grpcClient *GitSensorGrpcClientImpl,
+	localProvider *LocalGitChangesProviderImpl) (GitChangesProvider, error) {
+	switch protocolConfig.Protocol {
+	case bean.GitSensorProtocolRest, "":
+		return restClient, nil
+	case bean.GitSensorProtocolGrpc:
+		return grpcClient, nil
+	case bean.GitSensorProtocolLocal:
+		if localProvider.RepositoryCount() == 0 {
+			return nil, fmt.Errorf("GIT_SENSOR_PROTOCOL is %s but GIT_LOCAL_REPOS is empty", bean.GitSensorProtocolLocal)
+		}
+		return localProvider, nil
+	default:
+		return nil, fmt.Errorf("unknown GIT_SENSOR_PROTOCOL %q", protocolConfig.Protocol)
+	}
+}
+
+// GetReleaseChanges makes the grpc client a GitChangesProvider
+func (client *GitSensorGrpcClientImpl) GetReleaseChanges(ctx context.Context, request *ReleaseChangesRequest) (*GitChanges, error) {
+	return client.GetChangesInRelease(ctx, &pb.ReleaseChangeRequest{
+		PipelineMaterialId: int64(request.PipelineMaterialId),
+		OldCommit:          request.OldCommit,
+		NewCommit:          request.NewCommit,
+	})
+}
@@ -18,6 +18,7 @@ package gitSensor
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -28,17 +29,23 @@ import (
 	"time"
 
 	"github.com/caarlos0/env"
+	"github.com/devtron-labs/lens/internal/metrics"
+	"github.com/devtron-labs/lens/internal/tracing"
 	"go.uber.org/zap"
 )
 
 type GitSensorClient interface {
-	GetReleaseChanges(request *ReleaseChangesRequest) (*GitChanges, error)
+	GetReleaseChanges(ctx context.Context, request *ReleaseChangesRequest) (*GitChanges, error)
+	// Ping checks that git-sensor answers on its health endpoint
+	Ping(ctx context.Context) error
 }
 
 type GitSensorClientImpl struct {
 	httpClient *http.Client
 	logger     *zap.SugaredLogger
 	baseUrl    *url.URL
+	timeout    time.Duration
+	resilience *Resilience
 }
 
 func GetGitSensorConfig() (*GitSensorConfig, error) {
@@ -50,9 +57,12 @@ func GetGitSensorConfig() (*GitSensorConfig, error) {
 // ----------------------impl
 type GitSensorConfig struct {
 	Url     string `env:"GIT_SENSOR_URL" envDefault:"http://localhost:9999"`
-	Timeout int    `env:"GIT_SENSOR_TIMEOUT" envDefault:"0"` // in seconds
+	Timeout int    `env:"GIT_SENSOR_TIMEOUT" envDefault:"0"` // in seconds, per call, 0 leaves calls bounded by the caller's deadline only
 }
 
+// operationReleaseChanges labels release change calls in metrics
+const operationReleaseChanges = "release-changes"
+
 type StatusCode int
 
 func (code StatusCode) IsSuccess() bool {
@@ -66,71 +76,129 @@ type ClientRequest struct {
 	ResponseBody interface{}
 }
 
-func (session *GitSensorClientImpl) doRequest(clientRequest *ClientRequest) (resBody []byte, resCode *StatusCode, err error) {
+// doRequest calls git-sensor once, failures are GitSensorError typed as retryable or permanent
+func (session *GitSensorClientImpl) doRequest(ctx context.Context, clientRequest *ClientRequest) (resBody []byte, resCode *StatusCode, err error) {
 	if clientRequest.ResponseBody == nil {
-		return nil, nil, fmt.Errorf("responce body cant be nil")
+		return nil, nil, permanent(metrics.ProtocolRest, fmt.Errorf("responce body cant be nil"))
 	}
 	if reflect.ValueOf(clientRequest.ResponseBody).Kind() != reflect.Ptr {
-		return nil, nil, fmt.Errorf("responsebody non pointer")
+		return nil, nil, permanent(metrics.ProtocolRest, fmt.Errorf("responsebody non pointer"))
 	}
 	rel, err := session.baseUrl.Parse(clientRequest.Path)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, permanent(metrics.ProtocolRest, err)
 	}
 	var body io.Reader
 	if clientRequest.RequestBody != nil {
 		if req, err := json.Marshal(clientRequest.RequestBody); err != nil {
-			return nil, nil, err
+			return nil, nil, permanent(metrics.ProtocolRest, err)
 		} else {
 			session.logger.Infow("argo req with body", "body", string(req))
 			body = bytes.NewBuffer(req)
 		}
 
 	}
-	httpReq, err := http.NewRequest(clientRequest.Method, rel.String(), body)
+	httpReq, err := http.NewRequestWithContext(ctx, clientRequest.Method, rel.String(), body)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, permanent(metrics.ProtocolRest, err)
 	}
 	httpRes, err := session.httpClient.Do(httpReq)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, retryLater(metrics.ProtocolRest, err)
 	}
 	defer httpRes.Body.Close()
 	resBody, err = ioutil.ReadAll(httpRes.Body)
 	if err != nil {
 		session.logger.Errorw("error in git communication ", "err", err)
-		return nil, nil, err
+		return nil, nil, retryLater(metrics.ProtocolRest, err)
 	}
 	status := StatusCode(httpRes.StatusCode)
-	if status.IsSuccess() {
-		apiRes := &GitSensorResponse{}
-		err = json.Unmarshal(resBody, apiRes)
-		if apiStatus := StatusCode(apiRes.Code); apiStatus.IsSuccess() {
-			err = json.Unmarshal(apiRes.Result, clientRequest.ResponseBody)
-			return resBody, &apiStatus, err
-		} else {
-			session.logger.Infow("api err", "res", apiRes.Errors)
-			return resBody, &apiStatus, fmt.Errorf("err in api res")
-		}
-	} else {
+	if !status.IsSuccess() {
 		session.logger.Infow("api err", "res", string(resBody))
-		return resBody, &status, fmt.Errorf("res not success, code: %d ", status)
+		err = fmt.Errorf("res not success, code: %d ", status)
+		if isRetryableStatus(int(status)) {
+			return resBody, &status, retryLater(metrics.ProtocolRest, err)
+		}
+		return resBody, &status, permanent(metrics.ProtocolRest, err)
+	}
+	apiRes := &GitSensorResponse{}
+	err = json.Unmarshal(resBody, apiRes)
+	if err != nil {
+		return resBody, &status, permanent(metrics.ProtocolRest, err)
+	}
+	apiStatus := StatusCode(apiRes.Code)
+	if !apiStatus.IsSuccess() {
+		session.logger.Infow("api err", "res", apiRes.Errors)
+		err = fmt.Errorf("err in api res, code: %d", apiStatus)
+		if isRetryableStatus(int(apiStatus)) {
+			return resBody, &apiStatus, retryLater(metrics.ProtocolRest, err)
+		}
+		return resBody, &apiStatus, permanent(metrics.ProtocolRest, err)
+	}
+	err = json.Unmarshal(apiRes.Result, clientRequest.ResponseBody)
+	if err != nil {
+		return resBody, &apiStatus, permanent(metrics.ProtocolRest, err)
 	}
-	return resBody, &status, err
+	return resBody, &apiStatus, nil
 }
 
-func NewGitSensorSession(config *GitSensorConfig, logger *zap.SugaredLogger) (session *GitSensorClientImpl, err error) {
+func NewGitSensorSession(config *GitSensorConfig, resilienceConfig *ResilienceConfig, logger *zap.SugaredLogger) (session *GitSensorClientImpl, err error) {
 	baseUrl, err := url.Parse(config.Url)
 	if err != nil {
 		return nil, err
 	}
-	client := &http.Client{Timeout: time.Duration(config.Timeout)}
-	return &GitSensorClientImpl{httpClient: client, logger: logger, baseUrl: baseUrl}, nil
+	client := &http.Client{Transport: tracing.NewTransport(http.DefaultTransport, "git-sensor")}
+	return &GitSensorClientImpl{
+		httpClient: client,
+		logger:     logger,
+		baseUrl:    baseUrl,
+		timeout:    time.Duration(config.Timeout) * time.Second,
+		resilience: NewResilience(logger, resilienceConfig, metrics.ProtocolRest),
+	}, nil
 }
 
-func (session GitSensorClientImpl) GetReleaseChanges(req *ReleaseChangesRequest) (changes *GitChanges, err error) {
-	changes = new(GitChanges)
-	request := &ClientRequest{ResponseBody: changes, Method: "POST", RequestBody: req, Path: "release/changes"}
-	_, _, err = session.doRequest(request)
-	return changes, err
+func (session GitSensorClientImpl) GetReleaseChanges(ctx context.Context, req *ReleaseChangesRequest) (changes *GitChanges, err error) {
+	err = session.resilience.Do(ctx, func(ctx context.Context) error {
+		changes = new(GitChanges)
+		request := &ClientRequest{ResponseBody: changes, Method: "POST", RequestBody: req, Path: "release/changes"}
+		ctx, cancel := withCallTimeout(ctx, session.timeout)
+		defer cancel()
+		start := time.Now()
+		_, _, err := session.doRequest(ctx, request)
+		metrics.GitSensorCallDone(metrics.ProtocolRest, operationReleaseChanges, time.Since(start), err)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func (session GitSensorClientImpl) Ping(ctx context.Context) error {
+	rel, err := session.baseUrl.Parse("health")
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, rel.String(), nil)
+	if err != nil {
+		return err
+	}
+	httpRes, err := session.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close()
+	_, _ = io.Copy(io.Discard, httpRes.Body)
+	if status := StatusCode(httpRes.StatusCode); !status.IsSuccess() {
+		return fmt.Errorf("git-sensor health returned %d", status)
+	}
+	return nil
+}
+
+// withCallTimeout bounds a single git-sensor call by timeout, when set
+func withCallTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
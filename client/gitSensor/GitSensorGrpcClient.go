@@ -20,11 +20,14 @@ import (
 	"context"
 	"fmt"
 	"github.com/caarlos0/env"
+	"github.com/devtron-labs/lens/internal/metrics"
 	pb "github.com/devtron-labs/protos/gitSensor"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
+	"sync"
 	"time"
 )
 
@@ -34,33 +37,71 @@ const (
 
 type GitSensorGrpcClient interface {
 	GetChangesInRelease(ctx context.Context, req *pb.ReleaseChangeRequest) (*GitChanges, error)
+	// Ping waits for the grpc connection to git-sensor to become ready
+	Ping(ctx context.Context) error
 }
 
 type GitSensorGrpcClientImpl struct {
 	logger        *zap.SugaredLogger
 	config        *GitSensorGrpcClientConfig
+	resilience    *Resilience
+	restFallback  GitSensorClient
+	mu            sync.Mutex
+	conn          *grpc.ClientConn
 	serviceClient pb.GitSensorServiceClient
 }
 
-func NewGitSensorGrpcClientImpl(logger *zap.SugaredLogger, config *GitSensorGrpcClientConfig) *GitSensorGrpcClientImpl {
-	return &GitSensorGrpcClientImpl{
-		logger: logger,
-		config: config,
+func NewGitSensorGrpcClientImpl(logger *zap.SugaredLogger, config *GitSensorGrpcClientConfig, resilienceConfig *ResilienceConfig) (*GitSensorGrpcClientImpl, error) {
+	client := &GitSensorGrpcClientImpl{
+		logger:     logger,
+		config:     config,
+		resilience: NewResilience(logger, resilienceConfig, metrics.ProtocolGrpc),
 	}
+	if resilienceConfig.RestFallbackUrl != "" {
+		restFallback, err := NewGitSensorSession(&GitSensorConfig{Url: resilienceConfig.RestFallbackUrl, Timeout: config.Timeout}, resilienceConfig, logger)
+		if err != nil {
+			logger.Errorw("error in creating git-sensor rest fallback", "url", resilienceConfig.RestFallbackUrl, "err", err)
+			return nil, err
+		}
+		client.restFallback = restFallback
+	}
+	return client, nil
 }
 
 // getGitSensorServiceClient initializes and returns gRPC GitSensorService client
 func (client *GitSensorGrpcClientImpl) getGitSensorServiceClient() (pb.GitSensorServiceClient, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
 	if client.serviceClient == nil {
 		conn, err := client.getConnection()
 		if err != nil {
 			return nil, err
 		}
+		client.conn = conn
 		client.serviceClient = pb.NewGitSensorServiceClient(conn)
 	}
 	return client.serviceClient, nil
 }
 
+func (client *GitSensorGrpcClientImpl) Ping(ctx context.Context) error {
+	if _, err := client.getGitSensorServiceClient(); err != nil {
+		return err
+	}
+	client.mu.Lock()
+	conn := client.conn
+	client.mu.Unlock()
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("git-sensor grpc connection %s", state)
+		}
+	}
+}
+
 // getConnection initializes and returns a grpc client connection
 func (client *GitSensorGrpcClientImpl) getConnection() (*grpc.ClientConn, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), ContextTimeoutInSeconds*time.Second)
@@ -88,7 +129,8 @@ func (client *GitSensorGrpcClientImpl) getConnection() (*grpc.ClientConn, error)
 }
 
 type GitSensorGrpcClientConfig struct {
-	Url string `env:"GIT_SENSOR_URL" envDefault:"127.0.0.1:7070"`
+	Url     string `env:"GIT_SENSOR_URL" envDefault:"127.0.0.1:7070"`
+	Timeout int    `env:"GIT_SENSOR_TIMEOUT" envDefault:"0"` // in seconds, per call
 }
 
 // GetConfig parses and returns GitSensor gRPC client configuration
@@ -98,20 +140,43 @@ func GetConfig() (*GitSensorGrpcClientConfig, error) {
 	return cfg, err
 }
 
+// GetChangesInRelease calls git-sensor with retries, falling back to REST when configured and grpc stays unavailable
 func (client *GitSensorGrpcClientImpl) GetChangesInRelease(ctx context.Context, req *pb.ReleaseChangeRequest) (
 	*GitChanges, error) {
 
+	var changes *GitChanges
+	err := client.resilience.Do(ctx, func(ctx context.Context) error {
+		var err error
+		changes, err = client.getChangesInRelease(ctx, req)
+		return err
+	})
+	if err != nil && IsRetryLater(err) && client.restFallback != nil && ctx.Err() == nil {
+		client.logger.Warnw("git-sensor grpc unavailable, falling back to rest", "pipelineMaterialId", req.PipelineMaterialId, "err", err)
+		return client.restFallback.GetReleaseChanges(ctx, &ReleaseChangesRequest{
+			PipelineMaterialId: int(req.PipelineMaterialId),
+			OldCommit:          req.OldCommit,
+			NewCommit:          req.NewCommit,
+		})
+	}
+	return changes, err
+}
+
+func (client *GitSensorGrpcClientImpl) getChangesInRelease(ctx context.Context, req *pb.ReleaseChangeRequest) (*GitChanges, error) {
 	serviceClient, err := client.getGitSensorServiceClient()
 	if err != nil {
-		return nil, nil
+		return nil, retryLater(metrics.ProtocolGrpc, err)
 	}
 
+	ctx, cancel := withCallTimeout(ctx, time.Duration(client.config.Timeout)*time.Second)
+	defer cancel()
+	start := time.Now()
 	res, err := serviceClient.GetChangesInRelease(ctx, req)
+	metrics.GitSensorCallDone(metrics.ProtocolGrpc, operationReleaseChanges, time.Since(start), err)
 	if err != nil {
-		return nil, err
+		return nil, classifyGrpcError(err)
 	}
 	if res == nil {
-		return nil, nil
+		return nil, permanent(metrics.ProtocolGrpc, fmt.Errorf("empty release changes for material %d", req.PipelineMaterialId))
 	}
 
 	// map res
@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gitSensor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/lens/internal/metrics"
+	"go.uber.org/zap"
+)
+
+type LocalGitConfig struct {
+	// Repos maps pipeline materials to local bare repositories as pipelineMaterialId:path
+	Repos     []string `env:"GIT_LOCAL_REPOS" envSeparator:","`
+	GitBinary string   `env:"GIT_LOCAL_BINARY" envDefault:"git"`
+	Timeout   int      `env:"GIT_SENSOR_TIMEOUT" envDefault:"0"` // in seconds, per call
+}
+
+func GetLocalGitConfig() (*LocalGitConfig, error) {
+	cfg := &LocalGitConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+const (
+	localFieldSeparator  = "\x1f"
+	localRecordSeparator = "\x1e"
+	// localLogFormat prints hash, tree, author, committer, tags, subject and body of a commit
+	localLogFormat = "%H%x1f%h%x1f%T%x1f%t%x1f%an%x1f%ae%x1f%aI%x1f%cn%x1f%ce%x1f%cI%x1f%D%x1f%s%x1f%b%x1e"
+	localLogFields = 13
+)
+
+// LocalGitChangesProviderImpl reads git changes from bare repositories on the local filesystem with the git binary
+type LocalGitChangesProviderImpl struct {
+	logger  *zap.SugaredLogger
+	config  *LocalGitConfig
+	repos   map[int]string
+	timeout time.Duration
+}
+
+func NewLocalGitChangesProviderImpl(logger *zap.SugaredLogger, config *LocalGitConfig) (*LocalGitChangesProviderImpl, error) {
+	repos, err := parseLocalRepos(config.Repos)
+	if err != nil {
+		logger.Errorw("error in parsing local git repositories", "repos", config.Repos, "err", err)
+		return nil, err
+	}
+	return &LocalGitChangesProviderImpl{
+		logger:  logger,
+		config:  config,
+		repos:   repos,
+		timeout: time.Duration(config.Timeout) * time.Second,
+	}, nil
+}
+
+// parseLocalRepos parses pipelineMaterialId:path items
+func parseLocalRepos(items []string) (map[int]string, error) {
+	repos := make(map[int]string)
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid local repository %q, expected pipelineMaterialId:path", item)
+		}
+		materialId, err := strconv.Atoi(parts[0])
+		if err != nil || materialId <= 0 {
+			return nil, fmt.Errorf("invalid pipeline material id in local repository %q", item)
+		}
+		repos[materialId] = strings.TrimSpace(parts[1])
+	}
+	return repos, nil
+}
+
+func (impl *LocalGitChangesProviderImpl) RepositoryCount() int {
+	return len(impl.repos)
+}
+
+func (impl *LocalGitChangesProviderImpl) GetReleaseChanges(ctx context.Context, request *ReleaseChangesRequest) (changes *GitChanges, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.GitSensorCallDone(metrics.ProtocolLocal, operationReleaseChanges, time.Since(start), err)
+	}()
+	repo, ok := impl.repos[request.PipelineMaterialId]
+	if !ok {
+		return nil, permanent(metrics.ProtocolLocal, fmt.Errorf("no local repository for pipeline material %d", request.PipelineMaterialId))
+	}
+	if !isRevision(request.OldCommit) || !isRevision(request.NewCommit) {
+		return nil, permanent(metrics.ProtocolLocal, fmt.Errorf("invalid commit range %q..%q", request.OldCommit, request.NewCommit))
+	}
+	ctx, cancel := withCallTimeout(ctx, impl.timeout)
+	defer cancel()
+
+	out, err := impl.git(ctx, repo, "log", "--format="+localLogFormat, "--decorate-refs=refs/tags/", request.OldCommit+".."+request.NewCommit, "--")
+	if err != nil {
+		return nil, err
+	}
+	commits, err := parseLocalLog(out)
+	if err != nil {
+		return nil, permanent(metrics.ProtocolLocal, err)
+	}
+	out, err = impl.git(ctx, repo, "diff", "--numstat", "--no-renames", request.OldCommit, request.NewCommit, "--")
+	if err != nil {
+		return nil, err
+	}
+	fileStats, err := parseNumstat(out)
+	if err != nil {
+		return nil, permanent(metrics.ProtocolLocal, err)
+	}
+	return &GitChanges{Commits: commits, FileStats: fileStats}, nil
+}
+
+// Ping checks that the git binary is installed and every configured path is a repository
+func (impl *LocalGitChangesProviderImpl) Ping(ctx context.Context) error {
+	if _, err := exec.LookPath(impl.config.GitBinary); err != nil {
+		return err
+	}
+	for materialId, repo := range impl.repos {
+		if _, err := impl.git(ctx, repo, "rev-parse", "--git-dir"); err != nil {
+			return fmt.Errorf("repository of pipeline material %d: %w", materialId, err)
+		}
+	}
+	return nil
+}
+
+// git runs a git command against repo, a failure caused by ctx can be retried, any other is permanent
+func (impl *LocalGitChangesProviderImpl) git(ctx context.Context, repo string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, impl.config.GitBinary, append([]string{"--git-dir=" + repo}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, retryLater(metrics.ProtocolLocal, ctx.Err())
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			impl.logger.Errorw("error in git command", "repo", repo, "args", args, "stderr", stderr.String())
+			return nil, permanent(metrics.ProtocolLocal, fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String())))
+		}
+		return nil, permanent(metrics.ProtocolLocal, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// isRevision rejects empty revisions and ones git would read as an option
+func isRevision(revision string) bool {
+	return revision != "" && !strings.HasPrefix(revision, "-")
+}
+
+func parseLocalLog(out []byte) ([]*Commit, error) {
+	var commits []*Commit
+	for _, record := range strings.Split(string(out), localRecordSeparator) {
+		record = strings.TrimLeft(record, "\n")
+		if record == "" {
+			continue
+		}
+		fields := strings.SplitN(record, localFieldSeparator, localLogFields)
+		if len(fields) != localLogFields {
+			return nil, fmt.Errorf("unexpected git log record %q", record)
+		}
+		authorDate, err := time.Parse(time.RFC3339, fields[6])
+		if err != nil {
+			return nil, err
+		}
+		committerDate, err := time.Parse(time.RFC3339, fields[9])
+		if err != nil {
+			return nil, err
+		}
+		commit := &Commit{
+			Hash:      &Hash{Long: fields[0], Short: fields[1]},
+			Tree:      &Tree{Long: fields[2], Short: fields[3]},
+			Author:    &Author{Name: fields[4], Email: fields[5], Date: authorDate},
+			Committer: &Committer{Name: fields[7], Email: fields[8], Date: committerDate},
+			Subject:   fields[11],
+			Body:      strings.TrimSpace(fields[12]),
+		}
+		// decorations restricted to tags read "tag: v1.2, tag: v1.2.1"
+		for _, ref := range strings.Split(fields[10], ", ") {
+			if name := strings.TrimPrefix(ref, "tag: "); name != ref {
+				commit.Tag = &Tag{Name: name, Date: committerDate}
+				break
+			}
+		}
+		commits = append(commits, commit)
+	}
+	return commits, nil
+}
+
+// parseNumstat parses "added<TAB>deleted<TAB>path" lines, binary files show "-" and count as no lines
+func parseNumstat(out []byte) ([]FileStat, error) {
+	var fileStats []FileStat
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("unexpected git numstat line %q", line)
+		}
+		fileStat := FileStat{Name: parts[2]}
+		if parts[0] != "-" {
+			addition, err := strconv.Atoi(parts[0])
+			if err != nil {
+				return nil, fmt.Errorf("unexpected git numstat line %q", line)
+			}
+			fileStat.Addition = addition
+		}
+		if parts[1] != "-" {
+			deletion, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("unexpected git numstat line %q", line)
+			}
+			fileStat.Deletion = deletion
+		}
+		fileStats = append(fileStats, fileStat)
+	}
+	return fileStats, nil
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gitSensor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// newLocalRepo commits the given file contents one commit each and returns the .git dir and the commit hashes
+func newLocalRepo(t *testing.T, contents ...string) (string, []string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=jane", "GIT_AUTHOR_EMAIL=jane@example.com",
+			"GIT_COMMITTER_NAME=jane", "GIT_COMMITTER_EMAIL=jane@example.com", "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	run("init", "-q")
+	var hashes []string
+	for i, content := range contents {
+		if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "logo.bin"), []byte{0, byte(i), 0}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		run("add", ".")
+		run("commit", "-q", "-m", "change "+strconv.Itoa(i), "-m", "body "+strconv.Itoa(i))
+		hashes = append(hashes, run("rev-parse", "HEAD"))
+	}
+	run("tag", "v1.0.0")
+	return filepath.Join(dir, ".git"), hashes
+}
+
+func TestLocalGitChangesProvider_GetReleaseChanges(t *testing.T) {
+	repo, hashes := newLocalRepo(t, "a\n", "a\nb\n", "c\nb\nd\n")
+	provider, err := NewLocalGitChangesProviderImpl(zap.NewNop().Sugar(), &LocalGitConfig{Repos: []string{"9:" + repo}, GitBinary: "git"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = provider.Ping(context.Background()); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+
+	changes, err := provider.GetReleaseChanges(context.Background(), &ReleaseChangesRequest{PipelineMaterialId: 9, OldCommit: hashes[0], NewCommit: hashes[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Commits) != 2 || changes.Commits[0].Hash.Long != hashes[2] || changes.Commits[1].Hash.Long != hashes[1] {
+		t.Fatalf("unexpected commits %+v", changes.Commits)
+	}
+	latest := changes.Commits[0]
+	if latest.Subject != "change 2" || latest.Body != "body 2" || latest.Author.Email != "jane@example.com" || latest.Author.Date.IsZero() {
+		t.Fatalf("unexpected commit %+v %+v", latest, latest.Author)
+	}
+	if latest.Tag == nil || latest.Tag.Name != "v1.0.0" || changes.Commits[1].Tag != nil {
+		t.Fatalf("expected only the latest commit tagged, got %+v", latest.Tag)
+	}
+	stats := make(map[string]FileStat)
+	for _, fileStat := range changes.FileStats {
+		stats[fileStat.Name] = fileStat
+	}
+	if stats["main.go"].Addition != 3 || stats["main.go"].Deletion != 1 {
+		t.Fatalf("unexpected main.go stats %+v", stats["main.go"])
+	}
+	if binary, ok := stats["logo.bin"]; !ok || binary.Addition != 0 || binary.Deletion != 0 {
+		t.Fatalf("unexpected binary stats %+v", binary)
+	}
+
+	_, err = provider.GetReleaseChanges(context.Background(), &ReleaseChangesRequest{PipelineMaterialId: 10, OldCommit: hashes[0], NewCommit: hashes[2]})
+	if !IsPermanent(err) {
+		t.Fatalf("expected permanent error for unknown material, got %v", err)
+	}
+	_, err = provider.GetReleaseChanges(context.Background(), &ReleaseChangesRequest{PipelineMaterialId: 9, OldCommit: hashes[0], NewCommit: strings.Repeat("f", 40)})
+	if !IsPermanent(err) {
+		t.Fatalf("expected permanent error for unknown commit, got %v", err)
+	}
+	_, err = provider.GetReleaseChanges(context.Background(), &ReleaseChangesRequest{PipelineMaterialId: 9, OldCommit: "--output=/tmp/x", NewCommit: hashes[2]})
+	if !IsPermanent(err) {
+		t.Fatalf("expected permanent error for option like revision, got %v", err)
+	}
+}
+
+func TestParseLocalRepos(t *testing.T) {
+	repos, err := parseLocalRepos([]string{"1:/repos/a.git", " 2:/repos/b:c.git ", ""})
+	if err != nil || repos[1] != "/repos/a.git" || repos[2] != "/repos/b:c.git" {
+		t.Fatalf("unexpected repos %v, err %v", repos, err)
+	}
+	for _, item := range []string{"/repos/a.git", "x:/repos/a.git", "0:/repos/a.git", "1:"} {
+		if _, err = parseLocalRepos([]string{item}); err == nil {
+			t.Errorf("expected error for %q", item)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gitSensor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/devtron-labs/lens/internal/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrCircuitOpen is returned without calling git-sensor while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// GitSensorError is a failed git-sensor call. Retryable tells a transient failure, such as git-sensor being
+// unreachable or overloaded, from a permanent one, such as an unknown material or commit.
+type GitSensorError struct {
+	Protocol  string
+	Retryable bool
+	Err       error
+}
+
+func (e *GitSensorError) Error() string {
+	return fmt.Sprintf("git-sensor %s: %v", e.Protocol, e.Err)
+}
+
+func (e *GitSensorError) Unwrap() error {
+	return e.Err
+}
+
+func retryLater(protocol string, err error) error {
+	return &GitSensorError{Protocol: protocol, Retryable: true, Err: err}
+}
+
+func permanent(protocol string, err error) error {
+	return &GitSensorError{Protocol: protocol, Err: err}
+}
+
+// IsRetryLater reports whether err is a transient git-sensor failure, the same call may succeed later
+func IsRetryLater(err error) bool {
+	var gitSensorError *GitSensorError
+	return errors.As(err, &gitSensorError) && gitSensorError.Retryable
+}
+
+// IsPermanent reports whether err is a git-sensor failure that retrying does not fix
+func IsPermanent(err error) bool {
+	var gitSensorError *GitSensorError
+	return errors.As(err, &gitSensorError) && !gitSensorError.Retryable
+}
+
+// isRetryableStatus is true for server side failures and throttling
+func isRetryableStatus(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
+
+// classifyGrpcError types err by its grpc status code
+func classifyGrpcError(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Canceled:
+		return retryLater(metrics.ProtocolGrpc, err)
+	default:
+		return permanent(metrics.ProtocolGrpc, err)
+	}
+}
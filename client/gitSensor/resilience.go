@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gitSensor
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"go.uber.org/zap"
+)
+
+type ResilienceConfig struct {
+	// RetryMaxAttempts is the number of attempts of a call failing with a retryable error, 1 disables retries
+	RetryMaxAttempts int `env:"GIT_SENSOR_RETRY_MAX_ATTEMPTS" envDefault:"3"`
+	RetryBaseDelayMs int `env:"GIT_SENSOR_RETRY_BASE_DELAY_MS" envDefault:"200"`
+	RetryMaxDelayMs  int `env:"GIT_SENSOR_RETRY_MAX_DELAY_MS" envDefault:"2000"`
+	// BreakerFailureThreshold is the number of consecutive retryable failures opening the circuit, 0 disables the breaker
+	BreakerFailureThreshold int `env:"GIT_SENSOR_BREAKER_FAILURE_THRESHOLD" envDefault:"5"`
+	BreakerOpenSecs         int `env:"GIT_SENSOR_BREAKER_OPEN_SECS" envDefault:"30"`
+	// RestFallbackUrl turns on the fallback of the grpc client to the git-sensor REST api at this url
+	RestFallbackUrl string `env:"GIT_SENSOR_REST_FALLBACK_URL"`
+}
+
+func GetResilienceConfig() (*ResilienceConfig, error) {
+	cfg := &ResilienceConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// CircuitBreaker fails calls fast after consecutive retryable failures. Once openDuration has passed
+// a single probe call is let through, its outcome closes or re-opens the circuit.
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	openDuration     time.Duration
+	state            breakerState
+	failures         int
+	openedAt         time.Time
+	probing          bool
+	now              func() time.Time
+}
+
+func NewCircuitBreaker(failureThreshold int, openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{failureThreshold: failureThreshold, openDuration: openDuration, now: time.Now}
+}
+
+// Allow reports whether a call may go through
+func (b *CircuitBreaker) Allow() bool {
+	if b.failureThreshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.openDuration {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record feeds the outcome of an allowed call, permanent errors say nothing about the health of git-sensor
+func (b *CircuitBreaker) Record(err error) {
+	if b.failureThreshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !IsRetryLater(err) {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.failureThreshold {
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// Resilience retries calls failing with retryable errors with jittered exponential backoff behind a circuit breaker
+type Resilience struct {
+	logger   *zap.SugaredLogger
+	config   *ResilienceConfig
+	protocol string
+	breaker  *CircuitBreaker
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+func NewResilience(logger *zap.SugaredLogger, config *ResilienceConfig, protocol string) *Resilience {
+	return &Resilience{
+		logger:   logger,
+		config:   config,
+		protocol: protocol,
+		breaker:  NewCircuitBreaker(config.BreakerFailureThreshold, time.Duration(config.BreakerOpenSecs)*time.Second),
+		sleep:    sleepContext,
+	}
+}
+
+// Do runs call until it succeeds, fails permanently, attempts are exhausted or ctx is done
+func (r *Resilience) Do(ctx context.Context, call func(ctx context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if !r.breaker.Allow() {
+			if err == nil {
+				err = retryLater(r.protocol, ErrCircuitOpen)
+			}
+			return err
+		}
+		err = call(ctx)
+		r.breaker.Record(err)
+		if err == nil || !IsRetryLater(err) || attempt >= r.config.RetryMaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		delay := r.backoff(attempt)
+		r.logger.Warnw("retrying git-sensor call", "protocol", r.protocol, "attempt", attempt, "delay", delay, "err", err)
+		if r.sleep(ctx, delay) != nil {
+			return err
+		}
+	}
+}
+
+// backoff is a full jitter delay, random up to base doubled per attempt and capped by the max delay
+func (r *Resilience) backoff(attempt int) time.Duration {
+	ceiling := time.Duration(r.config.RetryBaseDelayMs) * time.Millisecond << (attempt - 1)
+	if maxDelay := time.Duration(r.config.RetryMaxDelayMs) * time.Millisecond; ceiling > maxDelay || ceiling <= 0 {
+		ceiling = maxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gitSensor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/lens/internal/metrics"
+	"go.uber.org/zap"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+	unavailable := retryLater(metrics.ProtocolRest, errors.New("unavailable"))
+
+	breaker.Record(permanent(metrics.ProtocolRest, errors.New("unknown commit")))
+	breaker.Record(unavailable)
+	if !breaker.Allow() {
+		t.Fatalf("breaker opened before reaching the failure threshold")
+	}
+	breaker.Record(unavailable)
+	if breaker.Allow() {
+		t.Fatalf("breaker still closed after %d consecutive failures", 2)
+	}
+
+	now = now.Add(time.Minute)
+	if !breaker.Allow() {
+		t.Fatalf("breaker did not let a probe through after the open duration")
+	}
+	if breaker.Allow() {
+		t.Fatalf("breaker let a second call through while probing")
+	}
+	breaker.Record(unavailable)
+	if breaker.Allow() {
+		t.Fatalf("failed probe did not re-open the breaker")
+	}
+
+	now = now.Add(time.Minute)
+	breaker.Allow()
+	breaker.Record(nil)
+	if !breaker.Allow() || !breaker.Allow() {
+		t.Fatalf("successful probe did not close the breaker")
+	}
+}
+
+func newTestResilience(attempts int) *Resilience {
+	resilience := NewResilience(zap.NewNop().Sugar(), &ResilienceConfig{RetryMaxAttempts: attempts, BreakerFailureThreshold: 10}, metrics.ProtocolRest)
+	resilience.sleep = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+	return resilience
+}
+
+func TestResilience_Do(t *testing.T) {
+	tests := []struct {
+		name     string
+		errs     []error
+		wantCall int
+		wantErr  bool
+	}{
+		{name: "success", errs: []error{nil}, wantCall: 1},
+		{name: "transient then success", errs: []error{retryLater(metrics.ProtocolRest, errors.New("503")), nil}, wantCall: 2},
+		{name: "permanent is not retried", errs: []error{permanent(metrics.ProtocolRest, errors.New("404"))}, wantCall: 1, wantErr: true},
+		{name: "attempts exhausted", errs: []error{retryLater(metrics.ProtocolRest, errors.New("503")), retryLater(metrics.ProtocolRest, errors.New("503")), retryLater(metrics.ProtocolRest, errors.New("503")), nil}, wantCall: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := newTestResilience(3).Do(context.Background(), func(ctx context.Context) error {
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+			if calls != tt.wantCall || (err != nil) != tt.wantErr {
+				t.Errorf("Do() calls = %d, err = %v, want %d calls, error %t", calls, err, tt.wantCall, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResilience_DoCircuitOpen(t *testing.T) {
+	resilience := newTestResilience(1)
+	resilience.breaker = NewCircuitBreaker(1, time.Minute)
+	call := func(ctx context.Context) error { return retryLater(metrics.ProtocolRest, errors.New("unavailable")) }
+	_ = resilience.Do(context.Background(), call)
+	err := resilience.Do(context.Background(), func(ctx context.Context) error {
+		t.Fatalf("call made while the circuit is open")
+		return nil
+	})
+	if !errors.Is(err, ErrCircuitOpen) || !IsRetryLater(err) {
+		t.Errorf("Do() err = %v, want retryable ErrCircuitOpen", err)
+	}
+}
+
+func TestGitSensorClient_GetReleaseChanges(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		wantCalls     int32
+		wantPermanent bool
+		wantErr       bool
+	}{
+		{name: "retries server errors", statuses: []int{http.StatusServiceUnavailable, http.StatusOK}, wantCalls: 2},
+		{name: "client errors are permanent", statuses: []int{http.StatusNotFound}, wantCalls: 1, wantErr: true, wantPermanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[atomic.AddInt32(&calls, 1)-1]
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					_, _ = w.Write([]byte(`{"code": 200, "result": {"Commits": [], "FileStats": [{"Name": "main.go", "Addition": 3}]}}`))
+				}
+			}))
+			defer server.Close()
+			client, err := NewGitSensorSession(&GitSensorConfig{Url: server.URL}, &ResilienceConfig{RetryMaxAttempts: 3}, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes, err := client.GetReleaseChanges(context.Background(), &ReleaseChangesRequest{PipelineMaterialId: 1, OldCommit: "a", NewCommit: "b"})
+			if calls != tt.wantCalls || (err != nil) != tt.wantErr || IsPermanent(err) != tt.wantPermanent {
+				t.Fatalf("GetReleaseChanges() calls = %d, err = %v", calls, err)
+			}
+			if err == nil && (len(changes.FileStats) != 1 || changes.FileStats[0].Addition != 3) {
+				t.Errorf("GetReleaseChanges() changes = %+v", changes)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metrics names constants
+const (
+	LENS_DEPLOYMENT_EVENTS_RECEIVED_TOTAL    = "lens_deployment_events_received_total"
+	LENS_DEPLOYMENT_EVENTS_PROCESSED_TOTAL   = "lens_deployment_events_processed_total"
+	LENS_INGESTION_DURATION_SECONDS          = "lens_ingestion_duration_seconds"
+	LENS_INGESTION_STAGE_DURATION_SECONDS    = "lens_ingestion_stage_duration_seconds"
+	LENS_INGESTION_STAGE_TOTAL               = "lens_ingestion_stage_total"
+	LENS_RELEASES_TOTAL                      = "lens_releases_total"
+	LENS_GIT_SENSOR_REQUEST_DURATION_SECONDS = "lens_git_sensor_request_duration_seconds"
+	LENS_GIT_SENSOR_ERRORS_TOTAL             = "lens_git_sensor_errors_total"
+	LENS_DB_QUERY_DURATION_SECONDS           = "lens_db_query_duration_seconds"
+	LENS_GIT_CHANGES_CACHE_LOOKUPS_TOTAL     = "lens_git_changes_cache_lookups_total"
+	LENS_GIT_CHANGES_CACHE_EVICTIONS_TOTAL   = "lens_git_changes_cache_evictions_total"
+)
+
+// metrics labels constants
+const (
+	SOURCE         = "source"
+	OUTCOME        = "outcome"
+	STAGE          = "stage"
+	RELEASE_TYPE   = "release_type"
+	RELEASE_STATUS = "release_status"
+	PROTOCOL       = "protocol"
+	OPERATION      = "operation"
+	STATUS         = "status"
+	TIER           = "tier"
+	RESULT         = "result"
+)
+
+// deployment event sources
+const (
+	SourceNats = "nats"
+	SourceRest = "rest"
+)
+
+// outcomes of an event or stage, a stage is skipped when it finds nothing to do
+const (
+	OutcomeSuccess  = "success"
+	OutcomeRejected = "rejected"
+	OutcomeFailed   = "failed"
+	OutcomeSkipped  = "skipped"
+)
+
+// git-sensor protocols
+const (
+	ProtocolRest  = "rest"
+	ProtocolGrpc  = "grpc"
+	ProtocolLocal = "local"
+)
+
+// git changes cache tiers and lookup results
+const (
+	CacheTierMemory   = "memory"
+	CacheTierPostgres = "postgres"
+	CacheHit          = "hit"
+	CacheMiss         = "miss"
+)
+
+var (
+	eventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: LENS_DEPLOYMENT_EVENTS_RECEIVED_TOTAL,
+		Help: "Deployment events received, partitioned by source.",
+	}, []string{SOURCE})
+
+	eventsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: LENS_DEPLOYMENT_EVENTS_PROCESSED_TOTAL,
+		Help: "Deployment events done with, partitioned by source and outcome (success, rejected, failed).",
+	}, []string{SOURCE, OUTCOME})
+
+	ingestionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    LENS_INGESTION_DURATION_SECONDS,
+		Help:    "End to end duration of processing a deployment event, from receipt to the saved release.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{SOURCE, OUTCOME})
+
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: LENS_INGESTION_STAGE_DURATION_SECONDS,
+		Help: "Duration of an ingestion stage.",
+	}, []string{STAGE})
+
+	stageOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: LENS_INGESTION_STAGE_TOTAL,
+		Help: "Ingestion stages run, partitioned by stage and outcome (success, skipped, failed).",
+	}, []string{STAGE, OUTCOME})
+
+	releases = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: LENS_RELEASES_TOTAL,
+		Help: "Releases ingested, partitioned by the release type and status they were classified with.",
+	}, []string{RELEASE_TYPE, RELEASE_STATUS})
+
+	gitSensorDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: LENS_GIT_SENSOR_REQUEST_DURATION_SECONDS,
+		Help: "Duration of git-sensor calls, partitioned by protocol, operation and status (success, error).",
+	}, []string{PROTOCOL, OPERATION, STATUS})
+
+	gitSensorErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: LENS_GIT_SENSOR_ERRORS_TOTAL,
+		Help: "Failed git-sensor calls, partitioned by protocol and operation.",
+	}, []string{PROTOCOL, OPERATION})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    LENS_DB_QUERY_DURATION_SECONDS,
+		Help:    "Duration of postgres queries, partitioned by operation and status (success, error).",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{OPERATION, STATUS})
+
+	gitChangesCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: LENS_GIT_CHANGES_CACHE_LOOKUPS_TOTAL,
+		Help: "Lookups of the git changes cache, partitioned by tier (memory, postgres) and result (hit, miss).",
+	}, []string{TIER, RESULT})
+
+	gitChangesCacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: LENS_GIT_CHANGES_CACHE_EVICTIONS_TOTAL,
+		Help: "Entries evicted from the git changes cache to stay within its size limit, partitioned by tier.",
+	}, []string{TIER})
+)
+
+func EventReceived(source string) {
+	eventsReceived.WithLabelValues(source).Inc()
+}
+
+// EventProcessed records the outcome of an event received at start
+func EventProcessed(source string, outcome string, start time.Time) {
+	eventsProcessed.WithLabelValues(source, outcome).Inc()
+	if outcome != OutcomeRejected {
+		ingestionDuration.WithLabelValues(source, outcome).Observe(time.Since(start).Seconds())
+	}
+}
+
+func StageDone(stage string, outcome string, duration time.Duration) {
+	stageOutcomes.WithLabelValues(stage, outcome).Inc()
+	stageDuration.WithLabelValues(stage).Observe(duration.Seconds())
+}
+
+func ReleaseClassified(releaseType string, releaseStatus string) {
+	releases.WithLabelValues(releaseType, releaseStatus).Inc()
+}
+
+func GitSensorCallDone(protocol string, operation string, duration time.Duration, err error) {
+	status := "success"
+	if err != nil {
+		status = "error"
+		gitSensorErrors.WithLabelValues(protocol, operation).Inc()
+	}
+	gitSensorDuration.WithLabelValues(protocol, operation, status).Observe(duration.Seconds())
+}
+
+func QueryDone(operation string, duration time.Duration, err error) {
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	dbQueryDuration.WithLabelValues(operation, status).Observe(duration.Seconds())
+}
+
+func GitChangesCacheLookup(tier string, hit bool) {
+	result := CacheMiss
+	if hit {
+		result = CacheHit
+	}
+	gitChangesCacheLookups.WithLabelValues(tier, result).Inc()
+}
+
+func GitChangesCacheEvicted(tier string, count int) {
+	gitChangesCacheEvictions.WithLabelValues(tier).Add(float64(count))
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := counter.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func sampleCount(t *testing.T, observer prometheus.Observer) uint64 {
+	m := &dto.Metric{}
+	if err := observer.(prometheus.Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestEventProcessed(t *testing.T) {
+	EventProcessed(SourceRest, OutcomeRejected, time.Now())
+	EventProcessed(SourceRest, OutcomeSuccess, time.Now().Add(-time.Second))
+	if got := counterValue(t, eventsProcessed.WithLabelValues(SourceRest, OutcomeRejected)); got != 1 {
+		t.Errorf("rejected events = %v, want 1", got)
+	}
+	if got := sampleCount(t, ingestionDuration.WithLabelValues(SourceRest, OutcomeRejected)); got != 0 {
+		t.Errorf("rejected events must not be observed in the ingestion latency, got %d samples", got)
+	}
+	if got := sampleCount(t, ingestionDuration.WithLabelValues(SourceRest, OutcomeSuccess)); got != 1 {
+		t.Errorf("ingestion latency samples = %d, want 1", got)
+	}
+}
+
+func TestGitSensorCallDone(t *testing.T) {
+	GitSensorCallDone(ProtocolGrpc, "release-changes", time.Millisecond, errors.New("unavailable"))
+	GitSensorCallDone(ProtocolGrpc, "release-changes", time.Millisecond, nil)
+	if got := counterValue(t, gitSensorErrors.WithLabelValues(ProtocolGrpc, "release-changes")); got != 1 {
+		t.Errorf("git-sensor errors = %v, want 1", got)
+	}
+	if got := sampleCount(t, gitSensorDuration.WithLabelValues(ProtocolGrpc, "release-changes", "success")); got != 1 {
+		t.Errorf("successful git-sensor call samples = %d, want 1", got)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/lens/pkg/middleware"
+	"go.uber.org/zap"
+)
+
+type ServerConfig struct {
+	ListenAddr            string   `env:"HTTP_LISTEN_ADDR" envDefault:":8080"`
+	ReadTimeoutSecs       int      `env:"HTTP_READ_TIMEOUT_SECS" envDefault:"30"`
+	ReadHeaderTimeoutSecs int      `env:"HTTP_READ_HEADER_TIMEOUT_SECS" envDefault:"10"`
+	WriteTimeoutSecs      int      `env:"HTTP_WRITE_TIMEOUT_SECS" envDefault:"60"`
+	IdleTimeoutSecs       int      `env:"HTTP_IDLE_TIMEOUT_SECS" envDefault:"120"`
+	RequestTimeoutSecs    int      `env:"HTTP_REQUEST_TIMEOUT_SECS" envDefault:"30"`
+	GzipEnabled           bool     `env:"HTTP_GZIP_ENABLED" envDefault:"true"`
+	CorsAllowedOrigins    []string `env:"CORS_ALLOWED_ORIGINS" envSeparator:","`
+	TlsCertFile           string   `env:"TLS_CERT_FILE"`
+	TlsKeyFile            string   `env:"TLS_KEY_FILE"`
+	// TlsClientCaFile turns on mTLS, clients must present a certificate signed by this CA
+	TlsClientCaFile       string `env:"TLS_CLIENT_CA_FILE"`
+	TlsReloadIntervalSecs int    `env:"TLS_RELOAD_INTERVAL_SECS" envDefault:"30"`
+}
+
+func GetServerConfig() (*ServerConfig, error) {
+	cfg := &ServerConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+func (cfg *ServerConfig) TlsEnabled() bool {
+	return cfg.TlsCertFile != "" && cfg.TlsKeyFile != ""
+}
+
+// streamPathPrefix serves long-lived responses, they are exempt from the request deadline and clear the write timeout
+const streamPathPrefix = "/stream/"
+
+// HttpServer is the lens http server with its optional certificate reloader
+type HttpServer struct {
+	*http.Server
+	logger       *zap.SugaredLogger
+	config       *ServerConfig
+	certReloader *CertReloader
+}
+
+// NewHttpServer wraps handler with the request deadline, cors and gzip and applies the configured timeouts and tls
+func NewHttpServer(logger *zap.SugaredLogger, config *ServerConfig, handler http.Handler) (*HttpServer, error) {
+	handler = middleware.Deadline(time.Duration(config.RequestTimeoutSecs)*time.Second, streamPathPrefix)(handler)
+	if config.GzipEnabled {
+		handler = middleware.Gzip(handler)
+	}
+	handler = middleware.Cors(config.CorsAllowedOrigins)(handler)
+	httpServer := &HttpServer{
+		Server: &http.Server{
+			Addr:              config.ListenAddr,
+			Handler:           handler,
+			ReadTimeout:       time.Duration(config.ReadTimeoutSecs) * time.Second,
+			ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeoutSecs) * time.Second,
+			WriteTimeout:      time.Duration(config.WriteTimeoutSecs) * time.Second,
+			IdleTimeout:       time.Duration(config.IdleTimeoutSecs) * time.Second,
+		},
+		logger: logger,
+		config: config,
+	}
+	if config.TlsEnabled() {
+		certReloader, err := NewCertReloader(logger, config.TlsCertFile, config.TlsKeyFile, config.TlsClientCaFile, time.Duration(config.TlsReloadIntervalSecs)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		httpServer.certReloader = certReloader
+		httpServer.TLSConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			GetCertificate:     certReloader.GetCertificate,
+			GetConfigForClient: certReloader.GetConfigForClient,
+		}
+	}
+	return httpServer, nil
+}
+
+// ListenAndServe blocks until the server is shut down, http.ErrServerClosed is not an error
+func (s *HttpServer) ListenAndServe() error {
+	s.logger.Infow("starting server", "addr", s.config.ListenAddr, "tls", s.config.TlsEnabled(), "mtls", s.config.TlsClientCaFile != "")
+	var err error
+	if s.certReloader != nil {
+		s.certReloader.Start()
+		defer s.certReloader.Stop()
+		err = s.Server.ListenAndServeTLS("", "")
+	} else {
+		err = s.Server.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
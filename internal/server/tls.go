@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CertReloader serves the server certificate and client CA from files and picks up changes on them
+// without a restart, files are polled as rotated secrets are often swapped through symlinks.
+type CertReloader struct {
+	logger       *zap.SugaredLogger
+	certFile     string
+	keyFile      string
+	clientCaFile string
+	interval     time.Duration
+	config       atomic.Pointer[tls.Config]
+	lastModified time.Time
+	stopCh       chan struct{}
+	stopOnce     sync.Once
+	wg           sync.WaitGroup
+}
+
+func NewCertReloader(logger *zap.SugaredLogger, certFile, keyFile, clientCaFile string, interval time.Duration) (*CertReloader, error) {
+	reloader := &CertReloader{
+		logger:       logger,
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCaFile: clientCaFile,
+		interval:     interval,
+		stopCh:       make(chan struct{}),
+	}
+	if _, err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (impl *CertReloader) GetConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return impl.config.Load(), nil
+}
+
+func (impl *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &impl.config.Load().Certificates[0], nil
+}
+
+func (impl *CertReloader) Start() {
+	if impl.interval <= 0 {
+		return
+	}
+	impl.wg.Add(1)
+	go func() {
+		defer impl.wg.Done()
+		ticker := time.NewTicker(impl.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-impl.stopCh:
+				return
+			case <-ticker.C:
+				reloaded, err := impl.reload()
+				if err != nil {
+					// keep serving the previous certificate
+					impl.logger.Errorw("error in reloading tls certificates", "err", err)
+				} else if reloaded {
+					impl.logger.Infow("tls certificates reloaded", "cert", impl.certFile)
+				}
+			}
+		}
+	}()
+}
+
+func (impl *CertReloader) Stop() {
+	impl.stopOnce.Do(func() { close(impl.stopCh) })
+	impl.wg.Wait()
+}
+
+// reload rebuilds the tls config when any of the files changed since the last load
+func (impl *CertReloader) reload() (bool, error) {
+	modified, err := impl.latestModification()
+	if err != nil {
+		return false, err
+	}
+	if !modified.After(impl.lastModified) {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(impl.certFile, impl.keyFile)
+	if err != nil {
+		return false, err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if impl.clientCaFile != "" {
+		caPem, err := os.ReadFile(impl.clientCaFile)
+		if err != nil {
+			return false, err
+		}
+		clientCas := x509.NewCertPool()
+		if !clientCas.AppendCertsFromPEM(caPem) {
+			return false, fmt.Errorf("no certificates found in %s", impl.clientCaFile)
+		}
+		config.ClientCAs = clientCas
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	impl.config.Store(config)
+	impl.lastModified = modified
+	return true, nil
+}
+
+func (impl *CertReloader) latestModification() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{impl.certFile, impl.keyFile, impl.clientCaFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
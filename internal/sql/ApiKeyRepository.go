@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// ApiKey is a static bearer token, only the sha256 of the key is stored
+type ApiKey struct {
+	tableName struct{}  `pg:"api_key"`
+	Id        int       `pg:"id,pk"`
+	Name      string    `pg:"name,notnull"`
+	KeyHash   string    `pg:"key_hash,notnull"`
+	Scopes    []string  `pg:"scopes,array,notnull"`
+	AppIds    []int     `pg:"app_ids,array"` //empty allows all apps
+	Active    bool      `pg:"active,notnull,use_zero"`
+	CreatedBy string    `pg:"created_by,notnull"`
+	CreatedOn time.Time `pg:"created_on,notnull"`
+	ExpiresOn time.Time `pg:"expires_on"`
+}
+
+type ApiKeyRepository interface {
+	Save(ctx context.Context, apiKey *ApiKey) (*ApiKey, error)
+	FindActiveByKeyHash(ctx context.Context, keyHash string) (*ApiKey, error)
+	Deactivate(ctx context.Context, id int) error
+}
+
+type ApiKeyRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewApiKeyRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *ApiKeyRepositoryImpl {
+	return &ApiKeyRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *ApiKeyRepositoryImpl) Save(ctx context.Context, apiKey *ApiKey) (*ApiKey, error) {
+	_, err := impl.dbConnection.ModelContext(ctx, apiKey).Insert()
+	return apiKey, err
+}
+
+func (impl *ApiKeyRepositoryImpl) FindActiveByKeyHash(ctx context.Context, keyHash string) (*ApiKey, error) {
+	apiKey := &ApiKey{}
+	err := impl.dbConnection.
+		ModelContext(ctx, apiKey).
+		Where("key_hash = ?", keyHash).
+		Where("active = true").
+		Where("expires_on is null or expires_on > now()").
+		Select()
+	return apiKey, err
+}
+
+func (impl *ApiKeyRepositoryImpl) Deactivate(ctx context.Context, id int) error {
+	_, err := impl.dbConnection.ModelContext(ctx, (*ApiKey)(nil)).
+		Set("active = false").
+		Where("id = ?", id).
+		Update()
+	return err
+}
@@ -17,30 +17,43 @@
 package sql
 
 import (
+	"fmt"
+	"sync"
 	"time"
 
 	"context"
 
 	pg "github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
 	"go.uber.org/zap"
 )
 
 type AppRelease struct {
-	tableName             struct{}      `pg:"app_release"`
-	Id                    int           `pg:"id,pk"`
-	AppId                 int           `pg:"app_id,notnull,use_zero"`                   //orchestrator appId
-	EnvironmentId         int           `pg:"environment_id,notnull,use_zero"`           //orchestrator env id
-	CiArtifactId          int           `pg:"ci_artifact_id,notnull,use_zero"`           //orchestrator ciAretefactId  used for identifying rollback (appId,environmentId, ciArtifactId)
-	ReleaseId             int           `pg:"release_id,notnull,use_zero"`               // orchestrator release counter
-	PipelineOverrideId    int           `pg:"pipeline_override_id,notnull,use_zero"`     //pipeline override id orchestrator
-	ChangeSizeLineAdded   int           `pg:"change_size_line_added,notnull,use_zero"`   //total lines added in this release
-	ChangeSizeLineDeleted int           `pg:"change_size_line_deleted,notnull,use_zero"` //total lines deleted during this release
-	TriggerTime           time.Time     `pg:"trigger_time,notnull"`                      //deployment time
-	ReleaseType           ReleaseType   `pg:"release_type,notnull,use_zero"`
-	ReleaseStatus         ReleaseStatus `pg:"release_status,notnull,use_zero"`
-	ProcessStage          ProcessStage  `pg:"process_status,notnull,use_zero"`
-	CreatedTime           time.Time     `pg:"created_time,notnull"`
-	UpdatedTime           time.Time     `pg:"updated_time,notnull"`
+	tableName             struct{}        `pg:"app_release"`
+	Id                    int             `pg:"id,pk"`
+	AppId                 int             `pg:"app_id,notnull,use_zero"`                   //orchestrator appId
+	EnvironmentId         int             `pg:"environment_id,notnull,use_zero"`           //orchestrator env id
+	CiArtifactId          int             `pg:"ci_artifact_id,notnull,use_zero"`           //orchestrator ciAretefactId  used for identifying rollback (appId,environmentId, ciArtifactId)
+	ReleaseId             int             `pg:"release_id,notnull,use_zero"`               // orchestrator release counter
+	PipelineOverrideId    int             `pg:"pipeline_override_id,notnull,use_zero"`     //pipeline override id orchestrator
+	ChangeSizeLineAdded   int             `pg:"change_size_line_added,notnull,use_zero"`   //total lines added in this release
+	ChangeSizeLineDeleted int             `pg:"change_size_line_deleted,notnull,use_zero"` //total lines deleted during this release
+	TriggerTime           time.Time       `pg:"trigger_time,notnull"`                      //deployment time
+	ReleaseType           ReleaseType     `pg:"release_type,notnull,use_zero"`
+	ReleaseStatus         ReleaseStatus   `pg:"release_status,notnull,use_zero"`
+	ProcessStage          ProcessStage    `pg:"process_status,notnull,use_zero"`
+	CreatedTime           time.Time       `pg:"created_time,notnull"`
+	UpdatedTime           time.Time       `pg:"updated_time,notnull"`
+	ResetBatchId          int             `pg:"reset_batch_id"` //set when soft deleted by an app environment reset
+	ExcludedFromMetrics   bool            `pg:"excluded_from_metrics,notnull,use_zero"`
+	RollbackTargetId      int             `pg:"rollback_target_release_id"`        //earlier release of the same artifact a rollback restored
+	RolledBackReleaseId   int             `pg:"rolled_back_release_id"`            //failed release a rollback replaced
+	TimeToRollback        time.Duration   `pg:"time_to_rollback"`                  //from the trigger of the rolled back release to the rollback
+	CommitCount           int             `pg:"commit_count,notnull,use_zero"`     //commits brought by this release
+	FixCommitCount        int             `pg:"fix_commit_count,notnull,use_zero"` //of which fix: or revert commits
+	FailurePolicyId       int             `pg:"failure_policy_id,use_zero"`        //failure policy the release was classified with, 0 for the built-in one
+	FailurePolicyVersion  int             `pg:"failure_policy_version,use_zero"`
+	FailureReason         FailureRuleType `pg:"failure_reason"` //rule that marked the release failed
 	LeadTime              *LeadTime
 }
 
@@ -56,6 +69,15 @@ func (releaseStatus ReleaseStatus) String() string {
 	return [...]string{"Success", "Failure"}[releaseStatus]
 }
 
+func ParseReleaseStatus(name string) (ReleaseStatus, error) {
+	for releaseStatus := Success; releaseStatus <= Failure; releaseStatus++ {
+		if releaseStatus.String() == name {
+			return releaseStatus, nil
+		}
+	}
+	return Success, fmt.Errorf("unknown release status %q", name)
+}
+
 // ----------------
 type ReleaseType int
 
@@ -64,10 +86,91 @@ const (
 	RollForward
 	RollBack
 	Patch
+	Redeploy   //same artifact and commits as the release it replaced
+	ConfigOnly //new artifact or configuration without code change
 )
 
 func (releaseType ReleaseType) String() string {
-	return [...]string{"Unknown", "RollForward", "RollBack", "Patch"}[releaseType]
+	return [...]string{"Unknown", "RollForward", "RollBack", "Patch", "Redeploy", "ConfigOnly"}[releaseType]
+}
+
+// releaseTypeRules tells which metrics count releases of a type, types not listed count for all
+var releaseTypeRules = map[ReleaseType]struct{ deploymentFrequency, leadTime bool }{
+	RollBack:   {deploymentFrequency: true, leadTime: false},
+	Redeploy:   {deploymentFrequency: false, leadTime: false},
+	ConfigOnly: {deploymentFrequency: true, leadTime: false},
+}
+
+// CountsForDeploymentFrequency is false for redeploys, they ship nothing that was not live already
+func (releaseType ReleaseType) CountsForDeploymentFrequency() bool {
+	rule, ok := releaseTypeRules[releaseType]
+	return !ok || rule.deploymentFrequency
+}
+
+// CountsForLeadTime is false for releases that bring no new commits
+func (releaseType ReleaseType) CountsForLeadTime() bool {
+	rule, ok := releaseTypeRules[releaseType]
+	return !ok || rule.leadTime
+}
+
+// rework reasons, a release is rework when it is unplanned work fixing a user facing problem
+const (
+	ReworkPatch      = "patch"
+	ReworkRollback   = "rollback"
+	ReworkHotfixTag  = "hotfix-tag"
+	ReworkFixCommits = "fix-commits"
+)
+
+// a release tagged type=hotfix is rework
+const (
+	HotfixTagKey   = "type"
+	HotfixTagValue = "hotfix"
+)
+
+// IsFixDominated is true when most commits of the release are fix: or revert commits
+func (appRelease *AppRelease) IsFixDominated() bool {
+	return appRelease.CommitCount > 0 && appRelease.FixCommitCount*2 > appRelease.CommitCount
+}
+
+// ReworkReasons tells what makes a release rework given its tags, nil for planned work and releases not counted as deployments
+func (appRelease *AppRelease) ReworkReasons(tags map[string]string) []string {
+	if !appRelease.ReleaseType.CountsForDeploymentFrequency() {
+		return nil
+	}
+	var reasons []string
+	switch appRelease.ReleaseType {
+	case Patch:
+		reasons = append(reasons, ReworkPatch)
+	case RollBack:
+		reasons = append(reasons, ReworkRollback)
+	}
+	if tags[HotfixTagKey] == HotfixTagValue {
+		reasons = append(reasons, ReworkHotfixTag)
+	}
+	if appRelease.IsFixDominated() {
+		reasons = append(reasons, ReworkFixCommits)
+	}
+	return reasons
+}
+
+// releaseTypesNotCounted lists the release types counts rejects, for use in queries
+func releaseTypesNotCounted(counts func(ReleaseType) bool) []ReleaseType {
+	var releaseTypes []ReleaseType
+	for releaseType := Unknown; releaseType <= ConfigOnly; releaseType++ {
+		if !counts(releaseType) {
+			releaseTypes = append(releaseTypes, releaseType)
+		}
+	}
+	return releaseTypes
+}
+
+func ParseReleaseType(name string) (ReleaseType, error) {
+	for releaseType := Unknown; releaseType <= ConfigOnly; releaseType++ {
+		if releaseType.String() == name {
+			return releaseType, nil
+		}
+	}
+	return Unknown, fmt.Errorf("unknown release type %q", name)
 }
 
 // ------
@@ -77,56 +180,190 @@ const (
 	Init ProcessStage = iota
 	ReleaseTypeDetermined
 	LeadTimeFetch
+	Processed //ingestion completed, release-processed message written
 )
 
-var ctx = context.Background()
-
 func (ProcessStage ProcessStage) String() string {
-	return [...]string{"Init", "ReleaseTypeDetermined", "LeadTimeFetch"}[ProcessStage]
+	return [...]string{"Init", "ReleaseTypeDetermined", "LeadTimeFetch", "Processed"}[ProcessStage]
+}
+
+type recomputeScopeKey struct{}
+
+// RecomputeScope makes ingestion rebuild the releases of an app environment triggered from From on: it saves
+// releases staged under ResetBatchId and looks them up in place of the live releases from From on. Updates of the
+// live releases before From, e.g. one failed by a rebuilt redeploy, are deferred to the swap of the rebuilt releases.
+type RecomputeScope struct {
+	ResetBatchId int
+	From         time.Time
+	mu           sync.Mutex
+	deferred     []*deferredUpdate
+}
+
+// deferredUpdate is a change of the failure classification of a live release made by a recompute
+type deferredUpdate struct {
+	appRelease *AppRelease
+	cause      string
+}
+
+func (scope *RecomputeScope) deferUpdate(appRelease *AppRelease, cause string) {
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+	updated := *appRelease
+	scope.deferred = append(scope.deferred, &deferredUpdate{appRelease: &updated, cause: cause})
+}
+
+// overlay gives a live release read during the recompute the classification deferred for it, if any
+func (scope *RecomputeScope) overlay(appRelease *AppRelease) {
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+	for i := len(scope.deferred) - 1; i >= 0; i-- {
+		if updated := scope.deferred[i].appRelease; updated.Id == appRelease.Id {
+			appRelease.ReleaseStatus = updated.ReleaseStatus
+			appRelease.FailureReason = updated.FailureReason
+			appRelease.UpdatedTime = updated.UpdatedTime
+			return
+		}
+	}
+}
+
+func (scope *RecomputeScope) deferredUpdates() []*deferredUpdate {
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+	return append([]*deferredUpdate(nil), scope.deferred...)
+}
+
+// withDeferredUpdate overlays a live release read under a recompute scope with its deferred update
+func withDeferredUpdate(ctx context.Context, appRelease *AppRelease, err error) (*AppRelease, error) {
+	if scope := RecomputeScopeFrom(ctx); scope != nil && err == nil && appRelease.ResetBatchId == 0 {
+		scope.overlay(appRelease)
+	}
+	return appRelease, err
+}
+
+func WithRecomputeScope(ctx context.Context, scope *RecomputeScope) context.Context {
+	return context.WithValue(ctx, recomputeScopeKey{}, scope)
+}
+
+// RecomputeScopeFrom returns the scope of a recompute, nil for live ingestion
+func RecomputeScopeFrom(ctx context.Context) *RecomputeScope {
+	scope, _ := ctx.Value(recomputeScopeKey{}).(*RecomputeScope)
+	return scope
+}
+
+// liveReleases restricts a query on one app environment to the releases ingestion under ctx sees
+func liveReleases(ctx context.Context) func(query *orm.Query) (*orm.Query, error) {
+	return func(query *orm.Query) (*orm.Query, error) {
+		scope := RecomputeScopeFrom(ctx)
+		if scope == nil {
+			return query.Where("reset_batch_id is null"), nil
+		}
+		return query.Where("(reset_batch_id = ? or (reset_batch_id is null and trigger_time < ?))", scope.ResetBatchId, scope.From), nil
+	}
 }
 
 type AppReleaseRepository interface {
-	Save(appRelease *AppRelease) (*AppRelease, error)
-	Update(appRelease *AppRelease) (*AppRelease, error)
-	CheckDuplicateRelease(appId, environmentId, ciArtifactId int) (bool, error)
-	GetPreviousReleaseWithinTime(appId, environmentId int, within time.Time, currentAppReleaseId int) (*AppRelease, error)
-	GetPreviousRelease(appId, environmentId int, appReleaseId int) (*AppRelease, error)
-	GetReleaseBetween(appId, environmentId int, from time.Time, to time.Time) ([]AppRelease, error)
-	CleanAppDataForEnvironment(appId, environmentId int) error
+	// Save inserts a release and records its initial stage, status and type with cause
+	Save(ctx context.Context, appRelease *AppRelease, cause string) (*AppRelease, error)
+	// Update saves a release and records the changes of its stage, status and type with cause
+	Update(ctx context.Context, appRelease *AppRelease, cause string) (*AppRelease, error)
+	CheckDuplicateRelease(ctx context.Context, appId, environmentId, ciArtifactId int) (bool, error)
+	GetPreviousReleaseWithinTime(ctx context.Context, appId, environmentId int, within time.Time, currentAppReleaseId int) (*AppRelease, error)
+	GetPreviousRelease(ctx context.Context, appId, environmentId int, appReleaseId int) (*AppRelease, error)
+	// GetPreviousReleaseOfArtifact returns the latest release of ciArtifactId before appReleaseId
+	GetPreviousReleaseOfArtifact(ctx context.Context, appId, environmentId, ciArtifactId int, appReleaseId int) (*AppRelease, error)
+	// GetReleaseBetween returns releases counted in metrics, only those carrying all given tags if any
+	GetReleaseBetween(ctx context.Context, appId, environmentId int, from time.Time, to time.Time, tags map[string]string) ([]AppRelease, error)
+	FindById(ctx context.Context, id int) (*AppRelease, error)
+	// FindByTrigger returns the live release of an orchestrator trigger
+	FindByTrigger(ctx context.Context, appId, environmentId, pipelineOverrideId int) (*AppRelease, error)
+	// FindStalledReleases returns up to limit live releases whose ingestion stopped after their type was determined,
+	// last updated before updatedBefore and created after createdAfter, oldest first
+	FindStalledReleases(ctx context.Context, updatedBefore, createdAfter time.Time, limit int) ([]*AppRelease, error)
+	// FindAppEnvironmentsWithOtherFailurePolicy lists app environments having live releases not classified with
+	// the given version of a failure policy, environmentIds and excludedEnvironmentIds as in ArchiveReleasesBefore
+	FindAppEnvironmentsWithOtherFailurePolicy(ctx context.Context, environmentIds []int, excludedEnvironmentIds []int, failurePolicyId, version int) ([]AppEnvironment, error)
+	// SwapRecomputedReleases makes the releases staged by a recompute live in place of the live releases of the app
+	// environment triggered between from and to, which are soft deleted under the same batch. Tags and annotations
+	// added through the api and rollback links of later releases carry over to the rebuilt releases of the same
+	// trigger, annotations of eventAuthor are left as the rebuilt releases have them already. Updates of earlier live
+	// releases deferred by the recompute scope of ctx are applied in the same transaction.
+	SwapRecomputedReleases(ctx context.Context, resetBatch *ResetBatch, from, to time.Time, eventAuthor string) (int, error)
+	// MarkProcessed sets the release processed and saves message if any in one transaction
+	MarkProcessed(ctx context.Context, appRelease *AppRelease, message *ReleaseOutboxMessage) error
+	// ReclassifyAppEnvironment locks all live releases of an app environment, hands them oldest first to classify and
+	// saves the status, type and failure policy classify sets, changes are recorded with cause. Ingestion updates of
+	// these releases wait for the transaction instead of being overwritten.
+	ReclassifyAppEnvironment(ctx context.Context, appId, environmentId int, cause string, classify func(appReleases []*AppRelease) error) error
+	// SoftDeleteAppDataForEnvironment saves resetBatch and marks all live releases of its app environment with it
+	SoftDeleteAppDataForEnvironment(ctx context.Context, resetBatch *ResetBatch) (*ResetBatch, error)
+	RestoreResetBatch(ctx context.Context, resetBatch *ResetBatch) (*ResetBatch, error)
+	// PurgeResetBatch hard deletes the releases soft deleted by resetBatch
+	PurgeResetBatch(ctx context.Context, resetBatch *ResetBatch) (*ResetBatch, error)
+	// ArchiveReleasesBefore locks up to limit releases triggered before the given time, hands them to archive with
+	// their lead time set and their materials and tags, and deletes them along with their lead time and materials,
+	// all in one transaction. environmentIds restricts the batch to these environments, excludedEnvironmentIds skips
+	// environments.
+	ArchiveReleasesBefore(ctx context.Context, environmentIds []int, excludedEnvironmentIds []int, before time.Time, limit int,
+		archive func(releases []*AppRelease, materials []*PipelineMaterial, tags []*ReleaseTag, tx *pg.Tx) error) (int, error)
 }
 type AppReleaseRepositoryImpl struct {
 	dbConnection               *pg.DB
 	logger                     *zap.SugaredLogger
 	leadTimeRepository         LeadTimeRepository
 	pipelineMaterialRepository PipelineMaterialRepository
+	resetBatchRepository       ResetBatchRepository
+	releaseOutboxRepository    ReleaseOutboxRepository
 }
 
 func NewAppReleaseRepositoryImpl(dbConnection *pg.DB,
 	logger *zap.SugaredLogger,
 	leadTimeRepository LeadTimeRepository,
-	pipelineMaterialRepository PipelineMaterialRepository) *AppReleaseRepositoryImpl {
+	pipelineMaterialRepository PipelineMaterialRepository,
+	resetBatchRepository ResetBatchRepository,
+	releaseOutboxRepository ReleaseOutboxRepository) *AppReleaseRepositoryImpl {
 	return &AppReleaseRepositoryImpl{logger: logger, dbConnection: dbConnection,
 		leadTimeRepository:         leadTimeRepository,
-		pipelineMaterialRepository: pipelineMaterialRepository}
+		pipelineMaterialRepository: pipelineMaterialRepository,
+		resetBatchRepository:       resetBatchRepository,
+		releaseOutboxRepository:    releaseOutboxRepository}
 }
 
-func (impl *AppReleaseRepositoryImpl) Save(appRelease *AppRelease) (*AppRelease, error) {
-	_, err := impl.dbConnection.Model(appRelease).Insert()
+func (impl *AppReleaseRepositoryImpl) Save(ctx context.Context, appRelease *AppRelease, cause string) (*AppRelease, error) {
+	if scope := RecomputeScopeFrom(ctx); scope != nil {
+		appRelease.ResetBatchId = scope.ResetBatchId
+	}
+	err := impl.dbConnection.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := tx.ModelContext(ctx, appRelease).Insert()
+		if err != nil {
+			return err
+		}
+		return saveReleaseHistory(ctx, tx, releaseTransitions(nil, appRelease, cause, appRelease.CreatedTime))
+	})
 	return appRelease, err
 }
 
-func (impl *AppReleaseRepositoryImpl) Update(appRelease *AppRelease) (*AppRelease, error) {
-	_, err := impl.dbConnection.Model(appRelease).WherePK().Update()
+func (impl *AppReleaseRepositoryImpl) Update(ctx context.Context, appRelease *AppRelease, cause string) (*AppRelease, error) {
+	if scope := RecomputeScopeFrom(ctx); scope != nil && appRelease.ResetBatchId != scope.ResetBatchId {
+		scope.deferUpdate(appRelease, cause)
+		return appRelease, nil
+	}
+	err := impl.dbConnection.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return updateReleaseWithHistory(ctx, tx, appRelease, cause, func() error {
+			_, err := tx.ModelContext(ctx, appRelease).WherePK().Update()
+			return err
+		})
+	})
 	return appRelease, err
 }
 
-func (impl *AppReleaseRepositoryImpl) CheckDuplicateRelease(appId, environmentId, ciArtifactId int) (bool, error) {
+func (impl *AppReleaseRepositoryImpl) CheckDuplicateRelease(ctx context.Context, appId, environmentId, ciArtifactId int) (bool, error) {
 	var appRelease *AppRelease
 	count, err := impl.dbConnection.
-		Model(appRelease).
+		ModelContext(ctx, appRelease).
 		Where("app_id = ?", appId).
 		Where("environment_id =? ", environmentId).
 		Where("ci_artifact_id =? ", ciArtifactId).
+		Apply(liveReleases(ctx)).
 		Count()
 	if err != nil {
 		return false, err
@@ -134,79 +371,405 @@ func (impl *AppReleaseRepositoryImpl) CheckDuplicateRelease(appId, environmentId
 	return count > 1, nil
 }
 
-func (impl *AppReleaseRepositoryImpl) GetPreviousReleaseWithinTime(appId, environmentId int,
+func (impl *AppReleaseRepositoryImpl) GetPreviousReleaseWithinTime(ctx context.Context, appId, environmentId int,
 	within time.Time,
 	currentAppReleaseId int) (*AppRelease, error) {
 	appRelease := &AppRelease{}
 	err := impl.dbConnection.
-		Model(appRelease).
+		ModelContext(ctx, appRelease).
 		Where("app_id = ?", appId).
 		Where("environment_id =? ", environmentId).
 		Where("trigger_time > ?", within).
 		Where("id < ?", currentAppReleaseId).
+		Apply(liveReleases(ctx)).
 		Last()
-	return appRelease, err
+	return withDeferredUpdate(ctx, appRelease, err)
 }
 
-func (impl *AppReleaseRepositoryImpl) GetPreviousRelease(appId, environmentId int,
+func (impl *AppReleaseRepositoryImpl) GetPreviousRelease(ctx context.Context, appId, environmentId int,
 	appReleaseId int) (*AppRelease, error) {
 	appRelease := &AppRelease{}
 	err := impl.dbConnection.
-		Model(appRelease).
+		ModelContext(ctx, appRelease).
 		Where("app_id = ?", appId).
 		Where("environment_id =? ", environmentId).
 		Where("id < ?", appReleaseId).
+		Apply(liveReleases(ctx)).
 		Last()
-	return appRelease, err
+	return withDeferredUpdate(ctx, appRelease, err)
 }
 
-func (impl *AppReleaseRepositoryImpl) GetReleaseBetween(appId, environmentId int,
+func (impl *AppReleaseRepositoryImpl) GetPreviousReleaseOfArtifact(ctx context.Context, appId, environmentId, ciArtifactId int,
+	appReleaseId int) (*AppRelease, error) {
+	appRelease := &AppRelease{}
+	err := impl.dbConnection.
+		ModelContext(ctx, appRelease).
+		Where("app_id = ?", appId).
+		Where("environment_id =? ", environmentId).
+		Where("ci_artifact_id =? ", ciArtifactId).
+		Where("id < ?", appReleaseId).
+		Apply(liveReleases(ctx)).
+		Last()
+	return withDeferredUpdate(ctx, appRelease, err)
+}
+
+func (impl *AppReleaseRepositoryImpl) GetReleaseBetween(ctx context.Context, appId, environmentId int,
 	from time.Time, //inclusive
 	to time.Time, //inclusive
+	tags map[string]string,
 ) ([]AppRelease, error) {
 	var appReleases []AppRelease
-	err := impl.dbConnection.
-		Model(&appReleases).
+	query := impl.dbConnection.
+		ModelContext(ctx, &appReleases).
 		Where("app_id = ?", appId).
 		Where("environment_id =? ", environmentId).
 		Where("trigger_time >= ?", from).
 		Where("trigger_time <= ?", to).
-		Order("id desc").
+		Where("reset_batch_id is null").
+		Where("excluded_from_metrics = false")
+	for key, value := range tags {
+		query = query.Where("exists (select 1 from release_tag rt where rt.app_release_id = app_release.id and rt.key = ? and rt.value = ?)", key, value)
+	}
+	err := query.Order("id desc").
 		Select()
 	return appReleases, err
 }
 
-func (impl *AppReleaseRepositoryImpl) cleanAppDataForEnvironment(appId, environmentId int, tx *pg.Tx) error {
-	r, err := tx.Model((*AppRelease)(nil)).
-		Where("app_id =?", appId).
-		Where("environment_id =?", environmentId).
-		Delete()
-	if err != nil {
-		impl.logger.Infow("AppRelease deleted for ", "app", appId, "env", environmentId, "count", r.RowsAffected())
+func (impl *AppReleaseRepositoryImpl) FindById(ctx context.Context, id int) (*AppRelease, error) {
+	appRelease := &AppRelease{}
+	err := impl.dbConnection.
+		ModelContext(ctx, appRelease).
+		Where("id = ?", id).
+		Where("reset_batch_id is null").
+		Select()
+	return appRelease, err
+}
+
+func (impl *AppReleaseRepositoryImpl) FindByTrigger(ctx context.Context, appId, environmentId, pipelineOverrideId int) (*AppRelease, error) {
+	appRelease := &AppRelease{}
+	err := impl.dbConnection.
+		ModelContext(ctx, appRelease).
+		Where("app_id = ?", appId).
+		Where("environment_id = ?", environmentId).
+		Where("pipeline_override_id = ?", pipelineOverrideId).
+		Apply(liveReleases(ctx)).
+		Last()
+	return withDeferredUpdate(ctx, appRelease, err)
+}
+
+func (impl *AppReleaseRepositoryImpl) FindStalledReleases(ctx context.Context, updatedBefore, createdAfter time.Time, limit int) ([]*AppRelease, error) {
+	var appReleases []*AppRelease
+	err := impl.dbConnection.
+		ModelContext(ctx, &appReleases).
+		Where("reset_batch_id is null").
+		Where("process_status in (?)", pg.In([]ProcessStage{ReleaseTypeDetermined, LeadTimeFetch})).
+		Where("updated_time < ?", updatedBefore).
+		Where("created_time > ?", createdAfter).
+		Order("id asc").
+		Limit(limit).
+		Select()
+	return appReleases, err
+}
+
+type AppEnvironment struct {
+	AppId         int `pg:"app_id"`
+	EnvironmentId int `pg:"environment_id"`
+}
+
+func (impl *AppReleaseRepositoryImpl) FindAppEnvironmentsWithOtherFailurePolicy(ctx context.Context, environmentIds []int, excludedEnvironmentIds []int,
+	failurePolicyId, version int) ([]AppEnvironment, error) {
+	var appEnvironments []AppEnvironment
+	query := impl.dbConnection.
+		ModelContext(ctx, (*AppRelease)(nil)).
+		ColumnExpr("distinct app_id, environment_id").
+		Where("reset_batch_id is null").
+		Where("(failure_policy_id is distinct from ? or failure_policy_version is distinct from ?)", failurePolicyId, version)
+	if len(environmentIds) > 0 {
+		query = query.Where("environment_id in (?)", pg.In(environmentIds))
+	}
+	if len(excludedEnvironmentIds) > 0 {
+		query = query.Where("environment_id not in (?)", pg.In(excludedEnvironmentIds))
+	}
+	err := query.Order("environment_id asc", "app_id asc").Select(&appEnvironments)
+	return appEnvironments, err
+}
+
+func (impl *AppReleaseRepositoryImpl) MarkProcessed(ctx context.Context, appRelease *AppRelease, message *ReleaseOutboxMessage) error {
+	return impl.dbConnection.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		err := updateReleaseWithHistory(ctx, tx, appRelease, "ingestion completed", func() error {
+			appRelease.ProcessStage = Processed
+			_, err := tx.ModelContext(ctx, appRelease).
+				Column("process_status", "updated_time").
+				WherePK().
+				Update()
+			return err
+		})
+		if err != nil {
+			impl.logger.Errorw("error in marking release processed", "appRelease", appRelease.Id, "err", err)
+			return err
+		}
+		if message == nil {
+			return nil
+		}
+		err = impl.releaseOutboxRepository.Save(ctx, message, tx)
+		if err != nil {
+			impl.logger.Errorw("error in saving release outbox message", "appRelease", appRelease.Id, "err", err)
+		}
+		return err
+	})
+}
+
+func (impl *AppReleaseRepositoryImpl) ReclassifyAppEnvironment(ctx context.Context, appId, environmentId int, cause string, classify func(appReleases []*AppRelease) error) error {
+	return impl.dbConnection.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		var appReleases []*AppRelease
+		err := tx.ModelContext(ctx, &appReleases).
+			Where("app_id = ?", appId).
+			Where("environment_id = ?", environmentId).
+			Where("reset_batch_id is null").
+			Order("id asc").
+			For("UPDATE").
+			Select()
+		if err != nil {
+			impl.logger.Errorw("error in locking releases to reclassify", "appId", appId, "environmentId", environmentId, "err", err)
+			return err
+		}
+		if err = classify(appReleases); err != nil {
+			return err
+		}
+		for _, appRelease := range appReleases {
+			err = updateReleaseWithHistory(ctx, tx, appRelease, cause, func() error {
+				_, err := tx.ModelContext(ctx, appRelease).
+					Column("release_status", "release_type", "failure_reason", "failure_policy_id", "failure_policy_version", "updated_time").
+					WherePK().
+					Update()
+				return err
+			})
+			if err != nil {
+				impl.logger.Errorw("error in updating failure classification", "appRelease", appRelease.Id, "err", err)
+				return err
+			}
+		}
 		return nil
-	} else {
+	})
+}
+
+func (impl *AppReleaseRepositoryImpl) SwapRecomputedReleases(ctx context.Context, resetBatch *ResetBatch, from, to time.Time, eventAuthor string) (int, error) {
+	replaced := 0
+	err := impl.dbConnection.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		// pairs a replaced release with the rebuilt release of the same trigger as old_id, new_id
+		const rebuilt = `select distinct on (o.id) o.id as old_id, n.id as new_id from app_release o
+			join app_release n on n.app_id = o.app_id and n.environment_id = o.environment_id
+				and n.pipeline_override_id = o.pipeline_override_id and n.reset_batch_id = ?0
+			where o.app_id = ?1 and o.environment_id = ?2 and o.reset_batch_id is null
+				and o.trigger_time >= ?3 and o.trigger_time <= ?4
+			order by o.id, n.id desc`
+		params := []interface{}{resetBatch.Id, resetBatch.AppId, resetBatch.EnvironmentId, from, to, eventAuthor}
+		statements := []struct{ name, query string }{
+			{"tags", `insert into release_tag (app_release_id, key, value)
+				select m.new_id, t.key, t.value from (` + rebuilt + `) m join release_tag t on t.app_release_id = m.old_id
+				on conflict (app_release_id, key) do nothing`},
+			{"annotations", `insert into release_annotation (app_release_id, text, created_by, created_on)
+				select m.new_id, a.text, a.created_by, a.created_on from (` + rebuilt + `) m join release_annotation a on a.app_release_id = m.old_id
+				where a.created_by <> ?5`},
+			{"rollback targets", `update app_release r set rollback_target_release_id = m.new_id from (` + rebuilt + `) m
+				where r.rollback_target_release_id = m.old_id and r.reset_batch_id is null`},
+			{"rolled back releases", `update app_release r set rolled_back_release_id = m.new_id from (` + rebuilt + `) m
+				where r.rolled_back_release_id = m.old_id and r.reset_batch_id is null`},
+		}
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement.query, params...); err != nil {
+				impl.logger.Errorw("error in carrying over to recomputed releases", "what", statement.name, "resetBatch", resetBatch.Id, "err", err)
+				return err
+			}
+		}
+		if scope := RecomputeScopeFrom(ctx); scope != nil {
+			for _, update := range scope.deferredUpdates() {
+				err := updateReleaseWithHistory(ctx, tx, update.appRelease, update.cause, func() error {
+					_, err := tx.ModelContext(ctx, update.appRelease).
+						Column("release_status", "failure_reason", "updated_time").
+						WherePK().
+						Update()
+					return err
+				})
+				if err == pg.ErrNoRows {
+					// archived meanwhile
+					continue
+				} else if err != nil {
+					impl.logger.Errorw("error in applying deferred update of recompute", "appRelease", update.appRelease.Id, "resetBatch", resetBatch.Id, "err", err)
+					return err
+				}
+			}
+		}
+		staged, err := tx.ModelContext(ctx, (*AppRelease)(nil)).Where("reset_batch_id = ?", resetBatch.Id).Count()
+		if err != nil {
+			impl.logger.Errorw("error in counting recomputed releases", "resetBatch", resetBatch.Id, "err", err)
+			return err
+		}
+		r, err := tx.ExecContext(ctx, `update app_release set reset_batch_id = case when reset_batch_id = ?0 then null else ?0 end
+			where app_id = ?1 and environment_id = ?2
+				and (reset_batch_id = ?0 or (reset_batch_id is null and trigger_time >= ?3 and trigger_time <= ?4))`, params...)
+		if err != nil {
+			impl.logger.Errorw("error in swapping recomputed releases", "resetBatch", resetBatch.Id, "err", err)
+			return err
+		}
+		replaced = r.RowsAffected() - staged
+		resetBatch.Status = ResetReplaced
+		resetBatch.ReleaseCount = replaced
+		_, err = impl.resetBatchRepository.Update(ctx, resetBatch, tx)
 		return err
+	})
+	if err != nil {
+		// rolled back, the batch is still staged and dropped as such
+		resetBatch.Status = ResetStaged
 	}
+	return replaced, err
+}
+
+func (impl *AppReleaseRepositoryImpl) SoftDeleteAppDataForEnvironment(ctx context.Context, resetBatch *ResetBatch) (*ResetBatch, error) {
+	err := impl.dbConnection.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := impl.resetBatchRepository.Save(ctx, resetBatch, tx)
+		if err != nil {
+			impl.logger.Errorw("error in saving reset batch", "resetBatch", resetBatch, "err", err)
+			return err
+		}
+		r, err := tx.ModelContext(ctx, (*AppRelease)(nil)).
+			Set("reset_batch_id = ?", resetBatch.Id).
+			Where("app_id =?", resetBatch.AppId).
+			Where("environment_id =?", resetBatch.EnvironmentId).
+			Where("reset_batch_id is null").
+			Update()
+		if err != nil {
+			impl.logger.Errorw("error in marking AppRelease reset", "resetBatch", resetBatch, "err", err)
+			return err
+		}
+		resetBatch.ReleaseCount = r.RowsAffected()
+		_, err = impl.resetBatchRepository.Update(ctx, resetBatch, tx)
+		return err
+	})
+	return resetBatch, err
 }
 
-func (impl *AppReleaseRepositoryImpl) CleanAppDataForEnvironment(appId, environmentId int) error {
+func (impl *AppReleaseRepositoryImpl) RestoreResetBatch(ctx context.Context, resetBatch *ResetBatch) (*ResetBatch, error) {
 	err := impl.dbConnection.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		err := impl.leadTimeRepository.CleanAppDataForEnvironment(appId, environmentId, tx)
+		err := impl.resetBatchRepository.LockStatus(ctx, resetBatch, tx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ModelContext(ctx, (*AppRelease)(nil)).
+			Set("reset_batch_id = null").
+			Where("reset_batch_id = ?", resetBatch.Id).
+			Update()
+		if err != nil {
+			impl.logger.Errorw("error in restoring AppRelease", "resetBatch", resetBatch, "err", err)
+			return err
+		}
+		resetBatch.Status = ResetRestored
+		_, err = impl.resetBatchRepository.Update(ctx, resetBatch, tx)
+		return err
+	})
+	return resetBatch, err
+}
+
+func (impl *AppReleaseRepositoryImpl) PurgeResetBatch(ctx context.Context, resetBatch *ResetBatch) (*ResetBatch, error) {
+	err := impl.dbConnection.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		err := impl.resetBatchRepository.LockStatus(ctx, resetBatch, tx)
+		if err != nil {
+			return err
+		}
+		err = impl.leadTimeRepository.DeleteByResetBatchId(ctx, resetBatch.Id, tx)
+		if err != nil {
+			impl.logger.Errorw("error in purging lead time", "resetBatch", resetBatch.Id, "err", err)
+			return err
+		}
+		err = impl.pipelineMaterialRepository.DeleteByResetBatchId(ctx, resetBatch.Id, tx)
+		if err != nil {
+			impl.logger.Errorw("error in purging pipeline material", "resetBatch", resetBatch.Id, "err", err)
+			return err
+		}
+		r, err := tx.ModelContext(ctx, (*AppRelease)(nil)).
+			Where("reset_batch_id = ?", resetBatch.Id).
+			Delete()
+		if err != nil {
+			impl.logger.Errorw("error in purging AppRelease", "resetBatch", resetBatch.Id, "err", err)
+			return err
+		}
+		impl.logger.Infow("AppRelease purged for ", "resetBatch", resetBatch.Id, "count", r.RowsAffected())
+		resetBatch.Status = ResetPurged
+		_, err = impl.resetBatchRepository.Update(ctx, resetBatch, tx)
+		return err
+	})
+	return resetBatch, err
+}
+
+func (impl *AppReleaseRepositoryImpl) ArchiveReleasesBefore(ctx context.Context, environmentIds []int, excludedEnvironmentIds []int, before time.Time, limit int,
+	archive func(releases []*AppRelease, materials []*PipelineMaterial, tags []*ReleaseTag, tx *pg.Tx) error) (int, error) {
+	archived := 0
+	err := impl.dbConnection.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		var releases []*AppRelease
+		query := tx.ModelContext(ctx, &releases).
+			Where("trigger_time < ?", before).
+			Where("reset_batch_id is null")
+		if len(environmentIds) > 0 {
+			query = query.Where("environment_id in (?)", pg.In(environmentIds))
+		}
+		if len(excludedEnvironmentIds) > 0 {
+			query = query.Where("environment_id not in (?)", pg.In(excludedEnvironmentIds))
+		}
+		err := query.Order("id asc").
+			Limit(limit).
+			For("UPDATE SKIP LOCKED").
+			Select()
+		if err != nil || len(releases) == 0 {
+			return err
+		}
+		ids := make([]int, 0, len(releases))
+		releaseById := make(map[int]*AppRelease, len(releases))
+		for _, release := range releases {
+			ids = append(ids, release.Id)
+			releaseById[release.Id] = release
+		}
+		var leadTimes []*LeadTime
+		err = tx.ModelContext(ctx, &leadTimes).Where("app_release_id in (?)", pg.In(ids)).Select()
+		if err != nil {
+			impl.logger.Errorw("error in fetching lead time for archival", "err", err)
+			return err
+		}
+		for _, leadTime := range leadTimes {
+			releaseById[leadTime.AppReleaseId].LeadTime = leadTime
+		}
+		var materials []*PipelineMaterial
+		err = tx.ModelContext(ctx, &materials).Where("app_release_id in (?)", pg.In(ids)).Select()
+		if err != nil {
+			impl.logger.Errorw("error in fetching materials for archival", "err", err)
+			return err
+		}
+		var tags []*ReleaseTag
+		err = tx.ModelContext(ctx, &tags).Where("app_release_id in (?)", pg.In(ids)).Select()
+		if err != nil {
+			impl.logger.Errorw("error in fetching tags for archival", "err", err)
+			return err
+		}
+		err = archive(releases, materials, tags, tx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ModelContext(ctx, (*LeadTime)(nil)).Where("app_release_id in (?)", pg.In(ids)).Delete()
 		if err != nil {
-			impl.logger.Errorw("error in cleaning pipeline", "appId", appId, "environmentId", environmentId, "err", err)
+			impl.logger.Errorw("error in deleting archived lead time", "err", err)
 			return err
 		}
-		err = impl.pipelineMaterialRepository.CleanAppDataForEnvironment(appId, environmentId, tx)
+		_, err = tx.ModelContext(ctx, (*PipelineMaterial)(nil)).Where("app_release_id in (?)", pg.In(ids)).Delete()
 		if err != nil {
-			impl.logger.Errorw("error in cleaning pipeline", "appId", appId, "environmentId", environmentId, "err", err)
+			impl.logger.Errorw("error in deleting archived pipeline material", "err", err)
 			return err
 		}
-		err = impl.cleanAppDataForEnvironment(appId, environmentId, tx)
+		_, err = tx.ModelContext(ctx, (*AppRelease)(nil)).Where("id in (?)", pg.In(ids)).Delete()
 		if err != nil {
-			impl.logger.Errorw("error in cleaning AppRelease", "appId", appId, "environmentId", environmentId, "err", err)
+			impl.logger.Errorw("error in deleting archived app release", "err", err)
 			return err
 		}
+		archived = len(releases)
 		return nil
 	})
-	return err
+	return archived, err
 }
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRecomputeScope_DefersUpdatesOfLiveReleases(t *testing.T) {
+	scope := &RecomputeScope{ResetBatchId: 9, From: time.Now().Add(-time.Hour)}
+	ctx := WithRecomputeScope(context.Background(), scope)
+	// no db connection: updating a live release under the scope must not reach the db
+	repository := &AppReleaseRepositoryImpl{}
+
+	live := &AppRelease{Id: 4, ReleaseStatus: Success}
+	live.ReleaseStatus = Failure
+	live.FailureReason = RedeployWithin
+	if _, err := repository.Update(ctx, live, "redeployed within 60 minutes by release 12"); err != nil {
+		t.Fatalf("Update() of live release error = %v", err)
+	}
+	live.FailureReason = FollowedByRollback
+	if _, err := repository.Update(ctx, live, "rolled back by release 13"); err != nil {
+		t.Fatalf("Update() of live release error = %v", err)
+	}
+	// changes after the update are not deferred with it
+	live.FailureReason = ""
+
+	read := &AppRelease{Id: 4, ReleaseStatus: Success}
+	if _, err := withDeferredUpdate(ctx, read, nil); err != nil || read.ReleaseStatus != Failure || read.FailureReason != FollowedByRollback {
+		t.Errorf("live release read = %s %q, want the latest deferred classification", read.ReleaseStatus, read.FailureReason)
+	}
+	staged := &AppRelease{Id: 5, ResetBatchId: 9, ReleaseStatus: Success}
+	if withDeferredUpdate(ctx, staged, nil); staged.ReleaseStatus != Success {
+		t.Errorf("staged release read = %s, want it untouched", staged.ReleaseStatus)
+	}
+	other := &AppRelease{Id: 6, ReleaseStatus: Success}
+	if withDeferredUpdate(context.Background(), other, nil); other.ReleaseStatus != Success {
+		t.Errorf("release read outside a recompute = %s, want it untouched", other.ReleaseStatus)
+	}
+
+	updates := scope.deferredUpdates()
+	if len(updates) != 2 || updates[0].cause != "redeployed within 60 minutes by release 12" || updates[0].appRelease.FailureReason != RedeployWithin {
+		t.Fatalf("deferred updates = %d, first %+v, want both in order", len(updates), updates[0])
+	}
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// FailurePolicy chooses the rules that mark releases of an environment failed, environment 0 holds the default
+// policy of environments without their own. Version grows with every change so that releases classified under
+// an older version can be found and recomputed.
+type FailurePolicy struct {
+	tableName     struct{}      `pg:"failure_policy"`
+	Id            int           `pg:"id,pk"`
+	EnvironmentId int           `pg:"environment_id,notnull,use_zero"`
+	Rules         []FailureRule `pg:"rules,notnull"`
+	Version       int           `pg:"version,notnull,use_zero"`
+	UpdatedBy     string        `pg:"updated_by,notnull"`
+	UpdatedOn     time.Time     `pg:"updated_on,notnull"`
+}
+
+// DefaultEnvironmentId is the environment of the default failure policy
+const DefaultEnvironmentId = 0
+
+type FailureRuleType string
+
+const (
+	// RedeployWithin fails a release replaced by another deployment, other than a rollback, within WindowMins
+	RedeployWithin FailureRuleType = "redeploy-within"
+	// FollowedByRollback fails a release replaced by a rollback
+	FollowedByRollback FailureRuleType = "followed-by-rollback"
+	// CdStatusFailed fails a release whose deployment reported a failed status
+	CdStatusFailed FailureRuleType = "cd-status-failed"
+	// HealthDegradedWithin fails a release whose app reported degraded health within WindowMins of the trigger
+	HealthDegradedWithin FailureRuleType = "health-degraded-within"
+	// LinkedIncident fails a release tagged with TagKey, incident unless set
+	LinkedIncident FailureRuleType = "linked-incident"
+)
+
+const defaultIncidentTagKey = "incident"
+
+// status event values the failure rules look at
+const (
+	CdStatusFailedValue = "Failed"
+	HealthDegraded      = "Degraded"
+)
+
+type FailureRule struct {
+	Type       FailureRuleType `json:"type"`
+	WindowMins int             `json:"windowMins,omitempty"`
+	TagKey     string          `json:"tagKey,omitempty"`
+}
+
+func (rule FailureRule) window() time.Duration {
+	return time.Duration(rule.WindowMins) * time.Minute
+}
+
+func (rule FailureRule) Validate() error {
+	switch rule.Type {
+	case RedeployWithin, HealthDegradedWithin:
+		if rule.WindowMins <= 0 {
+			return fmt.Errorf("rule %s needs windowMins", rule.Type)
+		}
+	case FollowedByRollback, CdStatusFailed, LinkedIncident:
+	default:
+		return fmt.Errorf("unknown failure rule %q", rule.Type)
+	}
+	return nil
+}
+
+// DefaultFailurePolicy is in force until a default policy is saved, it keeps the historical heuristics of lens
+func DefaultFailurePolicy() *FailurePolicy {
+	return &FailurePolicy{
+		EnvironmentId: DefaultEnvironmentId,
+		Rules: []FailureRule{
+			{Type: RedeployWithin, WindowMins: 120},
+			{Type: FollowedByRollback},
+		},
+	}
+}
+
+func (policy *FailurePolicy) Rule(ruleType FailureRuleType) (FailureRule, bool) {
+	for _, rule := range policy.Rules {
+		if rule.Type == ruleType {
+			return rule, true
+		}
+	}
+	return FailureRule{}, false
+}
+
+// ReplacedWithin is true when next replaced release soon enough for the redeploy rule, next is then a patch
+func (policy *FailurePolicy) ReplacedWithin(release *AppRelease, next *AppRelease) bool {
+	rule, ok := policy.Rule(RedeployWithin)
+	return ok && next != nil && next.ReleaseType != RollBack && next.TriggerTime.Sub(release.TriggerTime) < rule.window()
+}
+
+// FailureReason returns the first rule failing release, empty when none does. next is the release that replaced
+// it, nil for the live one, tags and statusEvents are those of release.
+func (policy *FailurePolicy) FailureReason(release *AppRelease, next *AppRelease, tags map[string]string, statusEvents []*ReleaseStatusEvent) FailureRuleType {
+	for _, rule := range policy.Rules {
+		failed := false
+		switch rule.Type {
+		case RedeployWithin:
+			failed = policy.ReplacedWithin(release, next)
+		case FollowedByRollback:
+			failed = next != nil && next.ReleaseType == RollBack && next.RolledBackReleaseId == release.Id
+		case CdStatusFailed:
+			for _, statusEvent := range statusEvents {
+				failed = failed || statusEvent.CdStatus == CdStatusFailedValue
+			}
+		case HealthDegradedWithin:
+			for _, statusEvent := range statusEvents {
+				sinceTrigger := statusEvent.EventTime.Sub(release.TriggerTime)
+				failed = failed || (statusEvent.HealthStatus == HealthDegraded && sinceTrigger >= 0 && sinceTrigger <= rule.window())
+			}
+		case LinkedIncident:
+			tagKey := rule.TagKey
+			if tagKey == "" {
+				tagKey = defaultIncidentTagKey
+			}
+			failed = tags[tagKey] != ""
+		}
+		if failed {
+			return rule.Type
+		}
+	}
+	return ""
+}
+
+type FailurePolicyRepository interface {
+	// FindByEnvironmentId returns the policy saved for the environment, pg.ErrNoRows if it has none
+	FindByEnvironmentId(ctx context.Context, environmentId int) (*FailurePolicy, error)
+	FindAll(ctx context.Context) ([]*FailurePolicy, error)
+	// Save upserts the policy of its environment, bumping the version
+	Save(ctx context.Context, policy *FailurePolicy) (*FailurePolicy, error)
+}
+
+type FailurePolicyRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewFailurePolicyRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *FailurePolicyRepositoryImpl {
+	return &FailurePolicyRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *FailurePolicyRepositoryImpl) FindByEnvironmentId(ctx context.Context, environmentId int) (*FailurePolicy, error) {
+	policy := &FailurePolicy{}
+	err := impl.dbConnection.
+		ModelContext(ctx, policy).
+		Where("environment_id = ?", environmentId).
+		Select()
+	return policy, err
+}
+
+func (impl *FailurePolicyRepositoryImpl) FindAll(ctx context.Context) ([]*FailurePolicy, error) {
+	var policies []*FailurePolicy
+	err := impl.dbConnection.ModelContext(ctx, &policies).Order("environment_id asc").Select()
+	return policies, err
+}
+
+func (impl *FailurePolicyRepositoryImpl) Save(ctx context.Context, policy *FailurePolicy) (*FailurePolicy, error) {
+	policy.Version = 1
+	_, err := impl.dbConnection.ModelContext(ctx, policy).
+		OnConflict("(environment_id) DO UPDATE").
+		Set("rules = EXCLUDED.rules").
+		Set("version = failure_policy.version + 1").
+		Set("updated_by = EXCLUDED.updated_by").
+		Set("updated_on = EXCLUDED.updated_on").
+		Returning("*").
+		Insert()
+	return policy, err
+}
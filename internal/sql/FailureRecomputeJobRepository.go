@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// FailureRecomputeJob classifies again the releases of the environments following the failure policy of
+// EnvironmentId, those classified with another policy or an older version of it
+type FailureRecomputeJob struct {
+	tableName            struct{}        `pg:"failure_recompute_job"`
+	Id                   int             `pg:"id,pk"`
+	EnvironmentId        int             `pg:"environment_id,notnull,use_zero"`
+	FailurePolicyId      int             `pg:"failure_policy_id,notnull,use_zero"`
+	FailurePolicyVersion int             `pg:"failure_policy_version,notnull,use_zero"`
+	Status               RecomputeStatus `pg:"status,notnull,use_zero"`
+	AppEnvironmentCount  int             `pg:"app_environment_count,notnull,use_zero"`
+	ReleaseCount         int             `pg:"release_count,notnull,use_zero"`
+	// ChangedCount counts releases whose status or type changed
+	ChangedCount int       `pg:"changed_count,notnull,use_zero"`
+	Error        string    `pg:"error"`
+	RequestedBy  string    `pg:"requested_by,notnull"`
+	CreatedOn    time.Time `pg:"created_on,notnull"`
+	FinishedOn   time.Time `pg:"finished_on"`
+}
+
+// ErrFailureRecomputeRunning is returned when saving a running job while another one runs
+var ErrFailureRecomputeRunning = errors.New("a failure recompute job is already running")
+
+// failureRecomputeRunningIndex allows a single running job
+const failureRecomputeRunningIndex = "idx_failure_recompute_job_running"
+
+type FailureRecomputeJobRepository interface {
+	// Save saves a new job, ErrFailureRecomputeRunning if it is running while another job runs
+	Save(ctx context.Context, job *FailureRecomputeJob) error
+	Update(ctx context.Context, job *FailureRecomputeJob) error
+	FindById(ctx context.Context, id int) (*FailureRecomputeJob, error)
+	FindRunning(ctx context.Context) ([]*FailureRecomputeJob, error)
+}
+
+type FailureRecomputeJobRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewFailureRecomputeJobRepositoryImpl(dbConnection *pg.DB, logger *zap.SugaredLogger) *FailureRecomputeJobRepositoryImpl {
+	return &FailureRecomputeJobRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *FailureRecomputeJobRepositoryImpl) Save(ctx context.Context, job *FailureRecomputeJob) error {
+	_, err := impl.dbConnection.ModelContext(ctx, job).Insert()
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) && pgErr.IntegrityViolation() && pgErr.Field('n') == failureRecomputeRunningIndex {
+		return ErrFailureRecomputeRunning
+	}
+	return err
+}
+
+func (impl *FailureRecomputeJobRepositoryImpl) Update(ctx context.Context, job *FailureRecomputeJob) error {
+	_, err := impl.dbConnection.ModelContext(ctx, job).WherePK().Update()
+	return err
+}
+
+func (impl *FailureRecomputeJobRepositoryImpl) FindById(ctx context.Context, id int) (*FailureRecomputeJob, error) {
+	job := &FailureRecomputeJob{}
+	err := impl.dbConnection.ModelContext(ctx, job).Where("id = ?", id).Select()
+	return job, err
+}
+
+func (impl *FailureRecomputeJobRepositoryImpl) FindRunning(ctx context.Context) ([]*FailureRecomputeJob, error) {
+	var jobs []*FailureRecomputeJob
+	err := impl.dbConnection.ModelContext(ctx, &jobs).Where("status = ?", RecomputeRunning).Order("id asc").Select()
+	return jobs, err
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// GitChangesCache holds the git changes of a commit range, keyed by the sha256 of material and commits
+type GitChangesCache struct {
+	tableName          struct{}        `pg:"git_changes_cache"`
+	CacheKey           string          `pg:"cache_key,pk"`
+	PipelineMaterialId int             `pg:"pipeline_material_id,notnull"`
+	OldCommit          string          `pg:"old_commit,notnull"`
+	NewCommit          string          `pg:"new_commit,notnull"`
+	Changes            json.RawMessage `pg:"changes,type:jsonb,notnull"`
+	SizeBytes          int             `pg:"size_bytes,notnull,use_zero"`
+	CreatedOn          time.Time       `pg:"created_on,notnull"`
+	LastAccessedOn     time.Time       `pg:"last_accessed_on,notnull"`
+}
+
+type GitChangesCacheRepository interface {
+	// FindByKey returns pg.ErrNoRows on a miss and marks a hit as recently accessed
+	FindByKey(ctx context.Context, cacheKey string) (*GitChangesCache, error)
+	// Save keeps an already cached entry as it is
+	Save(ctx context.Context, entry *GitChangesCache) error
+	// Purge deletes the entries of a pipeline material, all entries for 0
+	Purge(ctx context.Context, pipelineMaterialId int) (int, error)
+	// EvictLeastRecentlyUsed deletes all but the keep most recently accessed entries
+	EvictLeastRecentlyUsed(ctx context.Context, keep int) (int, error)
+}
+
+type GitChangesCacheRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewGitChangesCacheRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *GitChangesCacheRepositoryImpl {
+	return &GitChangesCacheRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *GitChangesCacheRepositoryImpl) FindByKey(ctx context.Context, cacheKey string) (*GitChangesCache, error) {
+	entry := &GitChangesCache{}
+	_, err := impl.dbConnection.QueryOneContext(ctx, entry,
+		"update git_changes_cache set last_accessed_on = now() where cache_key = ? returning *", cacheKey)
+	return entry, err
+}
+
+func (impl *GitChangesCacheRepositoryImpl) Save(ctx context.Context, entry *GitChangesCache) error {
+	_, err := impl.dbConnection.ModelContext(ctx, entry).OnConflict("(cache_key) DO NOTHING").Insert()
+	return err
+}
+
+func (impl *GitChangesCacheRepositoryImpl) Purge(ctx context.Context, pipelineMaterialId int) (int, error) {
+	query := impl.dbConnection.ModelContext(ctx, (*GitChangesCache)(nil))
+	if pipelineMaterialId > 0 {
+		query = query.Where("pipeline_material_id = ?", pipelineMaterialId)
+	} else {
+		query = query.Where("true")
+	}
+	res, err := query.Delete()
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+func (impl *GitChangesCacheRepositoryImpl) EvictLeastRecentlyUsed(ctx context.Context, keep int) (int, error) {
+	res, err := impl.dbConnection.ExecContext(ctx,
+		"delete from git_changes_cache where last_accessed_on < (select last_accessed_on from git_changes_cache order by last_accessed_on desc offset ? limit 1)", keep)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
@@ -17,6 +17,7 @@
 package sql
 
 import (
+	"context"
 	"time"
 
 	pg "github.com/go-pg/pg/v10"
@@ -35,9 +36,10 @@ type LeadTime struct {
 }
 
 type LeadTimeRepository interface {
-	Save(leadTime *LeadTime) (*LeadTime, error)
-	FindByIds(ids []int) ([]LeadTime, error)
-	CleanAppDataForEnvironment(appId, environmentId int, tx *pg.Tx) error
+	// Save inserts the lead time of a release or replaces it when the release is processed again
+	Save(ctx context.Context, leadTime *LeadTime) (*LeadTime, error)
+	FindByIds(ctx context.Context, ids []int) ([]LeadTime, error)
+	DeleteByResetBatchId(ctx context.Context, resetBatchId int, tx *pg.Tx) error
 }
 
 type LeadTimeRepositoryImpl struct {
@@ -53,31 +55,33 @@ func NewLeadTimeRepositoryImpl(dbConnection *pg.DB,
 	}
 }
 
-func (impl *LeadTimeRepositoryImpl) Save(leadTime *LeadTime) (*LeadTime, error) {
-	_, err := impl.dbConnection.Model(leadTime).Insert()
+func (impl *LeadTimeRepositoryImpl) Save(ctx context.Context, leadTime *LeadTime) (*LeadTime, error) {
+	_, err := impl.dbConnection.ModelContext(ctx, leadTime).
+		OnConflict("(app_release_id) DO UPDATE").
+		Set("pipeline_material_id = EXCLUDED.pipeline_material_id, commit_hash = EXCLUDED.commit_hash, commit_time = EXCLUDED.commit_time, lead_time = EXCLUDED.lead_time").
+		Insert()
 	return leadTime, err
 }
 
-func (impl *LeadTimeRepositoryImpl) FindByIds(ids []int) ([]LeadTime, error) {
+func (impl *LeadTimeRepositoryImpl) FindByIds(ctx context.Context, ids []int) ([]LeadTime, error) {
 	var leadTimes []LeadTime
 	err := impl.dbConnection.
-		Model(&leadTimes).
+		ModelContext(ctx, &leadTimes).
 		Where("app_release_id in (?)", pg.In(ids)).
 		Select()
 	return leadTimes, err
 }
 
-func (impl *LeadTimeRepositoryImpl) CleanAppDataForEnvironment(appId, environmentId int, tx *pg.Tx) error {
-	r, err := tx.Model(&LeadTime{}).
+func (impl *LeadTimeRepositoryImpl) DeleteByResetBatchId(ctx context.Context, resetBatchId int, tx *pg.Tx) error {
+	r, err := tx.ModelContext(ctx, &LeadTime{}).
 		Table("app_release").
-		Where("app_release.app_id =?", appId).
-		Where("app_release.environment_id = ?", environmentId).
+		Where("app_release.reset_batch_id = ?", resetBatchId).
 		Where("app_release.id = lead_time.app_release_id").
 		Delete()
 	if err != nil {
 		return err
 	} else {
-		impl.logger.Infow("leadtime deleted for ", "app", appId, "env", environmentId, "count", r.RowsAffected())
+		impl.logger.Infow("leadtime deleted for ", "resetBatchId", resetBatchId, "count", r.RowsAffected())
 		return nil
 	}
 }
@@ -17,22 +17,42 @@
 package sql
 
 import (
+	"context"
 	pg "github.com/go-pg/pg/v10"
 	"go.uber.org/zap"
 )
 
 type PipelineMaterial struct {
-	tableName          struct{} `pg:"pipeline_material"`
-	PipelineMaterialId int      `pg:"pipeline_material_id"`
-	CommitHash         string   `pg:"commit_hash"`
-	AppReleaseId       int      `pg:"app_release_id"`
+	tableName          struct{}         `pg:"pipeline_material"`
+	PipelineMaterialId int              `pg:"pipeline_material_id"`
+	CommitHash         string           `pg:"commit_hash"`
+	AppReleaseId       int              `pg:"app_release_id"`
+	GitChangesStatus   GitChangesStatus `pg:"git_changes_status,notnull,use_zero"`
+	GitChangesError    string           `pg:"git_changes_error"` //set when fetching the changes failed
 	AppRelease         *AppRelease
 }
+
+// GitChangesStatus tells whether the changes of a material since the previous release were fetched
+type GitChangesStatus int
+
+const (
+	GitChangesPending GitChangesStatus = iota
+	GitChangesFetched
+	GitChangesUnchanged  //same commit as the previous release
+	GitChangesNoBaseline //no previous release of the material to compare with
+	GitChangesFailed
+)
+
+func (status GitChangesStatus) String() string {
+	return [...]string{"Pending", "Fetched", "Unchanged", "NoBaseline", "Failed"}[status]
+}
+
 type PipelineMaterialRepository interface {
-	Save(pipelineMaterial ...*PipelineMaterial) error
-	FindByAppReleaseId(appReleaseId int) ([]*PipelineMaterial, error)
-	FindByAppReleaseIds(appReleaseIds []int) ([]*PipelineMaterial, error)
-	CleanAppDataForEnvironment(appId, environmentId int, tx *pg.Tx) error
+	Save(ctx context.Context, pipelineMaterial ...*PipelineMaterial) error
+	FindByAppReleaseId(ctx context.Context, appReleaseId int) ([]*PipelineMaterial, error)
+	FindByAppReleaseIds(ctx context.Context, appReleaseIds []int) ([]*PipelineMaterial, error)
+	DeleteByResetBatchId(ctx context.Context, resetBatchId int, tx *pg.Tx) error
+	UpdateGitChangesStatus(ctx context.Context, pipelineMaterial ...*PipelineMaterial) error
 }
 
 type PipelineMaterialRepositoryImpl struct {
@@ -47,34 +67,47 @@ func NewPipelineMaterialRepositoryImpl(dbConnection *pg.DB,
 		logger:       logger,
 	}
 }
-func (impl *PipelineMaterialRepositoryImpl) FindByAppReleaseId(appReleaseId int) ([]*PipelineMaterial, error) {
+func (impl *PipelineMaterialRepositoryImpl) FindByAppReleaseId(ctx context.Context, appReleaseId int) ([]*PipelineMaterial, error) {
 	var pipelineMaterials []*PipelineMaterial
-	err := impl.dbConnection.Model(&pipelineMaterials).Where("app_release_id = ?", appReleaseId).Select()
+	err := impl.dbConnection.ModelContext(ctx, &pipelineMaterials).Where("app_release_id = ?", appReleaseId).Select()
 	return pipelineMaterials, err
 }
 
-func (impl *PipelineMaterialRepositoryImpl) FindByAppReleaseIds(appReleaseIds []int) ([]*PipelineMaterial, error) {
+func (impl *PipelineMaterialRepositoryImpl) FindByAppReleaseIds(ctx context.Context, appReleaseIds []int) ([]*PipelineMaterial, error) {
 	var pipelineMaterials []*PipelineMaterial
-	err := impl.dbConnection.Model(&pipelineMaterials).Where("app_release_id in (?)", pg.In(appReleaseIds)).Select()
+	err := impl.dbConnection.ModelContext(ctx, &pipelineMaterials).Where("app_release_id in (?)", pg.In(appReleaseIds)).Select()
 	return pipelineMaterials, err
 }
 
-func (impl *PipelineMaterialRepositoryImpl) Save(pipelineMaterial ...*PipelineMaterial) error {
-	_, err := impl.dbConnection.Model(&pipelineMaterial).Insert()
+func (impl *PipelineMaterialRepositoryImpl) Save(ctx context.Context, pipelineMaterial ...*PipelineMaterial) error {
+	_, err := impl.dbConnection.ModelContext(ctx, &pipelineMaterial).Insert()
 	return err
 }
 
-func (impl *PipelineMaterialRepositoryImpl) CleanAppDataForEnvironment(appId, environmentId int, tx *pg.Tx) error {
-	r, err := tx.Model(&PipelineMaterial{}).
+func (impl *PipelineMaterialRepositoryImpl) UpdateGitChangesStatus(ctx context.Context, pipelineMaterial ...*PipelineMaterial) error {
+	for _, material := range pipelineMaterial {
+		_, err := impl.dbConnection.ModelContext(ctx, material).
+			Column("git_changes_status", "git_changes_error").
+			Where("app_release_id = ?", material.AppReleaseId).
+			Where("pipeline_material_id = ?", material.PipelineMaterialId).
+			Update()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (impl *PipelineMaterialRepositoryImpl) DeleteByResetBatchId(ctx context.Context, resetBatchId int, tx *pg.Tx) error {
+	r, err := tx.ModelContext(ctx, &PipelineMaterial{}).
 		Table("app_release").
-		Where("app_release.app_id =?", appId).
-		Where("app_release.environment_id = ?", environmentId).
+		Where("app_release.reset_batch_id = ?", resetBatchId).
 		Where("app_release.id = pipeline_material.app_release_id").
 		Delete()
 	if err != nil {
 		return err
 	} else {
-		impl.logger.Infow("pipelineMaterial deleted for ", "app", appId, "env", environmentId, "count", r.RowsAffected())
+		impl.logger.Infow("pipelineMaterial deleted for ", "resetBatchId", resetBatchId, "count", r.RowsAffected())
 		return nil
 	}
 }
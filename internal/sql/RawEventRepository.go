@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// RawEvent is an inbound deployment or status event kept as received, so that derived data can be rebuilt from it
+type RawEvent struct {
+	tableName          struct{}        `pg:"raw_event"`
+	Id                 int64           `pg:"id,pk"`
+	EventType          string          `pg:"event_type,notnull"`
+	Source             string          `pg:"source,notnull"`
+	AppId              int             `pg:"app_id,notnull,use_zero"`
+	EnvironmentId      int             `pg:"environment_id,notnull,use_zero"`
+	PipelineOverrideId int             `pg:"pipeline_override_id,notnull,use_zero"`
+	TriggerTime        time.Time       `pg:"trigger_time"`
+	Payload            json.RawMessage `pg:"payload,notnull"`
+	ReceivedOn         time.Time       `pg:"received_on,notnull"`
+}
+
+const (
+	RawEventDeployment = "deployment"
+	RawEventStatus     = "status"
+)
+
+type RawEventRepository interface {
+	Save(ctx context.Context, rawEvent *RawEvent) error
+	// FindForReplay pages through the events of the deployments of an app environment triggered between from and to,
+	// status events of these deployments included, in order of receipt after afterId. Events received before a
+	// reset of the app environment are left out.
+	FindForReplay(ctx context.Context, appId, environmentId int, from, to time.Time, afterId int64, limit int) ([]*RawEvent, error)
+}
+
+type RawEventRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewRawEventRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *RawEventRepositoryImpl {
+	return &RawEventRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *RawEventRepositoryImpl) Save(ctx context.Context, rawEvent *RawEvent) error {
+	_, err := impl.dbConnection.ModelContext(ctx, rawEvent).Insert()
+	return err
+}
+
+func (impl *RawEventRepositoryImpl) FindForReplay(ctx context.Context, appId, environmentId int, from, to time.Time, afterId int64, limit int) ([]*RawEvent, error) {
+	var rawEvents []*RawEvent
+	err := impl.dbConnection.
+		ModelContext(ctx, &rawEvents).
+		Where("raw_event.app_id = ?", appId).
+		Where("raw_event.environment_id = ?", environmentId).
+		Where("raw_event.id > ?", afterId).
+		Where(`raw_event.pipeline_override_id in (select d.pipeline_override_id from raw_event d
+			where d.app_id = raw_event.app_id and d.environment_id = raw_event.environment_id
+			and d.event_type = ? and d.trigger_time >= ? and d.trigger_time <= ?)`, RawEventDeployment, from, to).
+		Where(`not exists (select 1 from reset_batch rb
+			where rb.app_id = raw_event.app_id and rb.environment_id = raw_event.environment_id
+			and rb.status in (?) and rb.created_on >= raw_event.received_on)`, pg.In([]ResetBatchStatus{ResetActive, ResetPurged})).
+		Order("raw_event.id asc").
+		Limit(limit).
+		Select()
+	return rawEvents, err
+}
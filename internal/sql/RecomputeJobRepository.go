@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// RecomputeJob rebuilds the releases of an app environment triggered between From and To from raw events. Rebuilt
+// releases are staged under ResetBatchId and swapped in once all events are replayed, the batch then holds the
+// releases they replaced.
+type RecomputeJob struct {
+	tableName            struct{}        `pg:"recompute_job"`
+	Id                   int             `pg:"id,pk"`
+	AppId                int             `pg:"app_id,notnull,use_zero"`
+	EnvironmentId        int             `pg:"environment_id,notnull,use_zero"`
+	From                 time.Time       `pg:"from_time,notnull"`
+	To                   time.Time       `pg:"to_time,notnull"`
+	Status               RecomputeStatus `pg:"status,notnull,use_zero"`
+	ResetBatchId         int             `pg:"reset_batch_id,notnull,use_zero"`
+	EventCount           int             `pg:"event_count,notnull,use_zero"`
+	ReleaseCount         int             `pg:"release_count,notnull,use_zero"`
+	ReplacedReleaseCount int             `pg:"replaced_release_count,notnull,use_zero"`
+	Error                string          `pg:"error"`
+	RequestedBy          string          `pg:"requested_by,notnull"`
+	CreatedOn            time.Time       `pg:"created_on,notnull"`
+	FinishedOn           time.Time       `pg:"finished_on"`
+}
+
+type RecomputeStatus int
+
+const (
+	RecomputeRunning RecomputeStatus = iota
+	RecomputeCompleted
+	RecomputeFailed
+)
+
+func (status RecomputeStatus) String() string {
+	return [...]string{"Running", "Completed", "Failed"}[status]
+}
+
+type RecomputeJobRepository interface {
+	// Save saves a new job along with the reset batch staging its releases
+	Save(ctx context.Context, job *RecomputeJob, resetBatch *ResetBatch) error
+	Update(ctx context.Context, job *RecomputeJob) error
+	FindById(ctx context.Context, id int) (*RecomputeJob, error)
+	// FindRunning returns running jobs, of a single app environment unless appId is 0
+	FindRunning(ctx context.Context, appId, environmentId int) ([]*RecomputeJob, error)
+}
+
+type RecomputeJobRepositoryImpl struct {
+	dbConnection         *pg.DB
+	logger               *zap.SugaredLogger
+	resetBatchRepository ResetBatchRepository
+}
+
+func NewRecomputeJobRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger,
+	resetBatchRepository ResetBatchRepository) *RecomputeJobRepositoryImpl {
+	return &RecomputeJobRepositoryImpl{
+		dbConnection:         dbConnection,
+		logger:               logger,
+		resetBatchRepository: resetBatchRepository,
+	}
+}
+
+func (impl *RecomputeJobRepositoryImpl) Save(ctx context.Context, job *RecomputeJob, resetBatch *ResetBatch) error {
+	return impl.dbConnection.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := impl.resetBatchRepository.Save(ctx, resetBatch, tx)
+		if err != nil {
+			impl.logger.Errorw("error in saving reset batch of recompute", "resetBatch", resetBatch, "err", err)
+			return err
+		}
+		job.ResetBatchId = resetBatch.Id
+		_, err = tx.ModelContext(ctx, job).Insert()
+		return err
+	})
+}
+
+func (impl *RecomputeJobRepositoryImpl) Update(ctx context.Context, job *RecomputeJob) error {
+	_, err := impl.dbConnection.ModelContext(ctx, job).WherePK().Update()
+	return err
+}
+
+func (impl *RecomputeJobRepositoryImpl) FindById(ctx context.Context, id int) (*RecomputeJob, error) {
+	job := &RecomputeJob{}
+	err := impl.dbConnection.ModelContext(ctx, job).Where("id = ?", id).Select()
+	return job, err
+}
+
+func (impl *RecomputeJobRepositoryImpl) FindRunning(ctx context.Context, appId, environmentId int) ([]*RecomputeJob, error) {
+	var jobs []*RecomputeJob
+	query := impl.dbConnection.ModelContext(ctx, &jobs).Where("status = ?", RecomputeRunning)
+	if appId > 0 {
+		query = query.Where("app_id = ?", appId).Where("environment_id = ?", environmentId)
+	}
+	err := query.Order("id asc").Select()
+	return jobs, err
+}
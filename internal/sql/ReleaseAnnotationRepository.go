@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// ReleaseAnnotation is a free-form note on a release
+type ReleaseAnnotation struct {
+	tableName    struct{}  `pg:"release_annotation"`
+	Id           int       `pg:"id,pk"`
+	AppReleaseId int       `pg:"app_release_id,notnull,use_zero"`
+	Text         string    `pg:"text,notnull"`
+	CreatedBy    string    `pg:"created_by,notnull"`
+	CreatedOn    time.Time `pg:"created_on,notnull"`
+}
+
+type ReleaseAnnotationRepository interface {
+	Save(ctx context.Context, annotations ...*ReleaseAnnotation) error
+	FindByAppReleaseId(ctx context.Context, appReleaseId int) ([]*ReleaseAnnotation, error)
+}
+
+type ReleaseAnnotationRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewReleaseAnnotationRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *ReleaseAnnotationRepositoryImpl {
+	return &ReleaseAnnotationRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *ReleaseAnnotationRepositoryImpl) Save(ctx context.Context, annotations ...*ReleaseAnnotation) error {
+	if len(annotations) == 0 {
+		return nil
+	}
+	_, err := impl.dbConnection.ModelContext(ctx, &annotations).Insert()
+	return err
+}
+
+func (impl *ReleaseAnnotationRepositoryImpl) FindByAppReleaseId(ctx context.Context, appReleaseId int) ([]*ReleaseAnnotation, error) {
+	var annotations []*ReleaseAnnotation
+	err := impl.dbConnection.
+		ModelContext(ctx, &annotations).
+		Where("app_release_id = ?", appReleaseId).
+		Order("id asc").
+		Select()
+	return annotations, err
+}
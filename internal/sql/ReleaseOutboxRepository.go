@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// ReleaseOutboxMessage is a message to publish, written together with the release it is about
+type ReleaseOutboxMessage struct {
+	tableName    struct{}  `pg:"release_outbox"`
+	Id           int64     `pg:"id,pk"`
+	AppReleaseId int       `pg:"app_release_id,notnull,use_zero"`
+	Topic        string    `pg:"topic,notnull"`
+	Payload      string    `pg:"payload,notnull"`
+	CreatedOn    time.Time `pg:"created_on,notnull"`
+	PublishedOn  time.Time `pg:"published_on"`
+	Attempts     int       `pg:"attempts,notnull,use_zero"`
+	LastError    string    `pg:"last_error"`
+}
+
+type ReleaseOutboxRepository interface {
+	Save(ctx context.Context, message *ReleaseOutboxMessage, tx *pg.Tx) error
+	// FindUnpublished returns messages not published yet, oldest first
+	FindUnpublished(ctx context.Context, limit int) ([]*ReleaseOutboxMessage, error)
+	Update(ctx context.Context, message *ReleaseOutboxMessage) error
+	// DeletePublishedBefore deletes messages published before a time, returns the deleted count
+	DeletePublishedBefore(ctx context.Context, before time.Time) (int, error)
+}
+
+type ReleaseOutboxRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewReleaseOutboxRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *ReleaseOutboxRepositoryImpl {
+	return &ReleaseOutboxRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *ReleaseOutboxRepositoryImpl) Save(ctx context.Context, message *ReleaseOutboxMessage, tx *pg.Tx) error {
+	_, err := tx.ModelContext(ctx, message).Insert()
+	return err
+}
+
+func (impl *ReleaseOutboxRepositoryImpl) FindUnpublished(ctx context.Context, limit int) ([]*ReleaseOutboxMessage, error) {
+	var messages []*ReleaseOutboxMessage
+	err := impl.dbConnection.
+		ModelContext(ctx, &messages).
+		Where("published_on is null").
+		Order("id asc").
+		Limit(limit).
+		Select()
+	return messages, err
+}
+
+func (impl *ReleaseOutboxRepositoryImpl) Update(ctx context.Context, message *ReleaseOutboxMessage) error {
+	_, err := impl.dbConnection.ModelContext(ctx, message).
+		Column("published_on", "attempts", "last_error").
+		WherePK().
+		Update()
+	return err
+}
+
+func (impl *ReleaseOutboxRepositoryImpl) DeletePublishedBefore(ctx context.Context, before time.Time) (int, error) {
+	r, err := impl.dbConnection.ModelContext(ctx, (*ReleaseOutboxMessage)(nil)).
+		Where("published_on < ?", before).
+		Delete()
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected(), nil
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// ReleaseOverride holds manual corrections of a release, keyed by the orchestrator trigger so that they
+// are re-applied whenever the release is processed again. Nil fields are not overridden.
+type ReleaseOverride struct {
+	tableName           struct{}       `pg:"release_override"`
+	Id                  int            `pg:"id,pk"`
+	AppId               int            `pg:"app_id,notnull,use_zero"`
+	EnvironmentId       int            `pg:"environment_id,notnull,use_zero"`
+	PipelineOverrideId  int            `pg:"pipeline_override_id,notnull,use_zero"`
+	ReleaseStatus       *ReleaseStatus `pg:"release_status"`
+	ReleaseType         *ReleaseType   `pg:"release_type"`
+	ExcludedFromMetrics *bool          `pg:"excluded_from_metrics"`
+	UpdatedBy           string         `pg:"updated_by,notnull"`
+	UpdatedOn           time.Time      `pg:"updated_on,notnull"`
+}
+
+// ReleaseAudit records a single field change made on a release
+type ReleaseAudit struct {
+	tableName          struct{}  `pg:"release_audit"`
+	Id                 int       `pg:"id,pk"`
+	AppReleaseId       int       `pg:"app_release_id,notnull,use_zero"`
+	AppId              int       `pg:"app_id,notnull,use_zero"`
+	EnvironmentId      int       `pg:"environment_id,notnull,use_zero"`
+	PipelineOverrideId int       `pg:"pipeline_override_id,notnull,use_zero"`
+	Field              string    `pg:"field,notnull"`
+	OldValue           string    `pg:"old_value,notnull,use_zero"`
+	NewValue           string    `pg:"new_value,notnull,use_zero"`
+	Actor              string    `pg:"actor,notnull"`
+	Reason             string    `pg:"reason,notnull"`
+	CreatedOn          time.Time `pg:"created_on,notnull"`
+}
+
+type ReleaseOverrideRepository interface {
+	FindByTrigger(ctx context.Context, appId, environmentId, pipelineOverrideId int) (*ReleaseOverride, error)
+	// FindByAppEnvironment returns the overrides of all triggers of an app environment
+	FindByAppEnvironment(ctx context.Context, appId, environmentId int) ([]*ReleaseOverride, error)
+	// SaveOverride updates the release, upserts its override and records the audits in one transaction
+	SaveOverride(ctx context.Context, appRelease *AppRelease, override *ReleaseOverride, audits []*ReleaseAudit) error
+	FindAuditsByAppReleaseId(ctx context.Context, appReleaseId int) ([]*ReleaseAudit, error)
+}
+
+type ReleaseOverrideRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewReleaseOverrideRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *ReleaseOverrideRepositoryImpl {
+	return &ReleaseOverrideRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *ReleaseOverrideRepositoryImpl) FindByTrigger(ctx context.Context, appId, environmentId, pipelineOverrideId int) (*ReleaseOverride, error) {
+	override := &ReleaseOverride{}
+	err := impl.dbConnection.
+		ModelContext(ctx, override).
+		Where("app_id = ?", appId).
+		Where("environment_id = ?", environmentId).
+		Where("pipeline_override_id = ?", pipelineOverrideId).
+		Select()
+	return override, err
+}
+
+func (impl *ReleaseOverrideRepositoryImpl) FindByAppEnvironment(ctx context.Context, appId, environmentId int) ([]*ReleaseOverride, error) {
+	var overrides []*ReleaseOverride
+	err := impl.dbConnection.
+		ModelContext(ctx, &overrides).
+		Where("app_id = ?", appId).
+		Where("environment_id = ?", environmentId).
+		Select()
+	return overrides, err
+}
+
+func (impl *ReleaseOverrideRepositoryImpl) SaveOverride(ctx context.Context, appRelease *AppRelease, override *ReleaseOverride, audits []*ReleaseAudit) error {
+	return impl.dbConnection.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		cause := "corrected by " + override.UpdatedBy
+		if len(audits) > 0 {
+			cause += ": " + audits[0].Reason
+		}
+		err := updateReleaseWithHistory(ctx, tx, appRelease, cause, func() error {
+			_, err := tx.ModelContext(ctx, appRelease).WherePK().Update()
+			return err
+		})
+		if err != nil {
+			impl.logger.Errorw("error in updating release", "appRelease", appRelease.Id, "err", err)
+			return err
+		}
+		_, err = tx.ModelContext(ctx, override).
+			OnConflict("(app_id, environment_id, pipeline_override_id) DO UPDATE").
+			Set("release_status = EXCLUDED.release_status").
+			Set("release_type = EXCLUDED.release_type").
+			Set("excluded_from_metrics = EXCLUDED.excluded_from_metrics").
+			Set("updated_by = EXCLUDED.updated_by").
+			Set("updated_on = EXCLUDED.updated_on").
+			Insert()
+		if err != nil {
+			impl.logger.Errorw("error in saving release override", "override", override, "err", err)
+			return err
+		}
+		if len(audits) > 0 {
+			_, err = tx.ModelContext(ctx, &audits).Insert()
+			if err != nil {
+				impl.logger.Errorw("error in saving release audit", "appRelease", appRelease.Id, "err", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (impl *ReleaseOverrideRepositoryImpl) FindAuditsByAppReleaseId(ctx context.Context, appReleaseId int) ([]*ReleaseAudit, error) {
+	var audits []*ReleaseAudit
+	err := impl.dbConnection.
+		ModelContext(ctx, &audits).
+		Where("app_release_id = ?", appReleaseId).
+		Order("id asc").
+		Select()
+	return audits, err
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// ReleaseRollup holds per day aggregates of an app environment, kept after the releases themselves are archived
+type ReleaseRollup struct {
+	tableName             struct{}      `pg:"release_rollup"`
+	AppId                 int           `pg:"app_id,pk"`
+	EnvironmentId         int           `pg:"environment_id,pk"`
+	Day                   time.Time     `pg:"day,pk,type:date"`
+	DeploymentCount       int           `pg:"deployment_count,notnull,use_zero"`
+	FailureCount          int           `pg:"failure_count,notnull,use_zero"`
+	RollbackCount         int           `pg:"rollback_count,notnull,use_zero"`
+	PatchCount            int           `pg:"patch_count,notnull,use_zero"`
+	LeadTimeTotal         time.Duration `pg:"lead_time_total,notnull,use_zero"`
+	LeadTimeCount         int           `pg:"lead_time_count,notnull,use_zero"`
+	ChangeSizeLineAdded   int           `pg:"change_size_line_added,notnull,use_zero"`
+	ChangeSizeLineDeleted int           `pg:"change_size_line_deleted,notnull,use_zero"`
+	ReworkCount           int           `pg:"rework_count,notnull,use_zero"`
+	HotfixCount           int           `pg:"hotfix_count,notnull,use_zero"`
+	FixReleaseCount       int           `pg:"fix_release_count,notnull,use_zero"` //releases dominated by fix: or revert commits
+	TimeToRollbackTotal   time.Duration `pg:"time_to_rollback_total,notnull,use_zero"`
+	TimeToRollbackCount   int           `pg:"time_to_rollback_count,notnull,use_zero"`
+}
+
+type ReleaseRollupRepository interface {
+	// Merge adds the given aggregates to the stored ones of the same app, environment and day
+	Merge(ctx context.Context, rollups []*ReleaseRollup, tx *pg.Tx) error
+	// FindDaily returns stored aggregates merged with the ones computed from releases not yet archived
+	FindDaily(ctx context.Context, appId, environmentId int, from time.Time, to time.Time) ([]*ReleaseRollup, error)
+}
+
+type ReleaseRollupRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewReleaseRollupRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *ReleaseRollupRepositoryImpl {
+	return &ReleaseRollupRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *ReleaseRollupRepositoryImpl) Merge(ctx context.Context, rollups []*ReleaseRollup, tx *pg.Tx) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+	_, err := tx.ModelContext(ctx, &rollups).
+		OnConflict("(app_id, environment_id, day) DO UPDATE").
+		Set("deployment_count = release_rollup.deployment_count + EXCLUDED.deployment_count").
+		Set("failure_count = release_rollup.failure_count + EXCLUDED.failure_count").
+		Set("rollback_count = release_rollup.rollback_count + EXCLUDED.rollback_count").
+		Set("patch_count = release_rollup.patch_count + EXCLUDED.patch_count").
+		Set("lead_time_total = release_rollup.lead_time_total + EXCLUDED.lead_time_total").
+		Set("lead_time_count = release_rollup.lead_time_count + EXCLUDED.lead_time_count").
+		Set("change_size_line_added = release_rollup.change_size_line_added + EXCLUDED.change_size_line_added").
+		Set("change_size_line_deleted = release_rollup.change_size_line_deleted + EXCLUDED.change_size_line_deleted").
+		Set("rework_count = release_rollup.rework_count + EXCLUDED.rework_count").
+		Set("hotfix_count = release_rollup.hotfix_count + EXCLUDED.hotfix_count").
+		Set("fix_release_count = release_rollup.fix_release_count + EXCLUDED.fix_release_count").
+		Set("time_to_rollback_total = release_rollup.time_to_rollback_total + EXCLUDED.time_to_rollback_total").
+		Set("time_to_rollback_count = release_rollup.time_to_rollback_count + EXCLUDED.time_to_rollback_count").
+		Insert()
+	return err
+}
+
+func (impl *ReleaseRollupRepositoryImpl) FindDaily(ctx context.Context, appId, environmentId int, from time.Time, to time.Time) ([]*ReleaseRollup, error) {
+	var archived []*ReleaseRollup
+	err := impl.dbConnection.
+		ModelContext(ctx, &archived).
+		Where("app_id = ?", appId).
+		Where("environment_id = ?", environmentId).
+		Where("day >= ?::date", from).
+		Where("day <= ?::date", to).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+	var live []*ReleaseRollup
+	// ?0 release types not counted as deployments, ?4 not counted for lead time, see ReleaseType and AppRelease.ReworkReasons
+	fixDominated := "ar.commit_count > 0 and ar.fix_commit_count * 2 > ar.commit_count"
+	_, err = impl.dbConnection.QueryContext(ctx, &live, `
+		select ar.app_id, ar.environment_id, (ar.trigger_time at time zone 'UTC')::date as day,
+			count(*) filter (where ar.release_type not in (?0)) as deployment_count,
+			count(*) filter (where ar.release_status = ?1) as failure_count,
+			count(*) filter (where ar.release_type = ?2) as rollback_count,
+			count(*) filter (where ar.release_type = ?3) as patch_count,
+			coalesce(sum(lt.lead_time) filter (where ar.release_type not in (?4)), 0) as lead_time_total,
+			count(lt.id) filter (where ar.release_type not in (?4)) as lead_time_count,
+			sum(ar.change_size_line_added) as change_size_line_added,
+			sum(ar.change_size_line_deleted) as change_size_line_deleted,
+			count(*) filter (where ar.release_type not in (?0)
+				and (ar.release_type in (?2, ?3) or hotfix.app_release_id is not null or `+fixDominated+`)) as rework_count,
+			count(*) filter (where ar.release_type not in (?0) and hotfix.app_release_id is not null) as hotfix_count,
+			count(*) filter (where ar.release_type not in (?0) and `+fixDominated+`) as fix_release_count,
+			coalesce(sum(ar.time_to_rollback) filter (where ar.release_type = ?2 and ar.time_to_rollback > 0), 0) as time_to_rollback_total,
+			count(*) filter (where ar.release_type = ?2 and ar.time_to_rollback > 0) as time_to_rollback_count
+		from app_release ar
+		left join lead_time lt on lt.app_release_id = ar.id
+		left join release_tag hotfix on hotfix.app_release_id = ar.id and hotfix.key = ?5 and hotfix.value = ?6
+		where ar.app_id = ?7 and ar.environment_id = ?8 and ar.trigger_time >= ?9 and ar.trigger_time <= ?10
+			and ar.reset_batch_id is null and ar.excluded_from_metrics = false
+		group by ar.app_id, ar.environment_id, day`,
+		pg.In(releaseTypesNotCounted(ReleaseType.CountsForDeploymentFrequency)), Failure, RollBack, Patch,
+		pg.In(releaseTypesNotCounted(ReleaseType.CountsForLeadTime)), HotfixTagKey, HotfixTagValue,
+		appId, environmentId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	byDay := make(map[time.Time]*ReleaseRollup)
+	var rollups []*ReleaseRollup
+	for _, rollup := range append(archived, live...) {
+		day := rollup.Day.UTC()
+		if existing, ok := byDay[day]; ok {
+			existing.DeploymentCount += rollup.DeploymentCount
+			existing.FailureCount += rollup.FailureCount
+			existing.RollbackCount += rollup.RollbackCount
+			existing.PatchCount += rollup.PatchCount
+			existing.LeadTimeTotal += rollup.LeadTimeTotal
+			existing.LeadTimeCount += rollup.LeadTimeCount
+			existing.ChangeSizeLineAdded += rollup.ChangeSizeLineAdded
+			existing.ChangeSizeLineDeleted += rollup.ChangeSizeLineDeleted
+			existing.ReworkCount += rollup.ReworkCount
+			existing.HotfixCount += rollup.HotfixCount
+			existing.FixReleaseCount += rollup.FixReleaseCount
+			existing.TimeToRollbackTotal += rollup.TimeToRollbackTotal
+			existing.TimeToRollbackCount += rollup.TimeToRollbackCount
+			continue
+		}
+		rollup.Day = day
+		byDay[day] = rollup
+		rollups = append(rollups, rollup)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].Day.Before(rollups[j].Day)
+	})
+	return rollups, nil
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"go.uber.org/zap"
+)
+
+// ReleaseStageHistory is a change of the processing stage, status or type of a release, or a failed ingestion stage
+type ReleaseStageHistory struct {
+	tableName    struct{}           `pg:"release_stage_history"`
+	Id           int64              `pg:"id,pk"`
+	AppReleaseId int                `pg:"app_release_id,notnull,use_zero"`
+	Kind         ReleaseHistoryKind `pg:"kind,notnull"`
+	FromValue    string             `pg:"from_value"` //empty when the release was created
+	ToValue      string             `pg:"to_value,notnull"`
+	Cause        string             `pg:"cause,notnull"`
+	CreatedOn    time.Time          `pg:"created_on,notnull"`
+}
+
+type ReleaseHistoryKind string
+
+const (
+	HistoryStage  ReleaseHistoryKind = "stage"
+	HistoryStatus ReleaseHistoryKind = "status"
+	HistoryType   ReleaseHistoryKind = "type"
+	// HistoryStageFailed records an ingestion stage that failed, from the stage reached to the failed one, with the error
+	HistoryStageFailed ReleaseHistoryKind = "stage-failed"
+)
+
+type ReleaseStageHistoryRepository interface {
+	Save(ctx context.Context, entries ...*ReleaseStageHistory) error
+	// FindByAppReleaseId returns the history of a release, oldest first
+	FindByAppReleaseId(ctx context.Context, appReleaseId int) ([]*ReleaseStageHistory, error)
+}
+
+type ReleaseStageHistoryRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewReleaseStageHistoryRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *ReleaseStageHistoryRepositoryImpl {
+	return &ReleaseStageHistoryRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *ReleaseStageHistoryRepositoryImpl) Save(ctx context.Context, entries ...*ReleaseStageHistory) error {
+	return saveReleaseHistory(ctx, impl.dbConnection, entries)
+}
+
+func (impl *ReleaseStageHistoryRepositoryImpl) FindByAppReleaseId(ctx context.Context, appReleaseId int) ([]*ReleaseStageHistory, error) {
+	var entries []*ReleaseStageHistory
+	err := impl.dbConnection.
+		ModelContext(ctx, &entries).
+		Where("app_release_id = ?", appReleaseId).
+		Order("id asc").
+		Select()
+	return entries, err
+}
+
+func saveReleaseHistory(ctx context.Context, db orm.DB, entries []*ReleaseStageHistory) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	_, err := db.ModelContext(ctx, &entries).Insert()
+	return err
+}
+
+// releaseState is what the history tracks of a release
+type releaseState struct {
+	tableName     struct{}      `pg:"app_release"`
+	Id            int           `pg:"id,pk"`
+	ProcessStage  ProcessStage  `pg:"process_status,use_zero"`
+	ReleaseStatus ReleaseStatus `pg:"release_status,use_zero"`
+	ReleaseType   ReleaseType   `pg:"release_type,use_zero"`
+}
+
+// releaseTransitions lists what changed from before to appRelease, everything when before is nil
+func releaseTransitions(before *releaseState, appRelease *AppRelease, cause string, now time.Time) []*ReleaseStageHistory {
+	created := before == nil
+	if created {
+		before = &releaseState{}
+	}
+	var entries []*ReleaseStageHistory
+	add := func(kind ReleaseHistoryKind, from, to string) {
+		if created {
+			from = ""
+		} else if from == to {
+			return
+		}
+		entries = append(entries, &ReleaseStageHistory{AppReleaseId: appRelease.Id, Kind: kind, FromValue: from, ToValue: to, Cause: cause, CreatedOn: now})
+	}
+	add(HistoryStage, before.ProcessStage.String(), appRelease.ProcessStage.String())
+	add(HistoryStatus, before.ReleaseStatus.String(), appRelease.ReleaseStatus.String())
+	add(HistoryType, before.ReleaseType.String(), appRelease.ReleaseType.String())
+	return entries
+}
+
+// updateReleaseWithHistory runs update of appRelease in tx and records what it changed, the row is locked
+// meanwhile so that concurrent updates are recorded one after the other
+func updateReleaseWithHistory(ctx context.Context, tx *pg.Tx, appRelease *AppRelease, cause string, update func() error) error {
+	before := &releaseState{Id: appRelease.Id}
+	err := tx.ModelContext(ctx, before).WherePK().For("UPDATE").Select()
+	if err != nil {
+		return err
+	}
+	if err = update(); err != nil {
+		return err
+	}
+	return saveReleaseHistory(ctx, tx, releaseTransitions(before, appRelease, cause, time.Now()))
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReleaseTransitions(t *testing.T) {
+	now := time.Now()
+	appRelease := &AppRelease{Id: 3, ProcessStage: Init, ReleaseStatus: Failure, ReleaseType: Unknown}
+	created := releaseTransitions(nil, appRelease, "deployment event", now)
+	if len(created) != 3 {
+		t.Fatalf("releaseTransitions() of new release = %d entries, want 3", len(created))
+	}
+	for _, entry := range created {
+		if entry.FromValue != "" || entry.AppReleaseId != 3 || entry.Cause != "deployment event" {
+			t.Errorf("releaseTransitions() of new release entry = %+v", entry)
+		}
+	}
+
+	before := &releaseState{Id: 3, ProcessStage: Init, ReleaseStatus: Failure, ReleaseType: Unknown}
+	appRelease.ProcessStage = ReleaseTypeDetermined
+	appRelease.ReleaseType = RollBack
+	changed := releaseTransitions(before, appRelease, "release type detected", now)
+	if len(changed) != 2 {
+		t.Fatalf("releaseTransitions() = %d entries, want 2", len(changed))
+	}
+	if stage := changed[0]; stage.Kind != HistoryStage || stage.FromValue != "Init" || stage.ToValue != "ReleaseTypeDetermined" {
+		t.Errorf("releaseTransitions() stage = %+v", stage)
+	}
+	if releaseType := changed[1]; releaseType.Kind != HistoryType || releaseType.FromValue != "Unknown" || releaseType.ToValue != "RollBack" {
+		t.Errorf("releaseTransitions() type = %+v", releaseType)
+	}
+
+	if unchanged := releaseTransitions(&releaseState{Id: 3, ProcessStage: ReleaseTypeDetermined, ReleaseStatus: Failure, ReleaseType: RollBack}, appRelease, "", now); len(unchanged) != 0 {
+		t.Errorf("releaseTransitions() of unchanged release = %+v", unchanged)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// ReleaseStatusEvent is a deployment status or app health reported for a release after its trigger
+type ReleaseStatusEvent struct {
+	tableName    struct{}  `pg:"release_status_event"`
+	Id           int       `pg:"id,pk"`
+	AppReleaseId int       `pg:"app_release_id,notnull,use_zero"`
+	CdStatus     string    `pg:"cd_status"`
+	HealthStatus string    `pg:"health_status"`
+	EventTime    time.Time `pg:"event_time,notnull"`
+	CreatedOn    time.Time `pg:"created_on,notnull"`
+}
+
+type ReleaseStatusEventRepository interface {
+	Save(ctx context.Context, statusEvent *ReleaseStatusEvent) error
+	FindByAppReleaseIds(ctx context.Context, appReleaseIds []int) ([]*ReleaseStatusEvent, error)
+}
+
+type ReleaseStatusEventRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewReleaseStatusEventRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *ReleaseStatusEventRepositoryImpl {
+	return &ReleaseStatusEventRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *ReleaseStatusEventRepositoryImpl) Save(ctx context.Context, statusEvent *ReleaseStatusEvent) error {
+	_, err := impl.dbConnection.ModelContext(ctx, statusEvent).Insert()
+	return err
+}
+
+func (impl *ReleaseStatusEventRepositoryImpl) FindByAppReleaseIds(ctx context.Context, appReleaseIds []int) ([]*ReleaseStatusEvent, error) {
+	var statusEvents []*ReleaseStatusEvent
+	if len(appReleaseIds) == 0 {
+		return statusEvents, nil
+	}
+	err := impl.dbConnection.ModelContext(ctx, &statusEvents).
+		Where("app_release_id in (?)", pg.In(appReleaseIds)).
+		Order("event_time asc").
+		Select()
+	return statusEvents, err
+}
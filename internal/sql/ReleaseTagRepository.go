@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// ReleaseTag is a key/value label on a release, such as incident=INC-123
+type ReleaseTag struct {
+	tableName    struct{} `pg:"release_tag"`
+	AppReleaseId int      `pg:"app_release_id,pk"`
+	Key          string   `pg:"key,pk"`
+	Value        string   `pg:"value,notnull,use_zero"`
+}
+
+type ReleaseTagRepository interface {
+	// Save inserts tags, replacing the value of keys already present on the release
+	Save(ctx context.Context, tags ...*ReleaseTag) error
+	Delete(ctx context.Context, appReleaseId int, key string) error
+	FindByAppReleaseIds(ctx context.Context, appReleaseIds []int) ([]*ReleaseTag, error)
+}
+
+type ReleaseTagRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewReleaseTagRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *ReleaseTagRepositoryImpl {
+	return &ReleaseTagRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *ReleaseTagRepositoryImpl) Save(ctx context.Context, tags ...*ReleaseTag) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := impl.dbConnection.ModelContext(ctx, &tags).
+		OnConflict("(app_release_id, key) DO UPDATE").
+		Set("value = EXCLUDED.value").
+		Insert()
+	return err
+}
+
+func (impl *ReleaseTagRepositoryImpl) Delete(ctx context.Context, appReleaseId int, key string) error {
+	_, err := impl.dbConnection.ModelContext(ctx, (*ReleaseTag)(nil)).
+		Where("app_release_id = ?", appReleaseId).
+		Where("key = ?", key).
+		Delete()
+	return err
+}
+
+func (impl *ReleaseTagRepositoryImpl) FindByAppReleaseIds(ctx context.Context, appReleaseIds []int) ([]*ReleaseTag, error) {
+	var tags []*ReleaseTag
+	if len(appReleaseIds) == 0 {
+		return tags, nil
+	}
+	err := impl.dbConnection.ModelContext(ctx, &tags).Where("app_release_id in (?)", pg.In(appReleaseIds)).Select()
+	return tags, err
+}
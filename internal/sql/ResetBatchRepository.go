@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// ResetBatch records a reset of an app environment, its releases are soft deleted by pointing at the batch
+type ResetBatch struct {
+	tableName     struct{}         `pg:"reset_batch"`
+	Id            int              `pg:"id,pk"`
+	AppId         int              `pg:"app_id,notnull,use_zero"`
+	EnvironmentId int              `pg:"environment_id,notnull,use_zero"`
+	Status        ResetBatchStatus `pg:"status,notnull,use_zero"`
+	ReleaseCount  int              `pg:"release_count,notnull,use_zero"`
+	RequestedBy   string           `pg:"requested_by,notnull"`
+	Reason        string           `pg:"reason,notnull"`
+	CreatedOn     time.Time        `pg:"created_on,notnull"`
+	RestoredBy    string           `pg:"restored_by"`
+	RestoredOn    time.Time        `pg:"restored_on"`
+	PurgedOn      time.Time        `pg:"purged_on"`
+}
+
+type ResetBatchStatus int
+
+const (
+	ResetActive ResetBatchStatus = iota
+	ResetRestored
+	ResetPurged
+	ResetStaged   //releases rebuilt by a recompute, not live until swapped in
+	ResetReplaced //releases replaced by a recompute, purged like a reset but never restored
+)
+
+func (status ResetBatchStatus) String() string {
+	return [...]string{"Active", "Restored", "Purged", "Staged", "Replaced"}[status]
+}
+
+// ErrResetBatchChanged is returned when a concurrent restore or purge changed the status of a reset batch first
+var ErrResetBatchChanged = errors.New("reset batch changed concurrently")
+
+type ResetBatchRepository interface {
+	Save(ctx context.Context, resetBatch *ResetBatch, tx *pg.Tx) (*ResetBatch, error)
+	Update(ctx context.Context, resetBatch *ResetBatch, tx *pg.Tx) (*ResetBatch, error)
+	FindById(ctx context.Context, id int) (*ResetBatch, error)
+	// LockStatus locks the row of resetBatch until tx ends, ErrResetBatchChanged when its status is no longer the loaded one
+	LockStatus(ctx context.Context, resetBatch *ResetBatch, tx *pg.Tx) error
+	// FindActiveCreatedBefore returns reset and replaced batches due for purging
+	FindActiveCreatedBefore(ctx context.Context, before time.Time) ([]*ResetBatch, error)
+}
+
+type ResetBatchRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewResetBatchRepositoryImpl(dbConnection *pg.DB,
+	logger *zap.SugaredLogger) *ResetBatchRepositoryImpl {
+	return &ResetBatchRepositoryImpl{
+		dbConnection: dbConnection,
+		logger:       logger,
+	}
+}
+
+func (impl *ResetBatchRepositoryImpl) Save(ctx context.Context, resetBatch *ResetBatch, tx *pg.Tx) (*ResetBatch, error) {
+	_, err := tx.ModelContext(ctx, resetBatch).Insert()
+	return resetBatch, err
+}
+
+func (impl *ResetBatchRepositoryImpl) Update(ctx context.Context, resetBatch *ResetBatch, tx *pg.Tx) (*ResetBatch, error) {
+	_, err := tx.ModelContext(ctx, resetBatch).WherePK().Update()
+	return resetBatch, err
+}
+
+func (impl *ResetBatchRepositoryImpl) FindById(ctx context.Context, id int) (*ResetBatch, error) {
+	resetBatch := &ResetBatch{}
+	err := impl.dbConnection.ModelContext(ctx, resetBatch).Where("id = ?", id).Select()
+	return resetBatch, err
+}
+
+func (impl *ResetBatchRepositoryImpl) LockStatus(ctx context.Context, resetBatch *ResetBatch, tx *pg.Tx) error {
+	locked := &ResetBatch{}
+	err := tx.ModelContext(ctx, locked).Column("status").Where("id = ?", resetBatch.Id).For("UPDATE").Select()
+	if err != nil {
+		return err
+	}
+	if locked.Status != resetBatch.Status {
+		return ErrResetBatchChanged
+	}
+	return nil
+}
+
+func (impl *ResetBatchRepositoryImpl) FindActiveCreatedBefore(ctx context.Context, before time.Time) ([]*ResetBatch, error) {
+	var resetBatches []*ResetBatch
+	err := impl.dbConnection.
+		ModelContext(ctx, &resetBatches).
+		Where("status in (?)", pg.In([]ResetBatchStatus{ResetActive, ResetReplaced})).
+		Where("created_on < ?", before).
+		Order("id asc").
+		Select()
+	return resetBatches, err
+}
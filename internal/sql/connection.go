@@ -19,10 +19,16 @@ package sql
 import (
 	"context"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env"
 	"github.com/devtron-labs/lens/internal/logger"
+	"github.com/devtron-labs/lens/internal/metrics"
+	"github.com/devtron-labs/lens/internal/tracing"
 	pg "github.com/go-pg/pg/v10"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -34,6 +40,8 @@ type Config struct {
 	Database        string `env:"PG_DATABASE" envDefault:"lens"`
 	ApplicationName string `env:"APP" envDefault:"lens"`
 	LogQuery        bool   `env:"PG_LOG_QUERY" envDefault:"true"`
+	// QueryTimeoutSecs bounds every single query on top of the caller's deadline, 0 disables it
+	QueryTimeoutSecs int `env:"PG_QUERY_TIMEOUT_SECS" envDefault:"30"`
 }
 
 func (d dbLogger) BeforeQuery(c context.Context, q *pg.QueryEvent) (context.Context, error) {
@@ -46,6 +54,93 @@ func (d dbLogger) AfterQuery(c context.Context, q *pg.QueryEvent) error {
 	return err
 }
 
+type queryTimeoutKey struct{}
+
+type queryCancelKey struct{}
+
+// WithoutQueryTimeout exempts the queries run with ctx from PG_QUERY_TIMEOUT_SECS, meant for schema migrations
+func WithoutQueryTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryTimeoutKey{}, true)
+}
+
+// queryTimeoutHook cancels a query running longer than timeout, go-pg then asks postgres to cancel it
+type queryTimeoutHook struct {
+	timeout time.Duration
+}
+
+func (h queryTimeoutHook) BeforeQuery(c context.Context, q *pg.QueryEvent) (context.Context, error) {
+	if skip, _ := c.Value(queryTimeoutKey{}).(bool); skip {
+		return c, nil
+	}
+	c, cancel := context.WithTimeout(c, h.timeout)
+	return context.WithValue(c, queryCancelKey{}, cancel), nil
+}
+
+func (h queryTimeoutHook) AfterQuery(c context.Context, q *pg.QueryEvent) error {
+	if cancel, ok := c.Value(queryCancelKey{}).(context.CancelFunc); ok {
+		cancel()
+	}
+	return nil
+}
+
+// tracingQueryHook records every query as a client span of the caller's trace
+type tracingQueryHook struct{}
+
+func (h tracingQueryHook) BeforeQuery(c context.Context, q *pg.QueryEvent) (context.Context, error) {
+	c, _ = tracing.Tracer().Start(c, "db.query", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("db.system", "postgresql")))
+	return c, nil
+}
+
+func (h tracingQueryHook) AfterQuery(c context.Context, q *pg.QueryEvent) error {
+	span := trace.SpanFromContext(c)
+	if !span.IsRecording() {
+		return nil
+	}
+	// unformatted to keep parameter values out of traces
+	if query, err := q.UnformattedQuery(); err == nil {
+		span.SetName("db " + queryOperation(q))
+		span.SetAttributes(attribute.String("db.statement", string(query)))
+	}
+	if q.Err != nil && q.Err != pg.ErrNoRows {
+		tracing.EndSpan(span, q.Err)
+		return nil
+	}
+	span.End()
+	return nil
+}
+
+// metricsQueryHook records the duration of every query by operation
+type metricsQueryHook struct{}
+
+func (h metricsQueryHook) BeforeQuery(c context.Context, q *pg.QueryEvent) (context.Context, error) {
+	return c, nil
+}
+
+func (h metricsQueryHook) AfterQuery(c context.Context, q *pg.QueryEvent) error {
+	err := q.Err
+	if err == pg.ErrNoRows {
+		err = nil
+	}
+	metrics.QueryDone(queryOperation(q), time.Since(q.StartTime), err)
+	return nil
+}
+
+var queryOperations = map[string]bool{"select": true, "insert": true, "update": true, "delete": true, "with": true,
+	"begin": true, "commit": true, "rollback": true}
+
+// queryOperation is the lower-cased leading keyword of the query, "other" for anything unexpected to bound metric labels
+func queryOperation(q *pg.QueryEvent) string {
+	query, err := q.UnformattedQuery()
+	if err != nil {
+		return "other"
+	}
+	words := strings.Fields(string(query))
+	if len(words) == 0 || !queryOperations[strings.ToLower(words[0])] {
+		return "other"
+	}
+	return strings.ToLower(words[0])
+}
+
 type dbLogger struct {
 	beforeQueryMethod func(context.Context, *pg.QueryEvent) (context.Context, error)
 	afterQueryMethod  func(context.Context, *pg.QueryEvent) error
@@ -67,8 +162,7 @@ func NewDbConnection(cfg *Config, logger *zap.SugaredLogger) (*pg.DB, error) {
 	}
 	dbConnection := pg.Connect(&options)
 	//check db connection
-	var test string
-	_, err := dbConnection.QueryOne(pg.Scan(&test), "SELECT 1")
+	err := PingDb(context.Background(), dbConnection)
 
 	if err != nil {
 		logger.Errorw("error in connecting db ", "db", obfuscateSecretTags(cfg), "err", err)
@@ -76,12 +170,24 @@ func NewDbConnection(cfg *Config, logger *zap.SugaredLogger) (*pg.DB, error) {
 	} else {
 		logger.Infow("connected with db", "db", obfuscateSecretTags(cfg))
 	}
+	if cfg.QueryTimeoutSecs > 0 {
+		dbConnection.AddQueryHook(queryTimeoutHook{timeout: time.Duration(cfg.QueryTimeoutSecs) * time.Second})
+	}
+	dbConnection.AddQueryHook(tracingQueryHook{})
+	dbConnection.AddQueryHook(metricsQueryHook{})
 	if cfg.LogQuery {
 		dbConnection.AddQueryHook(dbLogger{})
 	}
 	return dbConnection, err
 }
 
+// PingDb runs SELECT 1, used at startup and for readiness
+func PingDb(ctx context.Context, db *pg.DB) error {
+	var test string
+	_, err := db.WithContext(ctx).QueryOne(pg.Scan(&test), "SELECT 1")
+	return err
+}
+
 func obfuscateSecretTags(cfg interface{}) interface{} {
 
 	cfgDpl := reflect.New(reflect.ValueOf(cfg).Elem().Type()).Interface()
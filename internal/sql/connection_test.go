@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+)
+
+func TestQueryTimeoutHook(t *testing.T) {
+	hook := queryTimeoutHook{timeout: time.Minute}
+	ctx, err := hook.BeforeQuery(context.Background(), &pg.QueryEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > time.Minute {
+		t.Fatalf("BeforeQuery() deadline = %v, %t", deadline, ok)
+	}
+	if err = hook.AfterQuery(ctx, &pg.QueryEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() != context.Canceled {
+		t.Errorf("AfterQuery() did not release the query context, err = %v", ctx.Err())
+	}
+
+	ctx, _ = hook.BeforeQuery(WithoutQueryTimeout(context.Background()), &pg.QueryEvent{})
+	if _, ok = ctx.Deadline(); ok {
+		t.Errorf("BeforeQuery() set a deadline on a context exempted from the query timeout")
+	}
+}
+
+func TestQueryOperation(t *testing.T) {
+	tests := map[string]string{
+		"SELECT 1": "select",
+		"\n\t\tselect\n ar.app_id from app_release ar": "select",
+		"INSERT INTO lead_time (id) VALUES (1)":        "insert",
+		"create table if not exists x (id int)":        "other",
+		"":                                             "other",
+	}
+	for query, want := range tests {
+		if got := queryOperation(&pg.QueryEvent{Query: query}); got != want {
+			t.Errorf("queryOperation(%q) = %s, want %s", query, got, want)
+		}
+	}
+}
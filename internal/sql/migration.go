@@ -0,0 +1,269 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/caarlos0/env"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// migrationLockId is the postgres advisory lock key held while migrations run,
+// so that only one lens replica migrates the schema at a time.
+const migrationLockId = 7201553
+
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type MigrationConfig struct {
+	MigrateOnStartup bool `env:"PG_MIGRATE_ON_STARTUP" envDefault:"true"`
+}
+
+func GetMigrationConfig() (*MigrationConfig, error) {
+	cfg := &MigrationConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+type SchemaMigration struct {
+	tableName struct{}  `pg:"schema_migrations"`
+	Version   int       `pg:"version,pk"`
+	Name      string    `pg:"name,notnull"`
+	AppliedOn time.Time `pg:"applied_on,notnull"`
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedOn time.Time
+}
+
+type Migrator interface {
+	// Up applies all pending migrations in version order and returns the applied versions
+	Up(ctx context.Context) ([]int, error)
+	// Down reverts the latest applied migration and returns its version, 0 if nothing was applied
+	Down(ctx context.Context) (int, error)
+	Status(ctx context.Context) ([]*MigrationStatus, error)
+}
+
+type MigratorImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+	migrations   []*Migration
+}
+
+func NewMigratorImpl(dbConnection *pg.DB, logger *zap.SugaredLogger, source fs.FS) (*MigratorImpl, error) {
+	migrations, err := LoadMigrations(source)
+	if err != nil {
+		logger.Errorw("error in loading migrations", "err", err)
+		return nil, err
+	}
+	return &MigratorImpl{dbConnection: dbConnection, logger: logger, migrations: migrations}, nil
+}
+
+// LoadMigrations reads <version>_<name>.up.sql and <version>_<name>.down.sql pairs from source, sorted by version
+func LoadMigrations(source fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(source, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		parts := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			continue
+		}
+		version, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		content, err := fs.ReadFile(source, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = migration
+		} else if migration.Name != parts[2] {
+			return nil, fmt.Errorf("conflicting names for migration version %d: %s, %s", version, migration.Name, parts[2])
+		}
+		if parts[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("missing up migration for version %d", migration.Version)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func (impl *MigratorImpl) Up(ctx context.Context) ([]int, error) {
+	var appliedVersions []int
+	err := impl.withLock(ctx, func(conn *pg.Conn) error {
+		applied, err := impl.getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range impl.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			impl.logger.Infow("applying migration", "version", migration.Version, "name", migration.Name)
+			err = conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+					return err
+				}
+				schemaMigration := &SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedOn: time.Now()}
+				_, err := tx.ModelContext(ctx, schemaMigration).Insert()
+				return err
+			})
+			if err != nil {
+				impl.logger.Errorw("error in applying migration", "version", migration.Version, "name", migration.Name, "err", err)
+				return err
+			}
+			appliedVersions = append(appliedVersions, migration.Version)
+		}
+		return nil
+	})
+	return appliedVersions, err
+}
+
+func (impl *MigratorImpl) Down(ctx context.Context) (int, error) {
+	revertedVersion := 0
+	err := impl.withLock(ctx, func(conn *pg.Conn) error {
+		latest := &SchemaMigration{}
+		err := conn.ModelContext(ctx, latest).Order("version desc").Limit(1).Select()
+		if err == pg.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var migration *Migration
+		for _, m := range impl.migrations {
+			if m.Version == latest.Version {
+				migration = m
+				break
+			}
+		}
+		if migration == nil || migration.Down == "" {
+			return fmt.Errorf("no down migration found for version %d", latest.Version)
+		}
+		impl.logger.Infow("reverting migration", "version", migration.Version, "name", migration.Name)
+		err = conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+			if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+				return err
+			}
+			_, err := tx.ModelContext(ctx, latest).WherePK().Delete()
+			return err
+		})
+		if err != nil {
+			impl.logger.Errorw("error in reverting migration", "version", migration.Version, "name", migration.Name, "err", err)
+			return err
+		}
+		revertedVersion = migration.Version
+		return nil
+	})
+	return revertedVersion, err
+}
+
+func (impl *MigratorImpl) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	var statuses []*MigrationStatus
+	err := impl.withLock(ctx, func(conn *pg.Conn) error {
+		applied, err := impl.getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range impl.migrations {
+			status := &MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if schemaMigration, ok := applied[migration.Version]; ok {
+				status.Applied = true
+				status.AppliedOn = schemaMigration.AppliedOn
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// withLock runs fn on a single session holding the migration advisory lock
+func (impl *MigratorImpl) withLock(ctx context.Context, fn func(conn *pg.Conn) error) error {
+	conn := impl.dbConnection.Conn()
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(?)", migrationLockId); err != nil {
+		impl.logger.Errorw("error in acquiring migration lock", "err", err)
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(?)", migrationLockId); err != nil {
+			impl.logger.Errorw("error in releasing migration lock", "err", err)
+		}
+	}()
+	_, err := conn.ExecContext(ctx, `create table if not exists schema_migrations
+(
+    version    int primary key,
+    name       varchar(250) not null,
+    applied_on timestamptz not null
+)`)
+	if err != nil {
+		impl.logger.Errorw("error in creating schema_migrations table", "err", err)
+		return err
+	}
+	return fn(conn)
+}
+
+func (impl *MigratorImpl) getAppliedMigrations(ctx context.Context, conn *pg.Conn) (map[int]*SchemaMigration, error) {
+	var schemaMigrations []*SchemaMigration
+	err := conn.ModelContext(ctx, &schemaMigrations).Select()
+	if err != nil {
+		impl.logger.Errorw("error in fetching applied migrations", "err", err)
+		return nil, err
+	}
+	applied := make(map[int]*SchemaMigration, len(schemaMigrations))
+	for _, schemaMigration := range schemaMigrations {
+		applied[schemaMigration.Version] = schemaMigration
+	}
+	return applied, nil
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"testing"
+	"testing/fstest"
+
+	migrations "github.com/devtron-labs/lens/scripts/sql"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	source := fstest.MapFS{
+		"10_add_column.up.sql":    {Data: []byte("up 10")},
+		"10_add_column.down.sql":  {Data: []byte("down 10")},
+		"2_create_index.up.sql":   {Data: []byte("up 2")},
+		"2_create_index.down.sql": {Data: []byte("down 2")},
+		"README.md":               {Data: []byte("ignored")},
+	}
+	got, err := LoadMigrations(source)
+	if err != nil {
+		t.Fatalf("LoadMigrations() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("LoadMigrations() got %d migrations, want 2", len(got))
+	}
+	if got[0].Version != 2 || got[0].Name != "create_index" || got[0].Up != "up 2" || got[0].Down != "down 2" {
+		t.Errorf("LoadMigrations() got[0] = %+v", got[0])
+	}
+	if got[1].Version != 10 || got[1].Name != "add_column" {
+		t.Errorf("LoadMigrations() got[1] = %+v", got[1])
+	}
+}
+
+func TestLoadMigrations_MissingUp(t *testing.T) {
+	source := fstest.MapFS{
+		"3_orphan.down.sql": {Data: []byte("down 3")},
+	}
+	if _, err := LoadMigrations(source); err == nil {
+		t.Errorf("LoadMigrations() expected error for missing up migration")
+	}
+}
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	got, err := LoadMigrations(migrations.Migrations)
+	if err != nil {
+		t.Fatalf("LoadMigrations() error = %v", err)
+	}
+	for i, migration := range got {
+		if migration.Version != i+1 {
+			t.Errorf("embedded migrations not contiguous, got version %d at position %d", migration.Version, i)
+		}
+		if migration.Down == "" {
+			t.Errorf("embedded migration %d has no down script", migration.Version)
+		}
+	}
+}
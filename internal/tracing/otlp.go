@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+)
+
+// newOtlpExporter sends spans with the OTLP/HTTP protocol to endpoint, the collector base url, at /v1/traces
+func newOtlpExporter(endpoint string, headers []string) (*otlptrace.Exporter, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT is required for otlp exporter")
+	}
+	endpointUrl, err := url.Parse(endpoint)
+	if err != nil || endpointUrl.Host == "" {
+		return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_ENDPOINT %q, expected a base url", endpoint)
+	}
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpointUrl.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(endpointUrl.Path, "/") + "/v1/traces"),
+	}
+	if endpointUrl.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if len(headers) > 0 {
+		headerMap := make(map[string]string)
+		for _, header := range headers {
+			key, value, ok := strings.Cut(header, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid otlp header %q, expected key=value", header)
+			}
+			headerMap[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		options = append(options, otlptracehttp.WithHeaders(headerMap))
+	}
+	return otlptracehttp.New(context.Background(), options...)
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOtlpExporter_ExportSpans(t *testing.T) {
+	var received []*coltracepb.ExportTraceServiceRequest
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/collector/v1/traces" {
+			t.Errorf("export path = %s", r.URL.Path)
+		}
+		headers = r.Header
+		body, _ := io.ReadAll(r.Body)
+		request := &coltracepb.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, request); err != nil {
+			t.Errorf("invalid otlp protobuf: %v", err)
+		}
+		received = append(received, request)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer server.Close()
+
+	exporter, err := newOtlpExporter(server.URL+"/collector/", []string{"x-api-key=secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "lens"))))
+	tracer := provider.Tracer("test")
+	ctx, parent := tracer.Start(context.Background(), "parent", trace.WithSpanKind(trace.SpanKindServer))
+	_, child := tracer.Start(ctx, "child", trace.WithAttributes(attribute.Int("lens.app_id", 7)))
+	EndSpan(child, errors.New("boom"))
+	parent.End()
+	if err = provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if headers.Get("x-api-key") != "secret" || headers.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("export headers = %v", headers)
+	}
+	// the syncer exports the child first, in its own request
+	if len(received) != 2 {
+		t.Fatalf("received %d exports, want 2", len(received))
+	}
+	got := received[1].ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if got.Name != "parent" || len(got.ParentSpanId) != 0 || len(got.TraceId) != 16 {
+		t.Errorf("parent span = %+v", got)
+	}
+	if attrs := received[1].ResourceSpans[0].Resource.Attributes; len(attrs) != 1 || attrs[0].Value.GetStringValue() != "lens" {
+		t.Errorf("resource attributes = %+v", attrs)
+	}
+}
+
+func TestNewOtlpExporter_InvalidConfig(t *testing.T) {
+	if _, err := newOtlpExporter("localhost", nil); err == nil {
+		t.Errorf("newOtlpExporter() of endpoint without scheme must fail")
+	}
+	if _, err := newOtlpExporter("http://localhost:4318", []string{"x-api-key"}); err == nil {
+		t.Errorf("newOtlpExporter() of header without value must fail")
+	}
+}
+
+func TestExtract(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if spanContext := trace.SpanContextFromContext(Extract(context.Background(), nil)); spanContext.IsValid() {
+		t.Fatalf("empty carrier must not invent a context")
+	}
+	ctx := Extract(context.Background(), map[string]string{"Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"})
+	spanContext := trace.SpanContextFromContext(ctx)
+	if spanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || !spanContext.IsRemote() {
+		t.Errorf("Extract() = %+v", spanContext)
+	}
+}
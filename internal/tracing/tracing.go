@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/caarlos0/env"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	instrumentationName = "github.com/devtron-labs/lens"
+
+	ExporterNone   = "none"
+	ExporterOtlp   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+type TracingConfig struct {
+	Exporter     string `env:"TRACING_EXPORTER" envDefault:"none"`
+	OtlpEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT" envDefault:"http://localhost:4318"`
+	// OtlpHeaders are sent with every export, as key=value
+	OtlpHeaders []string `env:"OTEL_EXPORTER_OTLP_HEADERS" envSeparator:"," secretData:"-"`
+	FilePath    string   `env:"TRACING_FILE_PATH" envDefault:"/tmp/lens-traces.json"`
+	SampleRatio float64  `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+	ServiceName string   `env:"OTEL_SERVICE_NAME" envDefault:"lens"`
+}
+
+func GetTracingConfig() (*TracingConfig, error) {
+	cfg := &TracingConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// TracerProvider owns the exporter, Shutdown flushes pending spans
+type TracerProvider struct {
+	provider *sdktrace.TracerProvider
+	closer   io.Closer
+}
+
+// NewTracerProvider installs the global tracer provider and the w3c trace context propagator
+func NewTracerProvider(logger *zap.SugaredLogger, config *TracingConfig) (*TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	tracerProvider := &TracerProvider{}
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case ExporterNone, "":
+		logger.Infow("tracing disabled")
+		return tracerProvider, nil
+	case ExporterOtlp:
+		exporter, err = newOtlpExporter(config.OtlpEndpoint, config.OtlpHeaders)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		var file *os.File
+		file, err = os.OpenFile(config.FilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		tracerProvider.closer = file
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", config.ServiceName))),
+	)
+	otel.SetTracerProvider(tracerProvider.provider)
+	logger.Infow("tracing enabled", "exporter", config.Exporter, "sampleRatio", config.SampleRatio)
+	return tracerProvider, nil
+}
+
+func (impl *TracerProvider) Shutdown(ctx context.Context) error {
+	if impl == nil || impl.provider == nil {
+		return nil
+	}
+	err := impl.provider.Shutdown(ctx)
+	if impl.closer != nil {
+		if closeErr := impl.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// StartSpan starts an internal span, finish it with EndSpan
+func StartSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// EndSpan records err on the span before ending it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Extract reads trace context propagated as key values, e.g. traceparent and tracestate
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	normalized := make(propagation.MapCarrier, len(carrier))
+	for key, value := range carrier {
+		normalized[strings.ToLower(key)] = value
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, normalized)
+}
@@ -17,7 +17,6 @@
 package main
 
 import (
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -25,20 +24,39 @@ import (
 )
 
 func main() {
+	migrateCommand, err := InitializeMigrateCommand()
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = migrateCommand.Run(os.Args[2:])
+		_ = migrateCommand.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	err = migrateCommand.MigrateOnStartup()
+	_ = migrateCommand.Close()
+	if err != nil {
+		log.Panic(err)
+	}
 	app, err := InitializeApp()
 	if err != nil {
 		log.Panic(err)
 	}
 	//     gracefulStop start
-	var gracefulStop = make(chan os.Signal)
-	signal.Notify(gracefulStop, syscall.SIGTERM)
-	signal.Notify(gracefulStop, syscall.SIGINT)
+	var gracefulStop = make(chan os.Signal, 1)
+	signal.Notify(gracefulStop, syscall.SIGTERM, syscall.SIGINT)
+	stopped := make(chan struct{})
 	go func() {
 		sig := <-gracefulStop
-		fmt.Printf("caught term sig: %+v", sig)
+		app.Logger.Infow("caught term sig", "sig", sig.String())
 		app.Stop()
-		os.Exit(0)
+		close(stopped)
 	}()
 	//      gracefulStop end
 	app.Start()
+	// Start returns as soon as the http server is shut down, wait for the remaining phases
+	<-stopped
 }
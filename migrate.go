@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"text/tabwriter"
+
+	"github.com/devtron-labs/lens/internal/sql"
+	migrations "github.com/devtron-labs/lens/scripts/sql"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+const migrateUsage = "usage: lens migrate up|down|status"
+
+type MigrateCommand struct {
+	logger   *zap.SugaredLogger
+	db       *pg.DB
+	migrator sql.Migrator
+	config   *sql.MigrationConfig
+}
+
+func NewMigrateCommand(logger *zap.SugaredLogger, db *pg.DB, migrator sql.Migrator, config *sql.MigrationConfig) *MigrateCommand {
+	return &MigrateCommand{
+		logger:   logger,
+		db:       db,
+		migrator: migrator,
+		config:   config,
+	}
+}
+
+func NewMigrationSource() fs.FS {
+	return migrations.Migrations
+}
+
+// Run executes the migrate subcommand given by args
+func (cmd *MigrateCommand) Run(args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+	ctx := sql.WithoutQueryTimeout(context.Background())
+	switch args[0] {
+	case "up":
+		applied, err := cmd.migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migration(s) %v\n", len(applied), applied)
+	case "down":
+		reverted, err := cmd.migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		if reverted == 0 {
+			fmt.Println("no migration to revert")
+		} else {
+			fmt.Printf("reverted migration %d\n", reverted)
+		}
+	case "status":
+		statuses, err := cmd.migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED\tAPPLIED ON")
+		for _, status := range statuses {
+			appliedOn := ""
+			if status.Applied {
+				appliedOn = status.AppliedOn.Format("2006-01-02T15:04:05Z07:00")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%t\t%s\n", status.Version, status.Name, status.Applied, appliedOn)
+		}
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
+
+// MigrateOnStartup applies pending migrations unless disabled by PG_MIGRATE_ON_STARTUP
+func (cmd *MigrateCommand) MigrateOnStartup() error {
+	if !cmd.config.MigrateOnStartup {
+		cmd.logger.Infow("skipping schema migration on startup")
+		return nil
+	}
+	applied, err := cmd.migrator.Up(sql.WithoutQueryTimeout(context.Background()))
+	if err != nil {
+		cmd.logger.Errorw("error in migrating schema", "err", err)
+		return err
+	}
+	cmd.logger.Infow("schema migrated", "applied", applied)
+	return nil
+}
+
+func (cmd *MigrateCommand) Close() error {
+	return cmd.db.Close()
+}
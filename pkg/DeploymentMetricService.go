@@ -17,9 +17,11 @@
 package pkg
 
 import (
+	"context"
 	"time"
 
 	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/pkg/apperror"
 	pg "github.com/go-pg/pg/v10"
 	"go.uber.org/zap"
 )
@@ -29,7 +31,8 @@ const (
 )
 
 type DeploymentMetricService interface {
-	GetDeploymentMetrics(request *MetricRequest) (*Metrics, error)
+	GetDeploymentMetrics(ctx context.Context, request *MetricRequest) (*Metrics, error)
+	GetDailyMetrics(ctx context.Context, request *MetricRequest) ([]*DailyMetric, error)
 }
 
 type Metrics struct {
@@ -43,6 +46,15 @@ type Metrics struct {
 	AverageLineDeleted     float32   `json:"average_line_deleted"`
 	LastFailedTime         string    `json:"last_failed_time"`
 	RecoveryTimeLastFailed float64   `json:"recovery_time_last_failed"`
+	// RollbackRate is the percentage of deployments that were rollbacks, redeploys left out
+	RollbackRate float64 `json:"rollback_rate"`
+	// MeanTimeToRollback is the average time from a rolled back release to its rollback
+	MeanTimeToRollback float64 `json:"mean_time_to_rollback"`
+	// ReworkRate is the percentage of deployments that were unplanned fixes, ReworkBreakdown counts them per reason
+	ReworkRate      float64        `json:"rework_rate"`
+	ReworkBreakdown map[string]int `json:"rework_breakdown,omitempty"`
+	// Groups holds metrics per value of the requested group by tag
+	Groups map[string]*Metrics `json:"groups,omitempty"`
 }
 
 type Metric struct {
@@ -57,6 +69,29 @@ type Metric struct {
 	LeadTime              float64           `json:"lead_time"`
 	CycleTime             float64           `json:"cycle_time"`
 	RecoveryTime          float64           `json:"recovery_time"`
+	TimeToRollback        float64           `json:"time_to_rollback"`
+	CommitCount           int               `json:"commit_count"`
+	FixCommitCount        int               `json:"fix_commit_count"`
+	ReworkReasons         []string          `json:"rework_reasons,omitempty"`
+	Tags                  map[string]string `json:"tags,omitempty"`
+}
+
+// DailyMetric is a per day aggregate, it covers archived releases as well
+type DailyMetric struct {
+	Day                   string  `json:"day"`
+	DeploymentCount       int     `json:"deployment_count"`
+	FailureCount          int     `json:"failure_count"`
+	RollbackCount         int     `json:"rollback_count"`
+	PatchCount            int     `json:"patch_count"`
+	AverageLeadTime       float64 `json:"average_lead_time"`
+	ChangeSizeLineAdded   int     `json:"change_size_line_added"`
+	ChangeSizeLineDeleted int     `json:"change_size_line_deleted"`
+	ReworkCount           int     `json:"rework_count"`
+	ReworkRate            float64 `json:"rework_rate"`
+	// ReworkBreakdown counts rework per reason, a release can have several
+	ReworkBreakdown map[string]int `json:"rework_breakdown,omitempty"`
+	// MeanTimeToRollback is the average time in minutes from a rolled back release to its rollback
+	MeanTimeToRollback float64 `json:"mean_time_to_rollback"`
 }
 
 type MetricRequest struct {
@@ -64,38 +99,51 @@ type MetricRequest struct {
 	EnvId int    `json:"env_id"`
 	From  string `json:"from"`
 	To    string `json:"to"`
+	// Tags restricts metrics to releases carrying all of these tags
+	Tags map[string]string `json:"tags"`
+	// GroupBy is a tag key, metrics are additionally computed per value of it
+	GroupBy string `json:"group_by"`
 }
 
+// untaggedGroup is the group of releases without the group by tag
+const untaggedGroup = "(none)"
+
 type DeploymentMetricServiceImpl struct {
 	logger                     *zap.SugaredLogger
 	appReleaseRepository       sql.AppReleaseRepository
 	pipelineMaterialRepository sql.PipelineMaterialRepository
 	leadTimeRepository         sql.LeadTimeRepository
+	releaseRollupRepository    sql.ReleaseRollupRepository
+	releaseTagRepository       sql.ReleaseTagRepository
 }
 
 func NewDeploymentMetricServiceImpl(
 	logger *zap.SugaredLogger,
 	appReleaseRepository sql.AppReleaseRepository,
 	pipelineMaterialRepository sql.PipelineMaterialRepository,
-	leadTimeRepository sql.LeadTimeRepository) *DeploymentMetricServiceImpl {
+	leadTimeRepository sql.LeadTimeRepository,
+	releaseRollupRepository sql.ReleaseRollupRepository,
+	releaseTagRepository sql.ReleaseTagRepository) *DeploymentMetricServiceImpl {
 	return &DeploymentMetricServiceImpl{
 		logger:                     logger,
 		appReleaseRepository:       appReleaseRepository,
 		pipelineMaterialRepository: pipelineMaterialRepository,
 		leadTimeRepository:         leadTimeRepository,
+		releaseRollupRepository:    releaseRollupRepository,
+		releaseTagRepository:       releaseTagRepository,
 	}
 }
 
-func (impl DeploymentMetricServiceImpl) GetDeploymentMetrics(request *MetricRequest) (*Metrics, error) {
+func (impl DeploymentMetricServiceImpl) GetDeploymentMetrics(ctx context.Context, request *MetricRequest) (*Metrics, error) {
 	from, err := time.Parse(layout, request.From)
 	if err != nil {
-		return nil, err
+		return nil, apperror.Validationf("invalid from %q, expected %s", request.From, layout)
 	}
 	to, err := time.Parse(layout, request.To)
 	if err != nil {
-		return nil, err
+		return nil, apperror.Validationf("invalid to %q, expected %s", request.To, layout)
 	}
-	releases, err := impl.appReleaseRepository.GetReleaseBetween(request.AppId, request.EnvId, from, to)
+	releases, err := impl.appReleaseRepository.GetReleaseBetween(ctx, request.AppId, request.EnvId, from, to, request.Tags)
 	if err != nil {
 		impl.logger.Errorf("error getting data from db ", "err", err)
 		return nil, err
@@ -107,53 +155,185 @@ func (impl DeploymentMetricServiceImpl) GetDeploymentMetrics(request *MetricRequ
 	for _, v := range releases {
 		ids = append(ids, v.Id)
 	}
-	materials, err := impl.pipelineMaterialRepository.FindByAppReleaseIds(ids)
+	materials, err := impl.pipelineMaterialRepository.FindByAppReleaseIds(ctx, ids)
 	if err != nil {
 		impl.logger.Errorf("error getting material from db ", "err", err)
 		return nil, err
 	}
-	leadTimes, err := impl.leadTimeRepository.FindByIds(ids)
+	leadTimes, err := impl.leadTimeRepository.FindByIds(ctx, ids)
 	if err != nil {
 		impl.logger.Errorf("error getting lead time from db ", "err", err)
 		return nil, err
 	}
 	lastId := releases[len(releases)-1].Id
-	lastRelease, err := impl.appReleaseRepository.GetPreviousRelease(request.AppId, request.EnvId, lastId)
+	lastRelease, err := impl.appReleaseRepository.GetPreviousRelease(ctx, request.AppId, request.EnvId, lastId)
 	if err != nil {
 		if err != pg.ErrNoRows {
 			impl.logger.Errorf("error getting data from db ", "err", err)
 		}
 		lastRelease = nil
 	}
+	tags, err := impl.releaseTagRepository.FindByAppReleaseIds(ctx, ids)
+	if err != nil {
+		impl.logger.Errorw("error getting tags from db", "err", err)
+		return nil, err
+	}
+	tagsByRelease := groupTagsByRelease(tags)
 	metrics, err := impl.populateMetrics(releases, materials, leadTimes, lastRelease)
 	if err != nil {
 		return nil, err
 	}
+	setSeriesTags(metrics, releases, tagsByRelease)
+	calculateReworkRate(metrics, releases, tagsByRelease)
+	if request.GroupBy != "" {
+		metrics.Groups, err = impl.groupMetrics(releases, materials, leadTimes, tagsByRelease, request.GroupBy)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return metrics, nil
 }
 
+// groupMetrics computes metrics separately for each value of the groupBy tag
+func (impl DeploymentMetricServiceImpl) groupMetrics(releases []sql.AppRelease, materials []*sql.PipelineMaterial, leadTimes []sql.LeadTime,
+	tagsByRelease map[int]map[string]string, groupBy string) (map[string]*Metrics, error) {
+	releasesByGroup := make(map[string][]sql.AppRelease)
+	for _, release := range releases {
+		group, ok := tagsByRelease[release.Id][groupBy]
+		if !ok {
+			group = untaggedGroup
+		}
+		releasesByGroup[group] = append(releasesByGroup[group], release)
+	}
+	groups := make(map[string]*Metrics, len(releasesByGroup))
+	for group, groupReleases := range releasesByGroup {
+		metrics, err := impl.populateMetrics(groupReleases, materials, leadTimes, nil)
+		if err != nil {
+			return nil, err
+		}
+		setSeriesTags(metrics, groupReleases, tagsByRelease)
+		calculateReworkRate(metrics, groupReleases, tagsByRelease)
+		groups[group] = metrics
+	}
+	return groups, nil
+}
+
+// setSeriesTags relies on populateMetrics keeping the order of releases in the series
+func setSeriesTags(metrics *Metrics, releases []sql.AppRelease, tagsByRelease map[int]map[string]string) {
+	for i, release := range releases {
+		metrics.Series[i].Tags = tagsByRelease[release.Id]
+	}
+}
+
+// calculateReworkRate relies on populateMetrics keeping the order of releases in the series
+func calculateReworkRate(metrics *Metrics, releases []sql.AppRelease, tagsByRelease map[int]map[string]string) {
+	deployments := 0
+	rework := 0
+	for i := range releases {
+		if !releases[i].ReleaseType.CountsForDeploymentFrequency() {
+			continue
+		}
+		deployments++
+		reasons := releases[i].ReworkReasons(tagsByRelease[releases[i].Id])
+		if len(reasons) == 0 {
+			continue
+		}
+		rework++
+		metrics.Series[i].ReworkReasons = reasons
+		if metrics.ReworkBreakdown == nil {
+			metrics.ReworkBreakdown = make(map[string]int)
+		}
+		for _, reason := range reasons {
+			metrics.ReworkBreakdown[reason]++
+		}
+	}
+	if deployments > 0 {
+		metrics.ReworkRate = float64(rework) * float64(100) / float64(deployments)
+	}
+}
+
+// GetDailyMetrics covers archived releases through their rollups, which keep no tags, so tag filters and groups are
+// refused rather than applied to part of the range
+func (impl DeploymentMetricServiceImpl) GetDailyMetrics(ctx context.Context, request *MetricRequest) ([]*DailyMetric, error) {
+	if len(request.Tags) > 0 || request.GroupBy != "" {
+		return nil, apperror.Validationf("tag and group_by are not supported by daily metrics, rollups of archived releases keep no tags")
+	}
+	from, err := time.Parse(layout, request.From)
+	if err != nil {
+		return nil, apperror.Validationf("invalid from %q, expected %s", request.From, layout)
+	}
+	to, err := time.Parse(layout, request.To)
+	if err != nil {
+		return nil, apperror.Validationf("invalid to %q, expected %s", request.To, layout)
+	}
+	rollups, err := impl.releaseRollupRepository.FindDaily(ctx, request.AppId, request.EnvId, from, to)
+	if err != nil {
+		impl.logger.Errorw("error getting daily rollup from db", "err", err)
+		return nil, err
+	}
+	dailyMetrics := make([]*DailyMetric, 0, len(rollups))
+	for _, rollup := range rollups {
+		dailyMetric := &DailyMetric{
+			Day:                   rollup.Day.Format("2006-01-02"),
+			DeploymentCount:       rollup.DeploymentCount,
+			FailureCount:          rollup.FailureCount,
+			RollbackCount:         rollup.RollbackCount,
+			PatchCount:            rollup.PatchCount,
+			ChangeSizeLineAdded:   rollup.ChangeSizeLineAdded,
+			ChangeSizeLineDeleted: rollup.ChangeSizeLineDeleted,
+			ReworkCount:           rollup.ReworkCount,
+		}
+		if rollup.DeploymentCount > 0 {
+			dailyMetric.ReworkRate = float64(rollup.ReworkCount) * float64(100) / float64(rollup.DeploymentCount)
+		}
+		if rollup.ReworkCount > 0 {
+			dailyMetric.ReworkBreakdown = map[string]int{
+				sql.ReworkPatch:      rollup.PatchCount,
+				sql.ReworkRollback:   rollup.RollbackCount,
+				sql.ReworkHotfixTag:  rollup.HotfixCount,
+				sql.ReworkFixCommits: rollup.FixReleaseCount,
+			}
+		}
+		if rollup.LeadTimeCount > 0 {
+			dailyMetric.AverageLeadTime = rollup.LeadTimeTotal.Minutes() / float64(rollup.LeadTimeCount)
+		}
+		if rollup.TimeToRollbackCount > 0 {
+			dailyMetric.MeanTimeToRollback = rollup.TimeToRollbackTotal.Minutes() / float64(rollup.TimeToRollbackCount)
+		}
+		dailyMetrics = append(dailyMetrics, dailyMetric)
+	}
+	return dailyMetrics, nil
+}
+
 func (impl DeploymentMetricServiceImpl) populateMetrics(appReleases []sql.AppRelease, materials []*sql.PipelineMaterial, leadTimes []sql.LeadTime, lastRelease *sql.AppRelease) (*Metrics, error) {
 	releases := impl.transform(appReleases, materials, leadTimes)
 	leadTimesCount := 0
 	totalLeadTime := float64(0)
 	for _, r := range releases {
-		if r.LeadTime != float64(0) {
+		if r.LeadTime != float64(0) && r.ReleaseType.CountsForLeadTime() {
 			totalLeadTime += r.LeadTime
 			leadTimesCount++
 		}
 	}
 
+	// cycle time is the time since the previous release counted for deployment frequency
+	var deployments []*Metric
+	for _, r := range releases {
+		if r.ReleaseType.CountsForDeploymentFrequency() {
+			deployments = append(deployments, r)
+		}
+	}
 	totalCycleTime := float64(0)
-	cycleTimeCount := len(releases)
-	for i := 0; i < len(releases)-1; i++ {
-		releases[i].CycleTime = releases[i].ReleaseTime.Sub(releases[i+1].ReleaseTime).Minutes()
-		totalCycleTime += releases[i].CycleTime
-	}
-	if lastRelease != nil {
-		releases[len(releases)-1].CycleTime = releases[len(releases)-1].ReleaseTime.Sub(lastRelease.TriggerTime).Minutes()
-		totalCycleTime += releases[len(releases)-1].CycleTime
-	} else if len(releases) > 0 {
-		releases[len(releases)-1].CycleTime = 0
+	cycleTimeCount := len(deployments)
+	for i := 0; i < len(deployments)-1; i++ {
+		deployments[i].CycleTime = deployments[i].ReleaseTime.Sub(deployments[i+1].ReleaseTime).Minutes()
+		totalCycleTime += deployments[i].CycleTime
+	}
+	if lastRelease != nil && len(deployments) > 0 {
+		deployments[len(deployments)-1].CycleTime = deployments[len(deployments)-1].ReleaseTime.Sub(lastRelease.TriggerTime).Minutes()
+		totalCycleTime += deployments[len(deployments)-1].CycleTime
+	} else if len(deployments) > 0 {
+		deployments[len(deployments)-1].CycleTime = 0
 		cycleTimeCount -= 1
 	}
 	averageCycleTime := float64(0)
@@ -172,6 +352,7 @@ func (impl DeploymentMetricServiceImpl) populateMetrics(appReleases []sql.AppRel
 	}
 
 	impl.calculateChangeFailureRateAndRecoveryTime(metrics)
+	calculateRollbackRate(metrics)
 	if len(metrics.Series) > 0 {
 		impl.calculateChangeSize(metrics)
 	}
@@ -205,7 +386,8 @@ func (impl DeploymentMetricServiceImpl) calculateChangeFailureRateAndRecoveryTim
 				continue
 			}
 			for j := i - 1; j >= 0; j-- {
-				if releases[j].ReleaseStatus == sql.Success {
+				// a rollback restores service even when a later heuristic marks it failed
+				if releases[j].ReleaseStatus == sql.Success || releases[j].ReleaseType == sql.RollBack {
 					releases[i].RecoveryTime = releases[j].ReleaseTime.Sub(releases[i].ReleaseTime).Minutes()
 					recoveryTime += releases[i].RecoveryTime
 					recovered++
@@ -229,6 +411,33 @@ func (impl DeploymentMetricServiceImpl) calculateChangeFailureRateAndRecoveryTim
 	metrics.AverageRecoveryTime = averageRecoveryTime
 }
 
+// calculateRollbackRate is relative to the releases counted as deployments, like the rework rate
+func calculateRollbackRate(metrics *Metrics) {
+	deployments := 0
+	rollbacks := 0
+	timeToRollback := float64(0)
+	timed := 0
+	for _, v := range metrics.Series {
+		if v.ReleaseType.CountsForDeploymentFrequency() {
+			deployments++
+		}
+		if v.ReleaseType != sql.RollBack {
+			continue
+		}
+		rollbacks++
+		if v.TimeToRollback > 0 {
+			timeToRollback += v.TimeToRollback
+			timed++
+		}
+	}
+	if deployments > 0 {
+		metrics.RollbackRate = float64(rollbacks) * float64(100) / float64(deployments)
+	}
+	if timed > 0 {
+		metrics.MeanTimeToRollback = timeToRollback / float64(timed)
+	}
+}
+
 func (impl DeploymentMetricServiceImpl) calculateChangeSize(metrics *Metrics) {
 	releases := metrics.Series
 	lineAdded := 0
@@ -268,6 +477,9 @@ func (impl DeploymentMetricServiceImpl) transform(releases []sql.AppRelease, mat
 			LeadTime:              0,
 			CycleTime:             0,
 			RecoveryTime:          0,
+			TimeToRollback:        v.TimeToRollback.Minutes(),
+			CommitCount:           v.CommitCount,
+			FixCommitCount:        v.FixCommitCount,
 		}
 		if p, ok := pm[v.Id]; ok {
 			metric.CommitHash = p.CommitHash
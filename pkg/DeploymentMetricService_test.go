@@ -17,7 +17,9 @@
 package pkg
 
 import (
+	"context"
 	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/pkg/apperror"
 	"go.uber.org/zap"
 	"reflect"
 	"testing"
@@ -504,14 +506,16 @@ func TestDeploymentMetricServiceImpl_populateMetrics(t *testing.T) {
 				lastRelease: nil,
 			},
 			want: &Metrics{
-				Series:                nil,
-				AverageCycleTime:      24,
-				AverageLeadTime:       0,
-				ChangeFailureRate:     33.333332,
-				AverageRecoveryTime:   24,
-				AverageDeploymentSize: 22,
-				AverageLineAdded:      11,
-				AverageLineDeleted:    11,
+				Series:                 nil,
+				AverageCycleTime:       1440,
+				AverageLeadTime:        0,
+				ChangeFailureRate:      float64(2) * 100 / 3,
+				AverageRecoveryTime:    2880,
+				AverageDeploymentSize:  22,
+				AverageLineAdded:       11,
+				AverageLineDeleted:     11,
+				LastFailedTime:         currentTime.AddDate(0, 0, -2).Format(layout),
+				RecoveryTimeLastFailed: 2880,
 			},
 		},
 		{
@@ -530,7 +534,7 @@ func TestDeploymentMetricServiceImpl_populateMetrics(t *testing.T) {
 			},
 			want: &Metrics{
 				Series:                nil,
-				AverageCycleTime:      24,
+				AverageCycleTime:      1440,
 				AverageLeadTime:       0,
 				ChangeFailureRate:     0,
 				AverageRecoveryTime:   0,
@@ -555,13 +559,14 @@ func TestDeploymentMetricServiceImpl_populateMetrics(t *testing.T) {
 			},
 			want: &Metrics{
 				Series:                nil,
-				AverageCycleTime:      24,
+				AverageCycleTime:      1440,
 				AverageLeadTime:       0,
 				ChangeFailureRate:     100,
 				AverageRecoveryTime:   0,
 				AverageDeploymentSize: 22,
 				AverageLineAdded:      11,
 				AverageLineDeleted:    11,
+				LastFailedTime:        currentTime.AddDate(0, 0, -1).Format(layout),
 			},
 		},
 		{
@@ -580,13 +585,14 @@ func TestDeploymentMetricServiceImpl_populateMetrics(t *testing.T) {
 			},
 			want: &Metrics{
 				Series:                nil,
-				AverageCycleTime:      24,
+				AverageCycleTime:      1440,
 				AverageLeadTime:       0,
-				ChangeFailureRate:     66.666664,
+				ChangeFailureRate:     float64(2) * 100 / 3,
 				AverageRecoveryTime:   0,
 				AverageDeploymentSize: 22,
 				AverageLineAdded:      11,
 				AverageLineDeleted:    11,
+				LastFailedTime:        currentTime.AddDate(0, 0, -1).Format(layout),
 			},
 		},
 		{
@@ -604,14 +610,16 @@ func TestDeploymentMetricServiceImpl_populateMetrics(t *testing.T) {
 				lastRelease: &lastReleaseF2,
 			},
 			want: &Metrics{
-				Series:                nil,
-				AverageCycleTime:      24,
-				AverageLeadTime:       0,
-				ChangeFailureRate:     66.666664,
-				AverageRecoveryTime:   48,
-				AverageDeploymentSize: 22,
-				AverageLineAdded:      11,
-				AverageLineDeleted:    11,
+				Series:                 nil,
+				AverageCycleTime:       1440,
+				AverageLeadTime:        0,
+				ChangeFailureRate:      float64(2) * 100 / 3,
+				AverageRecoveryTime:    2880,
+				AverageDeploymentSize:  22,
+				AverageLineAdded:       11,
+				AverageLineDeleted:     11,
+				LastFailedTime:         currentTime.AddDate(0, 0, -2).Format(layout),
+				RecoveryTimeLastFailed: 2880,
 			},
 		},
 		{
@@ -629,33 +637,130 @@ func TestDeploymentMetricServiceImpl_populateMetrics(t *testing.T) {
 				lastRelease: &lastReleaseF3,
 			},
 			want: &Metrics{
-				Series:                nil,
-				AverageCycleTime:      24,
-				AverageLeadTime:       0,
-				ChangeFailureRate:     33.333332,
-				AverageRecoveryTime:   24,
-				AverageDeploymentSize: 22,
-				AverageLineAdded:      11,
-				AverageLineDeleted:    11,
+				Series:                 nil,
+				AverageCycleTime:       1440,
+				AverageLeadTime:        0,
+				ChangeFailureRate:      float64(1) * 100 / 3,
+				AverageRecoveryTime:    1440,
+				AverageDeploymentSize:  22,
+				AverageLineAdded:       11,
+				AverageLineDeleted:     11,
+				LastFailedTime:         currentTime.AddDate(0, 0, -2).Format(layout),
+				RecoveryTimeLastFailed: 1440,
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			impl := DeploymentMetricServiceImpl{
-				logger:                     tt.fields.logger,
+				logger:                     zap.NewNop().Sugar(),
 				appReleaseRepository:       tt.fields.appReleaseRepository,
 				pipelineMaterialRepository: tt.fields.pipelineMaterialRepository,
 				leadTimeRepository:         tt.fields.leadTimeRepository,
 			}
-			got, err := impl.populateMetrics(tt.args.appReleases, tt.args.leadTimes, tt.args.lastRelease)
+			got, err := impl.populateMetrics(tt.args.appReleases, nil, tt.args.leadTimes, tt.args.lastRelease)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("populateMetrics() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			got.Series = nil
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("populateMetrics() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestDeploymentMetricServiceImpl_rollbackMetrics(t *testing.T) {
+	impl := DeploymentMetricServiceImpl{logger: zap.NewNop().Sugar()}
+	now := time.Now()
+	// newest first: a rollback 30 minutes after a failed release, itself marked failed by a later heuristic,
+	// and a redeploy that is not a deployment for the rollback rate any more than for the rework rate
+	appReleases := []sql.AppRelease{
+		{Id: 5, TriggerTime: now, ReleaseType: sql.RollForward, ReleaseStatus: sql.Success},
+		{Id: 4, TriggerTime: now.Add(-time.Hour), ReleaseType: sql.RollBack, ReleaseStatus: sql.Failure, RolledBackReleaseId: 3, TimeToRollback: 30 * time.Minute},
+		{Id: 3, TriggerTime: now.Add(-90 * time.Minute), ReleaseType: sql.RollForward, ReleaseStatus: sql.Failure},
+		{Id: 2, TriggerTime: now.Add(-2 * time.Hour), ReleaseType: sql.RollForward, ReleaseStatus: sql.Success},
+		{Id: 1, TriggerTime: now.Add(-3 * time.Hour), ReleaseType: sql.Redeploy, ReleaseStatus: sql.Success},
+	}
+	metrics, err := impl.populateMetrics(appReleases, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.RollbackRate != 25 {
+		t.Errorf("RollbackRate = %v, want 25", metrics.RollbackRate)
+	}
+	if metrics.MeanTimeToRollback != 30 {
+		t.Errorf("MeanTimeToRollback = %v, want 30", metrics.MeanTimeToRollback)
+	}
+	if metrics.Series[2].RecoveryTime != 30 {
+		t.Errorf("recovery time of the rolled back release = %v, want the 30 minutes to its rollback", metrics.Series[2].RecoveryTime)
+	}
+}
+
+func TestDeploymentMetricServiceImpl_releaseTypeInclusion(t *testing.T) {
+	impl := DeploymentMetricServiceImpl{logger: zap.NewNop().Sugar()}
+	now := time.Now()
+	appReleases := []sql.AppRelease{
+		{Id: 4, TriggerTime: now, ReleaseType: sql.ConfigOnly},
+		{Id: 3, TriggerTime: now.Add(-time.Hour), ReleaseType: sql.Redeploy},
+		{Id: 2, TriggerTime: now.Add(-3 * time.Hour), ReleaseType: sql.RollForward},
+		{Id: 1, TriggerTime: now.Add(-4 * time.Hour), ReleaseType: sql.RollForward},
+	}
+	leadTimes := []sql.LeadTime{{AppReleaseId: 4, LeadTime: time.Hour}, {AppReleaseId: 2, LeadTime: 2 * time.Hour}}
+	metrics, err := impl.populateMetrics(appReleases, nil, leadTimes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.AverageLeadTime != 120 {
+		t.Errorf("AverageLeadTime = %v, want 120 with the config only release left out", metrics.AverageLeadTime)
+	}
+	// the redeploy is skipped: 180 minutes from release 2 to the config only release, 60 from release 1 to 2
+	if metrics.AverageCycleTime != 120 || metrics.Series[1].CycleTime != 0 {
+		t.Errorf("AverageCycleTime = %v, redeploy cycle time %v, want 120 and 0", metrics.AverageCycleTime, metrics.Series[1].CycleTime)
+	}
+}
+
+func TestCalculateReworkRate(t *testing.T) {
+	now := time.Now()
+	appReleases := []sql.AppRelease{
+		{Id: 5, TriggerTime: now, ReleaseType: sql.Patch, CommitCount: 3, FixCommitCount: 2},
+		{Id: 4, TriggerTime: now.Add(-time.Hour), ReleaseType: sql.Redeploy},
+		{Id: 3, TriggerTime: now.Add(-2 * time.Hour), ReleaseType: sql.RollForward},
+		{Id: 2, TriggerTime: now.Add(-3 * time.Hour), ReleaseType: sql.RollBack},
+		{Id: 1, TriggerTime: now.Add(-4 * time.Hour), ReleaseType: sql.RollForward, CommitCount: 2, FixCommitCount: 1},
+	}
+	tagsByRelease := map[int]map[string]string{4: {"type": "hotfix"}, 3: {"type": "hotfix"}}
+	metrics := &Metrics{Series: make([]*Metric, len(appReleases))}
+	for i := range metrics.Series {
+		metrics.Series[i] = &Metric{}
+	}
+	calculateReworkRate(metrics, appReleases, tagsByRelease)
+	// the redeploy is no deployment, release 1 has no majority of fix commits
+	if metrics.ReworkRate != 75 {
+		t.Errorf("ReworkRate = %v, want 75", metrics.ReworkRate)
+	}
+	wantBreakdown := map[string]int{sql.ReworkPatch: 1, sql.ReworkFixCommits: 1, sql.ReworkHotfixTag: 1, sql.ReworkRollback: 1}
+	if !reflect.DeepEqual(metrics.ReworkBreakdown, wantBreakdown) {
+		t.Errorf("ReworkBreakdown = %v, want %v", metrics.ReworkBreakdown, wantBreakdown)
+	}
+	if want := []string{sql.ReworkPatch, sql.ReworkFixCommits}; !reflect.DeepEqual(metrics.Series[0].ReworkReasons, want) {
+		t.Errorf("ReworkReasons = %v, want %v", metrics.Series[0].ReworkReasons, want)
+	}
+	if metrics.Series[1].ReworkReasons != nil {
+		t.Errorf("redeploy counted as rework: %v", metrics.Series[1].ReworkReasons)
+	}
+}
+
+func TestDeploymentMetricServiceImpl_GetDailyMetricsRefusesTags(t *testing.T) {
+	impl := NewDeploymentMetricServiceImpl(zap.NewNop().Sugar(), nil, nil, nil, nil, nil)
+	requests := []*MetricRequest{
+		{AppId: 1, EnvId: 1, Tags: map[string]string{"type": "feature"}},
+		{AppId: 1, EnvId: 1, GroupBy: "change-ticket"},
+	}
+	for _, request := range requests {
+		if _, err := impl.GetDailyMetrics(context.Background(), request); apperror.KindOf(err) != apperror.Validation {
+			t.Errorf("GetDailyMetrics(%+v) err = %v, want a validation error", request, err)
+		}
+	}
+}
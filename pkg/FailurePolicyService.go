@@ -0,0 +1,358 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/pkg/apperror"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+type FailurePolicyRequest struct {
+	EnvironmentId int               `json:"-"`
+	Rules         []sql.FailureRule `json:"rules"`
+	UpdatedBy     string            `json:"updatedBy"`
+}
+
+type FailureRecomputeRequest struct {
+	EnvironmentId int    `json:"-"`
+	RequestedBy   string `json:"requestedBy"`
+}
+
+type FailurePolicyService interface {
+	// GetPolicy returns the policy in force for an environment: its own, else the default one, else the built-in one
+	GetPolicy(ctx context.Context, environmentId int) (*sql.FailurePolicy, error)
+	// SavePolicy replaces the policy of an environment, environment 0 for the default policy
+	SavePolicy(ctx context.Context, request *FailurePolicyRequest) (*sql.FailurePolicy, error)
+	// Recompute starts a job classifying again the history of app environments following the policy of the
+	// environment that was classified with another policy or an older version of it
+	Recompute(ctx context.Context, request *FailureRecomputeRequest) (*sql.FailureRecomputeJob, error)
+	GetRecomputeJob(ctx context.Context, id int) (*sql.FailureRecomputeJob, error)
+	// Start fails jobs left running by an earlier process
+	Start()
+	// Stop cancels running jobs and waits for them
+	Stop()
+}
+
+type FailurePolicyServiceImpl struct {
+	logger                       *zap.SugaredLogger
+	failurePolicyRepository      sql.FailurePolicyRepository
+	appReleaseRepository         sql.AppReleaseRepository
+	releaseOverrideRepository    sql.ReleaseOverrideRepository
+	releaseTagRepository         sql.ReleaseTagRepository
+	releaseStatusEventRepository sql.ReleaseStatusEventRepository
+	recomputeJobRepository       sql.FailureRecomputeJobRepository
+	ctx                          context.Context
+	cancel                       context.CancelFunc
+	wg                           sync.WaitGroup
+}
+
+func NewFailurePolicyServiceImpl(logger *zap.SugaredLogger,
+	failurePolicyRepository sql.FailurePolicyRepository,
+	appReleaseRepository sql.AppReleaseRepository,
+	releaseOverrideRepository sql.ReleaseOverrideRepository,
+	releaseTagRepository sql.ReleaseTagRepository,
+	releaseStatusEventRepository sql.ReleaseStatusEventRepository,
+	recomputeJobRepository sql.FailureRecomputeJobRepository) *FailurePolicyServiceImpl {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &FailurePolicyServiceImpl{
+		logger:                       logger,
+		failurePolicyRepository:      failurePolicyRepository,
+		appReleaseRepository:         appReleaseRepository,
+		releaseOverrideRepository:    releaseOverrideRepository,
+		releaseTagRepository:         releaseTagRepository,
+		releaseStatusEventRepository: releaseStatusEventRepository,
+		recomputeJobRepository:       recomputeJobRepository,
+		ctx:                          ctx,
+		cancel:                       cancel,
+	}
+}
+
+func (impl *FailurePolicyServiceImpl) GetPolicy(ctx context.Context, environmentId int) (*sql.FailurePolicy, error) {
+	policy, err := impl.failurePolicyRepository.FindByEnvironmentId(ctx, environmentId)
+	if err == pg.ErrNoRows && environmentId != sql.DefaultEnvironmentId {
+		policy, err = impl.failurePolicyRepository.FindByEnvironmentId(ctx, sql.DefaultEnvironmentId)
+	}
+	if err == pg.ErrNoRows {
+		return sql.DefaultFailurePolicy(), nil
+	} else if err != nil {
+		impl.logger.Errorw("error in fetching failure policy", "environmentId", environmentId, "err", err)
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (impl *FailurePolicyServiceImpl) SavePolicy(ctx context.Context, request *FailurePolicyRequest) (*sql.FailurePolicy, error) {
+	if request.EnvironmentId < 0 {
+		return nil, apperror.Validationf("invalid environmentId %d", request.EnvironmentId)
+	}
+	if request.UpdatedBy == "" {
+		return nil, apperror.Validationf("updatedBy is required")
+	}
+	seen := make(map[sql.FailureRuleType]bool)
+	for _, rule := range request.Rules {
+		if err := rule.Validate(); err != nil {
+			return nil, apperror.Validationf("%v", err)
+		}
+		if seen[rule.Type] {
+			return nil, apperror.Validationf("rule %s given more than once", rule.Type)
+		}
+		seen[rule.Type] = true
+	}
+	rules := request.Rules
+	if rules == nil {
+		// no rule, releases are only failed by hand
+		rules = []sql.FailureRule{}
+	}
+	policy, err := impl.failurePolicyRepository.Save(ctx, &sql.FailurePolicy{
+		EnvironmentId: request.EnvironmentId,
+		Rules:         rules,
+		UpdatedBy:     request.UpdatedBy,
+		UpdatedOn:     time.Now(),
+	})
+	if err != nil {
+		impl.logger.Errorw("error in saving failure policy", "request", request, "err", err)
+		return nil, err
+	}
+	impl.logger.Infow("failure policy saved", "environmentId", policy.EnvironmentId, "version", policy.Version, "updatedBy", policy.UpdatedBy)
+	return policy, nil
+}
+
+func (impl *FailurePolicyServiceImpl) Recompute(ctx context.Context, request *FailureRecomputeRequest) (*sql.FailureRecomputeJob, error) {
+	if request.EnvironmentId < 0 {
+		return nil, apperror.Validationf("invalid environmentId %d", request.EnvironmentId)
+	}
+	if request.RequestedBy == "" {
+		return nil, apperror.Validationf("requestedBy is required")
+	}
+	// environments covered by the default policy overlap those of any other, one job runs at a time, which a
+	// unique index enforces when requests race
+	running, err := impl.recomputeJobRepository.FindRunning(ctx)
+	if err != nil {
+		impl.logger.Errorw("error in fetching running failure recompute jobs", "err", err)
+		return nil, err
+	}
+	if len(running) > 0 {
+		return nil, apperror.Conflictf("failure recompute job %d is still running", running[0].Id)
+	}
+	policy, err := impl.GetPolicy(ctx, request.EnvironmentId)
+	if err != nil {
+		return nil, err
+	}
+	job := &sql.FailureRecomputeJob{
+		EnvironmentId:        request.EnvironmentId,
+		FailurePolicyId:      policy.Id,
+		FailurePolicyVersion: policy.Version,
+		Status:               sql.RecomputeRunning,
+		RequestedBy:          request.RequestedBy,
+		CreatedOn:            time.Now(),
+	}
+	err = impl.recomputeJobRepository.Save(ctx, job)
+	if err == sql.ErrFailureRecomputeRunning {
+		// another job was started since the check above
+		return nil, apperror.Conflictf("%v", err)
+	} else if err != nil {
+		impl.logger.Errorw("error in saving failure recompute job", "request", request, "err", err)
+		return nil, err
+	}
+	impl.logger.Infow("failure recompute started", "job", job.Id, "environmentId", job.EnvironmentId, "policyVersion", policy.Version)
+	jobCopy := *job
+	impl.wg.Add(1)
+	go func() {
+		defer impl.wg.Done()
+		impl.finishRecompute(&jobCopy, impl.recompute(impl.ctx, &jobCopy, policy))
+	}()
+	return job, nil
+}
+
+func (impl *FailurePolicyServiceImpl) GetRecomputeJob(ctx context.Context, id int) (*sql.FailureRecomputeJob, error) {
+	return impl.recomputeJobRepository.FindById(ctx, id)
+}
+
+func (impl *FailurePolicyServiceImpl) recompute(ctx context.Context, job *sql.FailureRecomputeJob, policy *sql.FailurePolicy) error {
+	environmentId := job.EnvironmentId
+	var environmentIds, excludedEnvironmentIds []int
+	if policy.EnvironmentId == environmentId && environmentId != sql.DefaultEnvironmentId {
+		environmentIds = []int{environmentId}
+	} else {
+		// the default policy covers every environment without its own
+		policies, err := impl.failurePolicyRepository.FindAll(ctx)
+		if err != nil {
+			impl.logger.Errorw("error in fetching failure policies", "err", err)
+			return err
+		}
+		for _, other := range policies {
+			if other.EnvironmentId != sql.DefaultEnvironmentId {
+				excludedEnvironmentIds = append(excludedEnvironmentIds, other.EnvironmentId)
+			}
+		}
+		if environmentId != sql.DefaultEnvironmentId {
+			environmentIds = []int{environmentId}
+		}
+	}
+	appEnvironments, err := impl.appReleaseRepository.FindAppEnvironmentsWithOtherFailurePolicy(ctx, environmentIds, excludedEnvironmentIds, policy.Id, policy.Version)
+	if err != nil {
+		impl.logger.Errorw("error in fetching app environments to recompute", "environmentId", environmentId, "err", err)
+		return err
+	}
+	for _, appEnvironment := range appEnvironments {
+		releases, changed, err := impl.recomputeAppEnvironment(ctx, policy, appEnvironment)
+		if err != nil {
+			// app environments done so far keep their new classification, running again picks up the rest
+			return err
+		}
+		job.AppEnvironmentCount++
+		job.ReleaseCount += releases
+		job.ChangedCount += changed
+		if err = impl.recomputeJobRepository.Update(ctx, job); err != nil {
+			impl.logger.Errorw("error in updating failure recompute job progress", "job", job.Id, "err", err)
+		}
+	}
+	return nil
+}
+
+// finishRecompute records the outcome of a job
+func (impl *FailurePolicyServiceImpl) finishRecompute(job *sql.FailureRecomputeJob, err error) {
+	// the job outcome is recorded even when the service is stopping
+	ctx := context.Background()
+	job.FinishedOn = time.Now()
+	job.Status = sql.RecomputeCompleted
+	if err != nil {
+		impl.logger.Errorw("failure recompute failed", "job", job.Id, "err", err)
+		job.Status = sql.RecomputeFailed
+		job.Error = err.Error()
+	} else {
+		impl.logger.Infow("failure classification recomputed", "job", job.Id, "environmentId", job.EnvironmentId,
+			"appEnvironments", job.AppEnvironmentCount, "releases", job.ReleaseCount, "changed", job.ChangedCount)
+	}
+	if updateErr := impl.recomputeJobRepository.Update(ctx, job); updateErr != nil {
+		impl.logger.Errorw("error in updating failure recompute job", "job", job.Id, "err", updateErr)
+	}
+}
+
+func (impl *FailurePolicyServiceImpl) Start() {
+	jobs, err := impl.recomputeJobRepository.FindRunning(impl.ctx)
+	if err != nil {
+		impl.logger.Errorw("error in fetching interrupted failure recompute jobs", "err", err)
+		return
+	}
+	for _, job := range jobs {
+		impl.finishRecompute(job, fmt.Errorf("interrupted by a restart"))
+	}
+}
+
+func (impl *FailurePolicyServiceImpl) Stop() {
+	impl.cancel()
+	impl.wg.Wait()
+}
+
+// recomputeAppEnvironment classifies the releases of an app environment while they are locked, so that a release
+// failed or added by ingestion meanwhile is either seen or updated after the recompute
+func (impl *FailurePolicyServiceImpl) recomputeAppEnvironment(ctx context.Context, policy *sql.FailurePolicy, appEnvironment sql.AppEnvironment) (releases int, changed int, err error) {
+	cause := fmt.Sprintf("failure policy %d version %d applied", policy.Id, policy.Version)
+	err = impl.appReleaseRepository.ReclassifyAppEnvironment(ctx, appEnvironment.AppId, appEnvironment.EnvironmentId, cause, func(appReleases []*sql.AppRelease) error {
+		ids := make([]int, 0, len(appReleases))
+		for _, appRelease := range appReleases {
+			ids = append(ids, appRelease.Id)
+		}
+		releaseTags, err := impl.releaseTagRepository.FindByAppReleaseIds(ctx, ids)
+		if err != nil {
+			impl.logger.Errorw("error in fetching release tags", "appEnvironment", appEnvironment, "err", err)
+			return err
+		}
+		statusEvents, err := impl.releaseStatusEventRepository.FindByAppReleaseIds(ctx, ids)
+		if err != nil {
+			impl.logger.Errorw("error in fetching release status events", "appEnvironment", appEnvironment, "err", err)
+			return err
+		}
+		overrides, err := impl.releaseOverrideRepository.FindByAppEnvironment(ctx, appEnvironment.AppId, appEnvironment.EnvironmentId)
+		if err != nil {
+			impl.logger.Errorw("error in fetching release overrides", "appEnvironment", appEnvironment, "err", err)
+			return err
+		}
+		overrideByTrigger := make(map[int]*sql.ReleaseOverride, len(overrides))
+		for _, override := range overrides {
+			overrideByTrigger[override.PipelineOverrideId] = override
+		}
+		type classification struct {
+			releaseStatus sql.ReleaseStatus
+			releaseType   sql.ReleaseType
+		}
+		before := make([]classification, len(appReleases))
+		for i, appRelease := range appReleases {
+			before[i] = classification{appRelease.ReleaseStatus, appRelease.ReleaseType}
+		}
+		classifyFailures(policy, appReleases, groupTagsByRelease(releaseTags), groupStatusEventsByRelease(statusEvents))
+		now := time.Now()
+		releases, changed = len(appReleases), 0
+		for i, appRelease := range appReleases {
+			if override, ok := overrideByTrigger[appRelease.PipelineOverrideId]; ok {
+				applyReleaseOverride(appRelease, override)
+			}
+			appRelease.UpdatedTime = now
+			if before[i] != (classification{appRelease.ReleaseStatus, appRelease.ReleaseType}) {
+				changed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return releases, changed, nil
+}
+
+// classifyFailures sets status, failure reason and policy of the releases of an app environment, oldest first,
+// from scratch. Patch is derived from the redeploy rule and given again.
+func classifyFailures(policy *sql.FailurePolicy, appReleases []*sql.AppRelease, tagsByRelease map[int]map[string]string, statusEventsByRelease map[int][]*sql.ReleaseStatusEvent) {
+	for _, appRelease := range appReleases {
+		if appRelease.ReleaseType == sql.Patch {
+			appRelease.ReleaseType = sql.RollForward
+		}
+		appRelease.ReleaseStatus = sql.Success
+		appRelease.FailureReason = ""
+		appRelease.FailurePolicyId = policy.Id
+		appRelease.FailurePolicyVersion = policy.Version
+	}
+	for i, appRelease := range appReleases {
+		var next *sql.AppRelease
+		if i+1 < len(appReleases) {
+			next = appReleases[i+1]
+		}
+		if reason := policy.FailureReason(appRelease, next, tagsByRelease[appRelease.Id], statusEventsByRelease[appRelease.Id]); reason != "" {
+			appRelease.ReleaseStatus = sql.Failure
+			appRelease.FailureReason = reason
+		}
+		if next != nil && next.ReleaseType == sql.RollForward && policy.ReplacedWithin(appRelease, next) {
+			next.ReleaseType = sql.Patch
+		}
+	}
+}
+
+func groupStatusEventsByRelease(statusEvents []*sql.ReleaseStatusEvent) map[int][]*sql.ReleaseStatusEvent {
+	statusEventsByRelease := make(map[int][]*sql.ReleaseStatusEvent)
+	for _, statusEvent := range statusEvents {
+		statusEventsByRelease[statusEvent.AppReleaseId] = append(statusEventsByRelease[statusEvent.AppReleaseId], statusEvent)
+	}
+	return statusEventsByRelease
+}
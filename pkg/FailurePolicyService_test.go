@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/pkg/apperror"
+	"go.uber.org/zap"
+)
+
+func TestClassifyFailures(t *testing.T) {
+	now := time.Now()
+	newReleases := func() []*sql.AppRelease {
+		return []*sql.AppRelease{
+			{Id: 1, TriggerTime: now.Add(-10 * time.Hour), ReleaseType: sql.RollForward},
+			{Id: 2, TriggerTime: now.Add(-9 * time.Hour), ReleaseType: sql.Patch, ReleaseStatus: sql.Failure},
+			{Id: 3, TriggerTime: now.Add(-5 * time.Hour), ReleaseType: sql.RollForward},
+			{Id: 4, TriggerTime: now.Add(-4 * time.Hour), ReleaseType: sql.RollBack, RolledBackReleaseId: 3},
+			{Id: 5, TriggerTime: now.Add(-2 * time.Hour), ReleaseType: sql.RollForward},
+		}
+	}
+	tagsByRelease := map[int]map[string]string{5: {"incident": "INC-1"}}
+	statusEventsByRelease := map[int][]*sql.ReleaseStatusEvent{
+		1: {{HealthStatus: sql.HealthDegraded, EventTime: now.Add(-7 * time.Hour)}},
+		4: {{CdStatus: sql.CdStatusFailedValue, EventTime: now.Add(-4 * time.Hour)}},
+	}
+	tests := []struct {
+		name        string
+		rules       []sql.FailureRule
+		wantReasons []sql.FailureRuleType
+		wantTypes   []sql.ReleaseType
+	}{
+		{
+			name:        "built-in policy",
+			rules:       sql.DefaultFailurePolicy().Rules,
+			wantReasons: []sql.FailureRuleType{sql.RedeployWithin, "", sql.FollowedByRollback, "", ""},
+			wantTypes:   []sql.ReleaseType{sql.RollForward, sql.Patch, sql.RollForward, sql.RollBack, sql.RollForward},
+		},
+		{
+			name: "status and incident rules",
+			rules: []sql.FailureRule{
+				{Type: sql.CdStatusFailed},
+				{Type: sql.HealthDegradedWithin, WindowMins: 120},
+				{Type: sql.LinkedIncident},
+			},
+			// release 1 degraded 3 hours after its trigger, out of the window
+			wantReasons: []sql.FailureRuleType{"", "", "", sql.CdStatusFailed, sql.LinkedIncident},
+			wantTypes:   []sql.ReleaseType{sql.RollForward, sql.RollForward, sql.RollForward, sql.RollBack, sql.RollForward},
+		},
+		{
+			name:        "no rules",
+			wantReasons: []sql.FailureRuleType{"", "", "", "", ""},
+			wantTypes:   []sql.ReleaseType{sql.RollForward, sql.RollForward, sql.RollForward, sql.RollBack, sql.RollForward},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &sql.FailurePolicy{Id: 7, Version: 3, Rules: tt.rules}
+			appReleases := newReleases()
+			classifyFailures(policy, appReleases, tagsByRelease, statusEventsByRelease)
+			for i, appRelease := range appReleases {
+				wantStatus := sql.Success
+				if tt.wantReasons[i] != "" {
+					wantStatus = sql.Failure
+				}
+				if appRelease.FailureReason != tt.wantReasons[i] || appRelease.ReleaseStatus != wantStatus || appRelease.ReleaseType != tt.wantTypes[i] {
+					t.Errorf("release %d = %s %s %q, want %s %s %q", appRelease.Id, appRelease.ReleaseType, appRelease.ReleaseStatus, appRelease.FailureReason,
+						tt.wantTypes[i], wantStatus, tt.wantReasons[i])
+				}
+				if appRelease.FailurePolicyId != 7 || appRelease.FailurePolicyVersion != 3 {
+					t.Errorf("release %d classified with policy %d version %d", appRelease.Id, appRelease.FailurePolicyId, appRelease.FailurePolicyVersion)
+				}
+			}
+		})
+	}
+}
+
+type singleFailurePolicyRepository struct {
+	sql.FailurePolicyRepository
+	policy *sql.FailurePolicy
+}
+
+func (repo *singleFailurePolicyRepository) FindByEnvironmentId(ctx context.Context, environmentId int) (*sql.FailurePolicy, error) {
+	return repo.policy, nil
+}
+
+type recomputedReleaseRepository struct {
+	sql.AppReleaseRepository
+	releases []*sql.AppRelease
+	updated  []*sql.AppRelease
+}
+
+func (repo *recomputedReleaseRepository) FindAppEnvironmentsWithOtherFailurePolicy(ctx context.Context, environmentIds []int, excludedEnvironmentIds []int, failurePolicyId, version int) ([]sql.AppEnvironment, error) {
+	return []sql.AppEnvironment{{AppId: 1, EnvironmentId: 1}}, nil
+}
+
+func (repo *recomputedReleaseRepository) ReclassifyAppEnvironment(ctx context.Context, appId, environmentId int, cause string, classify func(appReleases []*sql.AppRelease) error) error {
+	if err := classify(repo.releases); err != nil {
+		return err
+	}
+	repo.updated = repo.releases
+	return nil
+}
+
+// appEnvironmentOverrideRepository only serves overrides by app environment, a lookup per trigger panics
+type appEnvironmentOverrideRepository struct {
+	sql.ReleaseOverrideRepository
+	overrides []*sql.ReleaseOverride
+	lookups   int
+}
+
+func (repo *appEnvironmentOverrideRepository) FindByAppEnvironment(ctx context.Context, appId, environmentId int) ([]*sql.ReleaseOverride, error) {
+	repo.lookups++
+	return repo.overrides, nil
+}
+
+type emptyReleaseTagRepository struct {
+	sql.ReleaseTagRepository
+}
+
+func (emptyReleaseTagRepository) FindByAppReleaseIds(ctx context.Context, appReleaseIds []int) ([]*sql.ReleaseTag, error) {
+	return nil, nil
+}
+
+type emptyReleaseStatusEventRepository struct {
+	sql.ReleaseStatusEventRepository
+}
+
+func (emptyReleaseStatusEventRepository) FindByAppReleaseIds(ctx context.Context, appReleaseIds []int) ([]*sql.ReleaseStatusEvent, error) {
+	return nil, nil
+}
+
+type memoryFailureRecomputeJobRepository struct {
+	sql.FailureRecomputeJobRepository
+	jobs []sql.FailureRecomputeJob
+}
+
+func (repo *memoryFailureRecomputeJobRepository) Save(ctx context.Context, job *sql.FailureRecomputeJob) error {
+	job.Id = len(repo.jobs) + 1
+	repo.jobs = append(repo.jobs, *job)
+	return nil
+}
+
+func (repo *memoryFailureRecomputeJobRepository) Update(ctx context.Context, job *sql.FailureRecomputeJob) error {
+	repo.jobs[job.Id-1] = *job
+	return nil
+}
+
+func (repo *memoryFailureRecomputeJobRepository) FindRunning(ctx context.Context) ([]*sql.FailureRecomputeJob, error) {
+	var running []*sql.FailureRecomputeJob
+	for _, job := range repo.jobs {
+		if job.Status == sql.RecomputeRunning {
+			job := job
+			running = append(running, &job)
+		}
+	}
+	return running, nil
+}
+
+func TestFailurePolicyService_Recompute(t *testing.T) {
+	now := time.Now()
+	policy := &sql.FailurePolicy{Id: 3, Version: 2, EnvironmentId: 1, Rules: []sql.FailureRule{{Type: sql.RedeployWithin, WindowMins: 60}}}
+	releases := &recomputedReleaseRepository{releases: []*sql.AppRelease{
+		{Id: 1, PipelineOverrideId: 11, TriggerTime: now.Add(-3 * time.Hour), ReleaseType: sql.RollForward},
+		{Id: 2, PipelineOverrideId: 12, TriggerTime: now.Add(-150 * time.Minute), ReleaseType: sql.RollForward},
+		{Id: 3, PipelineOverrideId: 13, TriggerTime: now, ReleaseType: sql.RollForward},
+	}}
+	success := sql.Success
+	overrides := &appEnvironmentOverrideRepository{overrides: []*sql.ReleaseOverride{{PipelineOverrideId: 11, ReleaseStatus: &success}}}
+	jobs := &memoryFailureRecomputeJobRepository{}
+	impl := NewFailurePolicyServiceImpl(zap.NewNop().Sugar(), &singleFailurePolicyRepository{policy: policy}, releases, overrides,
+		emptyReleaseTagRepository{}, emptyReleaseStatusEventRepository{}, jobs)
+
+	job, err := impl.Recompute(context.Background(), &FailureRecomputeRequest{EnvironmentId: 1, RequestedBy: "jane"})
+	if err != nil || job.Status != sql.RecomputeRunning || job.FailurePolicyVersion != 2 {
+		t.Fatalf("Recompute() = %+v, %v, want a running job of policy version 2", job, err)
+	}
+	impl.wg.Wait()
+
+	done := jobs.jobs[job.Id-1]
+	if done.Status != sql.RecomputeCompleted || done.AppEnvironmentCount != 1 || done.ReleaseCount != 3 || done.ChangedCount != 1 {
+		t.Errorf("finished job = %+v, want completed with 1 app environment, 3 releases and 1 changed", done)
+	}
+	if overrides.lookups != 1 {
+		t.Errorf("override lookups = %d, want one per app environment", overrides.lookups)
+	}
+	updated := releases.updated
+	if len(updated) != 3 || updated[0].ReleaseStatus != sql.Success || updated[1].ReleaseType != sql.Patch || updated[2].FailurePolicyVersion != 2 {
+		t.Errorf("recomputed releases = %+v %+v %+v, want the override kept and the redeploy a patch", updated[0], updated[1], updated[2])
+	}
+}
+
+// racingFailureRecomputeJobRepository misses the running job when checked, as when two requests race
+type racingFailureRecomputeJobRepository struct {
+	memoryFailureRecomputeJobRepository
+}
+
+func (repo *racingFailureRecomputeJobRepository) FindRunning(ctx context.Context) ([]*sql.FailureRecomputeJob, error) {
+	return nil, nil
+}
+
+func (repo *racingFailureRecomputeJobRepository) Save(ctx context.Context, job *sql.FailureRecomputeJob) error {
+	return sql.ErrFailureRecomputeRunning
+}
+
+func TestFailurePolicyService_RecomputeRace(t *testing.T) {
+	policy := &sql.FailurePolicy{Id: 3, Version: 2, EnvironmentId: 1}
+	impl := NewFailurePolicyServiceImpl(zap.NewNop().Sugar(), &singleFailurePolicyRepository{policy: policy}, nil, nil, nil, nil,
+		&racingFailureRecomputeJobRepository{})
+	_, err := impl.Recompute(context.Background(), &FailureRecomputeRequest{EnvironmentId: 1, RequestedBy: "jane"})
+	if apperror.KindOf(err) != apperror.Conflict {
+		t.Errorf("Recompute() err = %v, want a conflict", err)
+	}
+}
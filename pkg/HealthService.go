@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	pubsub "github.com/devtron-labs/common-lib/pubsub-lib"
+	"github.com/devtron-labs/lens/client/gitSensor"
+	"github.com/devtron-labs/lens/internal/sql"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+const (
+	DependencyDb        = "postgres"
+	DependencyNats      = "nats"
+	DependencyGitSensor = "git-sensor"
+
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusReady    = "ready"
+	StatusDegraded = "degraded"
+	StatusNotReady = "not-ready"
+)
+
+type HealthConfig struct {
+	// OptionalDependencies are reported but do not fail readiness
+	OptionalDependencies []string `env:"READINESS_OPTIONAL_DEPENDENCIES" envSeparator:","`
+	CheckTimeoutSecs     int      `env:"READINESS_CHECK_TIMEOUT_SECS" envDefault:"3"`
+}
+
+func GetHealthConfig() (*HealthConfig, error) {
+	cfg := &HealthConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+type DependencyStatus struct {
+	Status    string `json:"status"`
+	Optional  bool   `json:"optional"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+type ReadinessReport struct {
+	Status       string                       `json:"status"`
+	Dependencies map[string]*DependencyStatus `json:"dependencies"`
+}
+
+// Ready is false only when a required dependency is down
+func (report *ReadinessReport) Ready() bool {
+	return report.Status != StatusNotReady
+}
+
+type dependencyCheck func(ctx context.Context) error
+
+type HealthService interface {
+	Readiness(ctx context.Context) *ReadinessReport
+}
+
+type HealthServiceImpl struct {
+	logger   *zap.SugaredLogger
+	config   *HealthConfig
+	checks   map[string]dependencyCheck
+	optional map[string]bool
+}
+
+func NewHealthServiceImpl(logger *zap.SugaredLogger,
+	config *HealthConfig,
+	db *pg.DB,
+	pubSubClient *pubsub.PubSubClientServiceImpl,
+	gitChangesProvider gitSensor.GitChangesProvider) *HealthServiceImpl {
+	checks := map[string]dependencyCheck{
+		DependencyDb: func(ctx context.Context) error {
+			return sql.PingDb(ctx, db)
+		},
+		DependencyNats: func(ctx context.Context) error {
+			if pubSubClient.NatsClient == nil || !pubSubClient.NatsClient.Conn.IsConnected() {
+				return fmt.Errorf("nats not connected")
+			}
+			return nil
+		},
+		DependencyGitSensor: gitChangesProvider.Ping,
+	}
+	return newHealthServiceImpl(logger, config, checks)
+}
+
+func newHealthServiceImpl(logger *zap.SugaredLogger, config *HealthConfig, checks map[string]dependencyCheck) *HealthServiceImpl {
+	optional := make(map[string]bool)
+	for _, name := range config.OptionalDependencies {
+		if _, ok := checks[name]; !ok {
+			logger.Warnw("unknown optional readiness dependency", "dependency", name)
+		}
+		optional[name] = true
+	}
+	return &HealthServiceImpl{logger: logger, config: config, checks: checks, optional: optional}
+}
+
+// Readiness checks all dependencies in parallel, each within the check timeout
+func (impl *HealthServiceImpl) Readiness(ctx context.Context) *ReadinessReport {
+	report := &ReadinessReport{Status: StatusReady, Dependencies: make(map[string]*DependencyStatus, len(impl.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range impl.checks {
+		wg.Add(1)
+		go func(name string, check dependencyCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, time.Duration(impl.config.CheckTimeoutSecs)*time.Second)
+			defer cancel()
+			start := time.Now()
+			err := check(checkCtx)
+			status := &DependencyStatus{Status: StatusUp, Optional: impl.optional[name], LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				status.Status = StatusDown
+				status.Error = err.Error()
+				impl.logger.Warnw("readiness check failed", "dependency", name, "optional", status.Optional, "err", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Dependencies[name] = status
+		}(name, check)
+	}
+	wg.Wait()
+	for _, status := range report.Dependencies {
+		if status.Status == StatusUp {
+			continue
+		}
+		if !status.Optional {
+			report.Status = StatusNotReady
+			break
+		}
+		report.Status = StatusDegraded
+	}
+	return report
+}
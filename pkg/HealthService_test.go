@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestHealthServiceImpl_Readiness(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("unreachable") }
+	tests := []struct {
+		name     string
+		optional []string
+		checks   map[string]dependencyCheck
+		want     string
+	}{
+		{"all up", nil, map[string]dependencyCheck{DependencyDb: up, DependencyNats: up, DependencyGitSensor: up}, StatusReady},
+		{"required down", nil, map[string]dependencyCheck{DependencyDb: down, DependencyNats: up, DependencyGitSensor: up}, StatusNotReady},
+		{"optional down", []string{DependencyGitSensor}, map[string]dependencyCheck{DependencyDb: up, DependencyNats: up, DependencyGitSensor: down}, StatusDegraded},
+		{"optional and required down", []string{DependencyGitSensor}, map[string]dependencyCheck{DependencyDb: up, DependencyNats: down, DependencyGitSensor: down}, StatusNotReady},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			impl := newHealthServiceImpl(zap.NewNop().Sugar(), &HealthConfig{OptionalDependencies: tt.optional, CheckTimeoutSecs: 1}, tt.checks)
+			report := impl.Readiness(context.Background())
+			if report.Status != tt.want {
+				t.Errorf("Readiness() status = %s, want %s", report.Status, tt.want)
+			}
+			if len(report.Dependencies) != len(tt.checks) {
+				t.Errorf("Readiness() dependencies = %d, want %d", len(report.Dependencies), len(tt.checks))
+			}
+			for name, status := range report.Dependencies {
+				if (status.Status == StatusDown) != (status.Error != "") {
+					t.Errorf("Readiness() %s = %+v, error must be set only when down", name, status)
+				}
+			}
+		})
+	}
+}
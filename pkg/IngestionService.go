@@ -18,55 +18,90 @@ package pkg
 
 import (
 	"context"
+	"fmt"
 	"github.com/caarlos0/env"
-	"github.com/devtron-labs/lens/bean"
-	pb "github.com/devtron-labs/protos/gitSensor"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/devtron-labs/lens/client/gitSensor"
+	"github.com/devtron-labs/lens/internal/metrics"
 	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/internal/tracing"
+	"github.com/devtron-labs/lens/pkg/apperror"
 	pg "github.com/go-pg/pg/v10"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
+type IngestionConfig struct {
+	// TimeoutSecs bounds the processing of a single deployment event, git-sensor calls included
+	TimeoutSecs int `env:"INGESTION_TIMEOUT_SECS" envDefault:"120"`
+	// GitFetchParallelism bounds the pipeline materials of a release whose changes are fetched concurrently
+	GitFetchParallelism int `env:"GIT_CHANGES_FETCH_PARALLELISM" envDefault:"4"`
+}
+
+func GetIngestionConfig() (*IngestionConfig, error) {
+	cfg := &IngestionConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
 type IngestionService interface {
-	ProcessDeploymentEvent(deploymentEvent *DeploymentEvent) (*sql.AppRelease, error)
-	CleanAppDataForEnvironment(appId, environmentId int) (bool, error)
+	// ProcessDeploymentEvent ingests the release of a deployment event, a redelivered event resumes the release saved
+	// by its earlier delivery
+	ProcessDeploymentEvent(ctx context.Context, deploymentEvent *DeploymentEvent) (*sql.AppRelease, error)
+	// ReprocessRelease resumes the ingestion of a release left unprocessed, e.g. while git-sensor was unavailable
+	ReprocessRelease(ctx context.Context, appRelease *sql.AppRelease) (*sql.AppRelease, error)
+	// ProcessStatusEvent records a deployment status or app health of a release and fails the release when the
+	// failure policy of its environment says so, pg.ErrNoRows until the release itself is ingested
+	ProcessStatusEvent(ctx context.Context, statusEvent *DeploymentStatusEvent) (*sql.AppRelease, error)
 }
 type IngestionServiceImpl struct {
-	logger                     *zap.SugaredLogger
-	appReleaseRepository       sql.AppReleaseRepository
-	PipelineMaterialRepository sql.PipelineMaterialRepository
-	leadTimeRepository         sql.LeadTimeRepository
-	gitSensorRestClient        gitSensor.GitSensorClient
-	gitSensorGrpcClient        gitSensor.GitSensorGrpcClient
-	isGitSensorGrpcConfigured  bool
+	logger                        *zap.SugaredLogger
+	config                        *IngestionConfig
+	appReleaseRepository          sql.AppReleaseRepository
+	PipelineMaterialRepository    sql.PipelineMaterialRepository
+	leadTimeRepository            sql.LeadTimeRepository
+	gitChangesProvider            gitSensor.GitChangesProvider
+	releaseOverrideRepository     sql.ReleaseOverrideRepository
+	releaseTagRepository          sql.ReleaseTagRepository
+	releaseAnnotationRepository   sql.ReleaseAnnotationRepository
+	releaseStatusEventRepository  sql.ReleaseStatusEventRepository
+	failurePolicyService          FailurePolicyService
+	releaseStreamService          ReleaseStreamService
+	releaseStageHistoryRepository sql.ReleaseStageHistoryRepository
 }
 
 func NewIngestionServiceImpl(logger *zap.SugaredLogger,
+	config *IngestionConfig,
 	appReleaseRepository sql.AppReleaseRepository,
 	PipelineMaterialRepository sql.PipelineMaterialRepository,
 	leadTimeRepository sql.LeadTimeRepository,
-	gitSensorRestClient gitSensor.GitSensorClient,
-	gitSensorGrpcClient gitSensor.GitSensorGrpcClient) *IngestionServiceImpl {
+	gitChangesCache gitSensor.GitChangesCache,
+	releaseOverrideRepository sql.ReleaseOverrideRepository,
+	releaseTagRepository sql.ReleaseTagRepository,
+	releaseAnnotationRepository sql.ReleaseAnnotationRepository,
+	releaseStatusEventRepository sql.ReleaseStatusEventRepository,
+	failurePolicyService FailurePolicyService,
+	releaseStreamService ReleaseStreamService,
+	releaseStageHistoryRepository sql.ReleaseStageHistoryRepository) *IngestionServiceImpl {
 
-	ingestionService := &IngestionServiceImpl{
-		logger:                     logger,
-		appReleaseRepository:       appReleaseRepository,
-		PipelineMaterialRepository: PipelineMaterialRepository,
-		leadTimeRepository:         leadTimeRepository,
-		gitSensorRestClient:        gitSensorRestClient,
-		gitSensorGrpcClient:        gitSensorGrpcClient,
+	return &IngestionServiceImpl{
+		logger:                        logger,
+		config:                        config,
+		appReleaseRepository:          appReleaseRepository,
+		PipelineMaterialRepository:    PipelineMaterialRepository,
+		leadTimeRepository:            leadTimeRepository,
+		gitChangesProvider:            gitChangesCache,
+		releaseOverrideRepository:     releaseOverrideRepository,
+		releaseTagRepository:          releaseTagRepository,
+		releaseAnnotationRepository:   releaseAnnotationRepository,
+		releaseStatusEventRepository:  releaseStatusEventRepository,
+		failurePolicyService:          failurePolicyService,
+		releaseStreamService:          releaseStreamService,
+		releaseStageHistoryRepository: releaseStageHistoryRepository,
 	}
-
-	gitSensorProtocolConfig := bean.GitSensorProtocolConfig{}
-	_ = env.Parse(&gitSensorProtocolConfig)
-	if gitSensorProtocolConfig.Protocol == "GRPC" {
-		ingestionService.isGitSensorGrpcConfigured = true
-		logger.Infow("gRPC protocol configured for git sensor")
-	}
-
-	return ingestionService
 }
 
 type DeploymentEvent struct {
@@ -77,55 +112,258 @@ type DeploymentEvent struct {
 	TriggerTime        time.Time
 	PipelineMaterials  []*PipelineMaterialInfo
 	CiArtifactId       int
+	Tags               map[string]string // optional, e.g. change-ticket=CHG-9
+	Annotations        []string          // optional free-form notes
+	// TraceContext carries w3c traceparent and tracestate of the orchestrator, pubsub does not hand nats headers to consumers
+	TraceContext map[string]string `json:",omitempty"`
 }
+
+// deploymentEventAuthor is recorded as creator of annotations received with a deployment event
+const deploymentEventAuthor = "deployment-event"
+
 type PipelineMaterialInfo struct {
 	PipelineMaterialId int
 	CommitHash         string
 }
 
-// 1.save AppRelease
+// DeploymentStatusEvent reports how a release went after its trigger, identified like its deployment event
+type DeploymentStatusEvent struct {
+	ApplicationId      int
+	EnvironmentId      int
+	PipelineOverrideId int
+	CdStatus           string    // e.g. Succeeded, Failed
+	HealthStatus       string    // e.g. Healthy, Degraded
+	EventTime          time.Time // time of the report, receipt when not set
+}
+
+// 1.save AppRelease, or resume the one of a redelivered event
 // 2. save PipelineMaterial with release status
 // 4. check for first commit and rollback
 // 5. fetch changes from git
 // 6. save LeadTime and commit size
-func (impl *IngestionServiceImpl) ProcessDeploymentEvent(deploymentEvent *DeploymentEvent) (*sql.AppRelease, error) {
+func (impl *IngestionServiceImpl) ProcessDeploymentEvent(ctx context.Context, deploymentEvent *DeploymentEvent) (appRelease *sql.AppRelease, err error) {
 	impl.logger.Infow("processing release trigger", "request", deploymentEvent)
-	appRelease, err := impl.saveAppRelease(deploymentEvent)
+	if impl.config.TimeoutSecs > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(impl.config.TimeoutSecs)*time.Second)
+		defer cancel()
+	}
+	ctx, span := tracing.StartSpan(ctx, "ingestion.process-deployment-event",
+		attribute.Int("lens.app_id", deploymentEvent.ApplicationId),
+		attribute.Int("lens.env_id", deploymentEvent.EnvironmentId),
+		attribute.Int("lens.ci_artifact_id", deploymentEvent.CiArtifactId))
+	duplicate := false
+	defer func() {
+		// releases rebuilt by a recompute were counted when first ingested
+		if appRelease != nil && err == nil && !duplicate && sql.RecomputeScopeFrom(ctx) == nil {
+			metrics.ReleaseClassified(appRelease.ReleaseType.String(), appRelease.ReleaseStatus.String())
+		}
+		if appRelease != nil {
+			span.SetAttributes(attribute.Int("lens.app_release_id", appRelease.Id), attribute.String("lens.release_type", appRelease.ReleaseType.String()))
+		}
+		tracing.EndSpan(span, err)
+	}()
+	var policy *sql.FailurePolicy
+	resumed := false
+	err = impl.runStage(ctx, stageSaveRelease, func(ctx context.Context) error {
+		policy, err = impl.failurePolicyService.GetPolicy(ctx, deploymentEvent.EnvironmentId)
+		if err != nil {
+			return err
+		}
+		appRelease, resumed, err = impl.saveAppRelease(ctx, deploymentEvent, policy)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if appRelease.ProcessStage == sql.Processed {
+		// a redelivered event, the release was counted when first processed
+		impl.logger.Infow("release of deployment event already processed", "appRelease", appRelease.Id)
+		duplicate = true
+		return appRelease, nil
+	}
+	var materials []*sql.PipelineMaterial
+	err = impl.runReleaseStage(ctx, stageSaveMaterials, appRelease, func(ctx context.Context) error {
+		materials, err = impl.savePipelineMaterial(ctx, deploymentEvent, appRelease, resumed)
+		if err != nil {
+			return err
+		}
+		return impl.saveTagsAndAnnotations(ctx, deploymentEvent, appRelease, resumed)
+	})
 	if err != nil {
 		return nil, err
 	}
-	materials, err := impl.savePipelineMaterial(deploymentEvent, appRelease)
+	return impl.processRelease(ctx, appRelease, materials, policy)
+}
+
+// ReprocessRelease resumes the ingestion of a release from the stage it stopped at, with its saved materials
+func (impl *IngestionServiceImpl) ReprocessRelease(ctx context.Context, appRelease *sql.AppRelease) (_ *sql.AppRelease, err error) {
+	impl.logger.Infow("reprocessing release", "appRelease", appRelease.Id, "stage", appRelease.ProcessStage)
+	if impl.config.TimeoutSecs > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(impl.config.TimeoutSecs)*time.Second)
+		defer cancel()
+	}
+	ctx, span := tracing.StartSpan(ctx, "ingestion.reprocess-release", attribute.Int("lens.app_release_id", appRelease.Id))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+	policy, err := impl.failurePolicyService.GetPolicy(ctx, appRelease.EnvironmentId)
 	if err != nil {
 		return nil, err
 	}
-	//--------
-	appRelease, err = impl.checkAndUpdateReleaseType(appRelease)
+	materials, err := impl.PipelineMaterialRepository.FindByAppReleaseId(ctx, appRelease.Id)
 	if err != nil {
+		impl.logger.Errorw("error in fetching pipeline material", "appReleaseId", appRelease.Id, "err", err)
 		return nil, err
 	}
+	appRelease, err = impl.processRelease(ctx, appRelease, materials, policy)
+	if err == nil {
+		metrics.ReleaseClassified(appRelease.ReleaseType.String(), appRelease.ReleaseStatus.String())
+	}
+	return appRelease, err
+}
+
+// processRelease runs the stages following the save of a release and its materials, skipping those a previous
+// attempt completed. Failure and rollback rules are applied again, they leave releases they already updated unchanged.
+func (impl *IngestionServiceImpl) processRelease(ctx context.Context, appRelease *sql.AppRelease, materials []*sql.PipelineMaterial, policy *sql.FailurePolicy) (*sql.AppRelease, error) {
+	var err error
+	if appRelease.ProcessStage < sql.ReleaseTypeDetermined {
+		err = impl.runReleaseStage(ctx, stageReleaseType, appRelease, func(ctx context.Context) error {
+			appRelease, err = impl.checkAndUpdateReleaseType(ctx, appRelease, materials)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 	if appRelease.ReleaseType == sql.RollBack {
-		//no need to fetch git detail return
-		return appRelease, nil //FIXME
+		err = impl.runReleaseStage(ctx, stageRollback, appRelease, func(ctx context.Context) error {
+			return impl.recordRollback(ctx, appRelease, policy)
+		})
+		if err != nil {
+			return nil, err
+		}
+		// a rollback brings back changes already counted by its target, it has no lead time of its own
+		return impl.markProcessed(ctx, appRelease)
 	}
 	//mark previous pipeline fail
-	err = impl.markPreviousTriggerFail(appRelease)
+	err = impl.runReleaseStage(ctx, stageMarkPreviousFailed, appRelease, func(ctx context.Context) error {
+		return impl.markPreviousTriggerFail(ctx, appRelease, policy)
+	})
 	if err != nil && err != pg.ErrNoRows {
 		return nil, err
 	}
-
-	//TODO handle in separate worker/scheduler
-	err = impl.fetchAndSaveChangesFromGit(appRelease, materials)
+	if appRelease.ProcessStage >= sql.LeadTimeFetch {
+		return impl.markProcessed(ctx, appRelease)
+	}
+	err = impl.runReleaseStage(ctx, stageGitChanges, appRelease, func(ctx context.Context) error {
+		return impl.fetchAndSaveChangesFromGit(ctx, appRelease, materials)
+	})
+	if gitSensor.IsPermanent(err) {
+		// retrying would not help, the release is kept without lead time and change size
+		impl.logger.Errorw("changes of release can not be fetched, skipping lead time", "appRelease", appRelease.Id, "err", err)
+		return impl.markProcessed(ctx, appRelease)
+	}
 	if err != nil && err != pg.ErrNoRows {
+		if gitSensor.IsRetryLater(err) {
+			impl.logger.Warnw("git-sensor unavailable, changes of release to be fetched on redelivery or by the reprocess worker", "appRelease", appRelease.Id, "err", err)
+		}
+		return nil, err
+	}
+	return impl.markProcessed(ctx, appRelease)
+}
+
+// markProcessed completes ingestion of a release, the release-processed message is written in the same
+// transaction so that it is never published for an ingestion that failed
+func (impl *IngestionServiceImpl) markProcessed(ctx context.Context, appRelease *sql.AppRelease) (*sql.AppRelease, error) {
+	err := impl.runReleaseStage(ctx, stageMarkProcessed, appRelease, func(ctx context.Context) error {
+		now := time.Now()
+		var message *sql.ReleaseOutboxMessage
+		// releases rebuilt by a recompute were announced when first ingested
+		if sql.RecomputeScopeFrom(ctx) == nil {
+			var err error
+			message, err = newReleaseProcessedMessage(appRelease, now)
+			if err != nil {
+				impl.logger.Errorw("error in building release processed message", "appRelease", appRelease.Id, "err", err)
+				return err
+			}
+		}
+		appRelease.UpdatedTime = now
+		return impl.appReleaseRepository.MarkProcessed(ctx, appRelease, message)
+	})
+	if err != nil {
 		return nil, err
-	} else if err == pg.ErrNoRows {
-		return appRelease, nil
 	}
+	impl.streamRelease(ctx, ReleaseProcessed, appRelease)
 	return appRelease, nil
 }
 
-func (impl *IngestionServiceImpl) markPreviousTriggerFail(release *sql.AppRelease) error {
+// streamRelease sends a live release to the release stream, releases staged by a recompute are not live yet
+func (impl *IngestionServiceImpl) streamRelease(ctx context.Context, eventType string, appRelease *sql.AppRelease) {
+	if sql.RecomputeScopeFrom(ctx) == nil {
+		impl.releaseStreamService.Publish(eventType, appRelease)
+	}
+}
+
+// ingestion stages, used as span names
+const (
+	stageSaveRelease        = "save-release"
+	stageSaveMaterials      = "save-materials"
+	stageReleaseType        = "release-type"
+	stageMarkPreviousFailed = "mark-previous-failed"
+	stageRollback           = "rollback"
+	stageGitChanges         = "git-changes"
+	stageMarkProcessed      = "mark-processed"
+)
+
+// runReleaseStage runs a stage of an ingested release and records it in the history of the release if it fails
+func (impl *IngestionServiceImpl) runReleaseStage(ctx context.Context, stage string, appRelease *sql.AppRelease, fn func(ctx context.Context) error) error {
+	err := impl.runStage(ctx, stage, fn)
+	if err != nil && err != pg.ErrNoRows {
+		// recorded even when ingestion timed out, that is when it matters most
+		historyErr := impl.releaseStageHistoryRepository.Save(context.WithoutCancel(ctx), &sql.ReleaseStageHistory{
+			AppReleaseId: appRelease.Id,
+			Kind:         sql.HistoryStageFailed,
+			FromValue:    appRelease.ProcessStage.String(),
+			ToValue:      stage,
+			Cause:        err.Error(),
+			CreatedOn:    time.Now(),
+		})
+		if historyErr != nil {
+			impl.logger.Errorw("error in recording failed stage", "appRelease", appRelease.Id, "stage", stage, "err", historyErr)
+		}
+	}
+	return err
+}
+
+// runStage runs one ingestion stage in its own span and records its outcome, pg.ErrNoRows is an expected outcome of some stages
+func (impl *IngestionServiceImpl) runStage(ctx context.Context, stage string, fn func(ctx context.Context) error) error {
+	ctx, span := tracing.StartSpan(ctx, "ingestion."+stage)
+	start := time.Now()
+	err := fn(ctx)
+	switch err {
+	case nil:
+		metrics.StageDone(stage, metrics.OutcomeSuccess, time.Since(start))
+		tracing.EndSpan(span, nil)
+	case pg.ErrNoRows:
+		metrics.StageDone(stage, metrics.OutcomeSkipped, time.Since(start))
+		tracing.EndSpan(span, nil)
+	default:
+		metrics.StageDone(stage, metrics.OutcomeFailed, time.Since(start))
+		tracing.EndSpan(span, err)
+	}
+	return err
+}
+
+// markPreviousTriggerFail applies the redeploy rule of the failure policy, pg.ErrNoRows when it does not apply
+func (impl *IngestionServiceImpl) markPreviousTriggerFail(ctx context.Context, release *sql.AppRelease, policy *sql.FailurePolicy) error {
 	impl.logger.Infow("markPreviousTriggerFail", "release", release)
-	previousAppRelease, err := impl.appReleaseRepository.GetPreviousReleaseWithinTime(release.AppId, release.EnvironmentId, release.TriggerTime.Add(time.Hour*time.Duration(-2)), release.Id)
+	rule, ok := policy.Rule(sql.RedeployWithin)
+	if !ok {
+		return pg.ErrNoRows
+	}
+	previousAppRelease, err := impl.appReleaseRepository.GetPreviousReleaseWithinTime(ctx, release.AppId, release.EnvironmentId, release.TriggerTime.Add(-time.Duration(rule.WindowMins)*time.Minute), release.Id)
 	if err != nil && err != pg.ErrNoRows {
 		impl.logger.Errorw("error in getting previous release", "app", release.AppId, "err", err)
 		return err
@@ -134,37 +372,91 @@ func (impl *IngestionServiceImpl) markPreviousTriggerFail(release *sql.AppReleas
 	}
 	if previousAppRelease != nil {
 		impl.logger.Infow("pipeline failure detected", "PreviousappRelease", previousAppRelease)
+		if previousAppRelease.ReleaseStatus != sql.Failure {
+			previousAppRelease.FailureReason = sql.RedeployWithin
+			previousAppRelease.FailurePolicyId = policy.Id
+			previousAppRelease.FailurePolicyVersion = policy.Version
+		}
 		previousAppRelease.ReleaseStatus = sql.Failure
 		previousAppRelease.UpdatedTime = time.Now()
-		_, err = impl.appReleaseRepository.Update(previousAppRelease)
+		_, err = impl.updateAppRelease(ctx, previousAppRelease, fmt.Sprintf("redeployed within %d minutes by release %d", rule.WindowMins, release.Id))
 		if err != nil {
 			impl.logger.Errorw("error in updating pipeline status", "PreviousappRelease", previousAppRelease, "err", err)
 			return err
 		}
-		//mark this release as patch
-		release.ReleaseType = sql.Patch
-		release.UpdatedTime = time.Now()
-		_, err = impl.appReleaseRepository.Update(release)
-		if err != nil {
-			impl.logger.Errorw("error in updating  patch status", "release", release, "err", err)
-			return err
+		//mark this release as patch, a config only fix keeps its type
+		if release.ReleaseType == sql.RollForward {
+			release.ReleaseType = sql.Patch
+			release.UpdatedTime = time.Now()
+			_, err = impl.updateAppRelease(ctx, release, fmt.Sprintf("redeploy of failed release %d", previousAppRelease.Id))
+			if err != nil {
+				impl.logger.Errorw("error in updating  patch status", "release", release, "err", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recordRollback links a rollback to the earlier release of the same artifact it restored and to the release it
+// replaced, which is marked failed if the policy says so. Redeploying the artifact already live replaces nothing.
+func (impl *IngestionServiceImpl) recordRollback(ctx context.Context, appRelease *sql.AppRelease, policy *sql.FailurePolicy) error {
+	target, err := impl.appReleaseRepository.GetPreviousReleaseOfArtifact(ctx, appRelease.AppId, appRelease.EnvironmentId, appRelease.CiArtifactId, appRelease.Id)
+	if err != nil {
+		impl.logger.Errorw("error in getting rollback target", "appRelease", appRelease.Id, "err", err)
+		return err
+	}
+	appRelease.RollbackTargetId = target.Id
+	replaced, err := impl.appReleaseRepository.GetPreviousRelease(ctx, appRelease.AppId, appRelease.EnvironmentId, appRelease.Id)
+	if err != nil {
+		impl.logger.Errorw("error in getting rolled back release", "appRelease", appRelease.Id, "err", err)
+		return err
+	}
+	if replaced.CiArtifactId != appRelease.CiArtifactId {
+		appRelease.RolledBackReleaseId = replaced.Id
+		appRelease.TimeToRollback = appRelease.TriggerTime.Sub(replaced.TriggerTime)
+		if _, ok := policy.Rule(sql.FollowedByRollback); ok && replaced.ReleaseStatus != sql.Failure {
+			impl.logger.Infow("rollback detected, marking rolled back release failed", "appRelease", appRelease.Id, "rolledBackRelease", replaced.Id)
+			replaced.ReleaseStatus = sql.Failure
+			replaced.FailureReason = sql.FollowedByRollback
+			replaced.FailurePolicyId = policy.Id
+			replaced.FailurePolicyVersion = policy.Version
+			replaced.UpdatedTime = time.Now()
+			_, err = impl.updateAppRelease(ctx, replaced, fmt.Sprintf("rolled back by release %d", appRelease.Id))
+			if err != nil {
+				impl.logger.Errorw("error in updating rolled back release", "rolledBackRelease", replaced.Id, "err", err)
+				return err
+			}
 		}
 	}
+	appRelease.UpdatedTime = time.Now()
+	_, err = impl.updateAppRelease(ctx, appRelease, fmt.Sprintf("rollback to release %d", target.Id))
+	if err != nil {
+		impl.logger.Errorw("error in updating rollback", "appRelease", appRelease.Id, "err", err)
+		return err
+	}
 	return nil
 }
 
-func (impl *IngestionServiceImpl) fetchAndSaveChangesFromGit(appRelease *sql.AppRelease, materials []*sql.PipelineMaterial) error {
+func (impl *IngestionServiceImpl) fetchAndSaveChangesFromGit(ctx context.Context, appRelease *sql.AppRelease, materials []*sql.PipelineMaterial) error {
 	impl.logger.Infow("fetchAndSaveChangesFromGit", "appRelease", appRelease, "materials", materials)
 
 	//fetch previous released gitHash
-	previousAppRelease, err := impl.appReleaseRepository.GetPreviousRelease(appRelease.AppId, appRelease.EnvironmentId, appRelease.Id)
+	previousAppRelease, err := impl.appReleaseRepository.GetPreviousRelease(ctx, appRelease.AppId, appRelease.EnvironmentId, appRelease.Id)
 	if err != nil && err != pg.ErrNoRows {
 		impl.logger.Errorw("error in getting previous release for", "appRelease", appRelease.Id, "err", err)
 		return err
 	} else if err == pg.ErrNoRows {
+		for _, material := range materials {
+			material.GitChangesStatus = sql.GitChangesNoBaseline
+		}
+		if statusErr := impl.PipelineMaterialRepository.UpdateGitChangesStatus(ctx, materials...); statusErr != nil {
+			impl.logger.Errorw("error in saving git changes status", "appRelease", appRelease.Id, "err", statusErr)
+			return statusErr
+		}
 		return err
 	}
-	previousPipelineMaterials, err := impl.PipelineMaterialRepository.FindByAppReleaseId(previousAppRelease.Id)
+	previousPipelineMaterials, err := impl.PipelineMaterialRepository.FindByAppReleaseId(ctx, previousAppRelease.Id)
 	if err != nil {
 		impl.logger.Errorw("error in fetching previous pipeline material", "appReleaseId", previousAppRelease.Id, "err", err)
 		return err
@@ -175,77 +467,53 @@ func (impl *IngestionServiceImpl) fetchAndSaveChangesFromGit(appRelease *sql.App
 		oldMaterialCommitHash[pipelineMaterial.PipelineMaterialId] = pipelineMaterial.CommitHash
 	}
 
-	lineAdded := 0
-	lineRemoved := 0
-	oldestTime := time.Now()
-	now := oldestTime
-	oldestId := 0
-	var oldest *gitSensor.Commit
-
-	for _, pipelineMaterial := range materials {
-		oldHash, ok := oldMaterialCommitHash[pipelineMaterial.PipelineMaterialId]
-		if ok && oldHash != pipelineMaterial.CommitHash {
-
-			var changes *gitSensor.GitChanges
-
-			if impl.isGitSensorGrpcConfigured {
-				// gRPC protocol is configured, use gRPC client
-
-				request := &pb.ReleaseChangeRequest{
-					PipelineMaterialId: int64(pipelineMaterial.PipelineMaterialId),
-					OldCommit:          oldHash,
-					NewCommit:          pipelineMaterial.CommitHash,
-				}
-				changes, err = impl.gitSensorGrpcClient.GetChangesInRelease(context.Background(), request)
-
-			} else {
-				request := &gitSensor.ReleaseChangesRequest{
-					PipelineMaterialId: pipelineMaterial.PipelineMaterialId,
-					OldCommit:          oldHash,
-					NewCommit:          pipelineMaterial.CommitHash,
-				}
-				changes, err = impl.gitSensorRestClient.GetReleaseChanges(request)
-			}
-
-			if err != nil {
-				impl.logger.Errorw("error in fetching git data", "err", err)
-				return err
-			}
-			for _, change := range changes.FileStats {
-				//change.Name	//TODO apply file filter
-				lineRemoved = lineRemoved + change.Deletion
-				lineAdded = lineAdded + change.Addition
-			}
-			for _, d := range changes.Commits {
-				if oldestTime.After(d.Author.Date) {
-					oldestTime = d.Author.Date
-					oldest = d
-					oldestId = pipelineMaterial.PipelineMaterialId
-				}
-			}
+	changes, errs := impl.fetchMaterialChanges(ctx, materials, oldMaterialCommitHash)
+	err = impl.PipelineMaterialRepository.UpdateGitChangesStatus(ctx, materials...)
+	if err != nil {
+		impl.logger.Errorw("error in saving git changes status", "appRelease", appRelease.Id, "err", err)
+		return err
+	}
+	var firstErr error
+	fetched := 0
+	for i := range materials {
+		if errs[i] != nil && firstErr == nil {
+			firstErr = errs[i]
+		}
+		if changes[i] != nil {
+			fetched++
 		}
 	}
-	if !now.Equal(oldestTime) {
+	if fetched == 0 && firstErr != nil {
+		return firstErr
+	}
+	if firstErr != nil {
+		impl.logger.Warnw("changes of some materials could not be fetched, saving partial lead time and change size", "appRelease", appRelease.Id, "err", firstErr)
+	}
 
+	merged := mergeMaterialChanges(materials, changes, time.Now())
+	if merged.oldest != nil {
 		leadTime := &sql.LeadTime{
 			AppReleaseId:       appRelease.Id,
-			CommitTime:         oldest.Committer.Date,                          //
-			CommitHash:         oldest.Hash.Long,                               //
-			PipelineMaterialId: oldestId,                                       //
-			LeadTime:           appRelease.TriggerTime.Sub(oldest.Author.Date), //
+			CommitTime:         merged.oldest.Committer.Date,                          //
+			CommitHash:         merged.oldest.Hash.Long,                               //
+			PipelineMaterialId: merged.oldestMaterialId,                               //
+			LeadTime:           appRelease.TriggerTime.Sub(merged.oldest.Author.Date), //
 		}
-		_, err = impl.leadTimeRepository.Save(leadTime)
+		_, err = impl.leadTimeRepository.Save(ctx, leadTime)
 		if err != nil {
 			impl.logger.Errorw("error in saving leadtime", "leadtime", leadTime, "err", err)
 			return err
 		}
+		appRelease.LeadTime = leadTime
 	}
 
 	appRelease.UpdatedTime = time.Now()
 	appRelease.ProcessStage = sql.LeadTimeFetch
-	appRelease.ChangeSizeLineAdded = lineAdded
-	appRelease.ChangeSizeLineDeleted = lineRemoved
-	appRelease, err = impl.appReleaseRepository.Update(appRelease)
+	appRelease.ChangeSizeLineAdded = merged.lineAdded
+	appRelease.ChangeSizeLineDeleted = merged.lineRemoved
+	appRelease.CommitCount = merged.commitCount
+	appRelease.FixCommitCount = merged.fixCommitCount
+	appRelease, err = impl.updateAppRelease(ctx, appRelease, "changes fetched from git")
 	if err != nil {
 		impl.logger.Errorw("error in updating releaseTime", "appRelease", appRelease, "err", err)
 		return err
@@ -253,30 +521,165 @@ func (impl *IngestionServiceImpl) fetchAndSaveChangesFromGit(appRelease *sql.App
 	return nil
 }
 
-func (impl *IngestionServiceImpl) saveAppRelease(deploymentEvent *DeploymentEvent) (*sql.AppRelease, error) {
+// fetchMaterialChanges fetches the changes of materials since their previous commit, at most GitFetchParallelism at a time.
+// Results and errors are indexed like materials, whose git changes status is set.
+func (impl *IngestionServiceImpl) fetchMaterialChanges(ctx context.Context, materials []*sql.PipelineMaterial, oldMaterialCommitHash map[int]string) ([]*gitSensor.GitChanges, []error) {
+	changes := make([]*gitSensor.GitChanges, len(materials))
+	errs := make([]error, len(materials))
+	parallelism := impl.config.GitFetchParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	semaphore := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, pipelineMaterial := range materials {
+		oldHash, ok := oldMaterialCommitHash[pipelineMaterial.PipelineMaterialId]
+		if !ok {
+			pipelineMaterial.GitChangesStatus = sql.GitChangesNoBaseline
+			continue
+		}
+		if oldHash == pipelineMaterial.CommitHash {
+			pipelineMaterial.GitChangesStatus = sql.GitChangesUnchanged
+			continue
+		}
+		wg.Add(1)
+		go func(i int, pipelineMaterial *sql.PipelineMaterial, oldHash string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			request := &gitSensor.ReleaseChangesRequest{
+				PipelineMaterialId: pipelineMaterial.PipelineMaterialId,
+				OldCommit:          oldHash,
+				NewCommit:          pipelineMaterial.CommitHash,
+			}
+			result, err := impl.gitChangesProvider.GetReleaseChanges(ctx, request)
+			if err != nil {
+				impl.logger.Errorw("error in fetching git data", "pipelineMaterialId", pipelineMaterial.PipelineMaterialId, "err", err)
+				errs[i] = err
+				pipelineMaterial.GitChangesStatus = sql.GitChangesFailed
+				pipelineMaterial.GitChangesError = err.Error()
+				return
+			}
+			changes[i] = result
+			pipelineMaterial.GitChangesStatus = sql.GitChangesFetched
+		}(i, pipelineMaterial, oldHash)
+	}
+	wg.Wait()
+	return changes, errs
+}
+
+type mergedChanges struct {
+	lineAdded        int
+	lineRemoved      int
+	commitCount      int
+	fixCommitCount   int
+	oldest           *gitSensor.Commit
+	oldestMaterialId int
+}
+
+// mergeMaterialChanges sums the change size and picks the oldest commit authored before now, in material order
+// so that ties go to the first material and commit whatever order the fetches completed in
+func mergeMaterialChanges(materials []*sql.PipelineMaterial, changes []*gitSensor.GitChanges, now time.Time) *mergedChanges {
+	merged := &mergedChanges{}
+	oldestTime := now
+	for i, pipelineMaterial := range materials {
+		if changes[i] == nil {
+			continue
+		}
+		for _, change := range changes[i].FileStats {
+			//change.Name	//TODO apply file filter
+			merged.lineRemoved = merged.lineRemoved + change.Deletion
+			merged.lineAdded = merged.lineAdded + change.Addition
+		}
+		for _, d := range changes[i].Commits {
+			merged.commitCount++
+			if isFixCommit(d.Subject) {
+				merged.fixCommitCount++
+			}
+			if oldestTime.After(d.Author.Date) {
+				oldestTime = d.Author.Date
+				merged.oldest = d
+				merged.oldestMaterialId = pipelineMaterial.PipelineMaterialId
+			}
+		}
+	}
+	return merged
+}
+
+// isFixCommit is true for conventional fix commits, "fix(scope)!: ..." included, and reverts
+func isFixCommit(subject string) bool {
+	subject = strings.ToLower(strings.TrimSpace(subject))
+	if strings.HasPrefix(subject, "revert") {
+		return true
+	}
+	commitType, _, ok := strings.Cut(subject, ":")
+	if !ok {
+		return false
+	}
+	commitType = strings.TrimSuffix(commitType, "!")
+	if scope := strings.IndexByte(commitType, '('); scope >= 0 && strings.HasSuffix(commitType, ")") {
+		commitType = commitType[:scope]
+	}
+	return commitType == "fix"
+}
+
+// saveAppRelease saves the release of a deployment event, resumed is true when the release of the event was saved
+// by an earlier delivery and is returned instead
+func (impl *IngestionServiceImpl) saveAppRelease(ctx context.Context, deploymentEvent *DeploymentEvent, policy *sql.FailurePolicy) (appRelease *sql.AppRelease, resumed bool, err error) {
 	impl.logger.Infow("save appRelease", "deploymentEvent", deploymentEvent)
-	appRelease := &sql.AppRelease{
-		AppId:              deploymentEvent.ApplicationId,
-		CiArtifactId:       deploymentEvent.CiArtifactId,
-		TriggerTime:        deploymentEvent.TriggerTime,
-		EnvironmentId:      deploymentEvent.EnvironmentId,
-		CreatedTime:        time.Now(),
-		UpdatedTime:        time.Now(),
-		PipelineOverrideId: deploymentEvent.PipelineOverrideId,
-		ReleaseId:          deploymentEvent.ReleaseId,
-		ProcessStage:       sql.Init,
-		ReleaseType:        sql.Unknown,
-	}
-	appRelease, err := impl.appReleaseRepository.Save(appRelease)
+	appRelease, err = impl.appReleaseRepository.FindByTrigger(ctx, deploymentEvent.ApplicationId, deploymentEvent.EnvironmentId, deploymentEvent.PipelineOverrideId)
+	if err == nil {
+		impl.logger.Infow("release of deployment event already saved, resuming it", "appRelease", appRelease.Id, "stage", appRelease.ProcessStage)
+		return appRelease, true, nil
+	} else if err != pg.ErrNoRows {
+		impl.logger.Errorw("error in fetching release of deployment event", "deploymentEvent", deploymentEvent, "err", err)
+		return nil, false, err
+	}
+	appRelease = &sql.AppRelease{
+		AppId:                deploymentEvent.ApplicationId,
+		CiArtifactId:         deploymentEvent.CiArtifactId,
+		TriggerTime:          deploymentEvent.TriggerTime,
+		EnvironmentId:        deploymentEvent.EnvironmentId,
+		CreatedTime:          time.Now(),
+		UpdatedTime:          time.Now(),
+		PipelineOverrideId:   deploymentEvent.PipelineOverrideId,
+		ReleaseId:            deploymentEvent.ReleaseId,
+		ProcessStage:         sql.Init,
+		ReleaseType:          sql.Unknown,
+		FailurePolicyId:      policy.Id,
+		FailurePolicyVersion: policy.Version,
+	}
+	// only rules on the release itself apply yet, those on the next release when it comes
+	if reason := policy.FailureReason(appRelease, nil, deploymentEvent.Tags, nil); reason != "" {
+		appRelease.ReleaseStatus = sql.Failure
+		appRelease.FailureReason = reason
+	}
+	err = impl.applyReleaseOverride(ctx, appRelease)
+	if err != nil {
+		return nil, false, err
+	}
+	appRelease, err = impl.appReleaseRepository.Save(ctx, appRelease, "deployment event")
 	if err != nil {
 		impl.logger.Errorw("error in saving initial event ", "event", appRelease, "err", err)
-		return nil, err
+		return nil, false, err
 	}
-	return appRelease, nil
+	impl.streamRelease(ctx, ReleaseCreated, appRelease)
+	return appRelease, false, nil
 }
 
-func (impl *IngestionServiceImpl) savePipelineMaterial(deploymentEvent *DeploymentEvent, appRelease *sql.AppRelease) (materials []*sql.PipelineMaterial, err error) {
+// savePipelineMaterial saves the materials of a deployment event, a resumed release keeps those already saved
+func (impl *IngestionServiceImpl) savePipelineMaterial(ctx context.Context, deploymentEvent *DeploymentEvent, appRelease *sql.AppRelease, resumed bool) (materials []*sql.PipelineMaterial, err error) {
 	impl.logger.Infow("save pipeline material ", "deploymentEvent", deploymentEvent, "appRelease", appRelease)
+	if resumed {
+		materials, err = impl.PipelineMaterialRepository.FindByAppReleaseId(ctx, appRelease.Id)
+		if err != nil {
+			impl.logger.Errorw("error in fetching pipeline material", "appReleaseId", appRelease.Id, "err", err)
+			return nil, err
+		}
+		if len(materials) > 0 {
+			return materials, nil
+		}
+	}
 	for _, pipelineMaterialInfo := range deploymentEvent.PipelineMaterials {
 		material := &sql.PipelineMaterial{
 			PipelineMaterialId: pipelineMaterialInfo.PipelineMaterialId,
@@ -285,7 +688,7 @@ func (impl *IngestionServiceImpl) savePipelineMaterial(deploymentEvent *Deployme
 		}
 		materials = append(materials, material)
 	}
-	err = impl.PipelineMaterialRepository.Save(materials...)
+	err = impl.PipelineMaterialRepository.Save(ctx, materials...)
 	if err != nil {
 		impl.logger.Errorw("error in saving pipeline material", "material", materials, "err", err)
 		return nil, err
@@ -293,21 +696,146 @@ func (impl *IngestionServiceImpl) savePipelineMaterial(deploymentEvent *Deployme
 	return materials, nil
 }
 
-func (impl *IngestionServiceImpl) checkAndUpdateReleaseType(appRelease *sql.AppRelease) (*sql.AppRelease, error) {
+// saveTagsAndAnnotations saves the tags and annotations of a deployment event, annotations only once per release
+func (impl *IngestionServiceImpl) saveTagsAndAnnotations(ctx context.Context, deploymentEvent *DeploymentEvent, appRelease *sql.AppRelease, resumed bool) error {
+	err := validateTags(deploymentEvent.Tags)
+	if err != nil {
+		impl.logger.Errorw("invalid tags in deployment event, skipping them", "tags", deploymentEvent.Tags, "err", err)
+	} else {
+		err = impl.releaseTagRepository.Save(ctx, toReleaseTags(appRelease.Id, deploymentEvent.Tags)...)
+		if err != nil {
+			impl.logger.Errorw("error in saving release tags", "appRelease", appRelease.Id, "err", err)
+			return err
+		}
+	}
+	if resumed {
+		saved, err := impl.releaseAnnotationRepository.FindByAppReleaseId(ctx, appRelease.Id)
+		if err != nil {
+			impl.logger.Errorw("error in fetching release annotations", "appRelease", appRelease.Id, "err", err)
+			return err
+		}
+		for _, annotation := range saved {
+			if annotation.CreatedBy == deploymentEventAuthor {
+				return nil
+			}
+		}
+	}
+	var annotations []*sql.ReleaseAnnotation
+	for _, text := range deploymentEvent.Annotations {
+		if text == "" {
+			continue
+		}
+		annotations = append(annotations, &sql.ReleaseAnnotation{
+			AppReleaseId: appRelease.Id,
+			Text:         text,
+			CreatedBy:    deploymentEventAuthor,
+			CreatedOn:    time.Now(),
+		})
+	}
+	err = impl.releaseAnnotationRepository.Save(ctx, annotations...)
+	if err != nil {
+		impl.logger.Errorw("error in saving release annotations", "appRelease", appRelease.Id, "err", err)
+		return err
+	}
+	return nil
+}
+
+func (impl *IngestionServiceImpl) ProcessStatusEvent(ctx context.Context, statusEvent *DeploymentStatusEvent) (appRelease *sql.AppRelease, err error) {
+	impl.logger.Infow("processing release status", "request", statusEvent)
+	if statusEvent.ApplicationId <= 0 || statusEvent.EnvironmentId <= 0 || statusEvent.PipelineOverrideId <= 0 {
+		return nil, apperror.Validationf("ApplicationId, EnvironmentId and PipelineOverrideId are required")
+	}
+	if statusEvent.CdStatus == "" && statusEvent.HealthStatus == "" {
+		return nil, apperror.Validationf("CdStatus or HealthStatus is required")
+	}
+	ctx, span := tracing.StartSpan(ctx, "ingestion.process-status-event",
+		attribute.Int("lens.app_id", statusEvent.ApplicationId),
+		attribute.Int("lens.env_id", statusEvent.EnvironmentId))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+	appRelease, err = impl.appReleaseRepository.FindByTrigger(ctx, statusEvent.ApplicationId, statusEvent.EnvironmentId, statusEvent.PipelineOverrideId)
+	if err != nil {
+		impl.logger.Errorw("error in fetching release of status event", "statusEvent", statusEvent, "err", err)
+		return nil, err
+	}
+	releaseStatusEvent := &sql.ReleaseStatusEvent{
+		AppReleaseId: appRelease.Id,
+		CdStatus:     statusEvent.CdStatus,
+		HealthStatus: statusEvent.HealthStatus,
+		EventTime:    statusEvent.EventTime,
+		CreatedOn:    time.Now(),
+	}
+	if releaseStatusEvent.EventTime.IsZero() {
+		releaseStatusEvent.EventTime = releaseStatusEvent.CreatedOn
+	}
+	err = impl.releaseStatusEventRepository.Save(ctx, releaseStatusEvent)
+	if err != nil {
+		impl.logger.Errorw("error in saving release status event", "appRelease", appRelease.Id, "err", err)
+		return nil, err
+	}
+	if appRelease.ReleaseStatus == sql.Failure {
+		return appRelease, nil
+	}
+	policy, err := impl.failurePolicyService.GetPolicy(ctx, appRelease.EnvironmentId)
+	if err != nil {
+		return nil, err
+	}
+	reason := policy.FailureReason(appRelease, nil, nil, []*sql.ReleaseStatusEvent{releaseStatusEvent})
+	if reason == "" {
+		return appRelease, nil
+	}
+	impl.logger.Infow("release failure reported", "appRelease", appRelease.Id, "reason", reason)
+	appRelease.ReleaseStatus = sql.Failure
+	appRelease.FailureReason = reason
+	appRelease.FailurePolicyId = policy.Id
+	appRelease.FailurePolicyVersion = policy.Version
+	appRelease.UpdatedTime = time.Now()
+	appRelease, err = impl.updateAppRelease(ctx, appRelease, "status event: "+string(reason))
+	if err != nil {
+		impl.logger.Errorw("error in updating failed release", "appRelease", appRelease.Id, "err", err)
+		return nil, err
+	}
+	return appRelease, nil
+}
+
+// checkAndUpdateReleaseType classifies a release against the one it replaces: deploying the live artifact again is a
+// redeploy, an artifact deployed earlier a rollback, and a new artifact with the commits of the live one config only
+func (impl *IngestionServiceImpl) checkAndUpdateReleaseType(ctx context.Context, appRelease *sql.AppRelease, materials []*sql.PipelineMaterial) (*sql.AppRelease, error) {
 	impl.logger.Infow("check and update release type ", "appRelease", appRelease)
-	duplicate, err := impl.appReleaseRepository.CheckDuplicateRelease(appRelease.AppId, appRelease.EnvironmentId, appRelease.CiArtifactId)
+	previousAppRelease, err := impl.appReleaseRepository.GetPreviousRelease(ctx, appRelease.AppId, appRelease.EnvironmentId, appRelease.Id)
+	if err == pg.ErrNoRows {
+		previousAppRelease = nil
+	} else if err != nil {
+		impl.logger.Errorw("error in getting previous release", "appRelease", appRelease.Id, "err", err)
+		return appRelease, err
+	}
+	duplicate, err := impl.appReleaseRepository.CheckDuplicateRelease(ctx, appRelease.AppId, appRelease.EnvironmentId, appRelease.CiArtifactId)
 	if err != nil {
 		impl.logger.Errorw("eror in determining rollback", "pipelineOverrideId", appRelease.PipelineOverrideId, "err", err)
 		return appRelease, err
 	}
-	if duplicate {
+	switch {
+	case previousAppRelease != nil && previousAppRelease.CiArtifactId == appRelease.CiArtifactId:
+		appRelease.ReleaseType = sql.Redeploy
+	case duplicate:
 		appRelease.ReleaseType = sql.RollBack
-	} else {
+	default:
 		appRelease.ReleaseType = sql.RollForward
+		if previousAppRelease != nil {
+			previousMaterials, err := impl.PipelineMaterialRepository.FindByAppReleaseId(ctx, previousAppRelease.Id)
+			if err != nil {
+				impl.logger.Errorw("error in fetching previous pipeline material", "appReleaseId", previousAppRelease.Id, "err", err)
+				return appRelease, err
+			}
+			if sameCommits(materials, previousMaterials) {
+				appRelease.ReleaseType = sql.ConfigOnly
+			}
+		}
 	}
 	appRelease.ProcessStage = sql.ReleaseTypeDetermined
 	appRelease.UpdatedTime = time.Now()
-	appRelease, err = impl.appReleaseRepository.Update(appRelease)
+	appRelease, err = impl.updateAppRelease(ctx, appRelease, "release type detected")
 
 	if err != nil {
 		impl.logger.Errorw("error in updating release status", "appRelease", appRelease, "err", err)
@@ -316,13 +844,45 @@ func (impl *IngestionServiceImpl) checkAndUpdateReleaseType(appRelease *sql.AppR
 	return appRelease, err
 }
 
-func (impl *IngestionServiceImpl) CleanAppDataForEnvironment(appId, environmentId int) (bool, error) {
-	impl.logger.Infow("cleaning app data for ", "app", appId, "env", environmentId)
-	err := impl.appReleaseRepository.CleanAppDataForEnvironment(appId, environmentId)
-	if err != nil {
-		impl.logger.Errorw("error in cleaning data", "err", err)
-		return false, err
+// sameCommits is true when both releases deploy the same commit of the same materials
+func sameCommits(materials []*sql.PipelineMaterial, previousMaterials []*sql.PipelineMaterial) bool {
+	if len(materials) == 0 || len(materials) != len(previousMaterials) {
+		return false
+	}
+	previousCommitHash := make(map[int]string, len(previousMaterials))
+	for _, material := range previousMaterials {
+		previousCommitHash[material.PipelineMaterialId] = material.CommitHash
+	}
+	for _, material := range materials {
+		if commitHash, ok := previousCommitHash[material.PipelineMaterialId]; !ok || commitHash != material.CommitHash {
+			return false
+		}
 	}
-	return true, err
+	return true
+}
 
+// applyReleaseOverride re-applies manual corrections so that processing never reverts them
+func (impl *IngestionServiceImpl) applyReleaseOverride(ctx context.Context, appRelease *sql.AppRelease) error {
+	override, err := impl.releaseOverrideRepository.FindByTrigger(ctx, appRelease.AppId, appRelease.EnvironmentId, appRelease.PipelineOverrideId)
+	if err == pg.ErrNoRows {
+		return nil
+	} else if err != nil {
+		impl.logger.Errorw("error in fetching release override", "appRelease", appRelease, "err", err)
+		return err
+	}
+	applyReleaseOverride(appRelease, override)
+	return nil
+}
+
+func (impl *IngestionServiceImpl) updateAppRelease(ctx context.Context, appRelease *sql.AppRelease, cause string) (*sql.AppRelease, error) {
+	err := impl.applyReleaseOverride(ctx, appRelease)
+	if err != nil {
+		return appRelease, err
+	}
+	appRelease, err = impl.appReleaseRepository.Update(ctx, appRelease, cause)
+	if err != nil {
+		return appRelease, err
+	}
+	impl.streamRelease(ctx, ReleaseUpdated, appRelease)
+	return appRelease, nil
 }
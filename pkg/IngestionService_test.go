@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/lens/client/gitSensor"
+	"github.com/devtron-labs/lens/internal/sql"
+	"go.uber.org/zap"
+)
+
+func commitAt(hash string, date time.Time) *gitSensor.Commit {
+	return &gitSensor.Commit{Hash: &gitSensor.Hash{Long: hash}, Author: &gitSensor.Author{Date: date}, Committer: &gitSensor.Committer{Date: date}}
+}
+
+func TestMergeMaterialChanges(t *testing.T) {
+	now := time.Now()
+	materials := []*sql.PipelineMaterial{{PipelineMaterialId: 1}, {PipelineMaterialId: 2}, {PipelineMaterialId: 3}}
+	changes := []*gitSensor.GitChanges{
+		{Commits: []*gitSensor.Commit{commitAt("a", now.Add(-time.Hour))}, FileStats: gitSensor.FileStats{{Addition: 1, Deletion: 2}}},
+		nil, // failed material
+		{Commits: []*gitSensor.Commit{commitAt("future", now.Add(time.Hour)), commitAt("b", now.Add(-2*time.Hour)), commitAt("c", now.Add(-2*time.Hour))},
+			FileStats: gitSensor.FileStats{{Addition: 10, Deletion: 20}, {Addition: 100}}},
+	}
+	merged := mergeMaterialChanges(materials, changes, now)
+	if merged.lineAdded != 111 || merged.lineRemoved != 22 {
+		t.Errorf("change size = +%d -%d, want +111 -22", merged.lineAdded, merged.lineRemoved)
+	}
+	if merged.commitCount != 4 || merged.fixCommitCount != 0 {
+		t.Errorf("commits = %d with %d fixes, want 4 with 0", merged.commitCount, merged.fixCommitCount)
+	}
+	if merged.oldest == nil || merged.oldest.Hash.Long != "b" || merged.oldestMaterialId != 3 {
+		t.Errorf("oldest = %+v of material %d, want b of material 3", merged.oldest, merged.oldestMaterialId)
+	}
+
+	if merged = mergeMaterialChanges(materials, make([]*gitSensor.GitChanges, 3), now); merged.oldest != nil {
+		t.Errorf("oldest picked without changes: %+v", merged.oldest)
+	}
+}
+
+type fakeGitChangesProvider struct {
+	inFlight    int32
+	maxInFlight int32
+	failing     map[int]error
+}
+
+func (provider *fakeGitChangesProvider) GetReleaseChanges(ctx context.Context, request *gitSensor.ReleaseChangesRequest) (*gitSensor.GitChanges, error) {
+	inFlight := atomic.AddInt32(&provider.inFlight, 1)
+	defer atomic.AddInt32(&provider.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&provider.maxInFlight)
+		if inFlight <= max || atomic.CompareAndSwapInt32(&provider.maxInFlight, max, inFlight) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := provider.failing[request.PipelineMaterialId]; err != nil {
+		return nil, err
+	}
+	return &gitSensor.GitChanges{Commits: []*gitSensor.Commit{commitAt(request.NewCommit, time.Now())}}, nil
+}
+
+func (provider *fakeGitChangesProvider) Ping(ctx context.Context) error {
+	return nil
+}
+
+func TestFetchMaterialChanges(t *testing.T) {
+	provider := &fakeGitChangesProvider{failing: map[int]error{3: errors.New("unknown commit")}}
+	impl := &IngestionServiceImpl{logger: zap.NewNop().Sugar(), config: &IngestionConfig{GitFetchParallelism: 2}, gitChangesProvider: provider}
+	materials := []*sql.PipelineMaterial{
+		{PipelineMaterialId: 1, CommitHash: "new1"},
+		{PipelineMaterialId: 2, CommitHash: "new2"},
+		{PipelineMaterialId: 3, CommitHash: "new3"},
+		{PipelineMaterialId: 4, CommitHash: "new4"},
+		{PipelineMaterialId: 5, CommitHash: "same"},
+		{PipelineMaterialId: 6, CommitHash: "new6"},
+	}
+	old := map[int]string{1: "old1", 2: "old2", 3: "old3", 4: "old4", 5: "same"}
+
+	changes, errs := impl.fetchMaterialChanges(context.Background(), materials, old)
+	if provider.maxInFlight != 2 {
+		t.Errorf("max concurrent fetches = %d, want 2", provider.maxInFlight)
+	}
+	want := []sql.GitChangesStatus{sql.GitChangesFetched, sql.GitChangesFetched, sql.GitChangesFailed, sql.GitChangesFetched, sql.GitChangesUnchanged, sql.GitChangesNoBaseline}
+	for i, material := range materials {
+		if material.GitChangesStatus != want[i] {
+			t.Errorf("material %d status = %s, want %s", material.PipelineMaterialId, material.GitChangesStatus, want[i])
+		}
+		if fetched := changes[i] != nil; fetched != (want[i] == sql.GitChangesFetched) {
+			t.Errorf("material %d changes = %v", material.PipelineMaterialId, changes[i])
+		}
+	}
+	if errs[2] == nil || materials[2].GitChangesError != "unknown commit" {
+		t.Errorf("failed material error = %v, recorded %q", errs[2], materials[2].GitChangesError)
+	}
+	if changes[3].Commits[0].Hash.Long != "new4" {
+		t.Errorf("changes not indexed like materials: %+v", changes[3].Commits[0].Hash)
+	}
+}
+
+func TestSameCommits(t *testing.T) {
+	previous := []*sql.PipelineMaterial{{PipelineMaterialId: 1, CommitHash: "a"}, {PipelineMaterialId: 2, CommitHash: "b"}}
+	tests := []struct {
+		name      string
+		materials []*sql.PipelineMaterial
+		want      bool
+	}{
+		{"same commits", []*sql.PipelineMaterial{{PipelineMaterialId: 2, CommitHash: "b"}, {PipelineMaterialId: 1, CommitHash: "a"}}, true},
+		{"new commit", []*sql.PipelineMaterial{{PipelineMaterialId: 1, CommitHash: "a"}, {PipelineMaterialId: 2, CommitHash: "c"}}, false},
+		{"material removed", []*sql.PipelineMaterial{{PipelineMaterialId: 1, CommitHash: "a"}}, false},
+		{"no materials", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameCommits(tt.materials, previous); got != tt.want {
+				t.Errorf("sameCommits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsFixCommit(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    bool
+	}{
+		{"fix: nil pointer in checkout", true},
+		{"Fix(cart)!: drop stale sessions", true},
+		{"Revert \"feat: new pricing\"", true},
+		{"feat: new pricing", false},
+		{"fixed the build", false},
+		{"prefix: something", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			if got := isFixCommit(tt.subject); got != tt.want {
+				t.Errorf("isFixCommit(%q) = %v, want %v", tt.subject, got, tt.want)
+			}
+		})
+	}
+}
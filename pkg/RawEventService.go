@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"time"
+
+	"github.com/devtron-labs/lens/internal/sql"
+	"go.uber.org/zap"
+)
+
+type RawEventService interface {
+	// RecordDeploymentEvent appends a deployment event as received, before it is processed
+	RecordDeploymentEvent(ctx context.Context, source string, payload []byte, deploymentEvent *DeploymentEvent) error
+	// RecordStatusEvent appends a status event as received, before it is processed
+	RecordStatusEvent(ctx context.Context, source string, payload []byte, statusEvent *DeploymentStatusEvent) error
+}
+
+type RawEventServiceImpl struct {
+	logger             *zap.SugaredLogger
+	rawEventRepository sql.RawEventRepository
+}
+
+func NewRawEventServiceImpl(logger *zap.SugaredLogger,
+	rawEventRepository sql.RawEventRepository) *RawEventServiceImpl {
+	return &RawEventServiceImpl{
+		logger:             logger,
+		rawEventRepository: rawEventRepository,
+	}
+}
+
+func (impl *RawEventServiceImpl) RecordDeploymentEvent(ctx context.Context, source string, payload []byte, deploymentEvent *DeploymentEvent) error {
+	return impl.record(ctx, &sql.RawEvent{
+		EventType:          sql.RawEventDeployment,
+		Source:             source,
+		AppId:              deploymentEvent.ApplicationId,
+		EnvironmentId:      deploymentEvent.EnvironmentId,
+		PipelineOverrideId: deploymentEvent.PipelineOverrideId,
+		TriggerTime:        deploymentEvent.TriggerTime,
+		Payload:            payload,
+	})
+}
+
+func (impl *RawEventServiceImpl) RecordStatusEvent(ctx context.Context, source string, payload []byte, statusEvent *DeploymentStatusEvent) error {
+	return impl.record(ctx, &sql.RawEvent{
+		EventType:          sql.RawEventStatus,
+		Source:             source,
+		AppId:              statusEvent.ApplicationId,
+		EnvironmentId:      statusEvent.EnvironmentId,
+		PipelineOverrideId: statusEvent.PipelineOverrideId,
+		Payload:            payload,
+	})
+}
+
+func (impl *RawEventServiceImpl) record(ctx context.Context, rawEvent *sql.RawEvent) error {
+	rawEvent.ReceivedOn = time.Now()
+	err := impl.rawEventRepository.Save(ctx, rawEvent)
+	if err != nil {
+		impl.logger.Errorw("error in saving raw event", "eventType", rawEvent.EventType, "appId", rawEvent.AppId, "environmentId", rawEvent.EnvironmentId, "err", err)
+	}
+	return err
+}
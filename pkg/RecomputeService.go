@@ -0,0 +1,246 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/pkg/apperror"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+type RecomputeRequest struct {
+	AppId         int       `json:"appId"`
+	EnvironmentId int       `json:"environmentId"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"` // now when not set
+	RequestedBy   string    `json:"requestedBy"`
+}
+
+type RecomputeService interface {
+	// Recompute starts a job rebuilding releases, lead time and materials of an app environment triggered in a time
+	// range by replaying its raw events through ingestion. The rebuilt releases replace the live ones at once when done.
+	// The range can not start before the retention cutoff of the environment.
+	Recompute(ctx context.Context, request *RecomputeRequest) (*sql.RecomputeJob, error)
+	GetRecomputeJob(ctx context.Context, id int) (*sql.RecomputeJob, error)
+	// Start fails jobs left running by an earlier process
+	Start()
+	// Stop cancels running jobs and waits for them
+	Stop()
+}
+
+// replayBatchSize is the number of raw events read at once
+const replayBatchSize = 500
+
+type RecomputeServiceImpl struct {
+	logger                 *zap.SugaredLogger
+	ingestionService       IngestionService
+	rawEventRepository     sql.RawEventRepository
+	recomputeJobRepository sql.RecomputeJobRepository
+	appReleaseRepository   sql.AppReleaseRepository
+	retentionService       RetentionService
+	ctx                    context.Context
+	cancel                 context.CancelFunc
+	wg                     sync.WaitGroup
+}
+
+func NewRecomputeServiceImpl(logger *zap.SugaredLogger,
+	ingestionService IngestionService,
+	rawEventRepository sql.RawEventRepository,
+	recomputeJobRepository sql.RecomputeJobRepository,
+	appReleaseRepository sql.AppReleaseRepository,
+	retentionService RetentionService) *RecomputeServiceImpl {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RecomputeServiceImpl{
+		logger:                 logger,
+		ingestionService:       ingestionService,
+		rawEventRepository:     rawEventRepository,
+		recomputeJobRepository: recomputeJobRepository,
+		appReleaseRepository:   appReleaseRepository,
+		retentionService:       retentionService,
+		ctx:                    ctx,
+		cancel:                 cancel,
+	}
+}
+
+func (impl *RecomputeServiceImpl) Recompute(ctx context.Context, request *RecomputeRequest) (*sql.RecomputeJob, error) {
+	if request.AppId <= 0 || request.EnvironmentId <= 0 {
+		return nil, apperror.Validationf("appId and environmentId are required")
+	}
+	if request.RequestedBy == "" {
+		return nil, apperror.Validationf("requestedBy is required")
+	}
+	now := time.Now()
+	if request.To.IsZero() {
+		request.To = now
+	}
+	if request.From.IsZero() || !request.From.Before(request.To) {
+		return nil, apperror.Validationf("from is required and must be before to")
+	}
+	// releases before the cutoff are archived, rebuilding them would count them twice
+	if cutoff := impl.retentionService.RetentionCutoff(request.EnvironmentId); !cutoff.IsZero() && request.From.Before(cutoff) {
+		return nil, apperror.Validationf("from is before the retention cutoff %s of the environment", cutoff.Format(time.RFC3339))
+	}
+	running, err := impl.recomputeJobRepository.FindRunning(ctx, request.AppId, request.EnvironmentId)
+	if err != nil {
+		impl.logger.Errorw("error in fetching running recompute jobs", "request", request, "err", err)
+		return nil, err
+	}
+	if len(running) > 0 {
+		return nil, apperror.Conflictf("recompute job %d of the app environment is still running", running[0].Id)
+	}
+	resetBatch := &sql.ResetBatch{
+		AppId:         request.AppId,
+		EnvironmentId: request.EnvironmentId,
+		Status:        sql.ResetStaged,
+		RequestedBy:   request.RequestedBy,
+		Reason:        "recompute",
+		CreatedOn:     now,
+	}
+	job := &sql.RecomputeJob{
+		AppId:         request.AppId,
+		EnvironmentId: request.EnvironmentId,
+		From:          request.From,
+		To:            request.To,
+		Status:        sql.RecomputeRunning,
+		RequestedBy:   request.RequestedBy,
+		CreatedOn:     now,
+	}
+	err = impl.recomputeJobRepository.Save(ctx, job, resetBatch)
+	if err != nil {
+		impl.logger.Errorw("error in saving recompute job", "request", request, "err", err)
+		return nil, err
+	}
+	impl.logger.Infow("recompute started", "job", job.Id, "appId", job.AppId, "environmentId", job.EnvironmentId, "from", job.From, "to", job.To)
+	jobCopy := *job
+	impl.wg.Add(1)
+	go func() {
+		defer impl.wg.Done()
+		impl.run(&jobCopy, resetBatch)
+	}()
+	return job, nil
+}
+
+func (impl *RecomputeServiceImpl) GetRecomputeJob(ctx context.Context, id int) (*sql.RecomputeJob, error) {
+	return impl.recomputeJobRepository.FindById(ctx, id)
+}
+
+func (impl *RecomputeServiceImpl) run(job *sql.RecomputeJob, resetBatch *sql.ResetBatch) {
+	ctx := sql.WithRecomputeScope(impl.ctx, &sql.RecomputeScope{ResetBatchId: resetBatch.Id, From: job.From})
+	err := impl.replay(ctx, job)
+	if err == nil {
+		job.ReplacedReleaseCount, err = impl.appReleaseRepository.SwapRecomputedReleases(ctx, resetBatch, job.From, job.To, deploymentEventAuthor)
+	}
+	impl.finish(job, resetBatch, err)
+}
+
+// replay processes raw events in order of receipt, as they were processed when received
+func (impl *RecomputeServiceImpl) replay(ctx context.Context, job *sql.RecomputeJob) error {
+	afterId := int64(0)
+	for {
+		rawEvents, err := impl.rawEventRepository.FindForReplay(ctx, job.AppId, job.EnvironmentId, job.From, job.To, afterId, replayBatchSize)
+		if err != nil {
+			impl.logger.Errorw("error in fetching raw events", "job", job.Id, "err", err)
+			return err
+		}
+		for _, rawEvent := range rawEvents {
+			afterId = rawEvent.Id
+			if err = impl.replayEvent(ctx, rawEvent, job); err != nil {
+				return fmt.Errorf("replaying raw event %d: %w", rawEvent.Id, err)
+			}
+			job.EventCount++
+		}
+		if err = impl.recomputeJobRepository.Update(ctx, job); err != nil {
+			impl.logger.Errorw("error in updating recompute job progress", "job", job.Id, "err", err)
+		}
+		if len(rawEvents) < replayBatchSize {
+			return nil
+		}
+	}
+}
+
+func (impl *RecomputeServiceImpl) replayEvent(ctx context.Context, rawEvent *sql.RawEvent, job *sql.RecomputeJob) error {
+	switch rawEvent.EventType {
+	case sql.RawEventDeployment:
+		deploymentEvent := &DeploymentEvent{}
+		if err := json.Unmarshal(rawEvent.Payload, deploymentEvent); err != nil {
+			return err
+		}
+		if _, err := impl.ingestionService.ProcessDeploymentEvent(ctx, deploymentEvent); err != nil {
+			return err
+		}
+		job.ReleaseCount++
+	case sql.RawEventStatus:
+		statusEvent := &DeploymentStatusEvent{}
+		if err := json.Unmarshal(rawEvent.Payload, statusEvent); err != nil {
+			return err
+		}
+		// the release may never have been ingested, the status event was then rejected when received as well
+		if _, err := impl.ingestionService.ProcessStatusEvent(ctx, statusEvent); err != nil && err != pg.ErrNoRows {
+			return err
+		}
+	default:
+		impl.logger.Warnw("skipping raw event of unknown type", "rawEvent", rawEvent.Id, "eventType", rawEvent.EventType)
+	}
+	return nil
+}
+
+// finish records the outcome of a job, the releases staged by a failed job are dropped and the live ones kept
+func (impl *RecomputeServiceImpl) finish(job *sql.RecomputeJob, resetBatch *sql.ResetBatch, err error) {
+	// the job outcome is recorded even when the service is stopping
+	ctx := context.Background()
+	job.FinishedOn = time.Now()
+	job.Status = sql.RecomputeCompleted
+	if err != nil {
+		impl.logger.Errorw("recompute failed", "job", job.Id, "err", err)
+		job.Status = sql.RecomputeFailed
+		job.Error = err.Error()
+		resetBatch.PurgedOn = job.FinishedOn
+		if _, purgeErr := impl.appReleaseRepository.PurgeResetBatch(ctx, resetBatch); purgeErr != nil {
+			impl.logger.Errorw("error in dropping releases staged by failed recompute", "job", job.Id, "resetBatch", resetBatch.Id, "err", purgeErr)
+		}
+	} else {
+		impl.logger.Infow("recompute done", "job", job.Id, "events", job.EventCount, "releases", job.ReleaseCount, "replaced", job.ReplacedReleaseCount)
+	}
+	if updateErr := impl.recomputeJobRepository.Update(ctx, job); updateErr != nil {
+		impl.logger.Errorw("error in updating recompute job", "job", job.Id, "err", updateErr)
+	}
+}
+
+func (impl *RecomputeServiceImpl) Start() {
+	jobs, err := impl.recomputeJobRepository.FindRunning(impl.ctx, 0, 0)
+	if err != nil {
+		impl.logger.Errorw("error in fetching interrupted recompute jobs", "err", err)
+		return
+	}
+	for _, job := range jobs {
+		resetBatch := &sql.ResetBatch{Id: job.ResetBatchId, AppId: job.AppId, EnvironmentId: job.EnvironmentId, Status: sql.ResetStaged,
+			RequestedBy: job.RequestedBy, Reason: "recompute", CreatedOn: job.CreatedOn}
+		impl.finish(job, resetBatch, fmt.Errorf("interrupted by a restart"))
+	}
+}
+
+func (impl *RecomputeServiceImpl) Stop() {
+	impl.cancel()
+	impl.wg.Wait()
+}
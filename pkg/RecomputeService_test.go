@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/pkg/apperror"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+type replayIngestionService struct {
+	deployments []int
+	statuses    []int
+}
+
+func (s *replayIngestionService) ProcessDeploymentEvent(ctx context.Context, deploymentEvent *DeploymentEvent) (*sql.AppRelease, error) {
+	s.deployments = append(s.deployments, deploymentEvent.PipelineOverrideId)
+	return &sql.AppRelease{}, nil
+}
+
+func (s *replayIngestionService) ProcessStatusEvent(ctx context.Context, statusEvent *DeploymentStatusEvent) (*sql.AppRelease, error) {
+	s.statuses = append(s.statuses, statusEvent.PipelineOverrideId)
+	return nil, pg.ErrNoRows
+}
+
+func (s *replayIngestionService) ReprocessRelease(ctx context.Context, appRelease *sql.AppRelease) (*sql.AppRelease, error) {
+	return appRelease, nil
+}
+
+func TestRecomputeService_ReplayEvent(t *testing.T) {
+	ingestionService := &replayIngestionService{}
+	impl := NewRecomputeServiceImpl(zap.NewNop().Sugar(), ingestionService, nil, nil, nil, nil)
+	rawEvent := func(eventType string, payload interface{}) *sql.RawEvent {
+		b, _ := json.Marshal(payload)
+		return &sql.RawEvent{EventType: eventType, Payload: b}
+	}
+	job := &sql.RecomputeJob{}
+	rawEvents := []*sql.RawEvent{
+		rawEvent(sql.RawEventDeployment, &DeploymentEvent{PipelineOverrideId: 11}),
+		rawEvent(sql.RawEventStatus, &DeploymentStatusEvent{PipelineOverrideId: 11}),
+		rawEvent("unknown", map[string]int{}),
+		rawEvent(sql.RawEventDeployment, &DeploymentEvent{PipelineOverrideId: 12}),
+	}
+	for _, e := range rawEvents {
+		if err := impl.replayEvent(context.Background(), e, job); err != nil {
+			t.Fatalf("replayEvent() error = %v", err)
+		}
+	}
+	if len(ingestionService.deployments) != 2 || ingestionService.deployments[1] != 12 || len(ingestionService.statuses) != 1 {
+		t.Errorf("replayEvent() deployments = %v, statuses = %v", ingestionService.deployments, ingestionService.statuses)
+	}
+	if job.ReleaseCount != 2 {
+		t.Errorf("replayEvent() releases = %d, want 2", job.ReleaseCount)
+	}
+	if err := impl.replayEvent(context.Background(), &sql.RawEvent{EventType: sql.RawEventDeployment, Payload: []byte("{")}, job); err == nil {
+		t.Errorf("replayEvent() of malformed payload error = nil")
+	}
+}
+
+func TestRecomputeService_RecomputeValidation(t *testing.T) {
+	retentionService, err := NewRetentionServiceImpl(zap.NewNop().Sugar(), &RetentionConfig{Enabled: true, RetentionDays: 90, EnvRetentionDays: []string{"2:30"}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impl := NewRecomputeServiceImpl(zap.NewNop().Sugar(), nil, nil, nil, nil, retentionService)
+	now := time.Now()
+	requests := map[string]*RecomputeRequest{
+		"from before environment retention": {AppId: 1, EnvironmentId: 2, From: now.AddDate(0, 0, -60), RequestedBy: "jane"},
+		"from before global retention":      {AppId: 1, EnvironmentId: 1, From: now.AddDate(0, 0, -120), RequestedBy: "jane"},
+		"no app":                            {EnvironmentId: 1, From: now.Add(-time.Hour), RequestedBy: "jane"},
+		"no requester":                      {AppId: 1, EnvironmentId: 1, From: now.Add(-time.Hour)},
+		"no from":                           {AppId: 1, EnvironmentId: 1, RequestedBy: "jane"},
+		"from after to":                     {AppId: 1, EnvironmentId: 1, From: now, To: now.Add(-time.Hour), RequestedBy: "jane"},
+		"from in future":                    {AppId: 1, EnvironmentId: 1, From: now.Add(time.Hour), RequestedBy: "jane"},
+	}
+	for name, request := range requests {
+		if _, err := impl.Recompute(context.Background(), request); apperror.KindOf(err) != apperror.Validation {
+			t.Errorf("Recompute() %s error = %v, want a validation error", name, err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	pubsub "github.com/devtron-labs/common-lib/pubsub-lib"
+	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	// ReleaseProcessedTopic is published once ingestion of a release completes
+	ReleaseProcessedTopic = "LENS.RELEASE-PROCESSED"
+	// lensStream captures the topics lens publishes, common-lib only knows streams of other services
+	lensStream = "LENS"
+)
+
+type ReleaseOutboxConfig struct {
+	PollIntervalSecs        int `env:"RELEASE_OUTBOX_POLL_INTERVAL_SECS" envDefault:"5"`
+	BatchSize               int `env:"RELEASE_OUTBOX_BATCH_SIZE" envDefault:"100"`
+	PublishedRetentionHours int `env:"RELEASE_OUTBOX_PUBLISHED_RETENTION_HOURS" envDefault:"72"`
+}
+
+func GetReleaseOutboxConfig() (*ReleaseOutboxConfig, error) {
+	cfg := &ReleaseOutboxConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// ReleaseEvent describes a release in messages and streams about it
+type ReleaseEvent struct {
+	AppReleaseId       int        `json:"appReleaseId"`
+	AppId              int        `json:"appId"`
+	EnvironmentId      int        `json:"environmentId"`
+	PipelineOverrideId int        `json:"pipelineOverrideId"`
+	CiArtifactId       int        `json:"ciArtifactId"`
+	TriggerTime        time.Time  `json:"triggerTime"`
+	ReleaseType        string     `json:"releaseType"`
+	ReleaseStatus      string     `json:"releaseStatus"`
+	FailureReason      string     `json:"failureReason,omitempty"`
+	ChangeSize         ChangeSize `json:"changeSize"`
+	LeadTimeSecs       *float64   `json:"leadTimeSecs,omitempty"` // not set when the changes of the release are unknown
+}
+
+type ChangeSize struct {
+	LinesAdded   int `json:"linesAdded"`
+	LinesDeleted int `json:"linesDeleted"`
+	CommitCount  int `json:"commitCount"`
+}
+
+// ReleaseProcessedEvent is the payload of ReleaseProcessedTopic, consumers should dedupe on AppReleaseId
+// as a message is published at least once
+type ReleaseProcessedEvent struct {
+	ReleaseEvent
+	ProcessedOn time.Time `json:"processedOn"`
+}
+
+func newReleaseEvent(appRelease *sql.AppRelease) ReleaseEvent {
+	event := ReleaseEvent{
+		AppReleaseId:       appRelease.Id,
+		AppId:              appRelease.AppId,
+		EnvironmentId:      appRelease.EnvironmentId,
+		PipelineOverrideId: appRelease.PipelineOverrideId,
+		CiArtifactId:       appRelease.CiArtifactId,
+		TriggerTime:        appRelease.TriggerTime,
+		ReleaseType:        appRelease.ReleaseType.String(),
+		ReleaseStatus:      appRelease.ReleaseStatus.String(),
+		FailureReason:      string(appRelease.FailureReason),
+		ChangeSize: ChangeSize{
+			LinesAdded:   appRelease.ChangeSizeLineAdded,
+			LinesDeleted: appRelease.ChangeSizeLineDeleted,
+			CommitCount:  appRelease.CommitCount,
+		},
+	}
+	if appRelease.LeadTime != nil {
+		leadTimeSecs := appRelease.LeadTime.LeadTime.Seconds()
+		event.LeadTimeSecs = &leadTimeSecs
+	}
+	return event
+}
+
+// newReleaseProcessedMessage builds the outbox message announcing a processed release
+func newReleaseProcessedMessage(appRelease *sql.AppRelease, now time.Time) (*sql.ReleaseOutboxMessage, error) {
+	payload, err := json.Marshal(&ReleaseProcessedEvent{ReleaseEvent: newReleaseEvent(appRelease), ProcessedOn: now})
+	if err != nil {
+		return nil, err
+	}
+	return &sql.ReleaseOutboxMessage{
+		AppReleaseId: appRelease.Id,
+		Topic:        ReleaseProcessedTopic,
+		Payload:      string(payload),
+		CreatedOn:    now,
+	}, nil
+}
+
+type ReleaseOutboxService interface {
+	Start()
+	Stop()
+	// PublishPending publishes outbox messages in order until one fails, returns the published count
+	PublishPending(ctx context.Context) (int, error)
+}
+
+type publishFunc func(topic string, msg string) error
+
+type ReleaseOutboxServiceImpl struct {
+	logger                  *zap.SugaredLogger
+	config                  *ReleaseOutboxConfig
+	releaseOutboxRepository sql.ReleaseOutboxRepository
+	publish                 publishFunc
+	ensureStream            func() error
+	ctx                     context.Context
+	cancel                  context.CancelFunc
+	wg                      sync.WaitGroup
+}
+
+func NewReleaseOutboxServiceImpl(logger *zap.SugaredLogger,
+	config *ReleaseOutboxConfig,
+	releaseOutboxRepository sql.ReleaseOutboxRepository,
+	pubSubClient *pubsub.PubSubClientServiceImpl) *ReleaseOutboxServiceImpl {
+	ensureStream := func() error {
+		if pubSubClient.NatsClient == nil {
+			return nil
+		}
+		js := pubSubClient.NatsClient.JetStrCtxt
+		_, err := js.StreamInfo(lensStream)
+		if err == nats.ErrStreamNotFound {
+			_, err = js.AddStream(&nats.StreamConfig{Name: lensStream, Subjects: []string{lensStream + ".>"}})
+		}
+		return err
+	}
+	return newReleaseOutboxServiceImpl(logger, config, releaseOutboxRepository, pubSubClient.Publish, ensureStream)
+}
+
+func newReleaseOutboxServiceImpl(logger *zap.SugaredLogger, config *ReleaseOutboxConfig, releaseOutboxRepository sql.ReleaseOutboxRepository,
+	publish publishFunc, ensureStream func() error) *ReleaseOutboxServiceImpl {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ReleaseOutboxServiceImpl{
+		logger:                  logger,
+		config:                  config,
+		releaseOutboxRepository: releaseOutboxRepository,
+		publish:                 publish,
+		ensureStream:            ensureStream,
+		ctx:                     ctx,
+		cancel:                  cancel,
+	}
+}
+
+func (impl *ReleaseOutboxServiceImpl) Start() {
+	if err := impl.ensureStream(); err != nil {
+		// publishing fails until the stream exists, messages stay in the outbox meanwhile
+		impl.logger.Errorw("error in creating lens stream", "stream", lensStream, "err", err)
+	}
+	impl.wg.Add(1)
+	go func() {
+		defer impl.wg.Done()
+		ticker := time.NewTicker(time.Duration(impl.config.PollIntervalSecs) * time.Second)
+		defer ticker.Stop()
+		for {
+			if _, err := impl.PublishPending(impl.ctx); err != nil {
+				impl.logger.Warnw("error in publishing release outbox, retrying on next poll", "err", err)
+			}
+			impl.deletePublished()
+			select {
+			case <-impl.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (impl *ReleaseOutboxServiceImpl) Stop() {
+	impl.cancel()
+	impl.wg.Wait()
+}
+
+func (impl *ReleaseOutboxServiceImpl) PublishPending(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		messages, err := impl.releaseOutboxRepository.FindUnpublished(ctx, impl.config.BatchSize)
+		if err != nil {
+			impl.logger.Errorw("error in fetching release outbox", "err", err)
+			return published, err
+		}
+		for _, message := range messages {
+			if ctx.Err() != nil {
+				return published, nil
+			}
+			message.Attempts++
+			err = impl.publish(message.Topic, message.Payload)
+			if err != nil {
+				// later messages wait so that consumers see releases in order
+				message.LastError = err.Error()
+				if updateErr := impl.releaseOutboxRepository.Update(ctx, message); updateErr != nil {
+					impl.logger.Errorw("error in updating release outbox message", "id", message.Id, "err", updateErr)
+				}
+				return published, err
+			}
+			message.PublishedOn = time.Now()
+			message.LastError = ""
+			err = impl.releaseOutboxRepository.Update(ctx, message)
+			if err != nil {
+				// the message is published again on the next poll
+				impl.logger.Errorw("error in marking release outbox message published", "id", message.Id, "err", err)
+				return published, err
+			}
+			published++
+		}
+		if len(messages) < impl.config.BatchSize {
+			return published, nil
+		}
+	}
+}
+
+func (impl *ReleaseOutboxServiceImpl) deletePublished() {
+	if impl.config.PublishedRetentionHours <= 0 {
+		return
+	}
+	before := time.Now().Add(-time.Duration(impl.config.PublishedRetentionHours) * time.Hour)
+	deleted, err := impl.releaseOutboxRepository.DeletePublishedBefore(impl.ctx, before)
+	if err != nil {
+		impl.logger.Errorw("error in deleting published release outbox messages", "err", err)
+	} else if deleted > 0 {
+		impl.logger.Debugw("published release outbox messages deleted", "count", deleted)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/lens/internal/sql"
+	"go.uber.org/zap"
+)
+
+type fakeReleaseOutboxRepository struct {
+	sql.ReleaseOutboxRepository
+	messages []*sql.ReleaseOutboxMessage
+}
+
+func (f *fakeReleaseOutboxRepository) FindUnpublished(ctx context.Context, limit int) ([]*sql.ReleaseOutboxMessage, error) {
+	var unpublished []*sql.ReleaseOutboxMessage
+	for _, message := range f.messages {
+		if message.PublishedOn.IsZero() && len(unpublished) < limit {
+			unpublished = append(unpublished, message)
+		}
+	}
+	return unpublished, nil
+}
+
+func (f *fakeReleaseOutboxRepository) Update(ctx context.Context, message *sql.ReleaseOutboxMessage) error {
+	return nil
+}
+
+func TestReleaseOutboxService_PublishPending(t *testing.T) {
+	repository := &fakeReleaseOutboxRepository{}
+	for i := 1; i <= 5; i++ {
+		repository.messages = append(repository.messages, &sql.ReleaseOutboxMessage{Id: int64(i), Topic: ReleaseProcessedTopic, Payload: fmt.Sprint(i)})
+	}
+	var published []string
+	failing := "4"
+	publish := func(topic string, msg string) error {
+		if msg == failing {
+			return fmt.Errorf("nats unavailable")
+		}
+		published = append(published, msg)
+		return nil
+	}
+	impl := newReleaseOutboxServiceImpl(zap.NewNop().Sugar(), &ReleaseOutboxConfig{BatchSize: 2}, repository, publish, func() error { return nil })
+
+	count, err := impl.PublishPending(context.Background())
+	if err == nil || count != 3 {
+		t.Fatalf("PublishPending() = %d, %v, want 3 and the publish error", count, err)
+	}
+	if failed := repository.messages[3]; failed.Attempts != 1 || failed.LastError == "" || !failed.PublishedOn.IsZero() {
+		t.Errorf("PublishPending() failed message = %+v", failed)
+	}
+	if !repository.messages[4].PublishedOn.IsZero() {
+		t.Errorf("PublishPending() published a message after a failed one")
+	}
+
+	failing = ""
+	count, err = impl.PublishPending(context.Background())
+	if err != nil || count != 2 {
+		t.Fatalf("PublishPending() retry = %d, %v, want 2", count, err)
+	}
+	if want := "[1 2 3 4 5]"; fmt.Sprint(published) != want {
+		t.Errorf("PublishPending() order = %v, want %v", published, want)
+	}
+}
+
+func TestNewReleaseProcessedMessage(t *testing.T) {
+	now := time.Now()
+	appRelease := &sql.AppRelease{Id: 9, AppId: 1, EnvironmentId: 2, ReleaseType: sql.Patch, ChangeSizeLineAdded: 10, CommitCount: 2}
+	message, err := newReleaseProcessedMessage(appRelease, now)
+	if err != nil {
+		t.Fatalf("newReleaseProcessedMessage() error = %v", err)
+	}
+	event := &ReleaseProcessedEvent{}
+	if err = json.Unmarshal([]byte(message.Payload), event); err != nil {
+		t.Fatalf("newReleaseProcessedMessage() payload error = %v", err)
+	}
+	if message.AppReleaseId != 9 || event.ReleaseType != "Patch" || event.ChangeSize.LinesAdded != 10 || event.LeadTimeSecs != nil {
+		t.Errorf("newReleaseProcessedMessage() = %+v, event %+v", message, event)
+	}
+	appRelease.LeadTime = &sql.LeadTime{LeadTime: 90 * time.Minute}
+	message, _ = newReleaseProcessedMessage(appRelease, now)
+	_ = json.Unmarshal([]byte(message.Payload), event)
+	if event.LeadTimeSecs == nil || *event.LeadTimeSecs != 5400 {
+		t.Errorf("newReleaseProcessedMessage() lead time = %v, want 5400", event.LeadTimeSecs)
+	}
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/pkg/apperror"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// ReleaseUpdateRequest corrects a release, nil fields are left unchanged
+type ReleaseUpdateRequest struct {
+	AppReleaseId        int     `json:"-"`
+	ReleaseStatus       *string `json:"releaseStatus"`
+	ReleaseType         *string `json:"releaseType"`
+	ExcludedFromMetrics *bool   `json:"excludedFromMetrics"`
+	Actor               string  `json:"actor"`
+	Reason              string  `json:"reason"`
+}
+
+type ReleaseService interface {
+	// UpdateRelease overrides status, type and exclusion of a release, the override outlives any reprocessing
+	UpdateRelease(ctx context.Context, request *ReleaseUpdateRequest) (*sql.AppRelease, error)
+	GetRelease(ctx context.Context, appReleaseId int) (*sql.AppRelease, error)
+	GetReleaseAudits(ctx context.Context, appReleaseId int) ([]*sql.ReleaseAudit, error)
+	// GetTimeline returns stage transitions, status changes, reclassifications and failed stages of a release, oldest first
+	GetTimeline(ctx context.Context, appReleaseId int) ([]*sql.ReleaseStageHistory, error)
+	// SaveTags adds tags to a release, replacing the value of existing keys
+	SaveTags(ctx context.Context, appReleaseId int, tags map[string]string) (map[string]string, error)
+	DeleteTag(ctx context.Context, appReleaseId int, key string) error
+	GetTags(ctx context.Context, appReleaseId int) (map[string]string, error)
+	AddAnnotation(ctx context.Context, request *AnnotationRequest) (*sql.ReleaseAnnotation, error)
+	GetAnnotations(ctx context.Context, appReleaseId int) ([]*sql.ReleaseAnnotation, error)
+}
+
+type AnnotationRequest struct {
+	AppReleaseId int    `json:"-"`
+	Text         string `json:"text"`
+	CreatedBy    string `json:"createdBy"`
+}
+
+const (
+	maxTagKeyLength   = 100
+	maxTagValueLength = 250
+)
+
+type ReleaseServiceImpl struct {
+	logger                        *zap.SugaredLogger
+	appReleaseRepository          sql.AppReleaseRepository
+	releaseOverrideRepository     sql.ReleaseOverrideRepository
+	releaseTagRepository          sql.ReleaseTagRepository
+	releaseAnnotationRepository   sql.ReleaseAnnotationRepository
+	releaseStageHistoryRepository sql.ReleaseStageHistoryRepository
+}
+
+func NewReleaseServiceImpl(logger *zap.SugaredLogger,
+	appReleaseRepository sql.AppReleaseRepository,
+	releaseOverrideRepository sql.ReleaseOverrideRepository,
+	releaseTagRepository sql.ReleaseTagRepository,
+	releaseAnnotationRepository sql.ReleaseAnnotationRepository,
+	releaseStageHistoryRepository sql.ReleaseStageHistoryRepository) *ReleaseServiceImpl {
+	return &ReleaseServiceImpl{
+		logger:                        logger,
+		appReleaseRepository:          appReleaseRepository,
+		releaseOverrideRepository:     releaseOverrideRepository,
+		releaseTagRepository:          releaseTagRepository,
+		releaseAnnotationRepository:   releaseAnnotationRepository,
+		releaseStageHistoryRepository: releaseStageHistoryRepository,
+	}
+}
+
+func (impl *ReleaseServiceImpl) UpdateRelease(ctx context.Context, request *ReleaseUpdateRequest) (*sql.AppRelease, error) {
+	if request.Actor == "" || request.Reason == "" {
+		return nil, apperror.Validationf("actor and reason are required")
+	}
+	if request.ReleaseStatus == nil && request.ReleaseType == nil && request.ExcludedFromMetrics == nil {
+		return nil, apperror.Validationf("nothing to update")
+	}
+	appRelease, err := impl.appReleaseRepository.FindById(ctx, request.AppReleaseId)
+	if err != nil {
+		impl.logger.Errorw("error in fetching release", "id", request.AppReleaseId, "err", err)
+		return nil, err
+	}
+	override, err := impl.releaseOverrideRepository.FindByTrigger(ctx, appRelease.AppId, appRelease.EnvironmentId, appRelease.PipelineOverrideId)
+	if err == pg.ErrNoRows {
+		override = &sql.ReleaseOverride{
+			AppId:              appRelease.AppId,
+			EnvironmentId:      appRelease.EnvironmentId,
+			PipelineOverrideId: appRelease.PipelineOverrideId,
+		}
+	} else if err != nil {
+		impl.logger.Errorw("error in fetching release override", "appRelease", appRelease.Id, "err", err)
+		return nil, err
+	}
+	now := time.Now()
+	var audits []*sql.ReleaseAudit
+	addAudit := func(field, oldValue, newValue string) {
+		if oldValue == newValue {
+			return
+		}
+		audits = append(audits, &sql.ReleaseAudit{
+			AppReleaseId:       appRelease.Id,
+			AppId:              appRelease.AppId,
+			EnvironmentId:      appRelease.EnvironmentId,
+			PipelineOverrideId: appRelease.PipelineOverrideId,
+			Field:              field,
+			OldValue:           oldValue,
+			NewValue:           newValue,
+			Actor:              request.Actor,
+			Reason:             request.Reason,
+			CreatedOn:          now,
+		})
+	}
+	if request.ReleaseStatus != nil {
+		releaseStatus, err := sql.ParseReleaseStatus(*request.ReleaseStatus)
+		if err != nil {
+			return nil, apperror.Validationf("%v", err)
+		}
+		addAudit("release_status", appRelease.ReleaseStatus.String(), releaseStatus.String())
+		override.ReleaseStatus = &releaseStatus
+	}
+	if request.ReleaseType != nil {
+		releaseType, err := sql.ParseReleaseType(*request.ReleaseType)
+		if err != nil {
+			return nil, apperror.Validationf("%v", err)
+		}
+		addAudit("release_type", appRelease.ReleaseType.String(), releaseType.String())
+		override.ReleaseType = &releaseType
+	}
+	if request.ExcludedFromMetrics != nil {
+		addAudit("excluded_from_metrics", strconv.FormatBool(appRelease.ExcludedFromMetrics), strconv.FormatBool(*request.ExcludedFromMetrics))
+		override.ExcludedFromMetrics = request.ExcludedFromMetrics
+	}
+	override.UpdatedBy = request.Actor
+	override.UpdatedOn = now
+	applyReleaseOverride(appRelease, override)
+	appRelease.UpdatedTime = now
+	err = impl.releaseOverrideRepository.SaveOverride(ctx, appRelease, override, audits)
+	if err != nil {
+		impl.logger.Errorw("error in saving release override", "appRelease", appRelease.Id, "err", err)
+		return nil, err
+	}
+	impl.logger.Infow("release overridden", "appRelease", appRelease.Id, "actor", request.Actor, "changes", len(audits))
+	return appRelease, nil
+}
+
+func (impl *ReleaseServiceImpl) GetRelease(ctx context.Context, appReleaseId int) (*sql.AppRelease, error) {
+	return impl.appReleaseRepository.FindById(ctx, appReleaseId)
+}
+
+func (impl *ReleaseServiceImpl) GetReleaseAudits(ctx context.Context, appReleaseId int) ([]*sql.ReleaseAudit, error) {
+	return impl.releaseOverrideRepository.FindAuditsByAppReleaseId(ctx, appReleaseId)
+}
+
+func (impl *ReleaseServiceImpl) GetTimeline(ctx context.Context, appReleaseId int) ([]*sql.ReleaseStageHistory, error) {
+	_, err := impl.appReleaseRepository.FindById(ctx, appReleaseId)
+	if err != nil {
+		impl.logger.Errorw("error in fetching release", "id", appReleaseId, "err", err)
+		return nil, err
+	}
+	return impl.releaseStageHistoryRepository.FindByAppReleaseId(ctx, appReleaseId)
+}
+
+func (impl *ReleaseServiceImpl) SaveTags(ctx context.Context, appReleaseId int, tags map[string]string) (map[string]string, error) {
+	err := validateTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	_, err = impl.appReleaseRepository.FindById(ctx, appReleaseId)
+	if err != nil {
+		impl.logger.Errorw("error in fetching release", "id", appReleaseId, "err", err)
+		return nil, err
+	}
+	err = impl.releaseTagRepository.Save(ctx, toReleaseTags(appReleaseId, tags)...)
+	if err != nil {
+		impl.logger.Errorw("error in saving release tags", "appReleaseId", appReleaseId, "tags", tags, "err", err)
+		return nil, err
+	}
+	return impl.GetTags(ctx, appReleaseId)
+}
+
+func (impl *ReleaseServiceImpl) DeleteTag(ctx context.Context, appReleaseId int, key string) error {
+	err := impl.releaseTagRepository.Delete(ctx, appReleaseId, key)
+	if err != nil {
+		impl.logger.Errorw("error in deleting release tag", "appReleaseId", appReleaseId, "key", key, "err", err)
+	}
+	return err
+}
+
+func (impl *ReleaseServiceImpl) GetTags(ctx context.Context, appReleaseId int) (map[string]string, error) {
+	releaseTags, err := impl.releaseTagRepository.FindByAppReleaseIds(ctx, []int{appReleaseId})
+	if err != nil {
+		impl.logger.Errorw("error in fetching release tags", "appReleaseId", appReleaseId, "err", err)
+		return nil, err
+	}
+	tags := groupTagsByRelease(releaseTags)[appReleaseId]
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	return tags, nil
+}
+
+func (impl *ReleaseServiceImpl) AddAnnotation(ctx context.Context, request *AnnotationRequest) (*sql.ReleaseAnnotation, error) {
+	if request.Text == "" || request.CreatedBy == "" {
+		return nil, apperror.Validationf("text and createdBy are required")
+	}
+	_, err := impl.appReleaseRepository.FindById(ctx, request.AppReleaseId)
+	if err != nil {
+		impl.logger.Errorw("error in fetching release", "id", request.AppReleaseId, "err", err)
+		return nil, err
+	}
+	annotation := &sql.ReleaseAnnotation{
+		AppReleaseId: request.AppReleaseId,
+		Text:         request.Text,
+		CreatedBy:    request.CreatedBy,
+		CreatedOn:    time.Now(),
+	}
+	err = impl.releaseAnnotationRepository.Save(ctx, annotation)
+	if err != nil {
+		impl.logger.Errorw("error in saving release annotation", "annotation", annotation, "err", err)
+		return nil, err
+	}
+	return annotation, nil
+}
+
+func (impl *ReleaseServiceImpl) GetAnnotations(ctx context.Context, appReleaseId int) ([]*sql.ReleaseAnnotation, error) {
+	return impl.releaseAnnotationRepository.FindByAppReleaseId(ctx, appReleaseId)
+}
+
+func validateTags(tags map[string]string) error {
+	for key, value := range tags {
+		if key == "" || len(key) > maxTagKeyLength {
+			return apperror.Validationf("tag key %q must be 1 to %d characters", key, maxTagKeyLength)
+		}
+		if len(value) > maxTagValueLength {
+			return apperror.Validationf("value of tag %q must be at most %d characters", key, maxTagValueLength)
+		}
+	}
+	return nil
+}
+
+func toReleaseTags(appReleaseId int, tags map[string]string) []*sql.ReleaseTag {
+	releaseTags := make([]*sql.ReleaseTag, 0, len(tags))
+	for key, value := range tags {
+		releaseTags = append(releaseTags, &sql.ReleaseTag{AppReleaseId: appReleaseId, Key: key, Value: value})
+	}
+	return releaseTags
+}
+
+func groupTagsByRelease(releaseTags []*sql.ReleaseTag) map[int]map[string]string {
+	tagsByRelease := make(map[int]map[string]string)
+	for _, releaseTag := range releaseTags {
+		if _, ok := tagsByRelease[releaseTag.AppReleaseId]; !ok {
+			tagsByRelease[releaseTag.AppReleaseId] = make(map[string]string)
+		}
+		tagsByRelease[releaseTag.AppReleaseId][releaseTag.Key] = releaseTag.Value
+	}
+	return tagsByRelease
+}
+
+// applyReleaseOverride sets the overridden fields on appRelease
+func applyReleaseOverride(appRelease *sql.AppRelease, override *sql.ReleaseOverride) {
+	if override == nil {
+		return
+	}
+	if override.ReleaseStatus != nil {
+		appRelease.ReleaseStatus = *override.ReleaseStatus
+	}
+	if override.ReleaseType != nil {
+		appRelease.ReleaseType = *override.ReleaseType
+	}
+	if override.ExcludedFromMetrics != nil {
+		appRelease.ExcludedFromMetrics = *override.ExcludedFromMetrics
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/pkg/apperror"
+	"go.uber.org/zap"
+)
+
+type ReleaseStreamConfig struct {
+	// BufferSize is the number of recent events kept for clients resuming with Last-Event-ID
+	BufferSize    int `env:"RELEASE_STREAM_BUFFER_SIZE" envDefault:"1000"`
+	HeartbeatSecs int `env:"RELEASE_STREAM_HEARTBEAT_SECS" envDefault:"15"`
+	// SubscriberBufferSize is the number of events a slow client may lag behind before it is disconnected
+	SubscriberBufferSize int `env:"RELEASE_STREAM_SUBSCRIBER_BUFFER_SIZE" envDefault:"100"`
+	MaxSubscribers       int `env:"RELEASE_STREAM_MAX_SUBSCRIBERS" envDefault:"100"`
+}
+
+func GetReleaseStreamConfig() (*ReleaseStreamConfig, error) {
+	cfg := &ReleaseStreamConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// release stream event types
+const (
+	ReleaseCreated   = "created"
+	ReleaseUpdated   = "updated"
+	ReleaseProcessed = "processed"
+)
+
+type ReleaseStreamEvent struct {
+	Id   string
+	Type string
+	Data []byte
+	// appId and environmentId of the release for filtering
+	appId         int
+	environmentId int
+}
+
+// ReleaseStreamFilter selects releases of an app and/or environment, 0 matches all
+type ReleaseStreamFilter struct {
+	AppId         int
+	EnvironmentId int
+}
+
+func (filter ReleaseStreamFilter) matches(event *ReleaseStreamEvent) bool {
+	return (filter.AppId == 0 || filter.AppId == event.appId) &&
+		(filter.EnvironmentId == 0 || filter.EnvironmentId == event.environmentId)
+}
+
+type ReleaseStreamSubscription struct {
+	// Reset is set when the events after the requested Last-Event-ID are no longer known, the client should reload
+	Reset bool
+	// Replay holds the buffered events after Last-Event-ID
+	Replay []*ReleaseStreamEvent
+	// Events is closed when the subscriber lags too far behind, the client resumes by reconnecting
+	Events <-chan *ReleaseStreamEvent
+	events chan *ReleaseStreamEvent
+	filter ReleaseStreamFilter
+}
+
+type ReleaseStreamService interface {
+	// Publish sends the current state of a release to subscribers
+	Publish(eventType string, appRelease *sql.AppRelease)
+	// Subscribe streams events matching filter from the one after lastEventId on, all new ones if empty
+	Subscribe(filter ReleaseStreamFilter, lastEventId string) (*ReleaseStreamSubscription, error)
+	Unsubscribe(subscription *ReleaseStreamSubscription)
+	HeartbeatInterval() time.Duration
+	// Close ends all streams and refuses new ones, streams would otherwise hold up server shutdown
+	Close()
+}
+
+// ReleaseStreamServiceImpl fans out release events of this instance to its subscribers. Event ids carry the start
+// of the instance so that a client resuming on another instance or after a restart is told to reload.
+type ReleaseStreamServiceImpl struct {
+	logger      *zap.SugaredLogger
+	config      *ReleaseStreamConfig
+	epoch       string
+	mu          sync.Mutex
+	seq         int64
+	buffer      []*ReleaseStreamEvent // ring of the last BufferSize events
+	subscribers map[*ReleaseStreamSubscription]struct{}
+	closed      bool
+}
+
+func NewReleaseStreamServiceImpl(logger *zap.SugaredLogger, config *ReleaseStreamConfig) *ReleaseStreamServiceImpl {
+	return &ReleaseStreamServiceImpl{
+		logger:      logger,
+		config:      config,
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		buffer:      make([]*ReleaseStreamEvent, 0, config.BufferSize),
+		subscribers: make(map[*ReleaseStreamSubscription]struct{}),
+	}
+}
+
+func (impl *ReleaseStreamServiceImpl) Publish(eventType string, appRelease *sql.AppRelease) {
+	data, err := json.Marshal(newReleaseEvent(appRelease))
+	if err != nil {
+		impl.logger.Errorw("error in marshalling release stream event", "appRelease", appRelease.Id, "err", err)
+		return
+	}
+	impl.mu.Lock()
+	defer impl.mu.Unlock()
+	impl.seq++
+	event := &ReleaseStreamEvent{
+		Id:            impl.eventId(impl.seq),
+		Type:          eventType,
+		Data:          data,
+		appId:         appRelease.AppId,
+		environmentId: appRelease.EnvironmentId,
+	}
+	if impl.config.BufferSize > 0 {
+		if len(impl.buffer) < impl.config.BufferSize {
+			impl.buffer = append(impl.buffer, event)
+		} else {
+			impl.buffer[(impl.seq-1)%int64(impl.config.BufferSize)] = event
+		}
+	}
+	for subscription := range impl.subscribers {
+		if !subscription.filter.matches(event) {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			// never block ingestion on a slow client
+			impl.logger.Warnw("release stream subscriber lagging, disconnecting", "filter", subscription.filter)
+			impl.remove(subscription)
+		}
+	}
+}
+
+func (impl *ReleaseStreamServiceImpl) Subscribe(filter ReleaseStreamFilter, lastEventId string) (*ReleaseStreamSubscription, error) {
+	impl.mu.Lock()
+	defer impl.mu.Unlock()
+	if impl.closed {
+		return nil, apperror.UpstreamUnavailablef("release stream closed")
+	}
+	if impl.config.MaxSubscribers > 0 && len(impl.subscribers) >= impl.config.MaxSubscribers {
+		return nil, apperror.UpstreamUnavailablef("too many release stream subscribers")
+	}
+	events := make(chan *ReleaseStreamEvent, impl.config.SubscriberBufferSize)
+	subscription := &ReleaseStreamSubscription{Events: events, events: events, filter: filter}
+	if lastEventId != "" {
+		subscription.Replay, subscription.Reset = impl.eventsAfter(lastEventId)
+		for i := 0; i < len(subscription.Replay); {
+			if filter.matches(subscription.Replay[i]) {
+				i++
+			} else {
+				subscription.Replay = append(subscription.Replay[:i], subscription.Replay[i+1:]...)
+			}
+		}
+	}
+	impl.subscribers[subscription] = struct{}{}
+	return subscription, nil
+}
+
+func (impl *ReleaseStreamServiceImpl) Unsubscribe(subscription *ReleaseStreamSubscription) {
+	impl.mu.Lock()
+	defer impl.mu.Unlock()
+	impl.remove(subscription)
+}
+
+func (impl *ReleaseStreamServiceImpl) HeartbeatInterval() time.Duration {
+	if impl.config.HeartbeatSecs <= 0 {
+		return 15 * time.Second
+	}
+	return time.Duration(impl.config.HeartbeatSecs) * time.Second
+}
+
+func (impl *ReleaseStreamServiceImpl) Close() {
+	impl.mu.Lock()
+	defer impl.mu.Unlock()
+	impl.closed = true
+	for subscription := range impl.subscribers {
+		impl.remove(subscription)
+	}
+}
+
+func (impl *ReleaseStreamServiceImpl) remove(subscription *ReleaseStreamSubscription) {
+	if _, ok := impl.subscribers[subscription]; ok {
+		delete(impl.subscribers, subscription)
+		close(subscription.events)
+	}
+}
+
+func (impl *ReleaseStreamServiceImpl) eventId(seq int64) string {
+	return impl.epoch + "-" + strconv.FormatInt(seq, 10)
+}
+
+// eventsAfter returns the buffered events after lastEventId, reset when some of them are no longer buffered
+func (impl *ReleaseStreamServiceImpl) eventsAfter(lastEventId string) ([]*ReleaseStreamEvent, bool) {
+	epoch, seqText, ok := strings.Cut(lastEventId, "-")
+	seq, err := strconv.ParseInt(seqText, 10, 64)
+	if !ok || err != nil || epoch != impl.epoch || seq > impl.seq {
+		return nil, true
+	}
+	oldest := impl.seq - int64(len(impl.buffer)) + 1
+	if seq < oldest-1 {
+		return nil, true
+	}
+	var events []*ReleaseStreamEvent
+	for next := seq + 1; next <= impl.seq; next++ {
+		events = append(events, impl.buffer[(next-1)%int64(impl.config.BufferSize)])
+	}
+	return events, false
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"testing"
+
+	"github.com/devtron-labs/lens/internal/sql"
+	"go.uber.org/zap"
+)
+
+func TestReleaseStreamService(t *testing.T) {
+	impl := NewReleaseStreamServiceImpl(zap.NewNop().Sugar(), &ReleaseStreamConfig{BufferSize: 3, SubscriberBufferSize: 1})
+	live, err := impl.Subscribe(ReleaseStreamFilter{AppId: 1}, "")
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	var ids []string
+	for i, appId := range []int{1, 2, 1, 2} {
+		impl.Publish(ReleaseCreated, &sql.AppRelease{Id: i + 1, AppId: appId, EnvironmentId: 5})
+		ids = append(ids, impl.eventId(int64(i+1)))
+	}
+	if event := <-live.Events; event.Id != ids[0] || event.Type != ReleaseCreated {
+		t.Errorf("Subscribe() first event = %+v, want %s", event, ids[0])
+	}
+	// the third event found the subscriber buffer full
+	if _, ok := <-live.Events; ok {
+		t.Errorf("Subscribe() lagging subscriber not disconnected")
+	}
+
+	resumed, _ := impl.Subscribe(ReleaseStreamFilter{EnvironmentId: 5}, ids[1])
+	if resumed.Reset || len(resumed.Replay) != 2 || resumed.Replay[0].Id != ids[2] || resumed.Replay[1].Id != ids[3] {
+		t.Errorf("Subscribe() resumed = %+v, want events 3 and 4", resumed)
+	}
+	filtered, _ := impl.Subscribe(ReleaseStreamFilter{AppId: 2}, ids[1])
+	if len(filtered.Replay) != 1 || filtered.Replay[0].Id != ids[3] {
+		t.Errorf("Subscribe() filtered replay = %+v, want event 4", filtered.Replay)
+	}
+	for name, lastEventId := range map[string]string{"evicted": ids[0][:len(ids[0])-1] + "0", "other instance": "x-2", "malformed": "4"} {
+		subscription, _ := impl.Subscribe(ReleaseStreamFilter{}, lastEventId)
+		if !subscription.Reset || len(subscription.Replay) != 0 {
+			t.Errorf("Subscribe() %s = %+v, want reset", name, subscription)
+		}
+	}
+
+	impl.Close()
+	if _, ok := <-resumed.Events; ok {
+		t.Errorf("Close() left stream open")
+	}
+	if _, err = impl.Subscribe(ReleaseStreamFilter{}, ""); err == nil {
+		t.Errorf("Subscribe() after Close() error = nil")
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/lens/internal/sql"
+	"go.uber.org/zap"
+)
+
+type ReprocessConfig struct {
+	IntervalSecs int `env:"INGESTION_REPROCESS_INTERVAL_SECS" envDefault:"300"`
+	// DelaySecs is how long a release stays untouched before it is reprocessed, longer than INGESTION_TIMEOUT_SECS
+	// so that an ingestion still running is not taken over
+	DelaySecs int `env:"INGESTION_REPROCESS_DELAY_SECS" envDefault:"600"`
+	// MaxAgeHours stops reprocessing releases created that long ago, they keep no lead time and change size
+	MaxAgeHours int `env:"INGESTION_REPROCESS_MAX_AGE_HOURS" envDefault:"72"`
+	BatchSize   int `env:"INGESTION_REPROCESS_BATCH_SIZE" envDefault:"100"`
+}
+
+func GetReprocessConfig() (*ReprocessConfig, error) {
+	cfg := &ReprocessConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// ReprocessService completes the ingestion of releases whose deployment event was given up on after their type
+// was determined, mostly releases whose changes could not be fetched while git-sensor was unavailable. Releases
+// stopped before have no materials to resume from and wait for their event to be delivered again.
+type ReprocessService interface {
+	// ReprocessStalledReleases reprocesses one batch of stalled releases, returns how many were processed
+	ReprocessStalledReleases(ctx context.Context) (int, error)
+	Start()
+	Stop()
+}
+
+type ReprocessServiceImpl struct {
+	logger               *zap.SugaredLogger
+	config               *ReprocessConfig
+	appReleaseRepository sql.AppReleaseRepository
+	ingestionService     IngestionService
+	ctx                  context.Context
+	cancel               context.CancelFunc
+	wg                   sync.WaitGroup
+}
+
+func NewReprocessServiceImpl(logger *zap.SugaredLogger,
+	config *ReprocessConfig,
+	appReleaseRepository sql.AppReleaseRepository,
+	ingestionService IngestionService) *ReprocessServiceImpl {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ReprocessServiceImpl{
+		logger:               logger,
+		config:               config,
+		appReleaseRepository: appReleaseRepository,
+		ingestionService:     ingestionService,
+		ctx:                  ctx,
+		cancel:               cancel,
+	}
+}
+
+func (impl *ReprocessServiceImpl) ReprocessStalledReleases(ctx context.Context) (int, error) {
+	now := time.Now()
+	appReleases, err := impl.appReleaseRepository.FindStalledReleases(ctx,
+		now.Add(-time.Duration(impl.config.DelaySecs)*time.Second),
+		now.Add(-time.Duration(impl.config.MaxAgeHours)*time.Hour),
+		impl.config.BatchSize)
+	if err != nil {
+		impl.logger.Errorw("error in fetching stalled releases", "err", err)
+		return 0, err
+	}
+	processed := 0
+	for _, appRelease := range appReleases {
+		if ctx.Err() != nil {
+			return processed, ctx.Err()
+		}
+		_, err = impl.ingestionService.ReprocessRelease(ctx, appRelease)
+		if err != nil {
+			// left for the next round, the failed stage is in the history of the release
+			impl.logger.Warnw("error in reprocessing release", "appRelease", appRelease.Id, "err", err)
+			continue
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+func (impl *ReprocessServiceImpl) Start() {
+	impl.wg.Add(1)
+	go func() {
+		defer impl.wg.Done()
+		ticker := time.NewTicker(time.Duration(impl.config.IntervalSecs) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-impl.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			processed, err := impl.ReprocessStalledReleases(impl.ctx)
+			if err != nil && impl.ctx.Err() == nil {
+				impl.logger.Errorw("error in reprocessing stalled releases", "err", err)
+			} else if processed > 0 {
+				impl.logger.Infow("stalled releases reprocessed", "count", processed)
+			}
+		}
+	}()
+}
+
+func (impl *ReprocessServiceImpl) Stop() {
+	impl.cancel()
+	impl.wg.Wait()
+}
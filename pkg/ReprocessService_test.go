@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/lens/client/gitSensor"
+	"github.com/devtron-labs/lens/internal/sql"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// memoryReleaseRepository keeps releases of one app environment, copies are handed out like rows of a db
+type memoryReleaseRepository struct {
+	sql.AppReleaseRepository
+	releases []sql.AppRelease
+}
+
+func (repo *memoryReleaseRepository) Save(ctx context.Context, appRelease *sql.AppRelease, cause string) (*sql.AppRelease, error) {
+	appRelease.Id = len(repo.releases) + 1
+	repo.releases = append(repo.releases, *appRelease)
+	return appRelease, nil
+}
+
+func (repo *memoryReleaseRepository) Update(ctx context.Context, appRelease *sql.AppRelease, cause string) (*sql.AppRelease, error) {
+	repo.releases[appRelease.Id-1] = *appRelease
+	return appRelease, nil
+}
+
+func (repo *memoryReleaseRepository) MarkProcessed(ctx context.Context, appRelease *sql.AppRelease, message *sql.ReleaseOutboxMessage) error {
+	appRelease.ProcessStage = sql.Processed
+	repo.releases[appRelease.Id-1] = *appRelease
+	return nil
+}
+
+func (repo *memoryReleaseRepository) FindByTrigger(ctx context.Context, appId, environmentId, pipelineOverrideId int) (*sql.AppRelease, error) {
+	for _, appRelease := range repo.releases {
+		if appRelease.PipelineOverrideId == pipelineOverrideId {
+			return &appRelease, nil
+		}
+	}
+	return nil, pg.ErrNoRows
+}
+
+func (repo *memoryReleaseRepository) GetPreviousRelease(ctx context.Context, appId, environmentId int, appReleaseId int) (*sql.AppRelease, error) {
+	if appReleaseId < 2 {
+		return nil, pg.ErrNoRows
+	}
+	previous := repo.releases[appReleaseId-2]
+	return &previous, nil
+}
+
+func (repo *memoryReleaseRepository) CheckDuplicateRelease(ctx context.Context, appId, environmentId, ciArtifactId int) (bool, error) {
+	count := 0
+	for _, appRelease := range repo.releases {
+		if appRelease.CiArtifactId == ciArtifactId {
+			count++
+		}
+	}
+	return count > 1, nil
+}
+
+func (repo *memoryReleaseRepository) FindStalledReleases(ctx context.Context, updatedBefore, createdAfter time.Time, limit int) ([]*sql.AppRelease, error) {
+	var stalled []*sql.AppRelease
+	for _, appRelease := range repo.releases {
+		if appRelease.ProcessStage == sql.ReleaseTypeDetermined || appRelease.ProcessStage == sql.LeadTimeFetch {
+			appRelease := appRelease
+			stalled = append(stalled, &appRelease)
+		}
+	}
+	return stalled, nil
+}
+
+// memoryMaterialRepository refuses a material saved twice for a release like the unique index does
+type memoryMaterialRepository struct {
+	sql.PipelineMaterialRepository
+	materials []sql.PipelineMaterial
+}
+
+func (repo *memoryMaterialRepository) Save(ctx context.Context, pipelineMaterials ...*sql.PipelineMaterial) error {
+	for _, material := range pipelineMaterials {
+		for _, saved := range repo.materials {
+			if saved.AppReleaseId == material.AppReleaseId && saved.PipelineMaterialId == material.PipelineMaterialId {
+				return fmt.Errorf("duplicate material %d of release %d", material.PipelineMaterialId, material.AppReleaseId)
+			}
+		}
+		repo.materials = append(repo.materials, *material)
+	}
+	return nil
+}
+
+func (repo *memoryMaterialRepository) FindByAppReleaseId(ctx context.Context, appReleaseId int) ([]*sql.PipelineMaterial, error) {
+	var materials []*sql.PipelineMaterial
+	for _, material := range repo.materials {
+		if material.AppReleaseId == appReleaseId {
+			material := material
+			materials = append(materials, &material)
+		}
+	}
+	return materials, nil
+}
+
+func (repo *memoryMaterialRepository) UpdateGitChangesStatus(ctx context.Context, pipelineMaterial ...*sql.PipelineMaterial) error {
+	return nil
+}
+
+type memoryLeadTimeRepository struct {
+	sql.LeadTimeRepository
+	leadTimes map[int]*sql.LeadTime
+}
+
+func (repo *memoryLeadTimeRepository) Save(ctx context.Context, leadTime *sql.LeadTime) (*sql.LeadTime, error) {
+	repo.leadTimes[leadTime.AppReleaseId] = leadTime
+	return leadTime, nil
+}
+
+type memoryAnnotationRepository struct {
+	sql.ReleaseAnnotationRepository
+	annotations []*sql.ReleaseAnnotation
+}
+
+func (repo *memoryAnnotationRepository) Save(ctx context.Context, annotations ...*sql.ReleaseAnnotation) error {
+	repo.annotations = append(repo.annotations, annotations...)
+	return nil
+}
+
+func (repo *memoryAnnotationRepository) FindByAppReleaseId(ctx context.Context, appReleaseId int) ([]*sql.ReleaseAnnotation, error) {
+	var annotations []*sql.ReleaseAnnotation
+	for _, annotation := range repo.annotations {
+		if annotation.AppReleaseId == appReleaseId {
+			annotations = append(annotations, annotation)
+		}
+	}
+	return annotations, nil
+}
+
+type nopReleaseOverrideRepository struct {
+	sql.ReleaseOverrideRepository
+}
+
+func (nopReleaseOverrideRepository) FindByTrigger(ctx context.Context, appId, environmentId, pipelineOverrideId int) (*sql.ReleaseOverride, error) {
+	return nil, pg.ErrNoRows
+}
+
+type nopReleaseTagRepository struct {
+	sql.ReleaseTagRepository
+}
+
+func (nopReleaseTagRepository) Save(ctx context.Context, tags ...*sql.ReleaseTag) error {
+	return nil
+}
+
+type nopReleaseStageHistoryRepository struct {
+	sql.ReleaseStageHistoryRepository
+}
+
+func (nopReleaseStageHistoryRepository) Save(ctx context.Context, entries ...*sql.ReleaseStageHistory) error {
+	return nil
+}
+
+type noRulesFailurePolicyService struct {
+	FailurePolicyService
+}
+
+func (noRulesFailurePolicyService) GetPolicy(ctx context.Context, environmentId int) (*sql.FailurePolicy, error) {
+	return &sql.FailurePolicy{}, nil
+}
+
+type nopReleaseStreamService struct {
+	ReleaseStreamService
+}
+
+func (nopReleaseStreamService) Publish(eventType string, appRelease *sql.AppRelease) {}
+
+func TestReprocessStalledReleases(t *testing.T) {
+	releases := &memoryReleaseRepository{releases: []sql.AppRelease{
+		{Id: 1, AppId: 1, EnvironmentId: 1, PipelineOverrideId: 1, CiArtifactId: 10, ProcessStage: sql.Processed, ReleaseType: sql.RollForward},
+	}}
+	materials := &memoryMaterialRepository{materials: []sql.PipelineMaterial{{AppReleaseId: 1, PipelineMaterialId: 1, CommitHash: "old"}}}
+	leadTimes := &memoryLeadTimeRepository{leadTimes: map[int]*sql.LeadTime{}}
+	annotations := &memoryAnnotationRepository{}
+	unavailable := &gitSensor.GitSensorError{Protocol: "grpc", Retryable: true, Err: errors.New("connection refused")}
+	provider := &fakeGitChangesProvider{failing: map[int]error{1: unavailable}}
+	ingestionService := NewIngestionServiceImpl(zap.NewNop().Sugar(), &IngestionConfig{GitFetchParallelism: 1}, releases, materials, leadTimes, nil,
+		nopReleaseOverrideRepository{}, nopReleaseTagRepository{}, annotations, nil, noRulesFailurePolicyService{}, nopReleaseStreamService{}, nopReleaseStageHistoryRepository{})
+	ingestionService.gitChangesProvider = provider
+	reprocessService := NewReprocessServiceImpl(zap.NewNop().Sugar(), &ReprocessConfig{BatchSize: 10}, releases, ingestionService)
+	event := &DeploymentEvent{ApplicationId: 1, EnvironmentId: 1, PipelineOverrideId: 2, CiArtifactId: 11, TriggerTime: time.Now(),
+		PipelineMaterials: []*PipelineMaterialInfo{{PipelineMaterialId: 1, CommitHash: "new"}}, Annotations: []string{"hotfix"}}
+	ctx := context.Background()
+
+	// git-sensor down: the release is kept with its type, twice over as the event is redelivered
+	for delivery := 1; delivery <= 2; delivery++ {
+		if _, err := ingestionService.ProcessDeploymentEvent(ctx, event); !gitSensor.IsRetryLater(err) {
+			t.Fatalf("delivery %d error = %v, want retry later", delivery, err)
+		}
+	}
+	if len(releases.releases) != 2 || len(materials.materials) != 2 || len(annotations.annotations) != 1 {
+		t.Fatalf("saved %d releases, %d materials, %d annotations, want 2, 2 and 1", len(releases.releases), len(materials.materials), len(annotations.annotations))
+	}
+	if stage := releases.releases[1].ProcessStage; stage != sql.ReleaseTypeDetermined {
+		t.Fatalf("stage = %s, want ReleaseTypeDetermined", stage)
+	}
+
+	// still down: the worker leaves the release for its next round
+	processed, err := reprocessService.ReprocessStalledReleases(ctx)
+	if err != nil || processed != 0 {
+		t.Fatalf("reprocessed %d, err %v, want 0 while git-sensor is down", processed, err)
+	}
+
+	delete(provider.failing, 1)
+	processed, err = reprocessService.ReprocessStalledReleases(ctx)
+	if err != nil || processed != 1 {
+		t.Fatalf("reprocessed %d, err %v, want 1", processed, err)
+	}
+	release := releases.releases[1]
+	if release.ProcessStage != sql.Processed || release.ReleaseType != sql.RollForward || release.CommitCount != 1 {
+		t.Errorf("reprocessed release = %s %s with %d commits, want processed roll forward with 1", release.ProcessStage, release.ReleaseType, release.CommitCount)
+	}
+	if leadTimes.leadTimes[release.Id] == nil {
+		t.Errorf("lead time of reprocessed release not saved")
+	}
+
+	// a late redelivery finds the release processed and leaves it as is
+	appRelease, err := ingestionService.ProcessDeploymentEvent(ctx, event)
+	if err != nil || appRelease.Id != release.Id || len(releases.releases) != 2 {
+		t.Errorf("late redelivery = release %v, err %v with %d releases saved", appRelease, err, len(releases.releases))
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/pkg/apperror"
+	"go.uber.org/zap"
+)
+
+type ResetConfig struct {
+	// GracePeriodHours is how long a reset can be restored before its releases are purged
+	GracePeriodHours  int `env:"RESET_GRACE_PERIOD_HOURS" envDefault:"72"`
+	PurgeIntervalMins int `env:"RESET_PURGE_INTERVAL_MINS" envDefault:"60"`
+}
+
+func GetResetConfig() (*ResetConfig, error) {
+	cfg := &ResetConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+type ResetRequest struct {
+	AppId         int    `json:"appId"`
+	EnvironmentId int    `json:"environmentId"`
+	RequestedBy   string `json:"requestedBy"`
+	Reason        string `json:"reason"`
+}
+
+type RestoreRequest struct {
+	ResetBatchId int    `json:"resetBatchId"`
+	RequestedBy  string `json:"requestedBy"`
+}
+
+type ResetService interface {
+	// ResetAppEnvironment soft deletes all releases of an app environment under a new reset batch
+	ResetAppEnvironment(ctx context.Context, request *ResetRequest) (*sql.ResetBatch, error)
+	// RestoreResetBatch brings back the releases of a reset batch within the grace period
+	RestoreResetBatch(ctx context.Context, request *RestoreRequest) (*sql.ResetBatch, error)
+	GetResetBatch(ctx context.Context, resetBatchId int) (*sql.ResetBatch, error)
+	// PurgeExpiredResetBatches hard deletes the releases of reset batches past the grace period
+	PurgeExpiredResetBatches(ctx context.Context) (int, error)
+	Start()
+	Stop()
+}
+
+type ResetServiceImpl struct {
+	logger               *zap.SugaredLogger
+	config               *ResetConfig
+	appReleaseRepository sql.AppReleaseRepository
+	resetBatchRepository sql.ResetBatchRepository
+	ctx                  context.Context
+	cancel               context.CancelFunc
+	wg                   sync.WaitGroup
+}
+
+func NewResetServiceImpl(logger *zap.SugaredLogger,
+	config *ResetConfig,
+	appReleaseRepository sql.AppReleaseRepository,
+	resetBatchRepository sql.ResetBatchRepository) *ResetServiceImpl {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ResetServiceImpl{
+		logger:               logger,
+		config:               config,
+		appReleaseRepository: appReleaseRepository,
+		resetBatchRepository: resetBatchRepository,
+		ctx:                  ctx,
+		cancel:               cancel,
+	}
+}
+
+func (impl *ResetServiceImpl) GetResetBatch(ctx context.Context, resetBatchId int) (*sql.ResetBatch, error) {
+	return impl.resetBatchRepository.FindById(ctx, resetBatchId)
+}
+
+func (impl *ResetServiceImpl) ResetAppEnvironment(ctx context.Context, request *ResetRequest) (*sql.ResetBatch, error) {
+	if request.AppId <= 0 || request.EnvironmentId <= 0 {
+		return nil, apperror.Validationf("appId and environmentId are required")
+	}
+	if request.RequestedBy == "" || request.Reason == "" {
+		return nil, apperror.Validationf("requestedBy and reason are required")
+	}
+	impl.logger.Infow("resetting app data for ", "app", request.AppId, "env", request.EnvironmentId, "requestedBy", request.RequestedBy, "reason", request.Reason)
+	resetBatch := &sql.ResetBatch{
+		AppId:         request.AppId,
+		EnvironmentId: request.EnvironmentId,
+		Status:        sql.ResetActive,
+		RequestedBy:   request.RequestedBy,
+		Reason:        request.Reason,
+		CreatedOn:     time.Now(),
+	}
+	resetBatch, err := impl.appReleaseRepository.SoftDeleteAppDataForEnvironment(ctx, resetBatch)
+	if err != nil {
+		impl.logger.Errorw("error in resetting app data", "request", request, "err", err)
+		return nil, err
+	}
+	impl.logger.Infow("app data reset", "resetBatch", resetBatch)
+	return resetBatch, nil
+}
+
+func (impl *ResetServiceImpl) RestoreResetBatch(ctx context.Context, request *RestoreRequest) (*sql.ResetBatch, error) {
+	if request.RequestedBy == "" {
+		return nil, apperror.Validationf("requestedBy is required")
+	}
+	resetBatch, err := impl.resetBatchRepository.FindById(ctx, request.ResetBatchId)
+	if err != nil {
+		impl.logger.Errorw("error in fetching reset batch", "id", request.ResetBatchId, "err", err)
+		return nil, err
+	}
+	if resetBatch.Status != sql.ResetActive {
+		return nil, apperror.Conflictf("reset batch %d is already %s", resetBatch.Id, resetBatch.Status)
+	}
+	if time.Since(resetBatch.CreatedOn) > impl.gracePeriod() {
+		return nil, apperror.Conflictf("reset batch %d is past the restore grace period", resetBatch.Id)
+	}
+	resetBatch.RestoredBy = request.RequestedBy
+	resetBatch.RestoredOn = time.Now()
+	resetBatch, err = impl.appReleaseRepository.RestoreResetBatch(ctx, resetBatch)
+	if err == sql.ErrResetBatchChanged {
+		return nil, apperror.Conflictf("reset batch %d was restored or purged concurrently", resetBatch.Id)
+	} else if err != nil {
+		impl.logger.Errorw("error in restoring reset batch", "resetBatch", resetBatch, "err", err)
+		return nil, err
+	}
+	impl.logger.Infow("reset batch restored", "resetBatch", resetBatch)
+	return resetBatch, nil
+}
+
+func (impl *ResetServiceImpl) PurgeExpiredResetBatches(ctx context.Context) (int, error) {
+	resetBatches, err := impl.resetBatchRepository.FindActiveCreatedBefore(ctx, time.Now().Add(-impl.gracePeriod()))
+	if err != nil {
+		impl.logger.Errorw("error in fetching expired reset batches", "err", err)
+		return 0, err
+	}
+	purged := 0
+	for _, resetBatch := range resetBatches {
+		resetBatch.PurgedOn = time.Now()
+		_, err = impl.appReleaseRepository.PurgeResetBatch(ctx, resetBatch)
+		if err == sql.ErrResetBatchChanged {
+			impl.logger.Infow("skipping purge of reset batch restored concurrently", "resetBatch", resetBatch.Id)
+			continue
+		} else if err != nil {
+			impl.logger.Errorw("error in purging reset batch", "resetBatch", resetBatch.Id, "err", err)
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (impl *ResetServiceImpl) gracePeriod() time.Duration {
+	return time.Duration(impl.config.GracePeriodHours) * time.Hour
+}
+
+func (impl *ResetServiceImpl) Start() {
+	impl.wg.Add(1)
+	go func() {
+		defer impl.wg.Done()
+		ticker := time.NewTicker(time.Duration(impl.config.PurgeIntervalMins) * time.Minute)
+		defer ticker.Stop()
+		for {
+			purged, err := impl.PurgeExpiredResetBatches(impl.ctx)
+			if err != nil {
+				impl.logger.Errorw("error in purging reset batches", "err", err)
+			} else if purged > 0 {
+				impl.logger.Infow("reset batches purged", "count", purged)
+			}
+			select {
+			case <-impl.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (impl *ResetServiceImpl) Stop() {
+	impl.cancel()
+	impl.wg.Wait()
+}
@@ -0,0 +1,388 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/lens/internal/sql"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+type RetentionConfig struct {
+	Enabled bool `env:"RETENTION_ENABLED" envDefault:"false"`
+	// RetentionDays is the global retention, 0 keeps releases forever
+	RetentionDays int `env:"RETENTION_DAYS" envDefault:"0"`
+	// EnvRetentionDays overrides the global retention per environment as envId:days, 0 days keeps the environment forever
+	EnvRetentionDays []string `env:"RETENTION_ENV_DAYS" envSeparator:","`
+	// ArchiveDir is a local or object-store mounted directory receiving archived releases
+	ArchiveDir   string `env:"RETENTION_ARCHIVE_DIR" envDefault:"/tmp/lens-archive"`
+	BatchSize    int    `env:"RETENTION_BATCH_SIZE" envDefault:"500"`
+	IntervalMins int    `env:"RETENTION_INTERVAL_MINS" envDefault:"1440"`
+}
+
+func GetRetentionConfig() (*RetentionConfig, error) {
+	cfg := &RetentionConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+type RetentionPolicy struct {
+	EnvironmentId int // 0 for the global policy
+	RetentionDays int
+}
+
+// ArchivedRelease is a single line of an archive file
+type ArchivedRelease struct {
+	*sql.AppRelease
+	PipelineMaterials []*sql.PipelineMaterial
+	Tags              map[string]string
+}
+
+type RetentionService interface {
+	Start()
+	Stop()
+	// ApplyRetention archives and deletes releases older than the configured policies, returns the archived count
+	ApplyRetention(ctx context.Context) (int, error)
+	// RetentionCutoff returns the time before which releases of an environment are archived, zero when they are kept
+	RetentionCutoff(environmentId int) time.Time
+}
+
+type RetentionServiceImpl struct {
+	logger                  *zap.SugaredLogger
+	config                  *RetentionConfig
+	appReleaseRepository    sql.AppReleaseRepository
+	releaseRollupRepository sql.ReleaseRollupRepository
+	policies                []*RetentionPolicy
+	ctx                     context.Context
+	cancel                  context.CancelFunc
+	wg                      sync.WaitGroup
+}
+
+func NewRetentionServiceImpl(logger *zap.SugaredLogger,
+	config *RetentionConfig,
+	appReleaseRepository sql.AppReleaseRepository,
+	releaseRollupRepository sql.ReleaseRollupRepository) (*RetentionServiceImpl, error) {
+	policies, err := parseRetentionPolicies(config)
+	if err != nil {
+		logger.Errorw("error in parsing retention policies", "config", config, "err", err)
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RetentionServiceImpl{
+		logger:                  logger,
+		config:                  config,
+		appReleaseRepository:    appReleaseRepository,
+		releaseRollupRepository: releaseRollupRepository,
+		policies:                policies,
+		ctx:                     ctx,
+		cancel:                  cancel,
+	}, nil
+}
+
+// parseRetentionPolicies returns the per environment policies followed by the global one
+func parseRetentionPolicies(config *RetentionConfig) ([]*RetentionPolicy, error) {
+	var policies []*RetentionPolicy
+	for _, item := range config.EnvRetentionDays {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid environment retention %q, expected envId:days", item)
+		}
+		envId, err := strconv.Atoi(parts[0])
+		if err != nil || envId <= 0 {
+			return nil, fmt.Errorf("invalid environment id in retention %q", item)
+		}
+		days, err := strconv.Atoi(parts[1])
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("invalid days in retention %q", item)
+		}
+		policies = append(policies, &RetentionPolicy{EnvironmentId: envId, RetentionDays: days})
+	}
+	if config.RetentionDays < 0 {
+		return nil, fmt.Errorf("invalid global retention days %d", config.RetentionDays)
+	}
+	policies = append(policies, &RetentionPolicy{RetentionDays: config.RetentionDays})
+	return policies, nil
+}
+
+func (impl *RetentionServiceImpl) Start() {
+	if !impl.config.Enabled {
+		impl.logger.Infow("data retention disabled")
+		return
+	}
+	impl.wg.Add(1)
+	go func() {
+		defer impl.wg.Done()
+		ticker := time.NewTicker(time.Duration(impl.config.IntervalMins) * time.Minute)
+		defer ticker.Stop()
+		for {
+			archived, err := impl.ApplyRetention(impl.ctx)
+			if err != nil {
+				impl.logger.Errorw("error in applying retention", "err", err)
+			} else {
+				impl.logger.Infow("retention applied", "archived", archived)
+			}
+			select {
+			case <-impl.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (impl *RetentionServiceImpl) Stop() {
+	impl.cancel()
+	impl.wg.Wait()
+}
+
+func (impl *RetentionServiceImpl) RetentionCutoff(environmentId int) time.Time {
+	if !impl.config.Enabled {
+		return time.Time{}
+	}
+	// the global policy comes last
+	policy := impl.policies[len(impl.policies)-1]
+	for _, environmentPolicy := range impl.policies {
+		if environmentPolicy.EnvironmentId == environmentId {
+			policy = environmentPolicy
+			break
+		}
+	}
+	if policy.RetentionDays == 0 {
+		return time.Time{}
+	}
+	return time.Now().AddDate(0, 0, -policy.RetentionDays)
+}
+
+func (impl *RetentionServiceImpl) ApplyRetention(ctx context.Context) (int, error) {
+	if err := impl.recoverPendingArchives(ctx); err != nil {
+		impl.logger.Errorw("error in recovering pending archives", "dir", impl.config.ArchiveDir, "err", err)
+		return 0, err
+	}
+	var overriddenEnvIds []int
+	total := 0
+	for _, policy := range impl.policies {
+		if policy.EnvironmentId != 0 {
+			overriddenEnvIds = append(overriddenEnvIds, policy.EnvironmentId)
+		}
+		if policy.RetentionDays == 0 {
+			continue
+		}
+		var environmentIds, excludedEnvironmentIds []int
+		if policy.EnvironmentId != 0 {
+			environmentIds = []int{policy.EnvironmentId}
+		} else {
+			excludedEnvironmentIds = overriddenEnvIds
+		}
+		before := time.Now().AddDate(0, 0, -policy.RetentionDays)
+		for {
+			select {
+			case <-ctx.Done():
+				return total, nil
+			default:
+			}
+			var pending string
+			archived, err := impl.appReleaseRepository.ArchiveReleasesBefore(ctx, environmentIds, excludedEnvironmentIds, before, impl.config.BatchSize,
+				func(releases []*sql.AppRelease, materials []*sql.PipelineMaterial, tags []*sql.ReleaseTag, tx *pg.Tx) error {
+					var err error
+					pending, err = impl.archive(ctx, releases, materials, tags, tx)
+					return err
+				})
+			if err != nil {
+				impl.logger.Errorw("error in archiving releases", "policy", policy, "err", err)
+				if pending != "" {
+					// the releases are kept, the next run archives them again
+					_ = os.Remove(pending)
+				}
+				return total, err
+			}
+			if pending != "" {
+				if err = os.Rename(pending, strings.TrimSuffix(pending, pendingArchiveSuffix)); err != nil {
+					// recovered by the next run as the releases are gone
+					impl.logger.Errorw("error in completing archive", "file", pending, "err", err)
+					return total, err
+				}
+			}
+			total += archived
+			if archived < impl.config.BatchSize {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// archive writes releases to a pending archive file and folds them into the daily rollups, the file is completed
+// once the releases are deleted
+func (impl *RetentionServiceImpl) archive(ctx context.Context, releases []*sql.AppRelease, materials []*sql.PipelineMaterial, tags []*sql.ReleaseTag, tx *pg.Tx) (string, error) {
+	tagsByRelease := groupTagsByRelease(tags)
+	materialsByRelease := make(map[int][]*sql.PipelineMaterial)
+	for _, material := range materials {
+		materialsByRelease[material.AppReleaseId] = append(materialsByRelease[material.AppReleaseId], material)
+	}
+	archivedReleases := make([]*ArchivedRelease, 0, len(releases))
+	for _, release := range releases {
+		archivedReleases = append(archivedReleases, &ArchivedRelease{AppRelease: release, PipelineMaterials: materialsByRelease[release.Id], Tags: tagsByRelease[release.Id]})
+	}
+	pending, err := impl.writeArchive(archivedReleases)
+	if err != nil {
+		impl.logger.Errorw("error in writing archive", "dir", impl.config.ArchiveDir, "err", err)
+		return "", err
+	}
+	return pending, impl.releaseRollupRepository.Merge(ctx, rollupReleases(releases, tagsByRelease), tx)
+}
+
+// pendingArchiveSuffix marks archive files whose releases may not be deleted yet
+const pendingArchiveSuffix = ".pending"
+
+// writeArchive writes gzip compressed NDJSON to a pending file, renamed in place only once complete
+func (impl *RetentionServiceImpl) writeArchive(releases []*ArchivedRelease) (string, error) {
+	now := time.Now().UTC()
+	dir := filepath.Join(impl.config.ArchiveDir, "app_release", now.Format("2006"), now.Format("01"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("app_release_%d_%d_%d.ndjson.gz", releases[0].Id, releases[len(releases)-1].Id, now.UnixNano())
+	tmpFile, err := os.CreateTemp(dir, name+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	gz := gzip.NewWriter(tmpFile)
+	encoder := json.NewEncoder(gz)
+	for _, release := range releases {
+		if err = encoder.Encode(release); err != nil {
+			tmpFile.Close()
+			return "", err
+		}
+	}
+	if err = gz.Close(); err != nil {
+		tmpFile.Close()
+		return "", err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return "", err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return "", err
+	}
+	pending := filepath.Join(dir, name+pendingArchiveSuffix)
+	return pending, os.Rename(tmpFile.Name(), pending)
+}
+
+// recoverPendingArchives completes the pending archive files left by a stop between the delete of their releases
+// and the rename, and drops those whose releases were kept
+func (impl *RetentionServiceImpl) recoverPendingArchives(ctx context.Context) error {
+	pendingFiles, err := filepath.Glob(filepath.Join(impl.config.ArchiveDir, "app_release", "*", "*", "*"+pendingArchiveSuffix))
+	if err != nil {
+		return err
+	}
+	for _, pending := range pendingFiles {
+		var firstId, lastId int
+		var createdOn int64
+		if _, err = fmt.Sscanf(filepath.Base(pending), "app_release_%d_%d_%d.ndjson.gz", &firstId, &lastId, &createdOn); err != nil {
+			impl.logger.Warnw("skipping unknown pending archive", "file", pending, "err", err)
+			continue
+		}
+		// a batch is deleted as a whole, its first release tells whether it was
+		_, err = impl.appReleaseRepository.FindById(ctx, firstId)
+		if err == pg.ErrNoRows {
+			impl.logger.Infow("completing pending archive", "file", pending)
+			err = os.Rename(pending, strings.TrimSuffix(pending, pendingArchiveSuffix))
+		} else if err == nil {
+			impl.logger.Infow("dropping pending archive of kept releases", "file", pending)
+			err = os.Remove(pending)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rollupReleases(releases []*sql.AppRelease, tagsByRelease map[int]map[string]string) []*sql.ReleaseRollup {
+	type rollupKey struct {
+		appId, environmentId int
+		day                  time.Time
+	}
+	byKey := make(map[rollupKey]*sql.ReleaseRollup)
+	var rollups []*sql.ReleaseRollup
+	for _, release := range releases {
+		if release.ExcludedFromMetrics {
+			continue
+		}
+		triggerTime := release.TriggerTime.UTC()
+		key := rollupKey{appId: release.AppId, environmentId: release.EnvironmentId,
+			day: time.Date(triggerTime.Year(), triggerTime.Month(), triggerTime.Day(), 0, 0, 0, 0, time.UTC)}
+		rollup, ok := byKey[key]
+		if !ok {
+			rollup = &sql.ReleaseRollup{AppId: key.appId, EnvironmentId: key.environmentId, Day: key.day}
+			byKey[key] = rollup
+			rollups = append(rollups, rollup)
+		}
+		if release.ReleaseType.CountsForDeploymentFrequency() {
+			rollup.DeploymentCount++
+		}
+		if release.ReleaseStatus == sql.Failure {
+			rollup.FailureCount++
+		}
+		switch release.ReleaseType {
+		case sql.RollBack:
+			rollup.RollbackCount++
+			if release.TimeToRollback > 0 {
+				rollup.TimeToRollbackTotal += release.TimeToRollback
+				rollup.TimeToRollbackCount++
+			}
+		case sql.Patch:
+			rollup.PatchCount++
+		}
+		if release.LeadTime != nil && release.ReleaseType.CountsForLeadTime() {
+			rollup.LeadTimeTotal += release.LeadTime.LeadTime
+			rollup.LeadTimeCount++
+		}
+		rollup.ChangeSizeLineAdded += release.ChangeSizeLineAdded
+		rollup.ChangeSizeLineDeleted += release.ChangeSizeLineDeleted
+		if reasons := release.ReworkReasons(tagsByRelease[release.Id]); len(reasons) > 0 {
+			rollup.ReworkCount++
+		}
+		if release.ReleaseType.CountsForDeploymentFrequency() {
+			if tagsByRelease[release.Id][sql.HotfixTagKey] == sql.HotfixTagValue {
+				rollup.HotfixCount++
+			}
+			if release.IsFixDominated() {
+				rollup.FixReleaseCount++
+			}
+		}
+	}
+	return rollups
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/lens/internal/sql"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+// archivingReleaseRepository hands a single batch to archive, failing its delete failDeletes times
+type archivingReleaseRepository struct {
+	sql.AppReleaseRepository
+	releases    []*sql.AppRelease
+	failDeletes int
+	deleted     bool
+}
+
+func (repo *archivingReleaseRepository) ArchiveReleasesBefore(ctx context.Context, environmentIds []int, excludedEnvironmentIds []int, before time.Time, limit int,
+	archive func(releases []*sql.AppRelease, materials []*sql.PipelineMaterial, tags []*sql.ReleaseTag, tx *pg.Tx) error) (int, error) {
+	if repo.deleted {
+		return 0, nil
+	}
+	if err := archive(repo.releases, nil, nil, nil); err != nil {
+		return 0, err
+	}
+	if repo.failDeletes > 0 {
+		repo.failDeletes--
+		return 0, errors.New("deadlock detected")
+	}
+	repo.deleted = true
+	return len(repo.releases), nil
+}
+
+func (repo *archivingReleaseRepository) FindById(ctx context.Context, id int) (*sql.AppRelease, error) {
+	if repo.deleted {
+		return nil, pg.ErrNoRows
+	}
+	return repo.releases[0], nil
+}
+
+type mergingReleaseRollupRepository struct {
+	sql.ReleaseRollupRepository
+}
+
+func (mergingReleaseRollupRepository) Merge(ctx context.Context, rollups []*sql.ReleaseRollup, tx *pg.Tx) error {
+	return nil
+}
+
+func archiveFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, filepath.Base(path))
+		}
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestApplyRetention_ArchivesOnceDeleted(t *testing.T) {
+	dir := t.TempDir()
+	oldTime := time.Now().AddDate(0, 0, -100)
+	releases := &archivingReleaseRepository{failDeletes: 1, releases: []*sql.AppRelease{
+		{Id: 1, AppId: 1, EnvironmentId: 1, TriggerTime: oldTime},
+		{Id: 2, AppId: 1, EnvironmentId: 1, TriggerTime: oldTime},
+	}}
+	impl, err := NewRetentionServiceImpl(zap.NewNop().Sugar(), &RetentionConfig{Enabled: true, RetentionDays: 90, ArchiveDir: dir, BatchSize: 500},
+		releases, mergingReleaseRollupRepository{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = impl.ApplyRetention(context.Background()); err == nil {
+		t.Fatal("ApplyRetention() with a failing delete succeeded")
+	}
+	if files := archiveFiles(t, dir); len(files) != 0 {
+		t.Errorf("archive files after a failed delete = %v, want none", files)
+	}
+	archived, err := impl.ApplyRetention(context.Background())
+	if err != nil || archived != 2 {
+		t.Fatalf("ApplyRetention() = %d, %v, want 2 archived", archived, err)
+	}
+	files := archiveFiles(t, dir)
+	if len(files) != 1 || !strings.HasPrefix(files[0], "app_release_1_2_") || !strings.HasSuffix(files[0], ".ndjson.gz") {
+		t.Errorf("archive files = %v, want a single complete archive of releases 1 to 2", files)
+	}
+}
+
+func TestRecoverPendingArchives(t *testing.T) {
+	for _, deleted := range []bool{true, false} {
+		dir := t.TempDir()
+		monthDir := filepath.Join(dir, "app_release", "2024", "01")
+		if err := os.MkdirAll(monthDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		name := "app_release_1_2_1704067200000000000.ndjson.gz"
+		if err := os.WriteFile(filepath.Join(monthDir, name+pendingArchiveSuffix), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		releases := &archivingReleaseRepository{deleted: deleted, releases: []*sql.AppRelease{{Id: 1}}}
+		impl, err := NewRetentionServiceImpl(zap.NewNop().Sugar(), &RetentionConfig{ArchiveDir: dir}, releases, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = impl.recoverPendingArchives(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		files := archiveFiles(t, dir)
+		if deleted && (len(files) != 1 || files[0] != name) {
+			t.Errorf("archive files of deleted releases = %v, want the pending archive completed", files)
+		} else if !deleted && len(files) != 0 {
+			t.Errorf("archive files of kept releases = %v, want the pending archive dropped", files)
+		}
+	}
+}
+
+func TestRollupReleases_TimeToRollback(t *testing.T) {
+	day := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	rollups := rollupReleases([]*sql.AppRelease{
+		{Id: 1, AppId: 1, EnvironmentId: 1, TriggerTime: day, ReleaseType: sql.RollForward, ReleaseStatus: sql.Failure},
+		{Id: 2, AppId: 1, EnvironmentId: 1, TriggerTime: day.Add(time.Hour), ReleaseType: sql.RollBack, TimeToRollback: time.Hour},
+		{Id: 3, AppId: 1, EnvironmentId: 1, TriggerTime: day.Add(2 * time.Hour), ReleaseType: sql.RollBack, TimeToRollback: 3 * time.Hour},
+	}, nil)
+	if len(rollups) != 1 || rollups[0].RollbackCount != 2 || rollups[0].TimeToRollbackTotal != 4*time.Hour || rollups[0].TimeToRollbackCount != 2 {
+		t.Fatalf("rollupReleases() = %+v, want 2 rollbacks taking 4h in total", rollups)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package apperror types the errors of the services by what a client can do about them. Each kind has a
+// stable code, an http status and a default user message, so that clients branch on the code instead of
+// parsing error strings.
+package apperror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/devtron-labs/lens/client/gitSensor"
+	pg "github.com/go-pg/pg/v10"
+)
+
+// Kind is the category of an error, its string is the stable code returned to clients
+type Kind string
+
+const (
+	Validation          Kind = "validation"
+	NotFound            Kind = "not-found"
+	UpstreamUnavailable Kind = "upstream-unavailable"
+	Conflict            Kind = "conflict"
+	Internal            Kind = "internal"
+	Unauthenticated     Kind = "unauthenticated"
+	Forbidden           Kind = "forbidden"
+)
+
+// HttpStatus is the status of a response failed with an error of kind k
+func (k Kind) HttpStatus() int {
+	switch k {
+	case Validation:
+		return http.StatusBadRequest
+	case NotFound:
+		return http.StatusNotFound
+	case UpstreamUnavailable:
+		return http.StatusServiceUnavailable
+	case Conflict:
+		return http.StatusConflict
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	case Forbidden:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// UserMessage is shown when the error does not carry a message of its own
+func (k Kind) UserMessage() string {
+	switch k {
+	case Validation:
+		return "invalid request"
+	case NotFound:
+		return "not found"
+	case UpstreamUnavailable:
+		return "a dependency is unavailable, retry later"
+	case Conflict:
+		return "conflicts with the current state"
+	case Unauthenticated:
+		return "authentication required"
+	case Forbidden:
+		return "not allowed"
+	default:
+		return "internal error"
+	}
+}
+
+// Error is a typed service error, Message is safe to show to the user
+type Error struct {
+	Kind    Kind
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	if e.Message == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newf(kind Kind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+func Validationf(format string, args ...interface{}) error {
+	return newf(Validation, format, args...)
+}
+
+func NotFoundf(format string, args ...interface{}) error {
+	return newf(NotFound, format, args...)
+}
+
+func Conflictf(format string, args ...interface{}) error {
+	return newf(Conflict, format, args...)
+}
+
+func UpstreamUnavailablef(format string, args ...interface{}) error {
+	return newf(UpstreamUnavailable, format, args...)
+}
+
+// KindOf is the kind of err. Besides typed errors, a missing row is not found and a transient git-sensor
+// failure or an expired deadline is an unavailable upstream, anything else is internal.
+func KindOf(err error) Kind {
+	var typed *Error
+	switch {
+	case errors.As(err, &typed):
+		return typed.Kind
+	case errors.Is(err, pg.ErrNoRows):
+		return NotFound
+	case gitSensor.IsRetryLater(err), errors.Is(err, context.DeadlineExceeded):
+		return UpstreamUnavailable
+	default:
+		return Internal
+	}
+}
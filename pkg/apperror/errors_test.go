@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package apperror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/devtron-labs/lens/client/gitSensor"
+	pg "github.com/go-pg/pg/v10"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		kind   Kind
+		status int
+	}{
+		{"validation", Validationf("from is required"), Validation, http.StatusBadRequest},
+		{"wrapped conflict", fmt.Errorf("restore: %w", Conflictf("reset batch %d is already %s", 1, "Restored")), Conflict, http.StatusConflict},
+		{"missing row", fmt.Errorf("finding release: %w", pg.ErrNoRows), NotFound, http.StatusNotFound},
+		{"git-sensor unavailable", &gitSensor.GitSensorError{Protocol: "grpc", Retryable: true, Err: errors.New("unavailable")}, UpstreamUnavailable, http.StatusServiceUnavailable},
+		{"git-sensor permanent", &gitSensor.GitSensorError{Protocol: "grpc", Err: errors.New("unknown commit")}, Internal, http.StatusInternalServerError},
+		{"deadline", context.DeadlineExceeded, UpstreamUnavailable, http.StatusServiceUnavailable},
+		{"typed wins over cause", &Error{Kind: Internal, Message: "inconsistent release", Err: pg.ErrNoRows}, Internal, http.StatusInternalServerError},
+		{"untyped", errors.New("connection reset"), Internal, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind := KindOf(tt.err)
+			if kind != tt.kind {
+				t.Errorf("KindOf() = %s, want %s", kind, tt.kind)
+			}
+			if status := kind.HttpStatus(); status != tt.status {
+				t.Errorf("HttpStatus() = %d, want %d", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	if got := Validationf("tag key %q must be 1 to %d characters", "", 64).Error(); got != `tag key "" must be 1 to 64 characters` {
+		t.Errorf("Error() = %q", got)
+	}
+	if got := (&Error{Kind: UpstreamUnavailable, Message: "git-sensor unavailable", Err: errors.New("timeout")}).Error(); got != "git-sensor unavailable: timeout" {
+		t.Errorf("Error() = %q", got)
+	}
+}
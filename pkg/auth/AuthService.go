@@ -0,0 +1,282 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/pkg/apperror"
+	pg "github.com/go-pg/pg/v10"
+	"go.uber.org/zap"
+)
+
+const (
+	ScopeReadMetrics = "metrics:read"
+	ScopeIngest      = "ingest"
+	// ScopeAdmin covers resets, release corrections and key management, and implies every other scope
+	ScopeAdmin = "admin"
+
+	apiKeyPrefix = "lens_"
+)
+
+var ErrUnauthenticated = errors.New("missing, invalid or expired bearer token")
+
+type AuthConfig struct {
+	Enabled bool `env:"AUTH_ENABLED" envDefault:"false"`
+	// JwtSecret verifies HS256 signed JWTs, JWTs are rejected when empty
+	JwtSecret string `env:"AUTH_JWT_SECRET" envDefault:"" secretData:"-"`
+}
+
+func GetAuthConfig() (*AuthConfig, error) {
+	cfg := &AuthConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// Principal is the authenticated caller
+type Principal struct {
+	Subject string
+	Scopes  []string
+	AppIds  []int //empty allows all apps
+}
+
+func (principal *Principal) HasScope(scope string) bool {
+	for _, s := range principal.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func (principal *Principal) CanAccessApp(appId int) bool {
+	if len(principal.AppIds) == 0 {
+		return true
+	}
+	for _, id := range principal.AppIds {
+		if id == appId {
+			return true
+		}
+	}
+	return false
+}
+
+type principalContextKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFrom returns the caller of the request, nil when authentication is disabled
+func PrincipalFrom(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return principal
+}
+
+// jwtClaims are the claims lens reads from a JWT
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Scopes    []string `json:"scopes"`
+	AppIds    []int    `json:"app_ids"`
+}
+
+type ApiKeyRequest struct {
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	AppIds    []int     `json:"appIds"`
+	ExpiresOn time.Time `json:"expiresOn"`
+	CreatedBy string    `json:"-"`
+}
+
+type ApiKeyResponse struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	// Key is only returned on creation
+	Key string `json:"key"`
+}
+
+type AuthService interface {
+	IsEnabled() bool
+	// Authenticate resolves a bearer token, either an HS256 JWT or an API key, to its principal
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+	CreateApiKey(ctx context.Context, request *ApiKeyRequest) (*ApiKeyResponse, error)
+	RevokeApiKey(ctx context.Context, id int) error
+}
+
+type AuthServiceImpl struct {
+	logger           *zap.SugaredLogger
+	config           *AuthConfig
+	apiKeyRepository sql.ApiKeyRepository
+}
+
+func NewAuthServiceImpl(logger *zap.SugaredLogger, config *AuthConfig, apiKeyRepository sql.ApiKeyRepository) *AuthServiceImpl {
+	if config.Enabled {
+		logger.Infow("authentication enabled for http api", "jwt", config.JwtSecret != "")
+	}
+	return &AuthServiceImpl{
+		logger:           logger,
+		config:           config,
+		apiKeyRepository: apiKeyRepository,
+	}
+}
+
+func (impl *AuthServiceImpl) IsEnabled() bool {
+	return impl.config.Enabled
+}
+
+func (impl *AuthServiceImpl) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+	if strings.Count(token, ".") == 2 {
+		return impl.authenticateJwt(token)
+	}
+	return impl.authenticateApiKey(ctx, token)
+}
+
+func (impl *AuthServiceImpl) authenticateJwt(token string) (*Principal, error) {
+	if impl.config.JwtSecret == "" {
+		return nil, ErrUnauthenticated
+	}
+	claims, err := verifyHS256(token, []byte(impl.config.JwtSecret), time.Now())
+	if err != nil {
+		impl.logger.Debugw("jwt rejected", "err", err)
+		return nil, ErrUnauthenticated
+	}
+	return &Principal{Subject: claims.Subject, Scopes: claims.Scopes, AppIds: claims.AppIds}, nil
+}
+
+func (impl *AuthServiceImpl) authenticateApiKey(ctx context.Context, token string) (*Principal, error) {
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return nil, ErrUnauthenticated
+	}
+	apiKey, err := impl.apiKeyRepository.FindActiveByKeyHash(ctx, hashApiKey(token))
+	if err == pg.ErrNoRows {
+		return nil, ErrUnauthenticated
+	} else if err != nil {
+		impl.logger.Errorw("error in fetching api key", "err", err)
+		return nil, err
+	}
+	return &Principal{Subject: "api-key:" + apiKey.Name, Scopes: apiKey.Scopes, AppIds: apiKey.AppIds}, nil
+}
+
+func (impl *AuthServiceImpl) CreateApiKey(ctx context.Context, request *ApiKeyRequest) (*ApiKeyResponse, error) {
+	if request.Name == "" || len(request.Scopes) == 0 {
+		return nil, apperror.Validationf("name and scopes are required")
+	}
+	for _, scope := range request.Scopes {
+		if scope != ScopeReadMetrics && scope != ScopeIngest && scope != ScopeAdmin {
+			return nil, apperror.Validationf("unknown scope %q", scope)
+		}
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(secret)
+	apiKey := &sql.ApiKey{
+		Name:      request.Name,
+		KeyHash:   hashApiKey(key),
+		Scopes:    request.Scopes,
+		AppIds:    request.AppIds,
+		Active:    true,
+		CreatedBy: request.CreatedBy,
+		CreatedOn: time.Now(),
+		ExpiresOn: request.ExpiresOn,
+	}
+	apiKey, err := impl.apiKeyRepository.Save(ctx, apiKey)
+	if err != nil {
+		impl.logger.Errorw("error in saving api key", "name", request.Name, "err", err)
+		return nil, err
+	}
+	impl.logger.Infow("api key created", "id", apiKey.Id, "name", apiKey.Name, "scopes", apiKey.Scopes, "createdBy", apiKey.CreatedBy)
+	return &ApiKeyResponse{Id: apiKey.Id, Name: apiKey.Name, Key: key}, nil
+}
+
+func (impl *AuthServiceImpl) RevokeApiKey(ctx context.Context, id int) error {
+	err := impl.apiKeyRepository.Deactivate(ctx, id)
+	if err != nil {
+		impl.logger.Errorw("error in revoking api key", "id", id, "err", err)
+		return err
+	}
+	impl.logger.Infow("api key revoked", "id", id)
+	return nil
+}
+
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyHS256 checks signature, algorithm and validity window of a compact JWT
+func verifyHS256(token string, secret []byte, now time.Time) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed jwt")
+	}
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	header := struct {
+		Alg string `json:"alg"`
+	}{}
+	if err = json.Unmarshal(headerJson, &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("unsupported jwt alg %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("invalid jwt signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	claims := &jwtClaims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, err
+	}
+	if claims.ExpiresAt == 0 || now.Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("jwt expired")
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return nil, fmt.Errorf("jwt not yet valid")
+	}
+	return claims, nil
+}
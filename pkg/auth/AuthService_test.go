@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func signHS256(header, payload string, secret []byte) string {
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyHS256(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Unix(1700000000, 0)
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", signHS256(hs256, `{"sub":"ci","exp":1700000100,"scopes":["ingest"],"app_ids":[1]}`, secret), false},
+		{"expired", signHS256(hs256, `{"sub":"ci","exp":1699999999}`, secret), true},
+		{"no expiry", signHS256(hs256, `{"sub":"ci"}`, secret), true},
+		{"not yet valid", signHS256(hs256, `{"sub":"ci","exp":1700000100,"nbf":1700000050}`, secret), true},
+		{"wrong secret", signHS256(hs256, `{"sub":"ci","exp":1700000100}`, []byte("other")), true},
+		{"alg none", signHS256(`{"alg":"none"}`, `{"sub":"ci","exp":1700000100}`, secret), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifyHS256(tt.token, secret, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyHS256() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (claims.Subject != "ci" || claims.Scopes[0] != ScopeIngest || claims.AppIds[0] != 1) {
+				t.Errorf("verifyHS256() claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	principal := &Principal{Scopes: []string{ScopeReadMetrics}, AppIds: []int{3}}
+	if !principal.HasScope(ScopeReadMetrics) || principal.HasScope(ScopeIngest) {
+		t.Errorf("unexpected scopes for %+v", principal)
+	}
+	if !principal.CanAccessApp(3) || principal.CanAccessApp(4) {
+		t.Errorf("unexpected app access for %+v", principal)
+	}
+	admin := &Principal{Scopes: []string{ScopeAdmin}}
+	if !admin.HasScope(ScopeIngest) || !admin.CanAccessApp(4) {
+		t.Errorf("admin without app list should access everything")
+	}
+}
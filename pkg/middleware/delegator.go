@@ -55,6 +55,11 @@ func (r *responseWriterDelegator) Written() int64 {
 	return r.written
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *responseWriterDelegator) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func (r *responseWriterDelegator) WriteHeader(code int) {
 	if r.observeWriteHeader != nil && !r.wroteHeader {
 		// Only call observeWriteHeader for the 1st time. It's a bug if
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2020-2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var gzipWriterPool = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+	// passthrough is set for responses that must not have a body
+	passthrough bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if !g.wroteHeader {
+		g.wroteHeader = true
+		g.Header().Del("Content-Length")
+		if code == http.StatusNoContent || code == http.StatusNotModified {
+			g.Header().Del("Content-Encoding")
+			g.passthrough = true
+		}
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.passthrough {
+		return g.ResponseWriter.Write(b)
+	}
+	return g.gz.Write(b)
+}
+
+// Flush pushes compressed bytes to the client, needed by streaming responses
+func (g *gzipResponseWriter) Flush() {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if !g.passthrough {
+		_ = g.gz.Flush()
+	}
+	if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// Gzip compresses responses for clients accepting gzip
+func Gzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Add("Vary", "Accept-Encoding")
+		// handlers compressing on their own, like promhttp, must not compress a second time
+		r.Header.Del("Accept-Encoding")
+		gz := gzipWriterPool.Get().(*gzip.Writer)
+		gz.Reset(w)
+		gw := &gzipResponseWriter{ResponseWriter: w, gz: gz}
+		defer func() {
+			if gw.wroteHeader {
+				if !gw.passthrough {
+					_ = gz.Close()
+				}
+			} else {
+				// nothing written, drop the encoding so an empty body stays valid
+				w.Header().Del("Content-Encoding")
+			}
+			gz.Reset(nil)
+			gzipWriterPool.Put(gz)
+		}()
+		next.ServeHTTP(gw, r)
+	})
+}
@@ -0,0 +1,24 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+alter table app_release
+    add column if not exists commit_count int not null default 0,
+    add column if not exists fix_commit_count int not null default 0;
+
+alter table release_rollup
+    add column if not exists rework_count int not null default 0,
+    add column if not exists hotfix_count int not null default 0,
+    add column if not exists fix_release_count int not null default 0;
@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+create table if not exists failure_policy
+(
+    id                          serial primary key,
+    environment_id              int not null unique, -- 0 for the default policy of environments without their own
+    rules                       jsonb not null,
+    version                     int not null,
+    updated_by                  varchar(250) not null,
+    updated_on                  timestamptz not null
+);
+
+create table if not exists release_status_event
+(
+    id                          serial primary key,
+    app_release_id              int not null references app_release on delete cascade,
+    cd_status                   varchar(50),
+    health_status               varchar(50),
+    event_time                  timestamptz not null,
+    created_on                  timestamptz not null
+);
+
+create index if not exists idx_release_status_event_app_release
+    on release_status_event (app_release_id);
+
+alter table app_release
+    add column if not exists failure_policy_id int,
+    add column if not exists failure_policy_version int,
+    add column if not exists failure_reason varchar(50);
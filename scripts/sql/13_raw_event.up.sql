@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+-- deployment and status events as received, append-only
+create table if not exists raw_event
+(
+    id                          bigserial primary key,
+    event_type                  varchar(50) not null,
+    source                      varchar(50) not null,
+    app_id                      int not null,
+    environment_id              int not null,
+    pipeline_override_id        int not null,
+    trigger_time                timestamptz, -- of deployment events
+    payload                     json not null,
+    received_on                 timestamptz not null
+);
+
+create index if not exists idx_raw_event_app_env_trigger
+    on raw_event (app_id, environment_id, pipeline_override_id);
+
+create or replace function raw_event_append_only() returns trigger as $$
+begin
+    raise exception 'raw_event is append-only';
+end;
+$$ language plpgsql;
+
+drop trigger if exists raw_event_append_only on raw_event;
+create trigger raw_event_append_only
+    before update on raw_event
+    for each row execute procedure raw_event_append_only();
+
+create table if not exists recompute_job
+(
+    id                          serial primary key,
+    app_id                      int not null,
+    environment_id              int not null,
+    from_time                   timestamptz not null,
+    to_time                     timestamptz not null,
+    status                      int not null,
+    reset_batch_id              int not null references reset_batch,
+    event_count                 int not null default 0,
+    release_count               int not null default 0,
+    replaced_release_count      int not null default 0,
+    error                       text,
+    requested_by                varchar(250) not null,
+    created_on                  timestamptz not null,
+    finished_on                 timestamptz
+);
+
+create index if not exists idx_recompute_job_app_env
+    on recompute_job (app_id, environment_id);
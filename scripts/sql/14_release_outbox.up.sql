@@ -0,0 +1,31 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+-- messages written in the transaction completing ingestion of a release, published to nats afterwards
+create table if not exists release_outbox
+(
+    id                          bigserial primary key,
+    app_release_id              int not null,
+    topic                       varchar(100) not null,
+    payload                     text not null,
+    created_on                  timestamptz not null,
+    published_on                timestamptz,
+    attempts                    int not null default 0,
+    last_error                  text
+);
+
+create index if not exists idx_release_outbox_unpublished
+    on release_outbox (id) where published_on is null;
@@ -0,0 +1,30 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+-- stage transitions, status changes and reclassifications of releases with their cause
+create table if not exists release_stage_history
+(
+    id                          bigserial primary key,
+    app_release_id              int not null references app_release (id) on delete cascade,
+    kind                        varchar(20) not null,
+    from_value                  varchar(50),
+    to_value                    varchar(50) not null,
+    cause                       text not null,
+    created_on                  timestamptz not null
+);
+
+create index if not exists idx_release_stage_history_app_release
+    on release_stage_history (app_release_id);
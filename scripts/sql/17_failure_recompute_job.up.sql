@@ -0,0 +1,32 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+-- failure policy recomputes, run in the background and polled by id
+create table if not exists failure_recompute_job
+(
+    id                          serial primary key,
+    environment_id              int not null,
+    failure_policy_id           int not null,
+    failure_policy_version      int not null,
+    status                      int not null,
+    app_environment_count       int not null default 0,
+    release_count               int not null default 0,
+    changed_count               int not null default 0,
+    error                       text,
+    requested_by                varchar(250) not null,
+    created_on                  timestamptz not null,
+    finished_on                 timestamptz
+);
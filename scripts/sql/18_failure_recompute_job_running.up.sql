@@ -0,0 +1,23 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+-- jobs started concurrently before the index existed, only the latest is kept running
+update failure_recompute_job set status = 2, error = 'interrupted by a restart', finished_on = now()
+    where status = 0 and id <> (select max(id) from failure_recompute_job where status = 0);
+
+-- a single failure recompute job runs at a time
+create unique index if not exists idx_failure_recompute_job_running
+    on failure_recompute_job ((true)) where status = 0;
@@ -0,0 +1,40 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+create index if not exists idx_app_release_app_env_trigger_time
+    on app_release (app_id, environment_id, trigger_time);
+
+create index if not exists idx_app_release_app_env_artifact
+    on app_release (app_id, environment_id, ci_artifact_id);
+
+-- earlier versions could save a release twice, keep the latest row of each before enforcing uniqueness
+delete from lead_time a
+    using lead_time b
+    where a.app_release_id = b.app_release_id
+      and a.id < b.id;
+
+create unique index if not exists uq_lead_time_app_release
+    on lead_time (app_release_id);
+
+-- pipeline_material has no id, its last written row is kept
+delete from pipeline_material a
+    using pipeline_material b
+    where a.app_release_id = b.app_release_id
+      and a.pipeline_material_id = b.pipeline_material_id
+      and a.ctid < b.ctid;
+
+create unique index if not exists uq_pipeline_material_app_release_material
+    on pipeline_material (app_release_id, pipeline_material_id);
@@ -0,0 +1,31 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+create table if not exists release_rollup
+(
+    app_id                      int not null,
+    environment_id              int not null,
+    day                         date not null,
+    deployment_count            int not null default 0,
+    failure_count               int not null default 0,
+    rollback_count              int not null default 0,
+    patch_count                 int not null default 0,
+    lead_time_total             bigint not null default 0,
+    lead_time_count             int not null default 0,
+    change_size_line_added      bigint not null default 0,
+    change_size_line_deleted    bigint not null default 0,
+    primary key (app_id, environment_id, day)
+);
@@ -0,0 +1,35 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+create table if not exists reset_batch
+(
+    id                          serial primary key,
+    app_id                      int not null,
+    environment_id              int not null,
+    status                      int not null,
+    release_count               int not null default 0,
+    requested_by                varchar(250) not null,
+    reason                      text not null,
+    created_on                  timestamptz not null,
+    restored_by                 varchar(250),
+    restored_on                 timestamptz,
+    purged_on                   timestamptz
+);
+
+alter table app_release add column if not exists reset_batch_id int references reset_batch;
+
+create index if not exists idx_app_release_reset_batch
+    on app_release (reset_batch_id) where reset_batch_id is not null;
@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+alter table app_release add column if not exists excluded_from_metrics bool not null default false;
+
+-- overrides are keyed by the orchestrator trigger so that they survive a rebuild of app_release
+create table if not exists release_override
+(
+    id                          serial primary key,
+    app_id                      int not null,
+    environment_id              int not null,
+    pipeline_override_id        int not null,
+    release_status              int,
+    release_type                int,
+    excluded_from_metrics       bool,
+    updated_by                  varchar(250) not null,
+    updated_on                  timestamptz not null,
+    unique (app_id, environment_id, pipeline_override_id)
+);
+
+create table if not exists release_audit
+(
+    id                          serial primary key,
+    app_release_id              int not null,
+    app_id                      int not null,
+    environment_id              int not null,
+    pipeline_override_id        int not null,
+    field                       varchar(100) not null,
+    old_value                   varchar(250) not null,
+    new_value                   varchar(250) not null,
+    actor                       varchar(250) not null,
+    reason                      text not null,
+    created_on                  timestamptz not null
+);
+
+create index if not exists idx_release_audit_app_release
+    on release_audit (app_release_id);
@@ -0,0 +1,38 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+create table if not exists release_tag
+(
+    app_release_id              int not null references app_release on delete cascade,
+    key                         varchar(100) not null,
+    value                       varchar(250) not null,
+    primary key (app_release_id, key)
+);
+
+create index if not exists idx_release_tag_key_value
+    on release_tag (key, value);
+
+create table if not exists release_annotation
+(
+    id                          serial primary key,
+    app_release_id              int not null references app_release on delete cascade,
+    text                        text not null,
+    created_by                  varchar(250) not null,
+    created_on                  timestamptz not null
+);
+
+create index if not exists idx_release_annotation_app_release
+    on release_annotation (app_release_id);
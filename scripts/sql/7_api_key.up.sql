@@ -0,0 +1,28 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+create table if not exists api_key
+(
+    id                          serial primary key,
+    name                        varchar(250) not null,
+    key_hash                    varchar(64) not null unique,
+    scopes                      text[] not null,
+    app_ids                     int[],
+    active                      bool not null default true,
+    created_by                  varchar(250) not null,
+    created_on                  timestamptz not null,
+    expires_on                  timestamptz
+);
@@ -0,0 +1,33 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+create table if not exists git_changes_cache
+(
+    cache_key                   varchar(64) primary key,
+    pipeline_material_id        int not null,
+    old_commit                  varchar(250) not null,
+    new_commit                  varchar(250) not null,
+    changes                     jsonb not null,
+    size_bytes                  int not null,
+    created_on                  timestamptz not null,
+    last_accessed_on            timestamptz not null
+);
+
+create index if not exists idx_git_changes_cache_material
+    on git_changes_cache (pipeline_material_id);
+
+create index if not exists idx_git_changes_cache_last_accessed
+    on git_changes_cache (last_accessed_on);
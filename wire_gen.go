@@ -9,17 +9,29 @@ package main
 import (
 	"github.com/devtron-labs/common-lib/pubsub-lib"
 	"github.com/devtron-labs/lens/api"
+	"github.com/devtron-labs/lens/bean"
 	"github.com/devtron-labs/lens/client"
 	"github.com/devtron-labs/lens/client/gitSensor"
 	"github.com/devtron-labs/lens/internal/logger"
+	"github.com/devtron-labs/lens/internal/server"
 	"github.com/devtron-labs/lens/internal/sql"
+	"github.com/devtron-labs/lens/internal/tracing"
 	"github.com/devtron-labs/lens/pkg"
+	"github.com/devtron-labs/lens/pkg/auth"
 )
 
 // Injectors from Wire.go:
 
 func InitializeApp() (*App, error) {
 	sugaredLogger := logger.NewSugardLogger()
+	tracingConfig, err := tracing.GetTracingConfig()
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider, err := tracing.NewTracerProvider(sugaredLogger, tracingConfig)
+	if err != nil {
+		return nil, err
+	}
 	config, err := sql.GetConfig()
 	if err != nil {
 		return nil, err
@@ -30,13 +42,21 @@ func InitializeApp() (*App, error) {
 	}
 	leadTimeRepositoryImpl := sql.NewLeadTimeRepositoryImpl(db, sugaredLogger)
 	pipelineMaterialRepositoryImpl := sql.NewPipelineMaterialRepositoryImpl(db, sugaredLogger)
-	appReleaseRepositoryImpl := sql.NewAppReleaseRepositoryImpl(db, sugaredLogger, leadTimeRepositoryImpl, pipelineMaterialRepositoryImpl)
-	deploymentMetricServiceImpl := pkg.NewDeploymentMetricServiceImpl(sugaredLogger, appReleaseRepositoryImpl, pipelineMaterialRepositoryImpl, leadTimeRepositoryImpl)
+	resetBatchRepositoryImpl := sql.NewResetBatchRepositoryImpl(db, sugaredLogger)
+	releaseOutboxRepositoryImpl := sql.NewReleaseOutboxRepositoryImpl(db, sugaredLogger)
+	appReleaseRepositoryImpl := sql.NewAppReleaseRepositoryImpl(db, sugaredLogger, leadTimeRepositoryImpl, pipelineMaterialRepositoryImpl, resetBatchRepositoryImpl, releaseOutboxRepositoryImpl)
+	releaseRollupRepositoryImpl := sql.NewReleaseRollupRepositoryImpl(db, sugaredLogger)
+	releaseTagRepositoryImpl := sql.NewReleaseTagRepositoryImpl(db, sugaredLogger)
+	deploymentMetricServiceImpl := pkg.NewDeploymentMetricServiceImpl(sugaredLogger, appReleaseRepositoryImpl, pipelineMaterialRepositoryImpl, leadTimeRepositoryImpl, releaseRollupRepositoryImpl, releaseTagRepositoryImpl)
 	gitSensorConfig, err := gitSensor.GetGitSensorConfig()
 	if err != nil {
 		return nil, err
 	}
-	gitSensorClientImpl, err := gitSensor.NewGitSensorSession(gitSensorConfig, sugaredLogger)
+	resilienceConfig, err := gitSensor.GetResilienceConfig()
+	if err != nil {
+		return nil, err
+	}
+	gitSensorClientImpl, err := gitSensor.NewGitSensorSession(gitSensorConfig, resilienceConfig, sugaredLogger)
 	if err != nil {
 		return nil, err
 	}
@@ -44,18 +64,130 @@ func InitializeApp() (*App, error) {
 	if err != nil {
 		return nil, err
 	}
-	gitSensorGrpcClientImpl := gitSensor.NewGitSensorGrpcClientImpl(sugaredLogger, gitSensorGrpcClientConfig)
-	ingestionServiceImpl := pkg.NewIngestionServiceImpl(sugaredLogger, appReleaseRepositoryImpl, pipelineMaterialRepositoryImpl, leadTimeRepositoryImpl, gitSensorClientImpl, gitSensorGrpcClientImpl)
-	restHandlerImpl := api.NewRestHandlerImpl(sugaredLogger, deploymentMetricServiceImpl, ingestionServiceImpl)
-	muxRouter := api.NewMuxRouter(sugaredLogger, restHandlerImpl)
+	gitSensorGrpcClientImpl, err := gitSensor.NewGitSensorGrpcClientImpl(sugaredLogger, gitSensorGrpcClientConfig, resilienceConfig)
+	if err != nil {
+		return nil, err
+	}
+	localGitConfig, err := gitSensor.GetLocalGitConfig()
+	if err != nil {
+		return nil, err
+	}
+	localGitChangesProviderImpl, err := gitSensor.NewLocalGitChangesProviderImpl(sugaredLogger, localGitConfig)
+	if err != nil {
+		return nil, err
+	}
+	gitSensorProtocolConfig, err := bean.GetGitSensorProtocolConfig()
+	if err != nil {
+		return nil, err
+	}
+	gitChangesProvider, err := gitSensor.NewGitChangesProvider(gitSensorProtocolConfig, gitSensorClientImpl, gitSensorGrpcClientImpl, localGitChangesProviderImpl)
+	if err != nil {
+		return nil, err
+	}
+	gitChangesCacheConfig, err := gitSensor.GetGitChangesCacheConfig()
+	if err != nil {
+		return nil, err
+	}
+	gitChangesCacheRepositoryImpl := sql.NewGitChangesCacheRepositoryImpl(db, sugaredLogger)
+	gitChangesCacheImpl := gitSensor.NewGitChangesCacheImpl(sugaredLogger, gitChangesCacheConfig, gitChangesProvider, gitChangesCacheRepositoryImpl)
+	releaseOverrideRepositoryImpl := sql.NewReleaseOverrideRepositoryImpl(db, sugaredLogger)
+	releaseAnnotationRepositoryImpl := sql.NewReleaseAnnotationRepositoryImpl(db, sugaredLogger)
+	ingestionConfig, err := pkg.GetIngestionConfig()
+	if err != nil {
+		return nil, err
+	}
+	releaseStatusEventRepositoryImpl := sql.NewReleaseStatusEventRepositoryImpl(db, sugaredLogger)
+	failurePolicyRepositoryImpl := sql.NewFailurePolicyRepositoryImpl(db, sugaredLogger)
+	failureRecomputeJobRepositoryImpl := sql.NewFailureRecomputeJobRepositoryImpl(db, sugaredLogger)
+	failurePolicyServiceImpl := pkg.NewFailurePolicyServiceImpl(sugaredLogger, failurePolicyRepositoryImpl, appReleaseRepositoryImpl, releaseOverrideRepositoryImpl, releaseTagRepositoryImpl, releaseStatusEventRepositoryImpl, failureRecomputeJobRepositoryImpl)
+	releaseStageHistoryRepositoryImpl := sql.NewReleaseStageHistoryRepositoryImpl(db, sugaredLogger)
+	releaseStreamConfig, err := pkg.GetReleaseStreamConfig()
+	if err != nil {
+		return nil, err
+	}
+	releaseStreamServiceImpl := pkg.NewReleaseStreamServiceImpl(sugaredLogger, releaseStreamConfig)
+	ingestionServiceImpl := pkg.NewIngestionServiceImpl(sugaredLogger, ingestionConfig, appReleaseRepositoryImpl, pipelineMaterialRepositoryImpl, leadTimeRepositoryImpl, gitChangesCacheImpl, releaseOverrideRepositoryImpl, releaseTagRepositoryImpl, releaseAnnotationRepositoryImpl, releaseStatusEventRepositoryImpl, failurePolicyServiceImpl, releaseStreamServiceImpl, releaseStageHistoryRepositoryImpl)
+	resetConfig, err := pkg.GetResetConfig()
+	if err != nil {
+		return nil, err
+	}
+	resetServiceImpl := pkg.NewResetServiceImpl(sugaredLogger, resetConfig, appReleaseRepositoryImpl, resetBatchRepositoryImpl)
+	rawEventRepositoryImpl := sql.NewRawEventRepositoryImpl(db, sugaredLogger)
+	rawEventServiceImpl := pkg.NewRawEventServiceImpl(sugaredLogger, rawEventRepositoryImpl)
+	recomputeJobRepositoryImpl := sql.NewRecomputeJobRepositoryImpl(db, sugaredLogger, resetBatchRepositoryImpl)
+	retentionConfig, err := pkg.GetRetentionConfig()
+	if err != nil {
+		return nil, err
+	}
+	retentionServiceImpl, err := pkg.NewRetentionServiceImpl(sugaredLogger, retentionConfig, appReleaseRepositoryImpl, releaseRollupRepositoryImpl)
+	if err != nil {
+		return nil, err
+	}
+	recomputeServiceImpl := pkg.NewRecomputeServiceImpl(sugaredLogger, ingestionServiceImpl, rawEventRepositoryImpl, recomputeJobRepositoryImpl, appReleaseRepositoryImpl, retentionServiceImpl)
+	reprocessConfig, err := pkg.GetReprocessConfig()
+	if err != nil {
+		return nil, err
+	}
+	reprocessServiceImpl := pkg.NewReprocessServiceImpl(sugaredLogger, reprocessConfig, appReleaseRepositoryImpl, ingestionServiceImpl)
+	releaseServiceImpl := pkg.NewReleaseServiceImpl(sugaredLogger, appReleaseRepositoryImpl, releaseOverrideRepositoryImpl, releaseTagRepositoryImpl, releaseAnnotationRepositoryImpl, releaseStageHistoryRepositoryImpl)
+	authConfig, err := auth.GetAuthConfig()
+	if err != nil {
+		return nil, err
+	}
+	apiKeyRepositoryImpl := sql.NewApiKeyRepositoryImpl(db, sugaredLogger)
+	authServiceImpl := auth.NewAuthServiceImpl(sugaredLogger, authConfig, apiKeyRepositoryImpl)
+	healthConfig, err := pkg.GetHealthConfig()
+	if err != nil {
+		return nil, err
+	}
 	pubSubClientServiceImpl, err := pubsub_lib.NewPubSubClientServiceImpl(sugaredLogger)
 	if err != nil {
 		return nil, err
 	}
-	natsSubscriptionImpl, err := client.NewNatsSubscription(pubSubClientServiceImpl, sugaredLogger, ingestionServiceImpl)
+	healthServiceImpl := pkg.NewHealthServiceImpl(sugaredLogger, healthConfig, db, pubSubClientServiceImpl, gitChangesProvider)
+	restHandlerImpl := api.NewRestHandlerImpl(sugaredLogger, deploymentMetricServiceImpl, ingestionServiceImpl, resetServiceImpl, releaseServiceImpl, authServiceImpl, healthServiceImpl, gitChangesCacheImpl, failurePolicyServiceImpl, rawEventServiceImpl, recomputeServiceImpl, releaseStreamServiceImpl)
+	authMiddleware := api.NewAuthMiddleware(sugaredLogger, authServiceImpl, releaseServiceImpl, resetServiceImpl, recomputeServiceImpl)
+	muxRouter := api.NewMuxRouter(sugaredLogger, restHandlerImpl, authMiddleware)
+	natsSubscriptionImpl, err := client.NewNatsSubscription(pubSubClientServiceImpl, sugaredLogger, ingestionServiceImpl, rawEventServiceImpl)
+	if err != nil {
+		return nil, err
+	}
+	releaseOutboxConfig, err := pkg.GetReleaseOutboxConfig()
+	if err != nil {
+		return nil, err
+	}
+	releaseOutboxServiceImpl := pkg.NewReleaseOutboxServiceImpl(sugaredLogger, releaseOutboxConfig, releaseOutboxRepositoryImpl, pubSubClientServiceImpl)
+	serverConfig, err := server.GetServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	shutdownConfig, err := GetShutdownConfig()
 	if err != nil {
 		return nil, err
 	}
-	app := NewApp(muxRouter, sugaredLogger, db, ingestionServiceImpl, natsSubscriptionImpl, pubSubClientServiceImpl)
+	app := NewApp(muxRouter, sugaredLogger, db, ingestionServiceImpl, natsSubscriptionImpl, pubSubClientServiceImpl, retentionServiceImpl, resetServiceImpl, recomputeServiceImpl, reprocessServiceImpl, failurePolicyServiceImpl, releaseOutboxServiceImpl, releaseStreamServiceImpl, serverConfig, shutdownConfig, tracerProvider)
 	return app, nil
 }
+
+func InitializeMigrateCommand() (*MigrateCommand, error) {
+	sugaredLogger := logger.NewSugardLogger()
+	config, err := sql.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.NewDbConnection(config, sugaredLogger)
+	if err != nil {
+		return nil, err
+	}
+	fs := NewMigrationSource()
+	migratorImpl, err := sql.NewMigratorImpl(db, sugaredLogger, fs)
+	if err != nil {
+		return nil, err
+	}
+	migrationConfig, err := sql.GetMigrationConfig()
+	if err != nil {
+		return nil, err
+	}
+	migrateCommand := NewMigrateCommand(sugaredLogger, db, migratorImpl, migrationConfig)
+	return migrateCommand, nil
+}